@@ -0,0 +1,70 @@
+package blksubscriber
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/redact"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// debugClient dumps requests and responses with credentials redacted
+type debugClient struct {
+	client   httpClient
+	out      io.Writer
+	redactor *redact.Redactor
+	lock     sync.Mutex
+}
+
+func (c *debugClient) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	var dump strings.Builder
+	fmt.Fprintf(&dump, "> %s %s\n", req.Method, req.URL.String())
+	writeHeaders(&dump, "> ", c.redactor.Header(req.Header))
+	fmt.Fprintf(&dump, "> %s\n", reqBody)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		fmt.Fprintf(&dump, "< error: %s\n", err)
+		c.write(dump.String())
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	fmt.Fprintf(&dump, "< %s\n", resp.Status)
+	writeHeaders(&dump, "< ", c.redactor.Header(resp.Header))
+	fmt.Fprintf(&dump, "< %s\n", respBody)
+	c.write(dump.String())
+	return resp, err
+}
+
+func (c *debugClient) write(dump string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, _ = io.WriteString(c.out, c.redactor.String(dump))
+}
+
+func writeHeaders(w io.Writer, prefix string, headers http.Header) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package blksubscriber
+
+import (
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"math/big"
+)
+
+type (
+	// RPCError is a failure reported by the endpoint, see rpc.Error
+	RPCError = rpc.Error
+	// NotFoundError is returned when requested block is not available, see rpc.NotFoundError
+	NotFoundError = rpc.NotFoundError
+)
+
+// ChainMismatchError is returned by Start when the endpoint serves another chain than the expected one
+type ChainMismatchError struct {
+	Expected *big.Int
+	Actual   *big.Int
+}
+
+func (e *ChainMismatchError) Error() string {
+	return fmt.Sprintf("endpoint serves chain %s, expected chain %s", e.Actual, e.Expected)
+}
+
+// ReorgTooDeepError stops the subscriber when no block among WithReorgDepth last ones is on the canonical chain
+type ReorgTooDeepError struct {
+	Depth int
+	Block *big.Int
+}
+
+func (e *ReorgTooDeepError) Error() string {
+	return fmt.Sprintf("block %s does not extend any of %d last blocks", e.Block, e.Depth)
+}
+
+// IsRateLimited reports whether the endpoint throttled the request
+func IsRateLimited(err error) bool {
+	return rpc.IsRateLimited(err)
+}
+
+// IsUnauthorized reports whether the endpoint rejected credentials, e.g. an invalid API key
+func IsUnauthorized(err error) bool {
+	return rpc.IsUnauthorized(err)
+}
+
+// IsMethodNotSupported reports whether the endpoint does not provide the called method
+func IsMethodNotSupported(err error) bool {
+	return rpc.IsMethodNotSupported(err)
+}
+
+// IsNotFound reports whether the requested object does not exist (yet)
+func IsNotFound(err error) bool {
+	return rpc.IsNotFound(err)
+}
+
+// IsRetryable reports whether repeating the same request later may succeed
+func IsRetryable(err error) bool {
+	return rpc.IsRetryable(err)
+}
@@ -0,0 +1,24 @@
+package blksubscriber
+
+import (
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+)
+
+// BlockSource produces blocks in ascending order into its channel, Subscriber reads them from a node,
+// other implementations can read them from anywhere else, e.g. recorded files.
+// Block channel is closed when source stops, LastError tells why it stopped if it failed.
+type BlockSource[T types.BlockType] interface {
+	Start() error
+	Stop()
+	GetBlockChan() <-chan *T
+	LastError() error
+	// GetCurrentBlock returns number of the next block to be produced
+	GetCurrentBlock() big.Int
+	IsRunning() bool
+}
+
+var (
+	_ BlockSource[types.Block]         = (*Subscriber[types.Block])(nil)
+	_ BlockSource[types.BlockDetailed] = (*Subscriber[types.BlockDetailed])(nil)
+)
@@ -1,17 +1,16 @@
 package blksubscriber
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
+	"github.com/dkropachev/ethscan/pkg/redact"
+	"github.com/dkropachev/ethscan/pkg/rpc"
 	"github.com/dkropachev/ethscan/pkg/types"
 	"io"
 	"math/big"
 	"net/http"
 	"net/url"
-	"reflect"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,32 +18,53 @@ import (
 )
 
 type (
-	rcpError struct {
-		Error struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-		}
-	}
-
 	options struct {
 		poolingPeriod time.Duration
 		currentBlock  atomic.Pointer[big.Int]
 		endBlock      atomic.Pointer[big.Int]
 		client        httpClient
+		rpcOptions    []rpc.Option
+		// secrets and sensitiveHeaders feed redactor, so that credentials never show up in errors and dumps
+		secrets          []string
+		sensitiveHeaders []string
+		headers          http.Header
+		debugLog         io.Writer
+		network          rpc.NetworkConfig
+		limiter          *rpc.Limiter
+		// expectedChainID makes Start fail if the endpoint serves another chain
+		expectedChainID *big.Int
+		reorgDepth      int
+		finalityDepth   int
+		finalityTags    bool
 	}
 
 	Option func(opts *options)
 
 	Subscriber[T types.BlockType] struct {
-		url           url.URL
-		ctx           context.Context
-		ctxCancel     context.CancelFunc
-		running       atomic.Bool
-		blockDetailed bool
-		blocksChan    chan *T
-		lastError     atomic.Pointer[error]
+		url        url.URL
+		ctx        context.Context
+		ctxCancel  context.CancelFunc
+		running    atomic.Bool
+		blocksChan chan *T
+		lastError  atomic.Pointer[error]
+		rpcLock    sync.Mutex
+		rpc        *rpc.Client
+		redactor   *redact.Redactor
+		chainID    atomic.Pointer[big.Int]
+		// recent are the last emitted blocks, oldest first, kept for WithReorgDepth
+		recent []recentBlock
+		reorgs atomic.Uint64
+		// head is the last known chain head, safe and finalized are blocks with these tags, kept with WithFinalityTags
+		head      atomic.Pointer[big.Int]
+		safe      atomic.Pointer[big.Int]
+		finalized atomic.Pointer[big.Int]
 		options
 	}
+
+	recentBlock struct {
+		number *big.Int
+		hash   types.EthHash
+	}
 )
 
 func (o *options) apply(mods ...Option) {
@@ -59,6 +79,149 @@ func WithHTTPClient(cl httpClient) Option {
 	}
 }
 
+// WithHeader adds header to every request sent to the endpoint, can be used multiple times
+func WithHeader(name, value string) Option {
+	return func(opts *options) {
+		opts.rpcOptions = append(opts.rpcOptions, rpc.WithHeader(name, value))
+		if opts.headers == nil {
+			opts.headers = http.Header{}
+		}
+		opts.headers.Add(name, value)
+	}
+}
+
+// WithBasicAuth authenticates requests with HTTP basic auth, credentials in endpoint URL are used when it is not set
+func WithBasicAuth(username, password string) Option {
+	return func(opts *options) {
+		opts.rpcOptions = append(opts.rpcOptions, rpc.WithBasicAuth(username, password))
+		opts.secrets = append(opts.secrets, password)
+	}
+}
+
+// WithJWTSecret authenticates requests with JWT bearer token signed by the secret, as Engine API does
+func WithJWTSecret(secret []byte) Option {
+	return func(opts *options) {
+		opts.rpcOptions = append(opts.rpcOptions, rpc.WithJWTSecret(secret))
+	}
+}
+
+// WithSensitiveHeaders masks values of these headers in errors and debug dumps,
+// in addition to redact.DefaultSensitiveHeaders
+func WithSensitiveHeaders(names ...string) Option {
+	return func(opts *options) {
+		opts.sensitiveHeaders = append(opts.sensitiveHeaders, names...)
+	}
+}
+
+// WithSecret masks the value wherever it shows up in errors and debug dumps
+func WithSecret(secrets ...string) Option {
+	return func(opts *options) {
+		opts.secrets = append(opts.secrets, secrets...)
+	}
+}
+
+// WithDebugLog dumps every HTTP request and response, with credentials redacted, into w
+func WithDebugLog(w io.Writer) Option {
+	return func(opts *options) {
+		opts.debugLog = w
+	}
+}
+
+// WithNetworkConfig sets TLS, proxy, timeout and connection pool settings of HTTP and WebSocket connections at once
+func WithNetworkConfig(cfg rpc.NetworkConfig) Option {
+	return func(opts *options) {
+		opts.network = cfg
+	}
+}
+
+// WithCABundle trusts CA certificates from PEM file in addition to system ones
+func WithCABundle(path string) Option {
+	return func(opts *options) {
+		opts.network.CAFile = path
+	}
+}
+
+// WithClientCert authenticates with TLS client certificate, both files are PEM encoded
+func WithClientCert(certFile, keyFile string) Option {
+	return func(opts *options) {
+		opts.network.CertFile = certFile
+		opts.network.KeyFile = keyFile
+	}
+}
+
+// WithInsecureSkipVerify disables server certificate verification, only meant for devnets
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(opts *options) {
+		opts.network.InsecureSkipVerify = skip
+	}
+}
+
+// WithProxy sends requests through http:// or https:// proxy, HTTP_PROXY and HTTPS_PROXY are used when it is not set
+func WithProxy(proxyURL string) Option {
+	return func(opts *options) {
+		opts.network.Proxy = proxyURL
+	}
+}
+
+// WithRequestTimeout limits every RPC call, including connection establishment
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.network.Timeout = timeout
+	}
+}
+
+// WithMaxIdleConns limits idle HTTP connections kept to the endpoint
+func WithMaxIdleConns(n int) Option {
+	return func(opts *options) {
+		opts.network.MaxIdleConns = n
+	}
+}
+
+// WithRateLimiter makes every RPC call wait for compute units budget of the limiter,
+// limiter can be shared by subscribers that use the same provider account
+func WithRateLimiter(l *rpc.Limiter) Option {
+	return func(opts *options) {
+		opts.limiter = l
+	}
+}
+
+// WithChainID makes Start fail with ChainMismatchError when eth_chainId of the endpoint is different
+func WithChainID(id *big.Int) Option {
+	return func(opts *options) {
+		opts.expectedChainID = id
+	}
+}
+
+// WithReorgDepth makes the subscriber check that every block extends the previous one.
+// When it does not, the subscriber finds the common ancestor among depth last blocks and sends blocks of the new
+// canonical chain from the block after it, so a block number that is not above the previous one signals a reorg.
+// Reorg deeper than depth stops the subscriber with ReorgTooDeepError.
+func WithReorgDepth(depth int) Option {
+	return func(opts *options) {
+		opts.reorgDepth = depth
+	}
+}
+
+// WithFinalityDepth makes blocks depth blocks below the head count as finalized when the finalized tag is not known,
+// see Finality. It defaults to the reorg depth, chains that finalize much slower than they reorg need it set.
+func WithFinalityDepth(depth int) Option {
+	return func(opts *options) {
+		opts.finalityDepth = depth
+	}
+}
+
+// WithFinalityTags makes the subscriber read numbers of blocks tagged safe and finalized whenever the head moves,
+// see Finality. Endpoints that do not know the tags are tolerated, the numbers stay unknown then.
+func WithFinalityTags(enabled bool) Option {
+	return func(opts *options) {
+		opts.finalityTags = enabled
+	}
+}
+
+// DefaultReorgDepth is enough for Ethereum, where blocks are final after two epochs.
+// Rollups take longer to finalize, but reorg no deeper, see chains.Chain.ReorgDepth.
+const DefaultReorgDepth = 64
+
 func WithPoolingPeriod(period time.Duration) Option {
 	return func(opts *options) {
 		opts.poolingPeriod = period
@@ -79,212 +242,459 @@ func WithEndBlock(blkId *big.Int) Option {
 
 const defaultPoolingPeriod = time.Second
 
-var typeOfBlockDetailed = reflect.TypeOf(types.BlockDetailed{})
-
 func New[T types.BlockType](endpoint string, opts ...Option) (*Subscriber[T], error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse endpoint URL")
+		return nil, redact.New().Error(errors.Wrap(err, "failed to parse endpoint URL"))
 	}
-	if u.Scheme != "ws" && u.Scheme != "http" && u.Scheme != "https" {
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	case "ipc", "unix", "":
+		if rpc.IPCPath(u) == "" {
+			return nil, errors.Errorf("ipc endpoint %q has no socket path", redact.URL(endpoint))
+		}
+	default:
 		return nil, errors.Errorf("unsupported URL scheme %q", u.Scheme)
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 
-	var blockDetailed bool
-	if reflect.TypeOf(*new(T)) == typeOfBlockDetailed {
-		blockDetailed = true
-	}
-
 	out := &Subscriber[T]{
-		url:           *u,
-		blocksChan:    make(chan *T, 1000),
-		ctx:           ctx,
-		ctxCancel:     cancel,
-		blockDetailed: blockDetailed,
+		url:        *u,
+		blocksChan: make(chan *T, 1000),
+		ctx:        ctx,
+		ctxCancel:  cancel,
 		options: options{
 			client:        http.DefaultClient,
 			poolingPeriod: defaultPoolingPeriod,
 		},
 	}
 	out.options.apply(opts...)
+
+	out.redactor = redact.New(out.sensitiveHeaders...)
+	out.redactor.AddURL(endpoint)
+	out.redactor.AddURL(out.network.Proxy)
+	out.redactor.AddHeaders(out.headers)
+	out.redactor.AddSecret(out.secrets...)
 	return out, nil
 }
 
+// Redactor returns redactor that knows credentials of this subscriber
+func (s *Subscriber[T]) Redactor() *redact.Redactor {
+	return s.redactor
+}
+
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
 func (s *Subscriber[T]) SetClient(re httpClient) {
+	s.rpcLock.Lock()
+	defer s.rpcLock.Unlock()
 	s.client = re
+	if s.rpc != nil {
+		_ = s.rpc.Close()
+		s.rpc = nil
+	}
 }
 
 func (s *Subscriber[T]) Stop() {
 	s.ctxCancel()
+	s.rpcLock.Lock()
+	defer s.rpcLock.Unlock()
+	if s.rpc != nil {
+		_ = s.rpc.Close()
+	}
 }
 
-func (s *Subscriber[T]) checkIfRPCError(buff []byte) error {
-	var rpcErr rcpError
-	err := json.Unmarshal(buff, &rpcErr)
-	if err != nil {
-		return errors.Wrapf(err, "failed to unmarshal response body %q", string(buff))
-	}
-	if rpcErr.Error.Code != 0 && rpcErr.Error.Message != "" {
-		return errors.Errorf("rpc error %d: %q", rpcErr.Error.Code, rpcErr.Error.Message)
+// rpcClient returns JSON-RPC client, connecting to the endpoint on first use
+func (s *Subscriber[T]) rpcClient() (*rpc.Client, error) {
+	s.rpcLock.Lock()
+	defer s.rpcLock.Unlock()
+	if s.rpc == nil {
+		var httpCl httpClient = s.client
+		if httpCl == http.DefaultClient && !s.network.IsZero() {
+			cl, err := s.network.HTTPClient()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to configure HTTP client")
+			}
+			httpCl = cl
+		}
+		if s.debugLog != nil {
+			httpCl = &debugClient{client: httpCl, out: s.debugLog, redactor: s.redactor}
+		}
+		opts := append([]rpc.Option{rpc.WithHTTPClient(httpCl), rpc.WithNetworkConfig(s.network), rpc.WithRateLimiter(s.limiter)}, s.rpcOptions...)
+		client, err := rpc.Dial(s.ctx, s.url.String(), opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to connect to endpoint")
+		}
+		s.rpc = client
 	}
-	return nil
+	return s.rpc, nil
 }
 
 func (s *Subscriber[T]) getCurrentBlockNumber() (*big.Int, error) {
-	// https://ethereum.org/en/developers/docs/apis/json-rpc/#eth_blocknumber
-
-	req, err := http.NewRequest(
-		http.MethodPost,
-		s.url.String(),
-		strings.NewReader("{\"jsonrpc\":\"2.0\",\"method\":\"eth_blockNumber\",\"params\":[],\"id\":1}"),
-	)
+	client, err := s.rpcClient()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request to get current block number")
+		return nil, err
 	}
-	req = req.WithContext(s.ctx)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
+	return client.BlockNumber(s.ctx)
+}
 
-	resp, err := s.client.Do(req)
+func (s *Subscriber[T]) getBlockInfo(blockNum *big.Int) (*T, error) {
+	client, err := s.rpcClient()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get current block number")
+		return nil, err
 	}
-
-	buff, err := io.ReadAll(resp.Body)
+	block, err := rpc.GetBlockByNumber[T](s.ctx, client, rpc.NumberRef(blockNum))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read response body")
+		return nil, errors.Wrap(err, "failed to get block info")
 	}
+	return block, nil
+}
 
-	if err = s.checkIfRPCError(buff); err != nil {
+// GetBlockReceipts returns receipts of all transactions in the block
+func (s *Subscriber[T]) GetBlockReceipts(blockNum *big.Int) ([]*types.Receipt, error) {
+	client, err := s.rpcClient()
+	if err != nil {
 		return nil, err
 	}
-
-	var respBody struct {
-		ID     int    `json:"id"`
-		Result string `json:"result"`
+	receipts, err := client.GetBlockReceipts(s.ctx, rpc.NumberRef(blockNum))
+	if err != nil {
+		return nil, s.redactor.Error(errors.Wrap(err, "failed to get block receipts"))
 	}
+	return receipts, nil
+}
 
-	err = json.Unmarshal(buff, &respBody)
+// GetBalance returns balance of the address at the end of the block
+func (s *Subscriber[T]) GetBalance(address types.EthAddress, blockNum *big.Int) (*big.Int, error) {
+	client, err := s.rpcClient()
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal response body %q", string(buff))
-	}
-	if respBody.Result == "" {
-		return nil, errors.Errorf("unexpected response %q", string(buff))
+		return nil, err
 	}
-	result, ok := new(big.Int).SetString(strings.TrimPrefix(respBody.Result, "0x"), 16)
-	if !ok {
-		return nil, errors.Errorf("failed to parse block number %q", respBody.Result)
+	balance, err := client.GetBalance(s.ctx, address, rpc.NumberRef(blockNum))
+	if err != nil {
+		return nil, s.redactor.Error(errors.Wrap(err, "failed to get balance"))
 	}
-	return result, nil
+	return balance, nil
 }
 
-func (s *Subscriber[T]) getBlockInfo(blockNum *big.Int) (*T, error) {
-	// https://ethereum.org/en/developers/docs/apis/json-rpc/#eth_getBlockByNumber
-
-	body := "{\"jsonrpc\":\"2.0\",\"method\":\"eth_getBlockByNumber\",\"params\":[\"" + fmt.Sprintf("0x%x", blockNum) + "\", " + fmt.Sprintf("%t", s.blockDetailed) + "],\"id\":1}"
-	req, err := http.NewRequest(
-		http.MethodPost,
-		s.url.String(),
-		strings.NewReader(body),
-	)
+var bigIntUno = big.NewInt(1)
+
+// detectChain reads chain ID of the endpoint and checks it against the expected one.
+// Endpoints not providing eth_chainId are only accepted when no chain is expected.
+func (s *Subscriber[T]) detectChain() error {
+	if s.chainID.Load() != nil {
+		return nil
+	}
+	client, err := s.rpcClient()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request to get current block number")
+		return err
+	}
+	chainID, err := client.ChainID(s.ctx)
+	if IsMethodNotSupported(err) && s.expectedChainID == nil {
+		return nil
 	}
-	req = req.WithContext(s.ctx)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "*/*")
-
-	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get current block number")
+		return errors.Wrap(err, "failed to get chain ID")
+	}
+	if s.expectedChainID != nil && s.expectedChainID.Cmp(chainID) != 0 {
+		return &ChainMismatchError{Expected: s.expectedChainID, Actual: chainID}
 	}
+	s.chainID.Store(chainID)
+	return nil
+}
 
-	buff := bytes.NewBuffer(make([]byte, 0, 1024))
+// ChainID returns chain ID reported by the endpoint on Start, nil before that
+func (s *Subscriber[T]) ChainID() *big.Int {
+	return s.chainID.Load()
+}
 
-	var respBody struct {
-		Result T
+func baseOf[T types.BlockType](blk *T) *types.BlockBase {
+	switch val := any(blk).(type) {
+	case *types.Block:
+		return &val.BlockBase
+	case *types.BlockDetailed:
+		return &val.BlockBase
 	}
+	return nil
+}
 
-	err = json.NewDecoder(io.TeeReader(resp.Body, &limitWriter{w: buff, limit: 1024})).Decode(&respBody)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal response body %q", buff.String())
+// stampChainID sets chain ID on the block, blocks stamped by other sources are kept as they are
+func (s *Subscriber[T]) stampChainID(blk *T) {
+	chainID := s.chainID.Load()
+	if chainID == nil {
+		return
+	}
+	if base := baseOf(blk); base != nil && base.ChainID == nil {
+		base.ChainID = (*types.BigInt)(new(big.Int).Set(chainID))
 	}
+}
 
-	if respBody.Result.IsEmpty() {
-		if err = s.checkIfRPCError(buff.Bytes()); err != nil {
-			return nil, err
-		}
-		return nil, errors.Errorf("unexpected response %q", buff.String())
+func (s *Subscriber[T]) initCurrentBlock() error {
+	if s.currentBlock.Load() != nil {
+		return nil
+	}
+	currentBlock, err := s.getCurrentBlockNumber()
+	if err != nil {
+		return errors.Wrap(err, "failed to get current block number on init")
 	}
+	s.currentBlock.Store(currentBlock)
+	return nil
+}
 
-	return &respBody.Result, nil
+// pollStart implements block subscription that polls eth_blockNumber every pooling period
+func (s *Subscriber[T]) pollStart() error {
+	if err := s.detectChain(); err != nil {
+		return err
+	}
+	if err := s.initCurrentBlock(); err != nil {
+		return err
+	}
+	s.running.Store(true)
+	go s.pollSubscriberBody()
+	return nil
 }
 
-var bigIntUno = big.NewInt(1)
+func (s *Subscriber[T]) pollSubscriberBody() {
+	defer func() {
+		close(s.blocksChan)
+		s.running.Store(false)
+	}()
+	timer := time.NewTicker(s.poolingPeriod)
+	defer timer.Stop()
+
+	currentBlock := new(big.Int).Set(s.currentBlock.Load())
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		headBlock, err := s.getCurrentBlockNumber()
+		if IsRateLimited(err) {
+			// Provider budget is exhausted, try again next period instead of giving up
+			s.lastError.Store(toPtr(errors.Wrap(err, "failed to get current block number")))
+			continue
+		}
+		if err != nil {
+			s.lastError.Store(toPtr(errors.Wrap(err, "failed to get current block number")))
+			return
+		}
 
-// httpStart implements http-based block subscription
-func (s *Subscriber[T]) httpStart() error {
-	var currentBlock *big.Int
-	var err error
+		s.updateFinality(headBlock)
+		topKnownBlock := new(big.Int).Add(headBlock, bigIntUno)
 
-	if currentBlock = s.currentBlock.Load(); currentBlock == nil {
-		currentBlock, err = s.getCurrentBlockNumber()
+		finished, err := s.fetchBlocks(currentBlock, topKnownBlock)
 		if err != nil {
-			return errors.Wrap(err, "failed to get current block number on init")
+			s.lastError.Store(toPtr(err))
+			return
+		}
+		s.currentBlock.Store(new(big.Int).Set(currentBlock))
+		if finished {
+			return
 		}
 	}
+}
 
-	s.currentBlock.Store(currentBlock)
+// pushStart implements block subscription driven by newHeads notifications, it requires websocket or IPC endpoint.
+// It falls back to polling if the endpoint does not support subscriptions.
+func (s *Subscriber[T]) pushStart() error {
+	// https://docs.infura.io/api/networks/ethereum/json-rpc-methods/subscription-methods/eth_subscribe
+	if err := s.detectChain(); err != nil {
+		return err
+	}
+
+	if err := s.initCurrentBlock(); err != nil {
+		return err
+	}
+	client, err := s.rpcClient()
+	if err != nil {
+		return err
+	}
+	sub, err := client.Subscribe(s.ctx, "newHeads")
+	if IsMethodNotSupported(err) {
+		return s.pollStart()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe to new heads")
+	}
 	s.running.Store(true)
-	go s.httpSubscriberBody()
+	go s.pushSubscriberBody(sub)
 	return nil
 }
 
-func (s *Subscriber[T]) httpSubscriberBody() {
+func (s *Subscriber[T]) pushSubscriberBody(sub *rpc.Subscription) {
 	defer func() {
 		close(s.blocksChan)
 		s.running.Store(false)
 	}()
-	timer := time.NewTicker(s.poolingPeriod)
-	defer timer.Stop()
 
-	currentBlock := s.currentBlock.Load()
+	currentBlock := new(big.Int).Set(s.currentBlock.Load())
 	for {
+		var head json.RawMessage
 		select {
 		case <-s.ctx.Done():
 			return
-		case <-timer.C:
+		case <-sub.Done():
+			s.lastError.Store(toPtr(errors.Wrap(sub.Err(), "new heads subscription failed")))
+			return
+		case head = <-sub.Notifications():
 		}
 
-		topKnownBlock, err := s.getCurrentBlockNumber()
+		var header struct {
+			Number types.BigInt `json:"number"`
+		}
+		if err := json.Unmarshal(head, &header); err != nil {
+			s.lastError.Store(toPtr(errors.Wrapf(err, "failed to parse new head %q", string(head))))
+			return
+		}
+
+		s.updateFinality(header.Number.AsBigInt())
+		topKnownBlock := new(big.Int).Add(header.Number.AsBigInt(), bigIntUno)
+		finished, err := s.fetchBlocks(currentBlock, topKnownBlock)
 		if err != nil {
-			s.lastError.Store(toPtr(errors.Wrap(err, "failed to get current block number")))
+			s.lastError.Store(toPtr(err))
+			return
+		}
+		s.currentBlock.Store(new(big.Int).Set(currentBlock))
+		if finished {
 			return
 		}
+	}
+}
 
-		for ; currentBlock.Cmp(topKnownBlock) < 0; currentBlock.Add(currentBlock, bigIntUno) {
-			endBlock := s.endBlock.Load()
-			if endBlock.Int64() != 0 && currentBlock.Cmp(endBlock) > 0 {
-				return
-			}
-			block, err := s.getBlockInfo(currentBlock)
+// fetchBlocks sends blocks from currentBlock up to, but not including, topKnownBlock into the channel, advancing currentBlock.
+// It returns true once the end block has been passed.
+func (s *Subscriber[T]) fetchBlocks(currentBlock, topKnownBlock *big.Int) (bool, error) {
+	for {
+		endBlock := s.endBlock.Load()
+		if endBlock != nil && endBlock.Sign() != 0 && currentBlock.Cmp(endBlock) > 0 {
+			return true, nil
+		}
+		if currentBlock.Cmp(topKnownBlock) >= 0 {
+			return false, nil
+		}
+		block, err := s.getBlockInfo(currentBlock)
+		if IsNotFound(err) {
+			// Node that served the request is behind the one that reported top block, retry later
+			return false, nil
+		}
+		if IsRateLimited(err) {
+			// Provider budget is exhausted, continue from this block later instead of giving up
+			s.lastError.Store(toPtr(errors.Wrapf(err, "failed to read block %x info", currentBlock)))
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read block %x info", currentBlock)
+		}
+		if s.reorgDepth > 0 {
+			ancestor, err := s.checkReorg(block)
 			if err != nil {
-				s.lastError.Store(toPtr(errors.Wrapf(err, "failed to read block %x info", currentBlock)))
-				return
+				return false, err
+			}
+			if ancestor != nil {
+				// Continue with the new canonical chain from the block after the common ancestor
+				s.reorgs.Add(1)
+				currentBlock.Add(ancestor, bigIntUno)
+				continue
 			}
-			s.blocksChan <- block
 		}
+		s.stampChainID(block)
+		s.blocksChan <- block
+		// Error that did not stop the subscriber, like rate limiting, is over once a block is read
+		s.lastError.Store(nil)
+		currentBlock.Add(currentBlock, bigIntUno)
+	}
+}
 
-		currentBlock = topKnownBlock
-		s.currentBlock.Store(currentBlock)
+// checkReorg remembers the block if it extends the previous one, otherwise it returns number of the common ancestor
+// of the previous block and the new chain, blocks after it are forgotten
+func (s *Subscriber[T]) checkReorg(block *T) (*big.Int, error) {
+	base := baseOf(block)
+	number := base.Number.AsBigInt()
+	if len(s.recent) != 0 {
+		last := s.recent[len(s.recent)-1]
+		if new(big.Int).Add(last.number, bigIntUno).Cmp(number) == 0 && last.hash != base.ParentHash {
+			for i := len(s.recent) - 1; i >= 0; i-- {
+				canonical, err := s.getBlockInfo(s.recent[i].number)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to read block %x info", s.recent[i].number)
+				}
+				if baseOf(canonical).Hash == s.recent[i].hash {
+					ancestor := s.recent[i].number
+					s.recent = s.recent[:i+1]
+					return ancestor, nil
+				}
+			}
+			s.recent = nil
+			return nil, &ReorgTooDeepError{Depth: s.reorgDepth, Block: new(big.Int).Set(number)}
+		}
+	}
+	s.recent = append(s.recent, recentBlock{number: new(big.Int).Set(number), hash: base.Hash})
+	if len(s.recent) > s.reorgDepth {
+		s.recent = s.recent[len(s.recent)-s.reorgDepth:]
+	}
+	return nil, nil
+}
+
+// updateFinality remembers the head and, with WithFinalityTags, reads safe and finalized blocks when it moved
+func (s *Subscriber[T]) updateFinality(head *big.Int) {
+	if last := s.head.Load(); last != nil && last.Cmp(head) == 0 {
+		return
+	}
+	s.head.Store(new(big.Int).Set(head))
+	if !s.finalityTags {
+		return
+	}
+	client, err := s.rpcClient()
+	if err != nil {
+		return
+	}
+	for _, tag := range []struct {
+		ref    rpc.BlockRef
+		number *atomic.Pointer[big.Int]
+	}{{rpc.Safe, &s.safe}, {rpc.Finalized, &s.finalized}} {
+		// Failure only leaves the number as it was, blocks keep coming anyway
+		if blk, err := rpc.GetBlockByNumber[types.Block](s.ctx, client, tag.ref); err == nil {
+			tag.number.Store(new(big.Int).Set(blk.Number.AsBigInt()))
+		}
 	}
 }
 
+// Finality returns the last known head and blocks tagged safe and finalized, nil when unknown.
+// Safe and finalized blocks are only read with WithFinalityTags. When the finalized block is not known that way,
+// the block WithFinalityDepth blocks below the head is taken as finalized, WithReorgDepth blocks if it is not set,
+// as reorgs deeper than that are not followed.
+func (s *Subscriber[T]) Finality() (head, safe, finalized *big.Int) {
+	head, safe, finalized = s.head.Load(), s.safe.Load(), s.finalized.Load()
+	depth := s.finalityDepth
+	if depth == 0 {
+		depth = s.reorgDepth
+	}
+	if finalized == nil && head != nil && depth > 0 {
+		finalized = new(big.Int).Sub(head, big.NewInt(int64(depth)))
+		// Genesis is final on any chain
+		if finalized.Sign() < 0 {
+			finalized.SetInt64(0)
+		}
+	}
+	return head, safe, finalized
+}
+
+// Reorgs returns number of reorgs detected so far, see WithReorgDepth
+func (s *Subscriber[T]) Reorgs() uint64 {
+	return s.reorgs.Load()
+}
+
+// RateLimitStats returns compute units spent through the rate limiter, ok is false when no limiter is set
+func (s *Subscriber[T]) RateLimitStats() (stats rpc.RateLimitStats, ok bool) {
+	if s.limiter == nil {
+		return rpc.RateLimitStats{}, false
+	}
+	return s.limiter.Stats(), true
+}
+
 func (s *Subscriber[T]) GetCurrentBlock() big.Int {
 	val := s.currentBlock.Load()
 	if val == nil {
@@ -297,27 +707,22 @@ func (s *Subscriber[T]) GetBlockChan() <-chan *T {
 	return s.blocksChan
 }
 
-// wsStart implements websocket-based block subscription
-func (s *Subscriber[T]) wsStart() error {
-	// https: //docs.infura.io/api/networks/ethereum/json-rpc-methods/subscription-methods/eth_subscribe
-
-	return errors.New("not implemented")
-}
-
 func (s *Subscriber[T]) Start() error {
 	switch s.url.Scheme {
 	case "http", "https":
-		return s.httpStart()
-	case "ws":
-		return s.wsStart()
+		return s.redactor.Error(s.pollStart())
+	case "ws", "wss", "ipc", "unix", "":
+		return s.redactor.Error(s.pushStart())
 	default:
 		return errors.Errorf("unsupported scheme %q", s.url.Scheme)
 	}
 }
 
+// LastError returns the error that stopped the subscriber, or the last one it recovers from, like rate limiting,
+// until the next block is read
 func (s *Subscriber[T]) LastError() error {
 	if err := s.lastError.Load(); err != nil {
-		return *err
+		return s.redactor.Error(*err)
 	}
 	return nil
 }
@@ -329,21 +734,3 @@ func (s *Subscriber[T]) IsRunning() bool {
 func toPtr[T any](in T) *T {
 	return &in
 }
-
-type limitWriter struct {
-	w     io.Writer
-	limit int
-}
-
-func (w *limitWriter) Write(p []byte) (n int, err error) {
-	if w.limit == 0 {
-		return len(p), nil
-	}
-	if len(p) > w.limit {
-		n, err = w.w.Write(p[:w.limit])
-	} else {
-		n, err = w.w.Write(p)
-	}
-	w.limit -= n
-	return n, err
-}
@@ -0,0 +1,458 @@
+package blksubscriber_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveIPC answers eth_blockNumber, eth_getBlockByNumber and eth_subscribe,
+// pushing a new head for every block number sent into heads.
+func serveIPC(t *testing.T, listener net.Listener, heads <-chan int64) {
+	t.Helper()
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var writeLock sync.Mutex
+	write := func(msg any) {
+		data, _ := json.Marshal(msg)
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		_, _ = conn.Write(data)
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []any           `json:"params"`
+		}
+		if err = decoder.Decode(&req); err != nil {
+			return
+		}
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_blockNumber":
+			resp["result"] = "0x5"
+		case "eth_getBlockByNumber":
+			resp["result"] = map[string]any{"number": req.Params[0], "transactions": []any{}}
+		case "eth_subscribe":
+			resp["result"] = "0x1"
+			go func() {
+				for head := range heads {
+					write(map[string]any{
+						"jsonrpc": "2.0",
+						"method":  "eth_subscription",
+						"params":  map[string]any{"subscription": "0x1", "result": map[string]any{"number": fmt.Sprintf("0x%x", head)}},
+					})
+				}
+			}()
+		default:
+			resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+		}
+		write(resp)
+	}
+}
+
+func TestIPCSubscription(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	heads := make(chan int64, 10)
+	go serveIPC(t, listener, heads)
+
+	// Pooling period is far longer than the test, so blocks can only come from pushed heads
+	sub, err := blksubscriber.New[types.Block](path, blksubscriber.WithPoolingPeriod(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	heads <- 6
+	heads <- 7
+	for expected := int64(5); expected <= 7; expected++ {
+		select {
+		case blk := <-sub.GetBlockChan():
+			require.NotNil(t, blk, sub.LastError())
+			assert.Equal(t, expected, blk.Number.AsBigInt().Int64())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("block %d has not been received", expected)
+		}
+	}
+	assert.Eventually(t, func() bool {
+		current := sub.GetCurrentBlock()
+		return current.Cmp(big.NewInt(8)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = blksubscriber.New[types.Block]("ipc://")
+	assert.Error(t, err)
+}
+
+func TestCredentialsRedacted(t *testing.T) {
+	t.Parallel()
+	const (
+		pathKey   = "0123456789abcdef0123456789abcdef"
+		headerKey = "tatum-api-key-value"
+	)
+	// Node that echoes credentials back, as some providers do in their error messages
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Api-Key", r.Header.Get("X-Api-Key"))
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"invalid project id %s, key %s"}}`,
+			r.URL.Path, r.Header.Get("X-Api-Key"))
+	}))
+	defer srv.Close()
+
+	var dump bytes.Buffer
+	sub, err := blksubscriber.New[types.Block](
+		srv.URL+"/v3/"+pathKey,
+		blksubscriber.WithHeader("X-Api-Key", headerKey),
+		blksubscriber.WithDebugLog(&dump),
+	)
+	require.NoError(t, err)
+	defer sub.Stop()
+
+	err = sub.Start()
+	require.Error(t, err)
+	assert.True(t, blksubscriber.IsUnauthorized(err), err)
+	for _, out := range []string{err.Error(), dump.String()} {
+		assert.NotContains(t, out, pathKey)
+		assert.NotContains(t, out, headerKey)
+	}
+	// Chain ID is the first thing checked on start
+	assert.Contains(t, dump.String(), "eth_chainId")
+	assert.Contains(t, dump.String(), "X-Api-Key: ****")
+}
+
+func TestRateLimitedPollingRecovers(t *testing.T) {
+	t.Parallel()
+	var lock sync.Mutex
+	throttled := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []any           `json:"params"`
+		}
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		throttle := req.Method == "eth_getBlockByNumber" && req.Params[0] == "0x2" && !throttled
+		if throttle {
+			throttled = true
+		}
+		lock.Unlock()
+		if throttle {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_blockNumber":
+			resp["result"] = "0x4"
+		case "eth_chainId":
+			resp["result"] = "0x1"
+		default:
+			resp["result"] = map[string]any{"number": req.Params[0], "transactions": []any{}}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	limiter := rpc.NewLimiter(1000, rpc.CostTable{Default: 1})
+	sub, err := blksubscriber.New[types.Block](srv.URL,
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithEndBlock(big.NewInt(3)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+		blksubscriber.WithRateLimiter(limiter),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	for expected := int64(1); expected <= 3; expected++ {
+		select {
+		case blk := <-sub.GetBlockChan():
+			require.NotNil(t, blk, sub.LastError())
+			assert.Equal(t, expected, blk.Number.AsBigInt().Int64())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("block %d has not been received", expected)
+		}
+	}
+	// Throttling is recorded by the limiter, while the error is cleared once blocks come again
+	stats, ok := sub.RateLimitStats()
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), stats.Throttled)
+	assert.NoError(t, sub.LastError())
+}
+
+// receiveBlocks reads count blocks from the subscriber, failing the test if they do not come in time
+func receiveBlocks[T types.BlockType](t *testing.T, sub *blksubscriber.Subscriber[T], count int) []*T {
+	t.Helper()
+	out := make([]*T, 0, count)
+	for len(out) < count {
+		select {
+		case blk := <-sub.GetBlockChan():
+			require.NotNil(t, blk, "subscriber stopped: %v", sub.LastError())
+			out = append(out, blk)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("block %d of %d has not been received", len(out)+1, count)
+		}
+	}
+	return out
+}
+
+func waitStopped[T types.BlockType](t *testing.T, sub *blksubscriber.Subscriber[T]) {
+	t.Helper()
+	select {
+	case blk, ok := <-sub.GetBlockChan():
+		require.False(t, ok, "unexpected block %v", blk)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber has not stopped")
+	}
+	assert.Eventually(t, func() bool { return !sub.IsRunning() }, time.Second, 10*time.Millisecond)
+}
+
+func TestPollingFollowsChain(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(2)
+
+	sub, err := blksubscriber.New[types.BlockDetailed](node.URL(),
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	blocks := receiveBlocks(t, sub, 2)
+	assert.Equal(t, node.Block(1).Hash, blocks[0].Hash)
+	assert.Equal(t, blocks[0].Hash, blocks[1].ParentHash)
+
+	mined := node.AppendBlock(ethtest.Tx{Value: 7})
+	blocks = receiveBlocks(t, sub, 1)
+	assert.Equal(t, mined.Hash, blocks[0].Hash)
+	require.Len(t, blocks[0].Transactions, 1)
+	assert.Equal(t, int64(7), blocks[0].Transactions[0].Value.AsBigInt().Int64())
+
+	// Blocks already delivered are not sent again, new ones come from the new fork
+	reorged := node.Reorg(1)
+	node.AppendBlock()
+	blocks = receiveBlocks(t, sub, 1)
+	assert.Equal(t, int64(4), blocks[0].Number.AsBigInt().Int64())
+	assert.Equal(t, reorged[0].Hash, blocks[0].ParentHash)
+	assert.NoError(t, sub.LastError())
+}
+
+func TestReorgDepth(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(3)
+
+	sub, err := blksubscriber.New[types.BlockDetailed](node.URL(),
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+		blksubscriber.WithReorgDepth(4),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	receiveBlocks(t, sub, 3)
+
+	// Blocks of the new fork are sent from the first replaced one
+	reorged := node.Reorg(2, ethtest.Tx{Value: 3})
+	node.AppendBlock()
+	blocks := receiveBlocks(t, sub, 3)
+	assert.Equal(t, []int64{2, 3, 4}, []int64{blocks[0].Number.AsBigInt().Int64(), blocks[1].Number.AsBigInt().Int64(), blocks[2].Number.AsBigInt().Int64()})
+	assert.Equal(t, reorged[0].Hash, blocks[0].Hash)
+	require.Len(t, blocks[0].Transactions, 1)
+	assert.Equal(t, blocks[1].Hash, blocks[2].ParentHash)
+	assert.Equal(t, uint64(1), sub.Reorgs())
+	assert.NoError(t, sub.LastError())
+
+	t.Run("TooDeep", func(t *testing.T) {
+		node := ethtest.NewNode(t)
+		node.AppendBlocks(3)
+		sub, err := blksubscriber.New[types.Block](node.URL(),
+			blksubscriber.WithStartBlock(big.NewInt(1)),
+			blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+			blksubscriber.WithReorgDepth(1),
+		)
+		require.NoError(t, err)
+		require.NoError(t, sub.Start())
+		defer sub.Stop()
+		receiveBlocks(t, sub, 3)
+
+		node.Reorg(2)
+		node.AppendBlock()
+		waitStopped(t, sub)
+		var tooDeep *blksubscriber.ReorgTooDeepError
+		assert.ErrorAs(t, sub.LastError(), &tooDeep)
+	})
+}
+
+func TestPollingEndBlockAtHead(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(3)
+
+	sub, err := blksubscriber.New[types.Block](node.URL(),
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithEndBlock(big.NewInt(3)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	blocks := receiveBlocks(t, sub, 3)
+	assert.Equal(t, int64(3), blocks[2].Number.AsBigInt().Int64())
+	waitStopped(t, sub)
+	assert.NoError(t, sub.LastError())
+}
+
+func TestPollingFailures(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RPCErrorStops", func(t *testing.T) {
+		t.Parallel()
+		node := ethtest.NewNode(t)
+		node.AppendBlocks(3)
+		node.FailNext("eth_getBlockByNumber", 1, rpc.CodeInternalError, "database corrupted")
+
+		sub, err := blksubscriber.New[types.Block](node.URL(),
+			blksubscriber.WithStartBlock(big.NewInt(1)),
+			blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+		require.NoError(t, sub.Start())
+		defer sub.Stop()
+
+		waitStopped(t, sub)
+		assert.ErrorContains(t, sub.LastError(), "database corrupted")
+		var rpcErr *blksubscriber.RPCError
+		assert.ErrorAs(t, sub.LastError(), &rpcErr)
+	})
+
+	t.Run("LaggingNodeRetried", func(t *testing.T) {
+		t.Parallel()
+		node := ethtest.NewNode(t)
+		node.AppendBlocks(2)
+		node.ReturnNull("eth_getBlockByNumber", 2)
+
+		sub, err := blksubscriber.New[types.Block](node.URL(),
+			blksubscriber.WithStartBlock(big.NewInt(1)),
+			blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+		require.NoError(t, sub.Start())
+		defer sub.Stop()
+
+		receiveBlocks(t, sub, 2)
+		assert.Equal(t, 4, node.Calls("eth_getBlockByNumber"))
+		assert.NoError(t, sub.LastError())
+	})
+
+	t.Run("ThrottledRetried", func(t *testing.T) {
+		t.Parallel()
+		node := ethtest.NewNode(t)
+		node.AppendBlocks(2)
+
+		sub, err := blksubscriber.New[types.Block](node.URL(),
+			blksubscriber.WithStartBlock(big.NewInt(1)),
+			blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+		require.NoError(t, sub.Start())
+		defer sub.Stop()
+
+		node.RateLimit(3)
+		receiveBlocks(t, sub, 2)
+		node.AppendBlock()
+		receiveBlocks(t, sub, 1)
+		assert.NoError(t, sub.LastError())
+	})
+
+	t.Run("SlowNodeTimesOut", func(t *testing.T) {
+		t.Parallel()
+		node := ethtest.NewNode(t)
+		node.SetLatency(time.Second)
+
+		sub, err := blksubscriber.New[types.Block](node.URL(), blksubscriber.WithRequestTimeout(50*time.Millisecond))
+		require.NoError(t, err)
+		defer sub.Stop()
+
+		start := time.Now()
+		err = sub.Start()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("UnauthorizedOnStart", func(t *testing.T) {
+		t.Parallel()
+		node := ethtest.NewNode(t)
+		node.FailHTTP(ethtest.AnyMethod, 1, http.StatusUnauthorized)
+
+		sub, err := blksubscriber.New[types.Block](node.URL())
+		require.NoError(t, err)
+		defer sub.Stop()
+		err = sub.Start()
+		assert.True(t, blksubscriber.IsUnauthorized(err), err)
+		assert.False(t, sub.IsRunning())
+	})
+}
+
+func TestChainID(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t, ethtest.WithChainID(8453))
+	node.AppendBlocks(2)
+
+	mismatch, err := blksubscriber.New[types.Block](node.URL(), blksubscriber.WithChainID(big.NewInt(11155111)))
+	require.NoError(t, err)
+	defer mismatch.Stop()
+	err = mismatch.Start()
+	var chainErr *blksubscriber.ChainMismatchError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, int64(8453), chainErr.Actual.Int64())
+	assert.False(t, mismatch.IsRunning())
+	assert.Equal(t, 0, node.Calls("eth_blockNumber"))
+
+	sub, err := blksubscriber.New[types.BlockDetailed](node.URL(),
+		blksubscriber.WithChainID(big.NewInt(8453)),
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	assert.Equal(t, int64(8453), sub.ChainID().Int64())
+	for _, blk := range receiveBlocks(t, sub, 2) {
+		require.NotNil(t, blk.ChainID)
+		assert.Equal(t, int64(8453), blk.ChainID.AsBigInt().Int64())
+	}
+}
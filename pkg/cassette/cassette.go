@@ -0,0 +1,402 @@
+// Package cassette records HTTP exchanges with a node into a file and serves them back.
+// Recorder and Replayer implement the same Do method as http.Client, so they plug into WithHTTPClient options,
+// which makes a session captured against a real endpoint reproducible without it, e.g. for bug reports and tests.
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/redact"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type (
+	// Interaction is a single request and its response, cassette files hold one per line
+	Interaction struct {
+		Request  Request  `json:"request"`
+		Response Response `json:"response"`
+	}
+
+	Request struct {
+		Method string      `json:"method"`
+		URL    string      `json:"url"`
+		Header http.Header `json:"header,omitempty"`
+		Body   Body        `json:"body"`
+	}
+
+	Response struct {
+		Status int         `json:"status,omitempty"`
+		Header http.Header `json:"header,omitempty"`
+		Body   Body        `json:"body"`
+		// Error is set when request failed without response, e.g. connection was refused
+		Error string `json:"error,omitempty"`
+	}
+
+	// Body keeps JSON payloads as they are, to make cassettes readable and diffable, and anything else as text
+	Body struct {
+		JSON json.RawMessage `json:"json,omitempty"`
+		Text string          `json:"text,omitempty"`
+	}
+)
+
+func newBody(data []byte) Body {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return Body{}
+	}
+	if json.Valid(data) {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err == nil {
+			return Body{JSON: compact.Bytes()}
+		}
+	}
+	return Body{Text: string(data)}
+}
+
+func (b Body) Bytes() []byte {
+	if len(b.JSON) != 0 {
+		return b.JSON
+	}
+	return []byte(b.Text)
+}
+
+// Recorder passes requests to the underlying client and writes every exchange into the cassette.
+// Credentials known to the redactor are masked before they get into the file.
+type Recorder struct {
+	client   httpClient
+	redactor *redact.Redactor
+	out      io.Writer
+	closer   io.Closer
+	lock     sync.Mutex
+	err      error
+}
+
+// NewRecorder creates recorder writing into w, client and redactor can be nil
+func NewRecorder(client httpClient, w io.Writer, redactor *redact.Redactor) *Recorder {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if redactor == nil {
+		redactor = redact.New()
+	}
+	return &Recorder{
+		client:   client,
+		redactor: redactor,
+		out:      w,
+	}
+}
+
+// Create creates cassette file at path, truncating it if it exists
+func Create(path string, client httpClient, redactor *redact.Redactor) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cassette")
+	}
+	out := NewRecorder(client, bufio.NewWriter(f), redactor)
+	out.closer = f
+	return out, nil
+}
+
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	interaction := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    r.redactor.String(req.URL.String()),
+			Header: r.redactor.Header(req.Header),
+			Body:   newBody([]byte(r.redactor.String(string(reqBody)))),
+		},
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		interaction.Response.Error = r.redactor.String(err.Error())
+		r.write(interaction)
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	interaction.Response.Status = resp.StatusCode
+	interaction.Response.Header = r.redactor.Header(resp.Header)
+	interaction.Response.Body = newBody([]byte(r.redactor.String(string(respBody))))
+	r.write(interaction)
+	return resp, err
+}
+
+func (r *Recorder) write(interaction *Interaction) {
+	line, err := json.Marshal(interaction)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err == nil {
+		_, err = r.out.Write(append(line, '\n'))
+	}
+	if err == nil {
+		if flusher, ok := r.out.(interface{ Flush() error }); ok {
+			// Keep the file complete even if the process is killed in the middle of a session
+			err = flusher.Flush()
+		}
+	}
+	r.err = err
+}
+
+// Err returns first error that happened writing the cassette, recording stops after it
+func (r *Recorder) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return errors.Wrap(r.err, "failed to write cassette")
+}
+
+// Close closes cassette file created by Create and returns Err
+func (r *Recorder) Close() error {
+	err := r.Err()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "failed to close cassette")
+		}
+	}
+	return err
+}
+
+// Replayer answers requests with recorded responses, it never touches the network.
+//
+// Requests are matched by HTTP method and JSON-RPC calls in the body, ignoring ids, URL and headers,
+// so that sessions recorded with a key replay without it. Responses get ids of the request they answer.
+// Recorded responses to the same calls are served in order they were recorded, after the last one
+// it is served again, e.g. polled eth_blockNumber keeps returning the head the session ended at.
+type Replayer struct {
+	lock         sync.Mutex
+	interactions []*Interaction
+	keys         []string
+	served       []bool
+}
+
+// Load reads cassette file
+func Load(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open cassette")
+	}
+	defer f.Close()
+	out, err := NewReplayer(f)
+	return out, errors.Wrap(err, path)
+}
+
+// NewReplayer reads cassette from r
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	out := &Replayer{}
+	scanner := bufio.NewScanner(r)
+	// Responses with detailed blocks easily exceed default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		interaction := new(Interaction)
+		if err := json.Unmarshal(line, interaction); err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		out.interactions = append(out.interactions, interaction)
+		out.keys = append(out.keys, requestKey(interaction.Request.Method, interaction.Request.Body.Bytes()))
+		out.served = append(out.served, false)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read cassette")
+	}
+	return out, nil
+}
+
+// Interactions returns number of recorded interactions
+func (r *Replayer) Interactions() int {
+	return len(r.interactions)
+}
+
+// Unserved returns number of recorded interactions that have not been served yet
+func (r *Replayer) Unserved() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var out int
+	for _, served := range r.served {
+		if !served {
+			out++
+		}
+	}
+	return out
+}
+
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+	}
+	key := requestKey(req.Method, reqBody)
+	interaction := r.match(key)
+	if interaction == nil {
+		return nil, errors.Errorf("cassette has no response to %s", key)
+	}
+	if interaction.Response.Error != "" {
+		return nil, errors.New(interaction.Response.Error)
+	}
+
+	body := interaction.Response.Body.Bytes()
+	if len(interaction.Response.Body.JSON) != 0 {
+		body = rewriteIDs(body, idMapping(interaction.Request.Body.Bytes(), reqBody))
+	}
+	header := interaction.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// match returns first unserved interaction with the key, or the last served one when all have been served
+func (r *Replayer) match(key string) *Interaction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	last := -1
+	for i, candidate := range r.keys {
+		if candidate != key {
+			continue
+		}
+		if !r.served[i] {
+			r.served[i] = true
+			return r.interactions[i]
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil
+	}
+	return r.interactions[last]
+}
+
+type rpcCall struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// parseCalls decodes single JSON-RPC call or a batch, ok is false if body is not JSON-RPC
+func parseCalls(body []byte) (calls []rpcCall, batch bool, ok bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, false, false
+	}
+	if trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &calls); err != nil {
+			return nil, false, false
+		}
+		return calls, true, true
+	}
+	var call rpcCall
+	if err := json.Unmarshal(trimmed, &call); err != nil || call.Method == "" {
+		return nil, false, false
+	}
+	return []rpcCall{call}, false, true
+}
+
+// requestKey identifies request by its calls without ids, bodies that are not JSON-RPC are compared as they are
+func requestKey(method string, body []byte) string {
+	calls, batch, ok := parseCalls(body)
+	if !ok {
+		return method + " " + string(bytes.TrimSpace(body))
+	}
+	parts := make([]string, len(calls))
+	for i, call := range calls {
+		params := newBody(call.Params)
+		parts[i] = call.Method + string(params.Bytes())
+	}
+	if batch {
+		return method + " [" + strings.Join(parts, ",") + "]"
+	}
+	return method + " " + parts[0]
+}
+
+// idMapping maps ids of recorded calls to ids of the calls being answered, position by position
+func idMapping(recorded, current []byte) map[string]json.RawMessage {
+	recordedCalls, _, ok := parseCalls(recorded)
+	if !ok {
+		return nil
+	}
+	currentCalls, _, ok := parseCalls(current)
+	if !ok || len(currentCalls) != len(recordedCalls) {
+		return nil
+	}
+	out := make(map[string]json.RawMessage, len(recordedCalls))
+	for i, call := range recordedCalls {
+		out[string(call.ID)] = currentCalls[i].ID
+	}
+	return out
+}
+
+// rewriteIDs replaces ids in JSON-RPC response or batch of responses, other fields are kept as they are
+func rewriteIDs(body []byte, ids map[string]json.RawMessage) []byte {
+	if len(ids) == 0 {
+		return body
+	}
+	rewrite := func(msg map[string]json.RawMessage) {
+		if id, ok := ids[string(msg["id"])]; ok {
+			msg["id"] = id
+		}
+	}
+	var out []byte
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) != 0 && trimmed[0] == '[' {
+		var messages []map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &messages) != nil {
+			return body
+		}
+		for _, msg := range messages {
+			rewrite(msg)
+		}
+		out, err = json.Marshal(messages)
+	} else {
+		var msg map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &msg) != nil {
+			return body
+		}
+		rewrite(msg)
+		out, err = json.Marshal(msg)
+	}
+	if err != nil {
+		return body
+	}
+	return out
+}
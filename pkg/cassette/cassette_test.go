@@ -0,0 +1,213 @@
+package cassette_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/cassette"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/redact"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/subscriber"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	wallet       = "0xc940323bdacd868c319e9039ea5fddd35745e62d"
+	feeRecipient = "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+)
+
+func address(t *testing.T, hex string) types.EthAddress {
+	var out types.EthAddress
+	require.NoError(t, out.UnmarshalJSON([]byte(`"`+hex+`"`)))
+	return out
+}
+
+func collectBlocks(t *testing.T, endpoint string, client blksubscriber.Option) []*types.BlockDetailed {
+	sub, err := blksubscriber.New[types.BlockDetailed](endpoint, client,
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithEndBlock(big.NewInt(3)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	var out []*types.BlockDetailed
+	for blk := range sub.GetBlockChan() {
+		out = append(out, blk)
+	}
+	require.NoError(t, sub.LastError())
+	return out
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+	const key = "0123456789abcdef0123456789abcdef"
+	node := ethtest.NewNode(t)
+	node.AppendBlock(ethtest.Tx{To: address(t, wallet), Value: 1})
+	node.AppendBlocks(2)
+
+	redactor := redact.New()
+	redactor.AddURL(node.URL() + "/v3/" + key)
+	var tape bytes.Buffer
+	recorder := cassette.NewRecorder(nil, &tape, redactor)
+	recorded := collectBlocks(t, node.URL()+"/v3/"+key, blksubscriber.WithHTTPClient(recorder))
+	require.NoError(t, recorder.Close())
+	require.Len(t, recorded, 3)
+	assert.NotContains(t, tape.String(), key)
+
+	replayer, err := cassette.NewReplayer(bytes.NewReader(tape.Bytes()))
+	require.NoError(t, err)
+	calls := node.Calls(ethtest.AnyMethod)
+	// Endpoint does not matter for replay, nothing listens on it
+	replayed := collectBlocks(t, "http://127.0.0.1:1/", blksubscriber.WithHTTPClient(replayer))
+	assert.Equal(t, recorded, replayed)
+	assert.Equal(t, calls, node.Calls(ethtest.AnyMethod))
+	assert.Equal(t, 0, replayer.Unserved())
+}
+
+func TestReplayerMatching(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(2)
+
+	var tape bytes.Buffer
+	recorder := cassette.NewRecorder(nil, &tape, nil)
+	client, err := rpc.Dial(ctx, node.URL(), rpc.WithHTTPClient(recorder))
+	require.NoError(t, err)
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	node.AppendBlock()
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	var chainID, balance string
+	require.NoError(t, client.BatchCall(ctx, []rpc.BatchElem{
+		{Method: "eth_chainId", Result: &chainID},
+		{Method: "eth_getBalance", Params: []any{wallet, "latest"}, Result: &balance},
+	}))
+	node.FailHTTP("eth_getBlockByNumber", 1, http.StatusBadGateway)
+	_, err = rpc.GetBlockByNumber[types.Block](ctx, client, rpc.Latest)
+	require.Error(t, err)
+	require.NoError(t, client.Close())
+
+	replayer, err := cassette.NewReplayer(&tape)
+	require.NoError(t, err)
+	assert.Equal(t, 4, replayer.Interactions())
+	replay, err := rpc.Dial(ctx, "http://127.0.0.1:1/", rpc.WithHTTPClient(replayer))
+	require.NoError(t, err)
+	defer replay.Close()
+
+	// Ids differ from recorded ones, responses get them rewritten
+	_, err = replay.ChainID(ctx)
+	assert.ErrorContains(t, err, "cassette has no response to POST eth_chainId[]")
+	var replayedChainID, replayedBalance string
+	batch := []rpc.BatchElem{
+		{Method: "eth_chainId", Result: &replayedChainID},
+		{Method: "eth_getBalance", Params: []any{wallet, "latest"}, Result: &replayedBalance},
+	}
+	require.NoError(t, replay.BatchCall(ctx, batch))
+	require.NoError(t, batch[0].Error)
+	assert.Equal(t, chainID, replayedChainID)
+	assert.Equal(t, balance, replayedBalance)
+
+	// Responses are served in recorded order, the last one is repeated
+	for _, expected := range []int64{2, 3, 3} {
+		head, err := replay.BlockNumber(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, expected, head.Int64())
+	}
+
+	_, err = rpc.GetBlockByNumber[types.Block](ctx, replay, rpc.Latest)
+	var rpcErr *rpc.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, http.StatusBadGateway, rpcErr.HTTPStatus)
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	recorder, err := cassette.Create(path, nil, nil)
+	require.NoError(t, err)
+	client, err := rpc.Dial(context.Background(), node.URL(), rpc.WithHTTPClient(recorder))
+	require.NoError(t, err)
+	_, err = client.BlockNumber(context.Background())
+	require.NoError(t, err)
+
+	// Every interaction is flushed as soon as it is recorded
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	require.NoError(t, recorder.Close())
+
+	replayer, err := cassette.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayer.Interactions())
+
+	_, err = cassette.Load(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.Error(t, err)
+}
+
+// recordSession captures a session with block rewards and wallet transactions into the cassette
+func recordSession(t *testing.T, path string) {
+	node := ethtest.NewNode(t)
+	node.SetMiner(address(t, feeRecipient))
+	node.AppendBlock(
+		ethtest.Tx{From: address(t, wallet), To: address(t, feeRecipient), Value: 1_000_000, GasPrice: 3_000_000_000},
+		ethtest.Tx{From: address(t, feeRecipient), To: address(t, feeRecipient), Value: 5},
+	)
+	node.AppendBlock()
+	node.AppendBlock(ethtest.Tx{From: address(t, feeRecipient), To: address(t, wallet), Value: 2, MaxFeePerGas: 5_000_000_000, MaxPriorityFeePerGas: 2_000_000_000, GasUsed: 18000})
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	recorder, err := cassette.Create(path, nil, nil)
+	require.NoError(t, err)
+	runSession(t, node.URL(), subscriber.WithHTTPClient(recorder))
+	require.NoError(t, recorder.Close())
+}
+
+func runSession(t *testing.T, endpoint string, client subscriber.Option) []any {
+	sub, err := subscriber.NewChanSubscriber(endpoint, client,
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithEndBlock(big.NewInt(3)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet)
+	sub.SubscribeBlockRewards(feeRecipient)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	var out []any
+	for tx := range sub.GetTransactionChan() {
+		out = append(out, tx)
+	}
+	for reward := range sub.GetBlockRewardChan() {
+		out = append(out, reward)
+	}
+	require.NoError(t, sub.LastError())
+	return out
+}
+
+func TestSessionGolden(t *testing.T) {
+	t.Parallel()
+	const path = "testdata/session.jsonl"
+	if *ethtest.Update {
+		recordSession(t, path)
+	}
+	replayer, err := cassette.Load(path)
+	require.NoError(t, err)
+	records := runSession(t, "http://127.0.0.1:1/", subscriber.WithHTTPClient(replayer))
+	ethtest.AssertGolden(t, "testdata/session.golden.json", records)
+}
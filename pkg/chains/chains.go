@@ -0,0 +1,159 @@
+// Package chains describes networks ethscan knows, so that endpoints can be checked against the expected one
+// and per-network defaults, like polling period and fee market parameters, do not have to be set by hand.
+package chains
+
+import (
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Chain describes network served by an endpoint
+type Chain struct {
+	Name string
+	ID   int64
+	// BlockTime is the average time between blocks, it is used as default polling period
+	BlockTime time.Duration
+	// FinalityDepth is approximate number of blocks after which a block is not expected to be reorged,
+	// subscribers of the chain without finality tags take blocks that deep as finalized
+	FinalityDepth uint64
+	// ReorgDepth is how deep reorgs subscribers of the chain follow. Rollups reorg only with L1 or after a sequencer
+	// failure, so it stays near the L1 one however long their finality takes.
+	ReorgDepth uint64
+	// ElasticityMultiplier and BaseFeeChangeDenominator are EIP-1559 parameters, L2s tune them
+	ElasticityMultiplier     uint64
+	BaseFeeChangeDenominator uint64
+	// Blobs are blob gas parameters of chains carrying EIP-4844 blob transactions, zero on the others
+	Blobs BlobSchedule
+	// FeeRecipientRewards is set when block miner is the proposer paid priority fees.
+	// On rollups it is a sequencer fee vault and on Polygon it is zero, so proposed block rewards make no sense there.
+	FeeRecipientRewards bool
+	// SystemTxTypes are transaction types created by the protocol rather than signed by users,
+	// e.g. OP stack deposits, they pay no priority fees
+	SystemTxTypes []uint64
+}
+
+// BlobSchedule holds EIP-4844 blob gas parameters of a chain, they are changed by forks, see EIP-7840
+type BlobSchedule struct {
+	TargetBlobGasPerBlock     uint64
+	MaxBlobGasPerBlock        uint64
+	BlobBaseFeeUpdateFraction uint64
+}
+
+var (
+	// pragueBlobs are parameters of the Prague/Electra fork, 6 blobs target and 9 max
+	pragueBlobs = BlobSchedule{TargetBlobGasPerBlock: 786432, MaxBlobGasPerBlock: 1179648, BlobBaseFeeUpdateFraction: 5007716}
+	// opStackSystemTxTypes is deposit transaction type
+	opStackSystemTxTypes = []uint64{types.DepositTxType}
+	// arbitrumSystemTxTypes are deposit, unsigned, contract, retry, submit retryable and internal transaction types
+	arbitrumSystemTxTypes = []uint64{0x64, 0x65, 0x66, 0x68, 0x69, 0x6a}
+)
+
+// Presets are networks known by name
+var Presets = map[string]Chain{
+	"mainnet": {
+		Name: "mainnet", ID: 1, BlockTime: 12 * time.Second, FinalityDepth: 64, ReorgDepth: 64,
+		ElasticityMultiplier: 2, BaseFeeChangeDenominator: 8, Blobs: pragueBlobs, FeeRecipientRewards: true,
+	},
+	"sepolia": {
+		Name: "sepolia", ID: 11155111, BlockTime: 12 * time.Second, FinalityDepth: 64, ReorgDepth: 64,
+		ElasticityMultiplier: 2, BaseFeeChangeDenominator: 8, Blobs: pragueBlobs, FeeRecipientRewards: true,
+	},
+	"holesky": {
+		Name: "holesky", ID: 17000, BlockTime: 12 * time.Second, FinalityDepth: 64, ReorgDepth: 64,
+		ElasticityMultiplier: 2, BaseFeeChangeDenominator: 8, Blobs: pragueBlobs, FeeRecipientRewards: true,
+	},
+	// OP stack blocks are final once the batch containing them is final on L1
+	"base": {
+		Name: "base", ID: 8453, BlockTime: 2 * time.Second, FinalityDepth: 450, ReorgDepth: 64,
+		ElasticityMultiplier: 6, BaseFeeChangeDenominator: 250, SystemTxTypes: opStackSystemTxTypes,
+	},
+	"optimism": {
+		Name: "optimism", ID: 10, BlockTime: 2 * time.Second, FinalityDepth: 450, ReorgDepth: 64,
+		ElasticityMultiplier: 6, BaseFeeChangeDenominator: 250, SystemTxTypes: opStackSystemTxTypes,
+	},
+	// ArbOS sets base fee itself, it does not follow EIP-1559 update rule
+	"arbitrum": {
+		Name: "arbitrum", ID: 42161, BlockTime: 250 * time.Millisecond, FinalityDepth: 3600, ReorgDepth: 64,
+		SystemTxTypes: arbitrumSystemTxTypes,
+	},
+	"polygon": {
+		Name: "polygon", ID: 137, BlockTime: 2 * time.Second, FinalityDepth: 16, ReorgDepth: 16,
+		ElasticityMultiplier: 2, BaseFeeChangeDenominator: 64,
+	},
+}
+
+// Names returns sorted preset names
+func Names() []string {
+	out := make([]string, 0, len(Presets))
+	for name := range Presets {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ByID returns preset of the chain, ok is false if it is not known
+func ByID(id int64) (Chain, bool) {
+	for _, chain := range Presets {
+		if chain.ID == id {
+			return chain, true
+		}
+	}
+	return Chain{}, false
+}
+
+// Parse takes preset name, case-insensitive, or decimal or 0x prefixed hex chain ID.
+// Unknown IDs give chain without preset defaults, it is only good for verification.
+func Parse(val string) (Chain, error) {
+	if chain, ok := Presets[strings.ToLower(val)]; ok {
+		return chain, nil
+	}
+	var id *big.Int
+	var ok bool
+	if strings.HasPrefix(val, "0x") {
+		id, ok = new(big.Int).SetString(strings.TrimPrefix(val, "0x"), 16)
+	} else {
+		id, ok = new(big.Int).SetString(val, 10)
+	}
+	if !ok || id.Sign() <= 0 || !id.IsInt64() {
+		return Chain{}, errors.Errorf("unknown chain %q, expected chain ID or one of: %s", val, strings.Join(Names(), ", "))
+	}
+	if chain, ok := ByID(id.Int64()); ok {
+		return chain, nil
+	}
+	return Chain{ID: id.Int64()}, nil
+}
+
+// String returns chain name, or its ID if it is not a preset
+func (c Chain) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return big.NewInt(c.ID).String()
+}
+
+// BigID returns chain ID as big.Int
+func (c Chain) BigID() *big.Int {
+	return big.NewInt(c.ID)
+}
+
+// FeeParams returns fee market parameters of the chain, unknown chains get processors.DefaultFeeParams
+func (c Chain) FeeParams() processors.FeeParams {
+	out := processors.DefaultFeeParams()
+	if c.Name == "" {
+		return out
+	}
+	out.ElasticityMultiplier = c.ElasticityMultiplier
+	out.BaseFeeChangeDenominator = c.BaseFeeChangeDenominator
+	out.TargetBlobGasPerBlock = c.Blobs.TargetBlobGasPerBlock
+	out.MaxBlobGasPerBlock = c.Blobs.MaxBlobGasPerBlock
+	out.BlobBaseFeeUpdateFraction = c.Blobs.BlobBaseFeeUpdateFraction
+	out.SystemTxTypes = c.SystemTxTypes
+	return out
+}
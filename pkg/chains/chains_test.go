@@ -0,0 +1,72 @@
+package chains_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/chains"
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		in   string
+		name string
+		id   int64
+	}{
+		{in: "mainnet", name: "mainnet", id: 1},
+		{in: "Sepolia", name: "sepolia", id: 11155111},
+		{in: "8453", name: "base", id: 8453},
+		{in: "0xa", name: "optimism", id: 10},
+		{in: "0xa4b1", name: "arbitrum", id: 42161},
+		{in: "31337", id: 31337},
+	} {
+		chain, err := chains.Parse(tc.in)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.name, chain.Name, tc.in)
+		assert.Equal(t, tc.id, chain.ID, tc.in)
+	}
+	for _, in := range []string{"", "goerli", "0", "-1", "0xzz", "99999999999999999999"} {
+		_, err := chains.Parse(in)
+		assert.Error(t, err, in)
+	}
+
+	unknown, err := chains.Parse("31337")
+	require.NoError(t, err)
+	assert.Equal(t, "31337", unknown.String())
+	assert.Equal(t, processors.DefaultFeeParams(), unknown.FeeParams())
+}
+
+func TestPresets(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"arbitrum", "base", "holesky", "mainnet", "optimism", "polygon", "sepolia"}, chains.Names())
+	for name, chain := range chains.Presets {
+		assert.Equal(t, name, chain.Name)
+		assert.NotZero(t, chain.BlockTime, name)
+		assert.NotZero(t, chain.FinalityDepth, name)
+		assert.NotZero(t, chain.ReorgDepth, name)
+		assert.LessOrEqual(t, chain.ReorgDepth, chain.FinalityDepth, name)
+		byID, ok := chains.ByID(chain.ID)
+		assert.True(t, ok, name)
+		assert.Equal(t, name, byID.Name)
+	}
+
+	mainnet := chains.Presets["mainnet"].FeeParams()
+	assert.Equal(t, uint64(2), mainnet.ElasticityMultiplier)
+	assert.Equal(t, uint64(786432), mainnet.TargetBlobGasPerBlock)
+	assert.Equal(t, uint64(1179648), mainnet.MaxBlobGasPerBlock)
+	assert.Equal(t, uint64(5007716), mainnet.BlobBaseFeeUpdateFraction)
+	assert.Zero(t, processors.DefaultFeeParams().MaxBlobGasPerBlock)
+
+	base := chains.Presets["base"]
+	assert.Equal(t, 2*time.Second, base.BlockTime)
+	params := base.FeeParams()
+	assert.Equal(t, uint64(6), params.ElasticityMultiplier)
+	assert.Equal(t, uint64(250), params.BaseFeeChangeDenominator)
+	assert.Zero(t, params.MaxBlobGasPerBlock)
+	assert.Equal(t, []uint64{0x7e}, params.SystemTxTypes)
+	assert.False(t, base.FeeRecipientRewards)
+}
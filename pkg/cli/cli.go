@@ -5,12 +5,21 @@ import (
 	"flag"
 	"fmt"
 	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/cassette"
+	"github.com/dkropachev/ethscan/pkg/chains"
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/redact"
+	"github.com/dkropachev/ethscan/pkg/replay"
+	"github.com/dkropachev/ethscan/pkg/rpc"
 	subscriber2 "github.com/dkropachev/ethscan/pkg/subscriber"
+	"github.com/dkropachev/ethscan/pkg/txexport"
 	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
 	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -20,36 +29,179 @@ import (
 
 type Options struct {
 	endpoint      string
-	header        string
+	headers       stringList
+	headersEnv    stringList
+	headerFile    string
+	jwtSecretFile string
+	headerValues  http.Header
+	jwtSecret     []byte
 	startBlock    string
 	startBlockInt *big.Int
 	endBlock      string
 	endBlockInt   *big.Int
 	poolingPeriod time.Duration
 	wallets       string
+	feeRecipients string
 	quite         bool
 	target        string
+	// sensitiveHeaders extends redact.DefaultSensitiveHeaders
+	sensitiveHeaders string
+	debug            bool
+	// secrets are header values loaded from environment and header file, they are masked regardless of header name
+	secrets  []string
+	redactor *redact.Redactor
+	network  rpc.NetworkConfig
+	// rateLimit is a preset name from rpc.RateLimitPresets
+	rateLimit   string
+	cuPerSecond float64
+	limiter     *rpc.Limiter
+	// replay is a comma separated list of recorded block files to read instead of the endpoint
+	replay      string
+	replaySpeed float64
+	// record is a cassette file HTTP exchanges with the endpoint are written into
+	record   string
+	recorder *cassette.Recorder
+	// chainName is a preset name or chain ID the endpoint has to serve
+	chainName string
+	chain     *chains.Chain
+	// chainEndpoints are chain=endpoint pairs followed in one process instead of --endpoint
+	chainEndpoints stringList
+	multiChain     []chainEndpoint
+	// command is given before options, see commands; without one transactions or --target objects are printed
+	command string
+	// args are arguments left after options, files to import
+	args []string
+	// store is a durable store directory export reads transactions from and import loads them into
+	store        string
+	out          string
+	format       string
+	split        string
+	gzip         bool
+	exportFormat txexport.Format
+	exportSplit  txexport.Split
+	// wallet is the one ledger is built for
+	wallet          string
+	checkpointEvery uint64
+	// output is where ledger and transaction records are written, by default ledger goes to stdout
+	// and records to stderr
+	output io.Writer
 }
 
+// commands are known commands besides the default one
+var commands = []string{"export", "import", "ledger"}
+
+type chainEndpoint struct {
+	chain    chains.Chain
+	endpoint string
+}
+
+// Parse parses command line, the command, if any, goes before options: ethscan export --wallets ...
 func (o *Options) Parse() {
-	flag.StringVar(&o.header, "header", "", "curl-style header to send with the request. Example: --header \"Authorization: Bearer <TOKEN>\"")
-	flag.StringVar(&o.endpoint, "endpoint", "", "ethereum JSON-RPC endpoint")
+	args := os.Args[1:]
+	if len(args) != 0 && slices.Contains(commands, args[0]) {
+		o.command, args = args[0], args[1:]
+	}
+	flag.Var(&o.headers, "header", "curl-style header to send with the request, can be repeated. Example: --header \"Authorization: Bearer <TOKEN>\"")
+	flag.Var(&o.headersEnv, "header-from-env", "header which value is taken from environment variable, can be repeated. Example: --header-from-env X-Api-Key=TATUM_API_KEY")
+	flag.StringVar(&o.headerFile, "header-file", "", "file with curl-style headers, one per line")
+	flag.StringVar(&o.jwtSecretFile, "jwt-secret", "", "file with hex encoded secret to authenticate with JWT bearer token, as Engine API does")
+	flag.StringVar(&o.endpoint, "endpoint", "", "ethereum JSON-RPC endpoint: http(s)://, ws(s)://, ipc:///path/geth.ipc or bare IPC socket path")
+	flag.StringVar(&o.replay, "replay", "", "recorded blocks to process instead of querying the endpoint: JSONL files, optionally gzip compressed or packed into tar, separated by comma")
+	flag.Float64Var(&o.replaySpeed, "replay-speed", 0, "replay speed relative to block timestamps, 1 is real time, 0 is as fast as possible")
+	flag.StringVar(&o.record, "record", "", "file to record HTTP requests and responses into, with credentials masked, to attach to bug reports")
 	flag.StringVar(&o.startBlock, "start-block", "", "start block number")
 	flag.StringVar(&o.endBlock, "end-block", "", "end block number")
-	flag.DurationVar(&o.poolingPeriod, "poolingPeriod", time.Second, "pooling period")
+	flag.DurationVar(&o.poolingPeriod, "poolingPeriod", 0, "pooling period, block time of --chain or 1s by default")
+	flag.StringVar(&o.chainName, "chain", "", "chain the endpoint has to serve, chain ID or one of: "+strings.Join(chains.Names(), ", ")+". Presets also set polling period, reorg depth and fee market parameters")
+	flag.Var(&o.chainEndpoints, "chain-endpoint", "chain and its endpoint to follow in the same process instead of --endpoint, can be repeated, --wallets are watched on all of them. Example: --chain-endpoint base=https://mainnet.base.org")
 	flag.StringVar(&o.wallets, "wallets", "", "wallets to subscribe, separated by comma")
+	flag.StringVar(&o.feeRecipients, "fee-recipients", "", "fee recipients to track proposed block rewards for, separated by comma")
 	flag.BoolVar(&o.quite, "quite", false, "print out only transactions, no logs or messages")
-	flag.StringVar(&o.target, "target", "tx", "target objects to print, options: tx, block, block-detailed")
-	flag.Parse()
+	flag.StringVar(&o.target, "target", "tx", "target objects to print, options: tx, block, block-detailed, fees")
+	flag.StringVar(&o.sensitiveHeaders, "sensitive-headers", "", "extra headers, separated by comma, which values are masked in errors and debug output. Authorization, X-Api-Key and alike are always masked")
+	flag.StringVar(&o.network.CAFile, "ca-bundle", "", "PEM file with CA certificates trusted in addition to system ones")
+	flag.StringVar(&o.network.CertFile, "client-cert", "", "PEM client certificate for mTLS, requires --client-key")
+	flag.StringVar(&o.network.KeyFile, "client-key", "", "PEM client key for mTLS, requires --client-cert")
+	flag.BoolVar(&o.network.InsecureSkipVerify, "insecure-skip-verify", false, "do not verify server certificate, only meant for devnets")
+	flag.StringVar(&o.network.Proxy, "proxy", "", "http:// or https:// proxy for HTTP and WebSocket connections, HTTP_PROXY and HTTPS_PROXY are used when it is not set")
+	flag.DurationVar(&o.network.Timeout, "request-timeout", 0, "timeout of every RPC call, 0 means no timeout")
+	flag.IntVar(&o.network.MaxIdleConns, "max-idle-conns", 0, "max idle HTTP connections kept to the endpoint, 0 keeps Go default")
+	flag.StringVar(&o.rateLimit, "rate-limit", "", "limit calls to the budget of provider plan: "+strings.Join(rpc.RateLimitPresetNames(), ", "))
+	flag.Float64Var(&o.cuPerSecond, "cu-per-second", 0, "compute units per second to spend, overrides --rate-limit budget. Without --rate-limit every call costs one unit")
+	flag.BoolVar(&o.debug, "debug", false, "dump every HTTP request and response to stderr, credentials are masked")
+	flag.StringVar(&o.store, "store", "", "durable store directory: export reads transactions from it instead of scanning blocks, import loads files into it")
+	flag.StringVar(&o.out, "out", ".", "directory export writes files into")
+	flag.StringVar(&o.format, "format", "jsonl", "format of exported files and ledger: jsonl, csv")
+	flag.StringVar(&o.split, "split", "none", "how exported records are spread over files: none, address, day")
+	flag.BoolVar(&o.gzip, "gzip", false, "gzip compress exported files")
+	flag.StringVar(&o.wallet, "wallet", "", "wallet to build ledger for")
+	flag.Uint64Var(&o.checkpointEvery, "checkpoint-every", 0, "reconcile ledger balance with eth_getBalance every that many blocks, it is always reconciled at the last row")
+	_ = flag.CommandLine.Parse(args)
+	o.args = flag.Args()
 }
 
+// Validate checks options and loads credentials, error never contains credentials
 func (o *Options) Validate() error {
+	o.redactor = o.newRedactor()
+	err := o.validate()
+	o.redactor.AddSecret(o.secrets...)
+	return o.redactor.Error(err)
+}
+
+// newRedactor builds redactor that knows credentials given on command line, before they get parsed
+func (o *Options) newRedactor() *redact.Redactor {
+	out := redact.New(splitList(o.sensitiveHeaders)...)
+	out.AddURL(o.endpoint)
+	out.AddURL(o.network.Proxy)
+	for _, val := range o.chainEndpoints {
+		_, endpoint, _ := strings.Cut(val, "=")
+		out.AddURL(endpoint)
+	}
+	for _, header := range o.headers {
+		name, value, err := rpc.ParseHeader(header)
+		if err != nil {
+			// Cannot tell name from value, so mask it whole
+			out.AddSecret(header)
+			continue
+		}
+		if out.IsSensitiveHeader(name) {
+			out.AddSecret(value)
+		}
+	}
+	return out
+}
+
+func (o *Options) validate() error {
 	var err error
 
-	if o.endpoint == "" {
-		return errors.New("endpoint option is required")
+	switch o.command {
+	case "import":
+		return o.validateImport()
+	case "export":
+		// Export of a store does not connect anywhere
+		if err = o.validateExport(); err != nil || o.store != "" {
+			return err
+		}
+	case "ledger":
+		// Ledger reads receipts and balances from the endpoint, so it is validated as following the chain
+		if err = o.validateLedger(); err != nil {
+			return err
+		}
+	}
+
+	if o.endpoint == "" && o.replay == "" && len(o.chainEndpoints) == 0 {
+		return errors.New("endpoint, replay or chain-endpoint option is required")
+	}
+	if err = o.validateChainEndpoints(); err != nil {
+		return err
+	}
+	if o.replaySpeed < 0 {
+		return errors.New("replay-speed must not be negative")
+	}
+	if o.record != "" && (o.replay != "" || !strings.HasPrefix(o.endpoint, "http://") && !strings.HasPrefix(o.endpoint, "https://")) {
+		return errors.New("record option requires http:// or https:// endpoint")
 	}
-	if o.wallets == "" {
+	if o.wallets == "" && o.target == "tx" {
 		return errors.New("wallets option is required")
 	}
 
@@ -64,34 +216,319 @@ func (o *Options) Validate() error {
 	}
 
 	switch o.target {
-	case "tx", "block", "block-detailed":
+	case "tx", "block", "block-detailed", "fees":
 	default:
 		return errors.Errorf("unknown target: %s\n", o.target)
 	}
 
+	if o.headerValues, err = o.loadHeaders(); err != nil {
+		return err
+	}
+
+	if o.jwtSecretFile != "" {
+		if o.jwtSecret, err = rpc.LoadJWTSecret(o.jwtSecretFile); err != nil {
+			return err
+		}
+	}
+
+	if err = o.validateChain(); err != nil {
+		return err
+	}
+
+	switch {
+	case o.cuPerSecond < 0:
+		return errors.New("cu-per-second must not be negative")
+	case o.rateLimit != "":
+		if o.limiter, err = rpc.NewRateLimitPreset(o.rateLimit, o.cuPerSecond); err != nil {
+			return err
+		}
+	case o.cuPerSecond > 0:
+		o.limiter = rpc.NewLimiter(o.cuPerSecond, rpc.CostTable{Default: 1})
+	}
+
+	// Fail on unreadable certificates and malformed proxy before connecting
+	if _, err = o.network.HTTPClient(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateChainEndpoints parses --chain-endpoint pairs, every chain starts at its head
+func (o *Options) validateChainEndpoints() error {
+	if len(o.chainEndpoints) == 0 {
+		return nil
+	}
+	if o.endpoint != "" || o.replay != "" || o.record != "" || o.chainName != "" || o.startBlock != "" || o.endBlock != "" {
+		return errors.New("chain-endpoint can not be used with endpoint, replay, record, chain, start-block and end-block options")
+	}
+	if o.target != "tx" {
+		return errors.New("chain-endpoint only supports tx target")
+	}
+	o.multiChain = nil
+	for _, val := range o.chainEndpoints {
+		name, endpoint, ok := strings.Cut(val, "=")
+		if !ok || endpoint == "" {
+			return errors.Errorf("invalid chain-endpoint %q, expected chain=endpoint", val)
+		}
+		chain, err := chains.Parse(name)
+		if err != nil {
+			return err
+		}
+		o.multiChain = append(o.multiChain, chainEndpoint{chain: chain, endpoint: endpoint})
+	}
+	return nil
+}
+
+// validateChain resolves --chain and applies its defaults
+func (o *Options) validateChain() error {
+	if o.chainName != "" {
+		chain, err := chains.Parse(o.chainName)
+		if err != nil {
+			return err
+		}
+		o.chain = &chain
+	}
+	// Every chain of --chain-endpoint gets its own block time
+	if o.poolingPeriod == 0 && len(o.multiChain) == 0 {
+		o.poolingPeriod = time.Second
+		if o.chain != nil && o.chain.BlockTime != 0 {
+			o.poolingPeriod = o.chain.BlockTime
+		}
+	}
+	if o.chain != nil && o.chain.Name != "" && !o.chain.FeeRecipientRewards && o.feeRecipients != "" {
+		return errors.Errorf("fee recipients are not paid block rewards on %s", o.chain)
+	}
 	return nil
 }
 
+// feeParams returns fee market parameters of --chain, mainnet EIP-1559 ones without blob gas parameters by default
+func (o *Options) feeParams() processors.FeeParams {
+	if o.chain == nil {
+		return processors.DefaultFeeParams()
+	}
+	return o.chain.FeeParams()
+}
+
+func (o *Options) loadHeaders() (http.Header, error) {
+	out := http.Header{}
+	if o.headerFile != "" {
+		headers, err := rpc.LoadHeaderFile(o.headerFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range headers {
+			out[name] = append(out[name], values...)
+			o.secrets = append(o.secrets, values...)
+		}
+	}
+	for _, header := range o.headers {
+		name, value, err := rpc.ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		out.Add(name, value)
+	}
+	for _, header := range o.headersEnv {
+		name, envName, ok := strings.Cut(header, "=")
+		if !ok || name == "" || envName == "" {
+			return nil, errors.Errorf("invalid header-from-env %q, expected Name=ENV_VARIABLE", header)
+		}
+		value, ok := os.LookupEnv(envName)
+		if !ok || value == "" {
+			return nil, errors.Errorf("environment variable %s for header %s is not set", envName, name)
+		}
+		out.Add(name, value)
+		o.secrets = append(o.secrets, value)
+	}
+	return out, nil
+}
+
+// Run subscribes and prints target objects until interrupted, error never contains credentials
 func (o *Options) Run() error {
+	if o.redactor == nil {
+		o.redactor = o.newRedactor()
+	}
+	err := o.startRecording()
+	if err == nil {
+		err = o.run()
+		if o.recorder != nil {
+			if closeErr := o.recorder.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	o.printRateLimitStats()
+	return o.redactor.Error(err)
+}
+
+// startRecording wraps HTTP client configured by network options into cassette recorder when --record is set
+func (o *Options) startRecording() error {
+	if o.record == "" {
+		return nil
+	}
+	client, err := o.network.HTTPClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to configure HTTP client")
+	}
+	o.recorder, err = cassette.Create(o.record, client, o.redactor)
+	return err
+}
+
+func (o *Options) printRateLimitStats() {
+	if o.limiter == nil || o.quite {
+		return
+	}
+	statsTxt, err := json.Marshal(o.limiter.Stats())
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Rate limit spend: %s\n", statsTxt)
+}
+
+func (o *Options) run() error {
+	switch o.command {
+	case "export":
+		return o.runExport()
+	case "import":
+		return o.runImport()
+	case "ledger":
+		return o.runLedger()
+	}
 	switch o.target {
 	case "tx":
-		return subscribeTransaction(o.endpoint, strings.Split(o.wallets, ","), o.quite, o.buildSubscriberOptions()...)
+		if len(o.multiChain) != 0 {
+			sub, err := o.newMultiChainSubscriber()
+			if err != nil {
+				return errors.Wrap(err, "failed to create subscriber")
+			}
+			return subscribeTransaction(sub, strings.Split(o.wallets, ","), splitList(o.feeRecipients), o.recordOutput(), o.quite)
+		}
+		sub, err := o.newChanSubscriber()
+		if err != nil {
+			return errors.Wrap(err, "failed to create subscriber")
+		}
+		return subscribeTransaction(sub, strings.Split(o.wallets, ","), splitList(o.feeRecipients), o.recordOutput(), o.quite)
 	case "block":
-		return subscribeBlocks[types.Block](o.endpoint, o.quite, o.buildBlkSubscriberOptions()...)
+		source, err := newBlockSource[types.Block](o)
+		if err != nil {
+			return errors.Wrap(err, "failed to create subscriber")
+		}
+		return subscribeBlocks(source, o.quite)
 	case "block-detailed":
-		return subscribeBlocks[types.BlockDetailed](o.endpoint, o.quite, o.buildBlkSubscriberOptions()...)
+		source, err := newBlockSource[types.BlockDetailed](o)
+		if err != nil {
+			return errors.Wrap(err, "failed to create subscriber")
+		}
+		return subscribeBlocks(source, o.quite)
+	case "fees":
+		source, err := newBlockSource[types.BlockDetailed](o)
+		if err != nil {
+			return errors.Wrap(err, "failed to create subscriber")
+		}
+		return subscribeFees(source, o.feeParams(), o.quite)
 	default:
 		return errors.Errorf("unknown target: %s\n", o.target)
 	}
 }
 
+// newBlockSource replays recorded files when --replay is set and subscribes to the endpoint otherwise
+func newBlockSource[T types.BlockType](o *Options) (blksubscriber.BlockSource[T], error) {
+	if o.replay != "" {
+		source, err := replay.New[T](splitList(o.replay), o.buildReplayOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
+	source, err := blksubscriber.New[T](o.endpoint, o.buildBlkSubscriberOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (o *Options) newChanSubscriber() (*subscriber2.ChanSubscriber, error) {
+	if o.replay != "" {
+		source, err := replay.New[types.BlockDetailed](splitList(o.replay), o.buildReplayOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		return subscriber2.NewChanSubscriberFromSource(source), nil
+	}
+	return subscriber2.NewChanSubscriber(o.endpoint, o.buildSubscriberOptions()...)
+}
+
+func (o *Options) newMultiChainSubscriber() (*subscriber2.MultiChainSubscriber, error) {
+	out := subscriber2.NewMultiChainSubscriber()
+	opts := o.buildSubscriberOptions()
+	for _, c := range o.multiChain {
+		if err := out.AddChain(c.chain, c.endpoint, opts...); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (o *Options) buildReplayOptions() []replay.Option {
+	opts := []replay.Option{
+		replay.WithSpeed(o.replaySpeed),
+	}
+
+	if o.chain != nil {
+		opts = append(opts, replay.WithChainID(o.chain.BigID()))
+	}
+
+	if o.startBlock != "" {
+		opts = append(opts, replay.WithStartBlock(o.startBlockInt))
+	}
+
+	if o.endBlock != "" {
+		opts = append(opts, replay.WithEndBlock(o.endBlockInt))
+	}
+	return opts
+}
+
 func (o *Options) buildSubscriberOptions() []subscriber2.Option {
 	opts := []subscriber2.Option{
-		subscriber2.WithPoolingPeriod(o.poolingPeriod),
+		subscriber2.WithNetworkConfig(o.network),
 	}
 
-	if httpClient := newSimpleHTTPClient(o.header); httpClient != nil {
-		opts = append(opts, subscriber2.WithHTTPClient(httpClient))
+	if o.poolingPeriod != 0 {
+		opts = append(opts, subscriber2.WithPoolingPeriod(o.poolingPeriod))
+	}
+
+	if o.recorder != nil {
+		opts = append(opts, subscriber2.WithHTTPClient(o.recorder))
+	}
+
+	if o.chain != nil {
+		opts = append(opts, subscriber2.WithChainID(o.chain.BigID()))
+		if o.chain.ReorgDepth != 0 {
+			opts = append(opts, subscriber2.WithReorgDepth(int(o.chain.ReorgDepth)))
+		}
+		if o.chain.FinalityDepth != 0 {
+			opts = append(opts, subscriber2.WithFinalityDepth(int(o.chain.FinalityDepth)))
+		}
+	}
+
+	if o.limiter != nil {
+		opts = append(opts, subscriber2.WithRateLimiter(o.limiter))
+	}
+
+	for name, values := range o.headerValues {
+		for _, value := range values {
+			opts = append(opts, subscriber2.WithHeader(name, value))
+		}
+	}
+
+	if o.jwtSecret != nil {
+		opts = append(opts, subscriber2.WithJWTSecret(o.jwtSecret))
+	}
+
+	opts = append(opts, subscriber2.WithSecret(o.secrets...), subscriber2.WithSensitiveHeaders(splitList(o.sensitiveHeaders)...))
+	if o.debug {
+		opts = append(opts, subscriber2.WithDebugLog(os.Stderr))
 	}
 
 	if o.startBlock != "" {
@@ -107,10 +544,40 @@ func (o *Options) buildSubscriberOptions() []subscriber2.Option {
 func (o *Options) buildBlkSubscriberOptions() []blksubscriber.Option {
 	opts := []blksubscriber.Option{
 		blksubscriber.WithPoolingPeriod(o.poolingPeriod),
+		blksubscriber.WithNetworkConfig(o.network),
+	}
+
+	if o.recorder != nil {
+		opts = append(opts, blksubscriber.WithHTTPClient(o.recorder))
+	}
+
+	if o.chain != nil {
+		opts = append(opts, blksubscriber.WithChainID(o.chain.BigID()))
+		if o.chain.ReorgDepth != 0 {
+			opts = append(opts, blksubscriber.WithReorgDepth(int(o.chain.ReorgDepth)))
+		}
+		if o.chain.FinalityDepth != 0 {
+			opts = append(opts, blksubscriber.WithFinalityDepth(int(o.chain.FinalityDepth)))
+		}
+	}
+
+	if o.limiter != nil {
+		opts = append(opts, blksubscriber.WithRateLimiter(o.limiter))
+	}
+
+	for name, values := range o.headerValues {
+		for _, value := range values {
+			opts = append(opts, blksubscriber.WithHeader(name, value))
+		}
 	}
 
-	if httpClient := newSimpleHTTPClient(o.header); httpClient != nil {
-		opts = append(opts, blksubscriber.WithHTTPClient(httpClient))
+	if o.jwtSecret != nil {
+		opts = append(opts, blksubscriber.WithJWTSecret(o.jwtSecret))
+	}
+
+	opts = append(opts, blksubscriber.WithSecret(o.secrets...), blksubscriber.WithSensitiveHeaders(splitList(o.sensitiveHeaders)...))
+	if o.debug {
+		opts = append(opts, blksubscriber.WithDebugLog(os.Stderr))
 	}
 
 	if o.startBlock != "" {
@@ -124,16 +591,10 @@ func (o *Options) buildBlkSubscriberOptions() []blksubscriber.Option {
 }
 
 func subscribeBlocks[T types.BlockType](
-	endpoint string,
+	sub blksubscriber.BlockSource[T],
 	quite bool,
-	opts ...blksubscriber.Option,
 ) error {
-	sub, err := blksubscriber.New[T](endpoint, opts...)
-	if err != nil {
-		return errors.Wrap(err, "failed to create subscriber")
-	}
-
-	if err = sub.Start(); err != nil {
+	if err := sub.Start(); err != nil {
 		return errors.Wrap(err, "failed to start subscriber")
 	}
 
@@ -166,22 +627,87 @@ func subscribeBlocks[T types.BlockType](
 	return nil
 }
 
-func subscribeTransaction(
-	endpoint string,
-	walletList []string,
+func subscribeFees(
+	sub blksubscriber.BlockSource[types.BlockDetailed],
+	params processors.FeeParams,
 	quite bool,
-	opts ...subscriber2.Option,
 ) error {
-	sub, err := subscriber2.NewChanSubscriber(endpoint, opts...)
-	if err != nil {
-		return errors.Wrap(err, "failed to create subscriber")
+	// Replayed blocks come without receipts, then priority fee percentiles are estimated
+	var receipts receiptSource
+	if val, ok := sub.(receiptSource); ok {
+		receipts = val
 	}
+	feeStats := processors.NewFeeStatsProcessor(sub.GetBlockChan(), params, receipts)
+
+	if err := sub.Start(); err != nil {
+		return errors.Wrap(err, "failed to start subscriber")
+	}
+
+	defer sub.Stop()
 
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-c
+		// Run Cleanup
+		sub.Stop()
+	}()
+
+	if !quite {
+		fmt.Println("Listening for fee statistics...")
+	}
+
+	for stats := range feeStats.Out() {
+		statsTxt, err := json.Marshal(stats)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal fee statistics")
+		}
+
+		println(string(statsTxt))
+	}
+	return errors.Wrap(sub.LastError(), "subscriber failed with error")
+}
+
+// receiptSource is implemented by block sources reading receipts from the endpoint
+type receiptSource interface {
+	GetBlockReceipts(blockNum *big.Int) ([]*types.Receipt, error)
+}
+
+// txSubscriber is implemented by subscriber.ChanSubscriber and subscriber.MultiChainSubscriber
+type txSubscriber interface {
+	Subscribe(address string) bool
+	SubscribeBlockRewards(address string) bool
+	Start() error
+	Stop()
+	LastError() error
+	GetTransactionChan() <-chan *types.Transaction
+	GetBlockRewardChan() <-chan *types.ProposedBlockReward
+}
+
+// recordOutput is where transaction and reward records are printed, stderr by default, as println does
+func (o *Options) recordOutput() io.Writer {
+	if o.output != nil {
+		return o.output
+	}
+	return os.Stderr
+}
+
+func subscribeTransaction(
+	sub txSubscriber,
+	walletList []string,
+	feeRecipients []string,
+	out io.Writer,
+	quite bool,
+) error {
 	for _, wallet := range walletList {
 		sub.Subscribe(wallet)
 	}
 
-	if err = sub.Start(); err != nil {
+	for _, wallet := range feeRecipients {
+		sub.SubscribeBlockRewards(wallet)
+	}
+
+	if err := sub.Start(); err != nil {
 		return errors.Wrap(err, "failed to start subscriber")
 	}
 
@@ -199,19 +725,54 @@ func subscribeTransaction(
 		fmt.Println("Listening for transactions...")
 	}
 
-	for tx := range sub.GetTransactionChan() {
-		if tx == nil {
-			return errors.Wrap(sub.LastError(), "subscriber failed with error")
+	rewardChan := sub.GetBlockRewardChan()
+	for {
+		var record any
+		select {
+		case tx := <-sub.GetTransactionChan():
+			if tx == nil {
+				// Rewards channel is closed before the transactions one, but may still hold rewards
+				for rewardChan != nil {
+					reward, ok := <-rewardChan
+					if !ok {
+						break
+					}
+					if err := printRecord(out, reward); err != nil {
+						return err
+					}
+				}
+				return errors.Wrap(sub.LastError(), "subscriber failed with error")
+			}
+			record = tx
+		case reward, ok := <-rewardChan:
+			if !ok {
+				rewardChan = nil
+				continue
+			}
+			record = reward
 		}
 
-		txTxt, err := json.Marshal(tx)
-		if err != nil {
-			return errors.Wrap(err, "failed to marshal tx")
+		if err := printRecord(out, record); err != nil {
+			return err
 		}
+	}
+}
 
-		println(string(txTxt))
+// printRecord prints transaction or reward as a JSON line
+func printRecord(out io.Writer, record any) error {
+	txTxt, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal tx")
 	}
-	return nil
+	_, err = fmt.Fprintln(out, string(txTxt))
+	return err
+}
+
+func splitList(val string) []string {
+	if val == "" {
+		return nil
+	}
+	return strings.Split(val, ",")
 }
 
 func parseBigInt(val, optionName string) (*big.Int, error) {
@@ -231,22 +792,14 @@ func parseBigInt(val, optionName string) (*big.Int, error) {
 	return out, nil
 }
 
-type simpleHTTPClient struct {
-	headers http.Header
-}
+// stringList is a flag that can be repeated
+type stringList []string
 
-func newSimpleHTTPClient(header string) *simpleHTTPClient {
-	tmp := strings.SplitN(header, ":", 1)
-	if len(tmp) != 2 {
-		return nil
-	}
-	headers := http.Header{}
-	headers.Set(tmp[0], strings.TrimSpace(tmp[1]))
-	return &simpleHTTPClient{
-		headers: headers,
-	}
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
 }
 
-func (c *simpleHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	return http.DefaultClient.Do(req)
+func (l *stringList) Set(val string) error {
+	*l = append(*l, val)
+	return nil
 }
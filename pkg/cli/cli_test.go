@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/cassette"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/ledger"
+	"github.com/dkropachev/ethscan/pkg/txexport"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const wallet = "0xc940323bdacd868c319e9039ea5fddd35745e62d"
+
+func newTestNode(t *testing.T) *ethtest.Node {
+	var to types.EthAddress
+	require.NoError(t, to.UnmarshalJSON([]byte(`"`+wallet+`"`)))
+	node := ethtest.NewNode(t)
+	node.AppendBlock()
+	node.AppendBlock(ethtest.Tx{To: to, Value: 1})
+	node.AppendBlock()
+	return node
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	for _, target := range []string{"tx", "block", "block-detailed", "fees"} {
+		t.Run(target, func(t *testing.T) {
+			t.Parallel()
+			node := newTestNode(t)
+			o := &Options{
+				endpoint:      node.URL(),
+				startBlock:    "1",
+				endBlock:      "3",
+				poolingPeriod: 10 * time.Millisecond,
+				wallets:       wallet,
+				target:        target,
+				quite:         true,
+				rateLimit:     "tatum",
+				cuPerSecond:   1000,
+			}
+			require.NoError(t, o.Validate())
+			require.NoError(t, o.Run())
+			assert.Equal(t, 3, node.Calls("eth_getBlockByNumber"))
+			assert.Equal(t, uint64(node.Calls(ethtest.AnyMethod)), o.limiter.Stats().Calls)
+		})
+	}
+}
+
+func TestRunFeeRecipients(t *testing.T) {
+	t.Parallel()
+	var address types.EthAddress
+	require.NoError(t, address.UnmarshalJSON([]byte(`"`+wallet+`"`)))
+	node := ethtest.NewNode(t)
+	node.SetMiner(address)
+	const blocks = 20
+	for range blocks {
+		node.AppendBlock(ethtest.Tx{From: types.EthAddress{1}, To: types.EthAddress{2}, GasPrice: 1_000_000_001})
+	}
+
+	var out bytes.Buffer
+	o := &Options{
+		endpoint:      node.URL(),
+		startBlock:    "1",
+		endBlock:      strconv.Itoa(blocks),
+		poolingPeriod: 10 * time.Millisecond,
+		wallets:       wallet,
+		feeRecipients: wallet,
+		target:        "tx",
+		quite:         true,
+		output:        &out,
+	}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+
+	// Rewards of the last blocks are still buffered when transactions end, they have to be printed too
+	var numbers []int64
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var reward types.ProposedBlockReward
+		require.NoError(t, json.Unmarshal([]byte(line), &reward), line)
+		assert.Equal(t, address, reward.FeeRecipient)
+		numbers = append(numbers, reward.BlockNumber.AsBigInt().Int64())
+	}
+	require.Len(t, numbers, blocks)
+	assert.Equal(t, int64(blocks), numbers[blocks-1])
+
+	// Transactions end while every reward is still buffered
+	sub := &bufferedSubscriber{txs: make(chan *types.Transaction), rewards: make(chan *types.ProposedBlockReward, blocks)}
+	for n := range blocks {
+		sub.rewards <- &types.ProposedBlockReward{BlockNumber: types.BigInt(*big.NewInt(int64(n))), FeeRecipient: address}
+	}
+	close(sub.rewards)
+	close(sub.txs)
+	out.Reset()
+	require.NoError(t, subscribeTransaction(sub, []string{wallet}, []string{wallet}, &out, true))
+	assert.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), blocks)
+}
+
+// bufferedSubscriber serves transactions and rewards put into its channels beforehand
+type bufferedSubscriber struct {
+	txs     chan *types.Transaction
+	rewards chan *types.ProposedBlockReward
+}
+
+func (s *bufferedSubscriber) Subscribe(string) bool                                 { return true }
+func (s *bufferedSubscriber) SubscribeBlockRewards(string) bool                     { return true }
+func (s *bufferedSubscriber) Start() error                                          { return nil }
+func (s *bufferedSubscriber) Stop()                                                 {}
+func (s *bufferedSubscriber) LastError() error                                      { return nil }
+func (s *bufferedSubscriber) GetTransactionChan() <-chan *types.Transaction         { return s.txs }
+func (s *bufferedSubscriber) GetBlockRewardChan() <-chan *types.ProposedBlockReward { return s.rewards }
+
+func TestRunRecord(t *testing.T) {
+	t.Parallel()
+	const key = "0123456789abcdef0123456789abcdef"
+	node := newTestNode(t)
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	o := &Options{
+		endpoint:      node.URL() + "/v3/" + key,
+		startBlock:    "1",
+		endBlock:      "3",
+		poolingPeriod: 10 * time.Millisecond,
+		target:        "block-detailed",
+		quite:         true,
+		record:        path,
+	}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), key)
+	replayer, err := cassette.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, node.Calls(ethtest.AnyMethod), replayer.Interactions())
+
+	o = &Options{endpoint: "ws://127.0.0.1:1/", target: "block", record: path}
+	assert.Error(t, o.Validate())
+}
+
+func TestRunChain(t *testing.T) {
+	t.Parallel()
+	node := newTestNode(t)
+
+	o := &Options{endpoint: node.URL(), target: "block", quite: true, chainName: "sepolia"}
+	require.NoError(t, o.Validate())
+	assert.Equal(t, 12*time.Second, o.poolingPeriod)
+	assert.ErrorContains(t, o.Run(), "endpoint serves chain 1, expected chain 11155111")
+
+	o = &Options{endpoint: node.URL(), startBlock: "1", endBlock: "3", target: "fees", quite: true, chainName: "1", poolingPeriod: 10 * time.Millisecond}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+
+	o = &Options{endpoint: node.URL(), target: "tx", wallets: wallet, feeRecipients: wallet, chainName: "base"}
+	assert.ErrorContains(t, o.Validate(), "fee recipients are not paid block rewards on base")
+	o = &Options{endpoint: node.URL(), target: "tx", wallets: wallet, chainName: "goerli"}
+	assert.Error(t, o.Validate())
+}
+
+func TestRunMultiChain(t *testing.T) {
+	t.Parallel()
+	mainnet := newTestNode(t)
+	base := ethtest.NewNode(t, ethtest.WithChainID(8453))
+
+	o := &Options{target: "tx", wallets: wallet, chainEndpoints: stringList{"mainnet=" + mainnet.URL(), "0x2105=" + base.URL()}}
+	require.NoError(t, o.Validate())
+	require.Len(t, o.multiChain, 2)
+	assert.Equal(t, "base", o.multiChain[1].chain.Name)
+	assert.Zero(t, o.poolingPeriod)
+
+	// Every chain is checked on start, so a mixed up endpoint fails the run
+	o = &Options{target: "tx", wallets: wallet, quite: true, chainEndpoints: stringList{"mainnet=" + mainnet.URL(), "optimism=" + base.URL()}}
+	require.NoError(t, o.Validate())
+	assert.ErrorContains(t, o.Run(), "endpoint serves chain 8453, expected chain 10")
+
+	for _, o := range []*Options{
+		{target: "tx", wallets: wallet, chainEndpoints: stringList{"mainnet"}},
+		{target: "tx", wallets: wallet, chainEndpoints: stringList{"goerli=" + base.URL()}},
+		{target: "fees", chainEndpoints: stringList{"base=" + base.URL()}},
+		{target: "tx", wallets: wallet, startBlock: "1", chainEndpoints: stringList{"base=" + base.URL()}},
+		{target: "tx", wallets: wallet, endpoint: mainnet.URL(), chainEndpoints: stringList{"base=" + base.URL()}},
+	} {
+		assert.Error(t, o.Validate(), o.chainEndpoints)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	t.Parallel()
+	const key = "0123456789abcdef0123456789abcdef"
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		t.Parallel()
+		node := newTestNode(t)
+		node.FailHTTP(ethtest.AnyMethod, 1, http.StatusUnauthorized)
+		o := &Options{endpoint: node.URL() + "/v3/" + key, target: "block", quite: true, poolingPeriod: time.Second}
+		require.NoError(t, o.Validate())
+		err := o.Run()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "401")
+		assert.NotContains(t, err.Error(), key)
+	})
+
+	t.Run("SubscriberFails", func(t *testing.T) {
+		t.Parallel()
+		node := newTestNode(t)
+		node.FailNext("eth_getBlockByNumber", 1, -32000, "missing trie node "+key)
+		o := &Options{
+			endpoint:      node.URL() + "/v3/" + key,
+			startBlock:    "1",
+			poolingPeriod: 10 * time.Millisecond,
+			wallets:       wallet,
+			target:        "tx",
+			quite:         true,
+		}
+		require.NoError(t, o.Validate())
+		err := o.Run()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing trie node")
+		assert.NotContains(t, err.Error(), key)
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	node := newTestNode(t)
+	scanned, imported, exported := t.TempDir(), t.TempDir(), t.TempDir()
+	o := &Options{
+		command:       "export",
+		endpoint:      node.URL(),
+		startBlock:    "1",
+		endBlock:      "3",
+		poolingPeriod: 10 * time.Millisecond,
+		wallets:       wallet,
+		target:        "tx",
+		quite:         true,
+		out:           scanned,
+		format:        "csv",
+		split:         "address",
+	}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+	files, err := filepath.Glob(filepath.Join(scanned, "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(scanned, wallet+".csv")}, files)
+
+	o = &Options{command: "import", store: imported, args: files, quite: true}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+
+	// Exported store gives the same transactions back
+	o = &Options{command: "export", store: imported, wallets: wallet, target: "tx", quite: true, out: exported, format: "jsonl", split: "day", gzip: true}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+	files, err = filepath.Glob(filepath.Join(exported, "*.jsonl.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	var records []*txexport.Record
+	require.NoError(t, txexport.ReadFile(files[0], func(rec *txexport.Record) error {
+		records = append(records, rec)
+		return nil
+	}))
+	require.Len(t, records, 1)
+	// Subscriber stamps transactions with chain ID and block timestamp, they survive the round trip through CSV
+	tx := records[0].Transaction
+	assert.Equal(t, node.Block(2).Transactions[0].Hash, tx.Hash)
+	assert.Equal(t, int64(1), tx.Value.AsBigInt().Int64())
+	require.NotNil(t, tx.BlockTimestamp)
+	assert.Equal(t, node.Block(2).Timestamp.AsBigInt().Int64(), tx.BlockTimestamp.AsBigInt().Int64())
+	assert.Equal(t, "incoming", records[0].Direction)
+
+	for _, o := range []*Options{
+		{command: "export", endpoint: node.URL(), wallets: wallet, target: "tx", out: exported},
+		{command: "export", endpoint: node.URL(), wallets: wallet, target: "tx", out: exported, endBlock: "3", format: "xml"},
+		{command: "export", store: filepath.Join(imported, "missing"), wallets: wallet, target: "tx", out: exported},
+		{command: "import", store: imported},
+	} {
+		assert.Error(t, o.Validate())
+	}
+}
+
+func TestLedger(t *testing.T) {
+	t.Parallel()
+	var address types.EthAddress
+	require.NoError(t, address.UnmarshalJSON([]byte(`"`+wallet+`"`)))
+	node := ethtest.NewNode(t)
+	node.SetBalance(address, big.NewInt(5))
+	node.AppendBlock()
+	node.AppendBlock(ethtest.Tx{To: address, Value: 1})
+	node.SetBalance(address, big.NewInt(6))
+	// Block 3 pays the wallet 21000 gas times 1 wei of priority fee
+	node.SetMiner(address)
+	node.AppendBlock(ethtest.Tx{From: types.EthAddress{1}, To: types.EthAddress{2}, GasPrice: 1_000_000_001})
+	node.SetBalance(address, big.NewInt(21006))
+
+	var out bytes.Buffer
+	o := &Options{
+		command:       "ledger",
+		endpoint:      node.URL(),
+		startBlock:    "1",
+		endBlock:      "3",
+		poolingPeriod: 10 * time.Millisecond,
+		wallet:        wallet,
+		target:        "tx",
+		quite:         true,
+		format:        "csv",
+		output:        &out,
+	}
+	require.NoError(t, o.Validate())
+	require.NoError(t, o.Run())
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, ledger.Columns, rows[0])
+	assert.Equal(t, []string{"2", "transaction", "incoming", "1", "0", "1", "6"}, []string{rows[1][0], rows[1][2], rows[1][4], rows[1][6], rows[1][7], rows[1][9], rows[1][10]})
+	// Reward of the last block is stored even though transactions end first
+	assert.Equal(t, []string{"3", "reward", "incoming", "21000", "0", "21000", "21006"}, []string{rows[2][0], rows[2][2], rows[2][4], rows[2][6], rows[2][7], rows[2][9], rows[2][10]})
+
+	for _, o := range []*Options{
+		{command: "ledger", endpoint: node.URL(), target: "tx", endBlock: "3"},
+		{command: "ledger", endpoint: node.URL(), wallet: wallet, wallets: wallet, target: "tx", endBlock: "3"},
+		{command: "ledger", wallet: wallet, target: "tx", endBlock: "3"},
+		{command: "ledger", endpoint: node.URL(), wallet: wallet, target: "tx"},
+		{command: "ledger", endpoint: node.URL(), wallet: wallet, target: "tx", endBlock: "3", format: "xml"},
+	} {
+		assert.Error(t, o.Validate())
+	}
+}
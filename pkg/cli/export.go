@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/disktxstore"
+	"github.com/dkropachev/ethscan/pkg/memtxstore"
+	"github.com/dkropachev/ethscan/pkg/txexport"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"math/big"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// validateExport checks options of export, blocks are scanned from the endpoint or replayed files unless --store is set
+func (o *Options) validateExport() error {
+	var err error
+	if o.wallets == "" {
+		return errors.New("wallets option is required")
+	}
+	if len(o.chainEndpoints) != 0 || o.target != "tx" {
+		return errors.New("export can not be used with chain-endpoint and target options")
+	}
+	if o.out == "" {
+		return errors.New("out option is required")
+	}
+	if o.exportFormat, err = txexport.ParseFormat(o.format); err != nil {
+		return err
+	}
+	if o.exportSplit, err = txexport.ParseSplit(o.split); err != nil {
+		return err
+	}
+	if o.startBlockInt, err = parseBigInt(o.startBlock, "start-block"); err != nil {
+		return err
+	}
+	if o.endBlockInt, err = parseBigInt(o.endBlock, "end-block"); err != nil {
+		return err
+	}
+	if o.store != "" {
+		if o.endpoint != "" || o.replay != "" {
+			return errors.New("export reads either store or blocks, store can not be used with endpoint and replay options")
+		}
+		if _, err = os.Stat(o.store); err != nil {
+			return errors.Wrap(err, "failed to open store")
+		}
+		return nil
+	}
+	// Subscriber follows the chain forever without the end, replayed files end by themselves
+	if o.endBlock == "" && o.replay == "" {
+		return errors.New("export of scanned blocks requires end-block option")
+	}
+	return nil
+}
+
+func (o *Options) validateImport() error {
+	if o.store == "" {
+		return errors.New("store option is required")
+	}
+	if len(o.args) == 0 {
+		return errors.New("files to import are required: ethscan import --store <dir> <file>...")
+	}
+	return nil
+}
+
+// runExport writes transactions of the wallets read from --store or scanned from --start-block to --end-block
+func (o *Options) runExport() error {
+	var (
+		store       txstore.Store
+		from, to    *big.Int
+		wallets     = splitList(o.wallets)
+		storeCloser func() error
+	)
+	if o.store != "" {
+		disk, err := disktxstore.Open(o.store)
+		if err != nil {
+			return errors.Wrap(err, "failed to open store")
+		}
+		store, storeCloser = disk, disk.Close
+		if o.startBlock != "" {
+			from = o.startBlockInt
+		}
+		if o.endBlock != "" {
+			to = o.endBlockInt
+		}
+	} else {
+		mem := memtxstore.New()
+		if err := o.scanTransactions(mem, wallets, nil); err != nil {
+			return err
+		}
+		store, storeCloser = mem, func() error { return nil }
+	}
+
+	w, err := txexport.NewWriter(o.out, txexport.WithFormat(o.exportFormat), txexport.WithSplit(o.exportSplit), txexport.WithGzip(o.gzip))
+	if err != nil {
+		return err
+	}
+	count, err := txexport.Export(w, store, wallets, from, to)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := storeCloser(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to export transactions")
+	}
+	if !o.quite {
+		fmt.Printf("Exported %d records into %s\n", count, strings.Join(w.Files(), ", "))
+	}
+	return nil
+}
+
+// scanTransactions stores transactions of the wallets and rewards of the fee recipients until subscriber reaches
+// the end block or replayed files end
+func (o *Options) scanTransactions(store txstore.Store, wallets, feeRecipients []string) error {
+	sub, err := o.newChanSubscriber()
+	if err != nil {
+		return errors.Wrap(err, "failed to create subscriber")
+	}
+	for _, wallet := range wallets {
+		sub.Subscribe(wallet)
+	}
+	for _, wallet := range feeRecipients {
+		sub.SubscribeBlockRewards(wallet)
+	}
+	if err = sub.Start(); err != nil {
+		return errors.Wrap(err, "failed to start subscriber")
+	}
+	defer sub.Stop()
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	defer func() {
+		signal.Stop(c)
+		close(c)
+	}()
+	go func() {
+		if _, ok := <-c; ok {
+			sub.Stop()
+		}
+	}()
+
+	if !o.quite {
+		fmt.Println("Scanning blocks...")
+	}
+	rewardChan := sub.GetBlockRewardChan()
+	for {
+		select {
+		case tx := <-sub.GetTransactionChan():
+			if tx == nil {
+				// Rewards channel is closed before the transactions one, but may still hold rewards
+				for rewardChan != nil {
+					reward, ok := <-rewardChan
+					if !ok {
+						break
+					}
+					if err = store.StoreBlockReward(reward); err != nil {
+						return errors.Wrap(err, "failed to store block reward")
+					}
+				}
+				return errors.Wrap(sub.LastError(), "subscriber failed with error")
+			}
+			if err = store.StoreTransaction(tx); err != nil {
+				return errors.Wrap(err, "failed to store transaction")
+			}
+		case reward, ok := <-rewardChan:
+			if !ok {
+				rewardChan = nil
+				continue
+			}
+			if err = store.StoreBlockReward(reward); err != nil {
+				return errors.Wrap(err, "failed to store block reward")
+			}
+		}
+	}
+}
+
+// runImport loads exported files into --store
+func (o *Options) runImport() error {
+	store, err := disktxstore.Open(o.store)
+	if err != nil {
+		return errors.Wrap(err, "failed to open store")
+	}
+	count, err := txexport.Import(store, o.args...)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to import transactions")
+	}
+	if !o.quite {
+		fmt.Printf("Imported %d records into %s\n", count, o.store)
+	}
+	return nil
+}
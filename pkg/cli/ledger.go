@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/disktxstore"
+	"github.com/dkropachev/ethscan/pkg/ledger"
+	"github.com/dkropachev/ethscan/pkg/memtxstore"
+	"github.com/dkropachev/ethscan/pkg/txexport"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// validateLedger checks options of ledger, transactions are read from --store or scanned from the endpoint,
+// receipts and balances are always read from the endpoint
+func (o *Options) validateLedger() error {
+	var err error
+	if o.wallet == "" {
+		return errors.New("wallet option is required")
+	}
+	if o.wallets != "" || strings.Contains(o.wallet, ",") {
+		return errors.New("ledger is built for a single wallet, use wallet option only")
+	}
+	if len(o.chainEndpoints) != 0 || o.replay != "" || o.target != "tx" {
+		return errors.New("ledger can not be used with chain-endpoint, replay and target options")
+	}
+	if o.endpoint == "" {
+		return errors.New("ledger requires endpoint option to read receipts and balances")
+	}
+	// Subscription and validation of the following go through the list of wallets
+	o.wallets = o.wallet
+	if o.exportFormat, err = txexport.ParseFormat(o.format); err != nil {
+		return err
+	}
+	if o.startBlockInt, err = parseBigInt(o.startBlock, "start-block"); err != nil {
+		return err
+	}
+	if o.endBlockInt, err = parseBigInt(o.endBlock, "end-block"); err != nil {
+		return err
+	}
+	if o.store != "" {
+		if _, err = os.Stat(o.store); err != nil {
+			return errors.Wrap(err, "failed to open store")
+		}
+		return nil
+	}
+	if o.endBlock == "" {
+		return errors.New("ledger of scanned blocks requires end-block option")
+	}
+	return nil
+}
+
+// runLedger builds ledger of --wallet from transactions and rewards of --store or scanned from --start-block
+// to --end-block, writes its rows to the output and reports balance drift to stderr
+func (o *Options) runLedger() error {
+	var (
+		store    txstore.Store
+		from, to *big.Int
+		wallet   = strings.ToLower(o.wallet)
+	)
+	if o.store != "" {
+		disk, err := disktxstore.Open(o.store)
+		if err != nil {
+			return errors.Wrap(err, "failed to open store")
+		}
+		defer disk.Close()
+		store = disk
+		if o.startBlock != "" {
+			from = o.startBlockInt
+		}
+		if o.endBlock != "" {
+			to = o.endBlockInt
+		}
+	} else {
+		mem := memtxstore.New()
+		if err := o.scanTransactions(mem, []string{wallet}, []string{wallet}); err != nil {
+			return err
+		}
+		store = mem
+	}
+
+	txs, err := store.GetTransactions(wallet)
+	if err != nil {
+		return errors.Wrap(err, "failed to read transactions")
+	}
+	page, err := (&txstore.Query{Address: wallet, FromBlock: from, ToBlock: to}).Run(txs)
+	if err != nil {
+		return err
+	}
+	rewards, err := store.GetBlockRewards(wallet)
+	if err != nil {
+		return errors.Wrap(err, "failed to read block rewards")
+	}
+	var inRange []*types.ProposedBlockReward
+	for _, reward := range rewards {
+		block := reward.BlockNumber.AsBigInt()
+		if (from == nil || block.Cmp(from) >= 0) && (to == nil || block.Cmp(to) <= 0) {
+			inRange = append(inRange, reward)
+		}
+	}
+
+	chain, err := blksubscriber.New[types.BlockDetailed](o.endpoint, o.buildBlkSubscriberOptions()...)
+	if err != nil {
+		return errors.Wrap(err, "failed to create block subscriber")
+	}
+	l, err := ledger.Build(chain, wallet, page.Transactions, inRange, ledger.WithCheckpointEvery(o.checkpointEvery))
+	if err != nil {
+		return errors.Wrap(err, "failed to build ledger")
+	}
+	if err = o.writeLedger(l); err != nil {
+		return errors.Wrap(err, "failed to write ledger")
+	}
+
+	if !o.quite {
+		fmt.Fprintf(os.Stderr, "Ledger of %s: %d rows, opening balance %s at block %s, closing balance %s\n", l.Wallet,
+			len(l.Rows), l.Opening.AsBigInt(), l.OpeningBlock.AsBigInt(), l.Balance.AsBigInt())
+		for _, drift := range l.Drifts() {
+			fmt.Fprintf(os.Stderr, "Balance drift at block %s: ledger %s, eth_getBalance %s, drift %s\n",
+				drift.BlockNumber.AsBigInt(), drift.Balance.AsBigInt(), drift.Actual.AsBigInt(), drift.Drift.AsBigInt())
+		}
+	}
+	return nil
+}
+
+// writeLedger writes rows of the ledger as CSV or as a JSON object per line
+func (o *Options) writeLedger(l *ledger.Ledger) error {
+	var out io.Writer = os.Stdout
+	if o.output != nil {
+		out = o.output
+	}
+	if o.exportFormat == txexport.FormatCSV {
+		return l.WriteCSV(out)
+	}
+	enc := json.NewEncoder(out)
+	for _, row := range l.Rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
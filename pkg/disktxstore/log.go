@@ -0,0 +1,172 @@
+package disktxstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Files are framed records: 4 bytes payload length, 4 bytes CRC-32C of payload, JSON payload.
+// Segments are named by sequence number, snapshot is named by the first segment it does not cover.
+const (
+	frameHeaderSize = 8
+	segmentExt      = ".wal"
+	snapshotExt     = ".snap"
+	tmpExt          = ".tmp"
+	// maxRecordSize guards against allocating garbage length of a torn frame
+	maxRecordSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	recordTx         = "tx"
+	recordReward     = "reward"
+	recordCheckpoint = "checkpoint"
+	recordTombstone  = "tombstone"
+)
+
+type record struct {
+	Type       string                     `json:"type"`
+	Tx         *types.Transaction         `json:"tx,omitempty"`
+	Reward     *types.ProposedBlockReward `json:"reward,omitempty"`
+	Checkpoint *types.BigInt              `json:"checkpoint,omitempty"`
+	Tombstone  *txstore.Tombstone         `json:"tombstone,omitempty"`
+}
+
+// location is where a record payload is stored
+type location struct {
+	file   *dataFile
+	offset int64
+	size   uint32
+}
+
+type dataFile struct {
+	name string
+	seq  uint64
+	f    *os.File
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+func snapshotName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, snapshotExt)
+}
+
+// listFiles returns sequence numbers of segments and snapshots in the directory, sorted, and removes leftover temporary files
+func listFiles(dir string) (segments, snapshots []uint64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list data directory")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			// Snapshot that was not completed before crash
+			if err = os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to remove incomplete snapshot")
+			}
+			continue
+		}
+		ext := filepath.Ext(name)
+		if ext != segmentExt && ext != snapshotExt {
+			continue
+		}
+		seq, parseErr := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		if ext == segmentExt {
+			segments = append(segments, seq)
+		} else {
+			snapshots = append(snapshots, seq)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	return segments, snapshots, nil
+}
+
+func encodeFrame(payload []byte) []byte {
+	out := make([]byte, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(out[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(out[4:8], crc32.Checksum(payload, crcTable))
+	copy(out[frameHeaderSize:], payload)
+	return out
+}
+
+// scanFrames calls fn for every record of the file, it returns offset after the last intact frame.
+// Error is only returned by fn and reading, torn or corrupted frame just stops the scan.
+func scanFrames(file *dataFile, fn func(loc location, payload []byte) error) (int64, error) {
+	var offset int64
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err := file.f.ReadAt(header, offset); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, errors.Wrapf(err, "failed to read %s", file.name)
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := file.f.ReadAt(payload, offset+frameHeaderSize); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, errors.Wrapf(err, "failed to read %s", file.name)
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return offset, nil
+		}
+		if err := fn(location{file: file, offset: offset + frameHeaderSize, size: size}, payload); err != nil {
+			return offset, err
+		}
+		offset += frameHeaderSize + int64(size)
+	}
+}
+
+func (l location) readPayload() ([]byte, error) {
+	out := make([]byte, l.size)
+	if _, err := l.file.f.ReadAt(out, l.offset); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", l.file.name)
+	}
+	return out, nil
+}
+
+func (l location) read() (*record, error) {
+	payload, err := l.readPayload()
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{}
+	if err = json.Unmarshal(payload, rec); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode record of %s at %d", l.file.name, l.offset)
+	}
+	return rec, nil
+}
+
+// syncDir makes file creation, rename and removal in the directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,161 @@
+package disktxstore
+
+import (
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// indexTombstone adds tombstone record to indexes and rolls back what it buries.
+// Flagged transaction is not touched, it is followed by its orphaned version in the log.
+func (s *Store) indexTombstone(tomb *txstore.Tombstone, loc location) {
+	idx := len(s.tombstones)
+	s.tombstones = append(s.tombstones, loc)
+	var addresses []string
+	switch {
+	case tomb.Transaction != nil:
+		addresses = []string{tomb.Transaction.To.String()}
+		if from := tomb.Transaction.From.String(); from != addresses[0] {
+			addresses = append(addresses, from)
+		}
+		if ref := s.txs[tomb.Transaction.Hash]; ref != nil && !tomb.Flagged {
+			s.unindexTx(ref)
+		}
+	case tomb.Reward != nil:
+		address := tomb.Reward.FeeRecipient.String()
+		addresses = []string{address}
+		key := rewardKey{blockHash: tomb.Reward.BlockHash, feeRecipient: tomb.Reward.FeeRecipient}
+		for _, ref := range s.rewards[address] {
+			if ref.key == key {
+				s.unindexReward(ref)
+				break
+			}
+		}
+	}
+	for _, address := range addresses {
+		s.addrTombs[address] = append(s.addrTombs[address], idx)
+	}
+}
+
+// Rollback rolls back transactions and rewards of blocks above blkId, after they were reorged out.
+// Rewards are removed, transactions are removed or flagged as orphaned depending on WithRollbackMode.
+// Everything rolled back is recorded as a tombstone. It returns the number of rolled back transactions and rewards.
+func (s *Store) Rollback(blkId big.Int) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if blkId.Sign() < 0 {
+		return s.rollback(0)
+	}
+	if !blkId.IsUint64() || blkId.Uint64() == ^uint64(0) {
+		return 0, nil
+	}
+	return s.rollback(blkId.Uint64() + 1)
+}
+
+// RollbackBlock rolls back the block with the hash and all blocks above it, see Rollback.
+// Block the store has nothing from is unknown to it, then nothing is rolled back.
+func (s *Store) RollbackBlock(hash types.EthHash) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ref := range s.txs {
+		if ref.blockHash == hash {
+			return s.rollback(ref.block)
+		}
+	}
+	for _, lst := range s.rewards {
+		for _, ref := range lst {
+			if ref.key.blockHash == hash {
+				return s.rollback(ref.block)
+			}
+		}
+	}
+	return 0, nil
+}
+
+// rollback rolls back everything from blocks starting with from, it has to be called with lock held
+func (s *Store) rollback(from uint64) (int, error) {
+	if s.active == nil {
+		return 0, errors.New("store is closed")
+	}
+	var txs []*txRef
+	for _, ref := range s.txs {
+		if ref.block >= from && !ref.orphaned {
+			txs = append(txs, ref)
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool { return refLess(txs[i], txs[j]) })
+	var rewards []*rewardRef
+	for _, lst := range s.rewards {
+		for _, ref := range lst {
+			if ref.block >= from {
+				rewards = append(rewards, ref)
+			}
+		}
+	}
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].block < rewards[j].block })
+
+	flag := s.rollbackMode == txstore.RollbackFlag
+	removedAt := s.now()
+	bury := func(tomb *txstore.Tombstone) error {
+		tomb.From = types.BigInt(*new(big.Int).SetUint64(from))
+		tomb.RemovedAt = removedAt
+		loc, err := s.write(&record{Type: recordTombstone, Tombstone: tomb})
+		if err != nil {
+			return err
+		}
+		s.indexTombstone(tomb, loc)
+		return nil
+	}
+	for _, ref := range txs {
+		rec, err := ref.loc.read()
+		if err != nil {
+			return 0, err
+		}
+		if err = bury(&txstore.Tombstone{Transaction: rec.Tx, Flagged: flag}); err != nil {
+			return 0, err
+		}
+		if !flag {
+			continue
+		}
+		orphan := *rec.Tx
+		orphan.Orphaned = true
+		payload, err := json.Marshal(&record{Type: recordTx, Tx: &orphan})
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to encode transaction")
+		}
+		loc, err := s.writePayload(payload)
+		if err != nil {
+			return 0, err
+		}
+		s.indexTx(&orphan, checksum(payload), loc)
+	}
+	for _, ref := range rewards {
+		rec, err := ref.loc.read()
+		if err != nil {
+			return 0, err
+		}
+		if err = bury(&txstore.Tombstone{Reward: rec.Reward}); err != nil {
+			return 0, err
+		}
+	}
+	return len(txs) + len(rewards), s.rotate()
+}
+
+// Tombstones returns rolled back transactions and rewards of the address, in the order they were rolled back
+func (s *Store) Tombstones(address string) ([]txstore.Tombstone, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var out []txstore.Tombstone
+	for _, idx := range s.addrTombs[address] {
+		rec, err := s.tombstones[idx].read()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rec.Tombstone)
+	}
+	return out, nil
+}
@@ -0,0 +1,716 @@
+// Package disktxstore is a transaction store that survives restarts, built on the standard library only.
+//
+// Every write is appended to a log segment and synced to disk before it returns. Segments are rotated once they
+// reach a size limit, and every few rotations the live records are compacted into a snapshot, which replaces the
+// segments it covers. Indexes by hash and by address are kept in memory and rebuilt from the snapshot and the
+// following segments on open, transaction bodies are read from disk.
+//
+// Rollback after a reorg appends a tombstone record for every rolled back transaction and reward, tombstones are
+// never compacted away, so the log keeps the audit trail of reorgs.
+package disktxstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"hash/fnv"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	options struct {
+		segmentSize  int64
+		compactEvery int
+		syncInterval time.Duration
+		rollbackMode txstore.RollbackMode
+		now          func() time.Time
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithSegmentSize sets size after which the log segment is rotated, 64MiB by default
+func WithSegmentSize(size int64) Option {
+	return func(opts *options) {
+		opts.segmentSize = size
+	}
+}
+
+// WithCompactEvery compacts the log into a snapshot after every n rotated segments, 8 by default, 0 disables compaction
+func WithCompactEvery(n int) Option {
+	return func(opts *options) {
+		opts.compactEvery = n
+	}
+}
+
+// WithSyncInterval syncs the log every interval instead of on every write, which is much faster,
+// but writes of the last interval can be lost on power failure
+func WithSyncInterval(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.syncInterval = interval
+	}
+}
+
+// WithRollbackMode sets what Rollback does with transactions, txstore.RollbackRemove by default
+func WithRollbackMode(mode txstore.RollbackMode) Option {
+	return func(opts *options) {
+		opts.rollbackMode = mode
+	}
+}
+
+// WithClock replaces time.Now for tombstones
+func WithClock(now func() time.Time) Option {
+	return func(opts *options) {
+		opts.now = now
+	}
+}
+
+// txRef is index entry of a stored transaction
+type txRef struct {
+	hash      types.EthHash
+	blockHash types.EthHash
+	block     uint64
+	index     uint64
+	orphaned  bool
+	// sum is a checksum of the encoded transaction, it tells duplicates from changed transactions without reading them
+	sum       uint64
+	addresses []string
+	loc       location
+}
+
+type rewardKey struct {
+	blockHash    types.EthHash
+	feeRecipient types.EthAddress
+}
+
+// rewardRef is index entry of a stored reward
+type rewardRef struct {
+	key   rewardKey
+	block uint64
+	loc   location
+}
+
+type Store struct {
+	dir string
+
+	lock sync.RWMutex
+	// files are open segments by sequence number, active is the segment written to
+	files      map[uint64]*dataFile
+	active     *dataFile
+	snapshot   *dataFile
+	activeSize int64
+	// rotated counts segments rotated since the last snapshot
+	rotated int
+	dirty   bool
+
+	txs        map[types.EthHash]*txRef
+	addrTxs    map[string][]*txRef
+	rewards    map[string][]*rewardRef
+	rewardKeys map[rewardKey]struct{}
+	checkpoint *big.Int
+	checkLoc   *location
+	// tombstones are locations of tombstone records in the order they were written, addrTombs indexes them by address
+	tombstones []location
+	addrTombs  map[string][]int
+
+	closed   chan struct{}
+	syncDone chan struct{}
+	options
+}
+
+// Open opens the store in dir, creating it if needed, and rebuilds its indexes.
+// Record torn by a crash at the end of the last segment is truncated.
+func Open(dir string, opts ...Option) (*Store, error) {
+	out := &Store{
+		dir:        dir,
+		files:      map[uint64]*dataFile{},
+		txs:        map[types.EthHash]*txRef{},
+		addrTxs:    map[string][]*txRef{},
+		rewards:    map[string][]*rewardRef{},
+		rewardKeys: map[rewardKey]struct{}{},
+		addrTombs:  map[string][]int{},
+		closed:     make(chan struct{}),
+		options: options{
+			segmentSize:  64 << 20,
+			compactEvery: 8,
+			now:          time.Now,
+		},
+	}
+	out.options.apply(opts...)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create data directory")
+	}
+	if err := out.load(); err != nil {
+		out.closeFiles()
+		return nil, err
+	}
+	if out.syncInterval > 0 {
+		out.syncDone = make(chan struct{})
+		go out.syncBody()
+	}
+	return out, nil
+}
+
+func (s *Store) load() error {
+	segments, snapshots, err := listFiles(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var snapshotSeq uint64
+	if len(snapshots) != 0 {
+		snapshotSeq = snapshots[len(snapshots)-1]
+		snapshot, err := openFile(s.dir, snapshotName(snapshotSeq), snapshotSeq, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		s.snapshot = snapshot
+		// Snapshot is renamed into place after it is synced, so it has to be intact
+		end, err := scanFrames(snapshot, s.apply)
+		if err != nil {
+			return err
+		}
+		if info, err := snapshot.f.Stat(); err != nil || info.Size() != end {
+			return errors.Errorf("snapshot %s is corrupted at %d", snapshot.name, end)
+		}
+		// Leftovers of a compaction interrupted before clean up
+		for _, seq := range snapshots[:len(snapshots)-1] {
+			if err = os.Remove(filepath.Join(s.dir, snapshotName(seq))); err != nil {
+				return errors.Wrap(err, "failed to remove old snapshot")
+			}
+		}
+	}
+
+	var live []uint64
+	for _, seq := range segments {
+		if seq < snapshotSeq {
+			if err = os.Remove(filepath.Join(s.dir, segmentName(seq))); err != nil {
+				return errors.Wrap(err, "failed to remove compacted segment")
+			}
+			continue
+		}
+		live = append(live, seq)
+	}
+	for i, seq := range live {
+		segment, err := openFile(s.dir, segmentName(seq), seq, os.O_RDWR)
+		if err != nil {
+			return err
+		}
+		s.files[seq] = segment
+		end, err := scanFrames(segment, s.apply)
+		if err != nil {
+			return err
+		}
+		info, err := segment.f.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", segment.name)
+		}
+		if info.Size() == end {
+			continue
+		}
+		if i != len(live)-1 {
+			return errors.Errorf("segment %s is corrupted at %d", segment.name, end)
+		}
+		if err = segment.f.Truncate(end); err != nil {
+			return errors.Wrapf(err, "failed to truncate torn record of %s", segment.name)
+		}
+		if err = segment.f.Sync(); err != nil {
+			return errors.Wrapf(err, "failed to sync %s", segment.name)
+		}
+	}
+
+	if len(live) != 0 {
+		s.active = s.files[live[len(live)-1]]
+		info, err := s.active.f.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", s.active.name)
+		}
+		s.activeSize = info.Size()
+		s.rotated = len(live) - 1
+		return nil
+	}
+	return s.newSegment(snapshotSeq + 1)
+}
+
+func openFile(dir, name string, seq uint64, flag int) (*dataFile, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name), flag, 0o644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", name)
+	}
+	return &dataFile{name: name, seq: seq, f: f}, nil
+}
+
+func (s *Store) newSegment(seq uint64) error {
+	segment, err := openFile(s.dir, segmentName(seq), seq, os.O_RDWR|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	s.files[seq] = segment
+	if err = syncDir(s.dir); err != nil {
+		return errors.Wrap(err, "failed to sync data directory")
+	}
+	s.active = segment
+	s.activeSize = 0
+	return nil
+}
+
+// apply adds record read from disk to indexes
+func (s *Store) apply(loc location, payload []byte) error {
+	rec := record{}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return errors.Wrapf(err, "failed to decode record of %s at %d", loc.file.name, loc.offset)
+	}
+	switch {
+	case rec.Type == recordTx && rec.Tx != nil:
+		s.indexTx(rec.Tx, checksum(payload), loc)
+	case rec.Type == recordReward && rec.Reward != nil:
+		s.indexReward(rec.Reward, loc)
+	case rec.Type == recordCheckpoint && rec.Checkpoint != nil:
+		s.checkpoint = new(big.Int).Set(rec.Checkpoint.AsBigInt())
+		s.checkLoc = &loc
+	case rec.Type == recordTombstone && rec.Tombstone != nil:
+		s.indexTombstone(rec.Tombstone, loc)
+	default:
+		return errors.Errorf("unknown record %q in %s at %d", rec.Type, loc.file.name, loc.offset)
+	}
+	return nil
+}
+
+func checksum(payload []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(payload)
+	return h.Sum64()
+}
+
+func (s *Store) indexTx(tx *types.Transaction, sum uint64, loc location) {
+	if old := s.txs[tx.Hash]; old != nil {
+		s.unindexTx(old)
+	}
+	ref := &txRef{
+		hash:      tx.Hash,
+		blockHash: tx.BlockHash,
+		block:     tx.BlockNumber.AsBigInt().Uint64(),
+		index:     tx.TransactionIndex.AsBigInt().Uint64(),
+		orphaned:  tx.Orphaned,
+		sum:       sum,
+		loc:       loc,
+	}
+	ref.addresses = []string{tx.To.String()}
+	if from := tx.From.String(); from != ref.addresses[0] {
+		ref.addresses = append(ref.addresses, from)
+	}
+	s.txs[tx.Hash] = ref
+	for _, address := range ref.addresses {
+		s.addrTxs[address] = insertSorted(s.addrTxs[address], ref)
+	}
+}
+
+func (s *Store) unindexTx(ref *txRef) {
+	delete(s.txs, ref.hash)
+	for _, address := range ref.addresses {
+		lst := s.addrTxs[address]
+		idx := sort.Search(len(lst), func(i int) bool { return !refLess(lst[i], ref) })
+		for ; idx < len(lst) && lst[idx] != ref; idx++ {
+		}
+		if idx == len(lst) {
+			continue
+		}
+		if lst = append(lst[:idx], lst[idx+1:]...); len(lst) == 0 {
+			delete(s.addrTxs, address)
+			continue
+		}
+		s.addrTxs[address] = lst
+	}
+}
+
+func (s *Store) indexReward(reward *types.ProposedBlockReward, loc location) bool {
+	key := rewardKey{blockHash: reward.BlockHash, feeRecipient: reward.FeeRecipient}
+	if _, ok := s.rewardKeys[key]; ok {
+		return false
+	}
+	s.rewardKeys[key] = struct{}{}
+	address := reward.FeeRecipient.String()
+	s.rewards[address] = append(s.rewards[address], &rewardRef{key: key, block: reward.BlockNumber.AsBigInt().Uint64(), loc: loc})
+	return true
+}
+
+func (s *Store) unindexReward(ref *rewardRef) {
+	delete(s.rewardKeys, ref.key)
+	address := ref.key.feeRecipient.String()
+	lst := s.rewards[address]
+	for i, val := range lst {
+		if val == ref {
+			lst = append(lst[:i], lst[i+1:]...)
+			break
+		}
+	}
+	if len(lst) == 0 {
+		delete(s.rewards, address)
+	} else {
+		s.rewards[address] = lst
+	}
+}
+
+// refLess orders transactions by block number, then by index in the block, then by hash, see txstore.Position
+func refLess(a, b *txRef) bool {
+	if a.block != b.block {
+		return a.block < b.block
+	}
+	if a.index != b.index {
+		return a.index < b.index
+	}
+	return bytes.Compare(a.hash[:], b.hash[:]) < 0
+}
+
+func insertSorted(lst []*txRef, ref *txRef) []*txRef {
+	if len(lst) == 0 || !refLess(ref, lst[len(lst)-1]) {
+		return append(lst, ref)
+	}
+	idx := sort.Search(len(lst), func(i int) bool { return refLess(ref, lst[i]) })
+	lst = append(lst, nil)
+	copy(lst[idx+1:], lst[idx:])
+	lst[idx] = ref
+	return lst
+}
+
+// write appends record to the active segment, it has to be called with lock held
+func (s *Store) write(rec *record) (location, error) {
+	if s.active == nil {
+		return location{}, errors.New("store is closed")
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return location{}, errors.Wrapf(err, "failed to encode %s record", rec.Type)
+	}
+	return s.writePayload(payload)
+}
+
+func (s *Store) writePayload(payload []byte) (location, error) {
+	frame := encodeFrame(payload)
+	if _, err := s.active.f.WriteAt(frame, s.activeSize); err != nil {
+		// Partial frame is overwritten by the next write and truncated on open
+		return location{}, errors.Wrapf(err, "failed to write %s", s.active.name)
+	}
+	if s.syncInterval > 0 {
+		s.dirty = true
+	} else if err := s.active.f.Sync(); err != nil {
+		return location{}, errors.Wrapf(err, "failed to sync %s", s.active.name)
+	}
+	loc := location{file: s.active, offset: s.activeSize + frameHeaderSize, size: uint32(len(payload))}
+	s.activeSize += int64(len(frame))
+	return loc, nil
+}
+
+// rotate seals the active segment once it is full and compacts the log once enough segments are rotated.
+// It is called after the written record is indexed, so that compaction picks it up.
+func (s *Store) rotate() error {
+	if s.activeSize < s.segmentSize {
+		return nil
+	}
+	if err := s.active.f.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync %s", s.active.name)
+	}
+	s.dirty = false
+	if err := s.newSegment(s.active.seq + 1); err != nil {
+		return err
+	}
+	s.rotated++
+	if s.compactEvery > 0 && s.rotated >= s.compactEvery {
+		return s.compact()
+	}
+	return nil
+}
+
+// StoreTransaction stores the transaction under its sender and recipient.
+// Storing the same transaction again is a no-op, storing it with different content replaces it.
+func (s *Store) StoreTransaction(tx *types.Transaction) error {
+	payload, err := json.Marshal(&record{Type: recordTx, Tx: tx})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode transaction")
+	}
+	sum := checksum(payload)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active == nil {
+		return errors.New("store is closed")
+	}
+	if old := s.txs[tx.Hash]; old != nil && old.sum == sum {
+		return nil
+	}
+	loc, err := s.writePayload(payload)
+	if err != nil {
+		return err
+	}
+	s.indexTx(tx, sum, loc)
+	return s.rotate()
+}
+
+func (s *Store) readTxs(refs []*txRef) ([]*types.Transaction, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	out := make([]*types.Transaction, len(refs))
+	for i, ref := range refs {
+		rec, err := ref.loc.read()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rec.Tx
+	}
+	return out, nil
+}
+
+// GetTransactions returns transactions sent from or to the address, ordered by block number and transaction index
+func (s *Store) GetTransactions(address string) ([]*types.Transaction, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.readTxs(s.addrTxs[address])
+}
+
+// GetTransactionsAfterBlock returns transactions of the address from blocks above blkId, in the same order as GetTransactions
+func (s *Store) GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	lst := s.addrTxs[address]
+	if !blkId.IsUint64() {
+		if blkId.Sign() < 0 {
+			return s.readTxs(lst)
+		}
+		return nil, nil
+	}
+	after := blkId.Uint64()
+	idx := sort.Search(len(lst), func(i int) bool { return lst[i].block > after })
+	return s.readTxs(lst[idx:])
+}
+
+// StoreBlockReward stores the reward, storing reward of the same block again is a no-op
+func (s *Store) StoreBlockReward(reward *types.ProposedBlockReward) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.rewardKeys[rewardKey{blockHash: reward.BlockHash, feeRecipient: reward.FeeRecipient}]; ok {
+		return nil
+	}
+	loc, err := s.write(&record{Type: recordReward, Reward: reward})
+	if err != nil {
+		return err
+	}
+	s.indexReward(reward, loc)
+	return s.rotate()
+}
+
+// GetBlockRewards returns rewards of the fee recipient in the order they were stored
+func (s *Store) GetBlockRewards(address string) ([]*types.ProposedBlockReward, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	refs := s.rewards[address]
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	out := make([]*types.ProposedBlockReward, len(refs))
+	for i, ref := range refs {
+		rec, err := ref.loc.read()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rec.Reward
+	}
+	return out, nil
+}
+
+// SaveCheckpoint records the last block whose transactions are all stored
+func (s *Store) SaveCheckpoint(blkId big.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.checkpoint != nil && s.checkpoint.Cmp(&blkId) == 0 {
+		return nil
+	}
+	loc, err := s.write(&record{Type: recordCheckpoint, Checkpoint: (*types.BigInt)(&blkId)})
+	if err != nil {
+		return err
+	}
+	s.checkpoint = new(big.Int).Set(&blkId)
+	s.checkLoc = &loc
+	return s.rotate()
+}
+
+// GetCheckpoint returns the last saved checkpoint, nil if there is none
+func (s *Store) GetCheckpoint() (*big.Int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.checkpoint == nil {
+		return nil, nil
+	}
+	return new(big.Int).Set(s.checkpoint), nil
+}
+
+// Compact writes live records into a snapshot and removes segments it replaces
+func (s *Store) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active == nil {
+		return errors.New("store is closed")
+	}
+	if err := s.active.f.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync %s", s.active.name)
+	}
+	s.dirty = false
+	if err := s.newSegment(s.active.seq + 1); err != nil {
+		return err
+	}
+	return s.compact()
+}
+
+// compact snapshots everything before the active segment, it has to be called with lock held right after rotation
+func (s *Store) compact() error {
+	seq := s.active.seq
+	tmpPath := filepath.Join(s.dir, snapshotName(seq)+tmpExt)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create snapshot")
+	}
+	snapshot := &dataFile{name: snapshotName(seq), seq: seq, f: tmp}
+
+	// Records are copied as they are and indexes are moved to the snapshot only once it is in place
+	var offset int64
+	moves := map[*location]location{}
+	copyRecord := func(loc *location) error {
+		payload, err := loc.readPayload()
+		if err != nil {
+			return err
+		}
+		frame := encodeFrame(payload)
+		if _, err = tmp.WriteAt(frame, offset); err != nil {
+			return errors.Wrap(err, "failed to write snapshot")
+		}
+		moves[loc] = location{file: snapshot, offset: offset + frameHeaderSize, size: loc.size}
+		offset += int64(len(frame))
+		return nil
+	}
+
+	// Tombstones go first, so that on load they do not remove transactions stored again after them
+	for i := range s.tombstones {
+		if err = copyRecord(&s.tombstones[i]); err != nil {
+			break
+		}
+	}
+	refs := make([]*txRef, 0, len(s.txs))
+	for _, ref := range s.txs {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refLess(refs[i], refs[j]) })
+	for _, ref := range refs {
+		if err == nil {
+			err = copyRecord(&ref.loc)
+		}
+	}
+	for _, lst := range s.rewards {
+		for _, ref := range lst {
+			if err == nil {
+				err = copyRecord(&ref.loc)
+			}
+		}
+	}
+	if err == nil && s.checkLoc != nil {
+		err = copyRecord(s.checkLoc)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(s.dir, snapshot.name))
+	}
+	if err == nil {
+		err = syncDir(s.dir)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	for loc, moved := range moves {
+		*loc = moved
+	}
+	var obsolete []*dataFile
+	for fileSeq, file := range s.files {
+		if fileSeq < seq {
+			obsolete = append(obsolete, file)
+			delete(s.files, fileSeq)
+		}
+	}
+	if s.snapshot != nil {
+		obsolete = append(obsolete, s.snapshot)
+	}
+	s.snapshot = snapshot
+	s.rotated = 0
+	for _, file := range obsolete {
+		_ = file.f.Close()
+		if err = os.Remove(filepath.Join(s.dir, file.name)); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", file.name)
+		}
+	}
+	return syncDir(s.dir)
+}
+
+func (s *Store) syncBody() {
+	defer close(s.syncDone)
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		if s.dirty && s.active != nil {
+			if err := s.active.f.Sync(); err == nil {
+				s.dirty = false
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Close syncs and closes data files, the store can not be used afterwards
+func (s *Store) Close() error {
+	s.lock.Lock()
+	if s.active == nil {
+		s.lock.Unlock()
+		return nil
+	}
+	err := s.active.f.Sync()
+	s.closeFiles()
+	s.lock.Unlock()
+
+	close(s.closed)
+	if s.syncDone != nil {
+		<-s.syncDone
+	}
+	return errors.Wrap(err, "failed to sync log")
+}
+
+func (s *Store) closeFiles() {
+	for _, file := range s.files {
+		_ = file.f.Close()
+	}
+	if s.snapshot != nil {
+		_ = s.snapshot.f.Close()
+	}
+	s.active = nil
+}
@@ -0,0 +1,303 @@
+package disktxstore_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/disktxstore"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/txstore/storetest"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+)
+
+func newTx(n byte, block, index int64) *types.Transaction {
+	return &types.Transaction{
+		Hash:             types.EthHash{n},
+		BlockHash:        types.EthHash{0xff, byte(block)},
+		BlockNumber:      types.BigInt(*big.NewInt(block)),
+		TransactionIndex: types.BigInt(*big.NewInt(index)),
+		From:             alice,
+		To:               bob,
+		Value:            types.BigInt(*big.NewInt(int64(n) * 1000)),
+		Input:            types.BinData{1, 2, 3, n},
+	}
+}
+
+// hashes are compared instead of transactions, since decoded big integers differ from constructed ones internally
+func hashes(txs []*types.Transaction, err error) []types.EthHash {
+	if err != nil {
+		panic(err)
+	}
+	var out []types.EthHash
+	for _, tx := range txs {
+		out = append(out, tx.Hash)
+	}
+	return out
+}
+
+func segments(t *testing.T, dir string) (wal, snap []string) {
+	t.Helper()
+	wal, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	snap, err = filepath.Glob(filepath.Join(dir, "*.snap"))
+	require.NoError(t, err)
+	return wal, snap
+}
+
+func TestStoreOrderAndDedup(t *testing.T) {
+	store, err := disktxstore.Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	b5i1, b3i0, b5i0, b7i2 := newTx(1, 5, 1), newTx(2, 3, 0), newTx(3, 5, 0), newTx(4, 7, 2)
+	for _, tx := range []*types.Transaction{b5i1, b3i0, b5i0, b7i2, b3i0} {
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	dup := *b5i1
+	require.NoError(t, store.StoreTransaction(&dup))
+
+	ordered := []types.EthHash{b3i0.Hash, b5i0.Hash, b5i1.Hash, b7i2.Hash}
+	assert.Equal(t, ordered, hashes(store.GetTransactions(alice.String())))
+	assert.Equal(t, ordered, hashes(store.GetTransactions(bob.String())))
+	assert.Equal(t, ordered[1:], hashes(store.GetTransactionsAfterBlock(*big.NewInt(4), alice.String())))
+	assert.Nil(t, hashes(store.GetTransactionsAfterBlock(*big.NewInt(7), alice.String())))
+
+	txs, err := store.GetTransactions(alice.String())
+	require.NoError(t, err)
+	assert.True(t, b3i0.Equal(txs[0]))
+
+	// Transaction re-included into another block replaces the old one
+	moved := *b3i0
+	moved.BlockNumber = types.BigInt(*big.NewInt(8))
+	require.NoError(t, store.StoreTransaction(&moved))
+	assert.Equal(t, []types.EthHash{b5i0.Hash, b5i1.Hash, b7i2.Hash, b3i0.Hash}, hashes(store.GetTransactions(alice.String())))
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := disktxstore.Open(dir)
+	require.NoError(t, err)
+
+	checkpoint, err := store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	require.NoError(t, store.StoreTransaction(newTx(1, 1, 0)))
+	require.NoError(t, store.StoreTransaction(newTx(2, 2, 0)))
+	reward := &types.ProposedBlockReward{BlockHash: types.EthHash{0xff, 2}, BlockNumber: types.BigInt(*big.NewInt(2)), FeeRecipient: alice}
+	require.NoError(t, store.StoreBlockReward(reward))
+	require.NoError(t, store.StoreBlockReward(reward))
+	require.NoError(t, store.SaveCheckpoint(*big.NewInt(2)))
+	require.NoError(t, store.Close())
+
+	store, err = disktxstore.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, []types.EthHash{{1}, {2}}, hashes(store.GetTransactions(alice.String())))
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	require.Len(t, rewards, 1)
+	assert.Equal(t, reward.BlockHash, rewards[0].BlockHash)
+	checkpoint, err = store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), checkpoint)
+
+	// Writes continue the same log
+	require.NoError(t, store.StoreTransaction(newTx(3, 3, 0)))
+	assert.Equal(t, []types.EthHash{{1}, {2}, {3}}, hashes(store.GetTransactions(bob.String())))
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	store, err := disktxstore.Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.StoreTransaction(newTx(1, 1, 0)))
+	require.NoError(t, store.StoreTransaction(newTx(2, 2, 0)))
+	require.NoError(t, store.Close())
+
+	// Crash in the middle of the second record
+	wal, _ := segments(t, dir)
+	require.Len(t, wal, 1)
+	info, err := os.Stat(wal[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(wal[0], info.Size()-5))
+
+	store, err = disktxstore.Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []types.EthHash{{1}}, hashes(store.GetTransactions(alice.String())))
+	require.NoError(t, store.StoreTransaction(newTx(3, 3, 0)))
+	require.NoError(t, store.Close())
+
+	store, err = disktxstore.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, []types.EthHash{{1}, {3}}, hashes(store.GetTransactions(alice.String())))
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	store, err := disktxstore.Open(dir, disktxstore.WithSegmentSize(1024), disktxstore.WithCompactEvery(3))
+	require.NoError(t, err)
+
+	var expected []types.EthHash
+	for n := range 50 {
+		tx := newTx(byte(n), int64(n), 0)
+		require.NoError(t, store.StoreTransaction(tx))
+		// Every transaction is written twice, compaction keeps only the last version
+		tx.Value = types.BigInt(*big.NewInt(int64(n)))
+		require.NoError(t, store.StoreTransaction(tx))
+		require.NoError(t, store.SaveCheckpoint(*big.NewInt(int64(n))))
+		expected = append(expected, tx.Hash)
+	}
+	wal, snap := segments(t, dir)
+	assert.LessOrEqual(t, len(wal), 3)
+	assert.Len(t, snap, 1)
+	assert.Equal(t, expected, hashes(store.GetTransactions(alice.String())))
+
+	require.NoError(t, store.Compact())
+	wal, snap = segments(t, dir)
+	assert.Len(t, wal, 1)
+	assert.Len(t, snap, 1)
+	require.NoError(t, store.Close())
+
+	store, err = disktxstore.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	txs, err := store.GetTransactions(alice.String())
+	assert.Equal(t, expected, hashes(txs, err))
+	for n, tx := range txs {
+		assert.Equal(t, int64(n), tx.Value.AsBigInt().Int64())
+	}
+	checkpoint, err := store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(49), checkpoint)
+}
+
+func TestParallel(t *testing.T) {
+	dir := t.TempDir()
+	store, err := disktxstore.Open(dir, disktxstore.WithSegmentSize(4096), disktxstore.WithSyncInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for w := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := range 50 {
+				tx := newTx(byte(n), int64(n), int64(w))
+				tx.Hash = types.EthHash{byte(n), byte(w)}
+				if err := store.StoreTransaction(tx); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := store.GetTransactions(alice.String()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, store.Close())
+
+	store, err = disktxstore.Open(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	txs, err := store.GetTransactions(bob.String())
+	require.NoError(t, err)
+	assert.Len(t, txs, 200)
+	for i := 1; i < len(txs); i++ {
+		assert.LessOrEqual(t, txs[i-1].BlockNumber.AsBigInt().Int64(), txs[i].BlockNumber.AsBigInt().Int64())
+	}
+}
+
+func TestRollback(t *testing.T) {
+	for _, mode := range []txstore.RollbackMode{txstore.RollbackRemove, txstore.RollbackFlag} {
+		dir := t.TempDir()
+		store, err := disktxstore.Open(dir, disktxstore.WithRollbackMode(mode))
+		require.NoError(t, err)
+		for n := range 4 {
+			require.NoError(t, store.StoreTransaction(newTx(byte(n+1), int64(n+1), 0)))
+			reward := &types.ProposedBlockReward{BlockHash: types.EthHash{0xff, byte(n + 1)}, BlockNumber: types.BigInt(*big.NewInt(int64(n + 1))), FeeRecipient: alice}
+			require.NoError(t, store.StoreBlockReward(reward))
+		}
+
+		removed, err := store.RollbackBlock(types.EthHash{0xff, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		removed, err = store.Rollback(*big.NewInt(2))
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		removed, err = store.RollbackBlock(types.EthHash{0xff, 9})
+		require.NoError(t, err)
+		assert.Zero(t, removed)
+
+		// Transaction 3 is included again by the new branch
+		require.NoError(t, store.StoreTransaction(newTx(3, 5, 0)))
+
+		check := func(store *disktxstore.Store) {
+			txs, err := store.GetTransactions(alice.String())
+			if mode == txstore.RollbackFlag {
+				require.Equal(t, []types.EthHash{{1}, {2}, {4}, {3}}, hashes(txs, err))
+				assert.Equal(t, []bool{false, false, true, false}, []bool{txs[0].Orphaned, txs[1].Orphaned, txs[2].Orphaned, txs[3].Orphaned})
+			} else {
+				assert.Equal(t, []types.EthHash{{1}, {2}, {3}}, hashes(txs, err))
+			}
+			rewards, err := store.GetBlockRewards(alice.String())
+			require.NoError(t, err)
+			assert.Len(t, rewards, 2)
+
+			tombs, err := store.Tombstones(bob.String())
+			require.NoError(t, err)
+			require.Len(t, tombs, 2)
+			assert.Equal(t, types.EthHash{4}, tombs[0].Transaction.Hash)
+			assert.Equal(t, int64(4), tombs[0].From.AsBigInt().Int64())
+			assert.Equal(t, types.EthHash{3}, tombs[1].Transaction.Hash)
+			assert.Equal(t, int64(3), tombs[1].From.AsBigInt().Int64())
+			assert.Equal(t, mode == txstore.RollbackFlag, tombs[1].Flagged)
+			tombs, err = store.Tombstones(alice.String())
+			require.NoError(t, err)
+			assert.Len(t, tombs, 4)
+		}
+		check(store)
+		require.NoError(t, store.Close())
+
+		// Tombstones are replayed on open and survive compaction
+		store, err = disktxstore.Open(dir)
+		require.NoError(t, err)
+		check(store)
+		require.NoError(t, store.Compact())
+		require.NoError(t, store.Close())
+		store, err = disktxstore.Open(dir)
+		require.NoError(t, err)
+		check(store)
+		require.NoError(t, store.Close())
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for name, mode := range map[string]txstore.RollbackMode{"Remove": txstore.RollbackRemove, "Flag": txstore.RollbackFlag} {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) txstore.Store {
+				store, err := disktxstore.Open(t.TempDir(), disktxstore.WithSegmentSize(4096), disktxstore.WithRollbackMode(mode))
+				require.NoError(t, err)
+				t.Cleanup(func() { _ = store.Close() })
+				return store
+			})
+		})
+	}
+}
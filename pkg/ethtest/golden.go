@@ -0,0 +1,48 @@
+package ethtest
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsf/jsondiff"
+)
+
+// Update tells tests to rewrite golden files and recordings instead of comparing with them: go test ./... -update
+var Update = flag.Bool("update", false, "rewrite golden files instead of comparing with them")
+
+var goldenDiffOptions = &jsondiff.Options{
+	Added:            jsondiff.Tag{Begin: "++++ ", End: " ===="},
+	Removed:          jsondiff.Tag{Begin: "---- ", End: " ===="},
+	Changed:          jsondiff.Tag{Begin: "---- ", End: " ===="},
+	ChangedSeparator: " ++++ ",
+	Indent:           "    ",
+}
+
+// AssertGolden marshals got and compares it with JSON stored in the golden file, ignoring formatting and key order.
+// With -update the file is rewritten with got instead.
+func AssertGolden(t testing.TB, path string, got any) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %s", path, err)
+	}
+	if *Update {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			t.Fatalf("failed to update %s: %s", path, err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %s", err)
+	}
+	if status, diff := jsondiff.Compare(expected, data, goldenDiffOptions); status != jsondiff.FullMatch {
+		t.Errorf("output does not match %s, run with -update if the change is expected:\n%s", path, diff)
+	}
+}
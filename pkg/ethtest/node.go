@@ -0,0 +1,859 @@
+// Package ethtest provides a fake Ethereum node serving JSON-RPC over httptest with a chain scripted by the test.
+// Tests append blocks, inject reorgs, RPC errors, rate limits and latency, and then run real clients against URL.
+package ethtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// AnyMethod matches every method in failure rules
+const AnyMethod = "*"
+
+// Tx describes transaction to include into a block, zero Gas defaults to 21000 and zero GasUsed to Gas
+type Tx struct {
+	From     types.EthAddress
+	To       types.EthAddress
+	Value    int64
+	Gas      int64
+	GasUsed  int64
+	GasPrice int64
+	// MaxFeePerGas and MaxPriorityFeePerGas make it a dynamic fee transaction when set
+	MaxFeePerGas         int64
+	MaxPriorityFeePerGas int64
+	Input                []byte
+	Logs                 []Log
+	// Failed makes receipt of the transaction report it reverted
+	Failed bool
+}
+
+// Log describes event emitted by a transaction
+type Log struct {
+	Address types.EthAddress
+	Topics  []types.EthHash
+	Data    []byte
+}
+
+type (
+	options struct {
+		chainID   int64
+		blockTime uint64
+		baseFee   int64
+		gasLimit  int64
+		genesis   time.Time
+	}
+
+	Option func(opts *options)
+)
+
+// WithChainID sets value returned by eth_chainId, 1 by default
+func WithChainID(id int64) Option {
+	return func(opts *options) {
+		opts.chainID = id
+	}
+}
+
+// WithBlockTime sets seconds between block timestamps, 12 by default
+func WithBlockTime(seconds uint64) Option {
+	return func(opts *options) {
+		opts.blockTime = seconds
+	}
+}
+
+// WithBaseFee sets base fee per gas of every block, 1 gwei by default
+func WithBaseFee(wei int64) Option {
+	return func(opts *options) {
+		opts.baseFee = wei
+	}
+}
+
+type (
+	block struct {
+		number    uint64
+		hash      types.EthHash
+		parent    types.EthHash
+		timestamp uint64
+		miner     types.EthAddress
+		baseFee   int64
+		gasLimit  int64
+		txs       []*tx
+	}
+
+	tx struct {
+		Tx
+		hash  types.EthHash
+		index int
+		// firstLogIndex is index of the first log of the transaction within the block
+		firstLogIndex int
+	}
+
+	balanceAt struct {
+		number uint64
+		wei    *big.Int
+	}
+
+	failure struct {
+		method string
+		count  int
+		status int
+		err    *rpc.ErrorObject
+		null   bool
+	}
+)
+
+// Node is a fake node, all methods are safe for concurrent use
+type Node struct {
+	srv  *httptest.Server
+	opts options
+
+	lock  sync.Mutex
+	chain []*block
+	fork  int
+	miner types.EthAddress
+	// balances are history of SetBalance, by the block each value was set at
+	balances map[types.EthAddress][]balanceAt
+	failures []*failure
+	latency  time.Duration
+	calls    map[string]int
+}
+
+// NewNode starts node with genesis block only, it is closed when the test finishes
+func NewNode(t testing.TB, opts ...Option) *Node {
+	out := &Node{
+		opts: options{
+			chainID:   1,
+			blockTime: 12,
+			baseFee:   1_000_000_000,
+			gasLimit:  30_000_000,
+			genesis:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		balances: make(map[types.EthAddress][]balanceAt),
+		calls:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(&out.opts)
+	}
+	out.chain = []*block{out.newBlock(0, types.EthHash{}, nil)}
+	out.srv = httptest.NewServer(http.HandlerFunc(out.serveHTTP))
+	t.Cleanup(out.Close)
+	return out
+}
+
+// URL returns http:// endpoint of the node
+func (n *Node) URL() string {
+	return n.srv.URL
+}
+
+// Close shuts the server down
+func (n *Node) Close() {
+	n.srv.Close()
+}
+
+// SetMiner sets fee recipient of blocks appended afterwards
+func (n *Node) SetMiner(address types.EthAddress) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.miner = address
+}
+
+// SetBalance sets value returned by eth_getBalance for the address at the current head and blocks appended after it,
+// balance at earlier blocks stays as it was
+func (n *Node) SetBalance(address types.EthAddress, wei *big.Int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	head := uint64(len(n.chain) - 1)
+	history := slices.DeleteFunc(n.balances[address], func(b balanceAt) bool { return b.number >= head })
+	n.balances[address] = append(history, balanceAt{number: head, wei: new(big.Int).Set(wei)})
+}
+
+// SetLatency delays every HTTP response
+func (n *Node) SetLatency(latency time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.latency = latency
+}
+
+// AppendBlock mines block with the transactions on top of the chain
+func (n *Node) AppendBlock(txs ...Tx) *types.BlockDetailed {
+	n.lock.Lock()
+	head := n.chain[len(n.chain)-1]
+	blk := n.newBlock(head.number+1, head.hash, txs)
+	n.chain = append(n.chain, blk)
+	n.lock.Unlock()
+	return n.decode(blk)
+}
+
+// AppendBlocks mines count empty blocks
+func (n *Node) AppendBlocks(count int) {
+	for i := 0; i < count; i++ {
+		n.AppendBlock()
+	}
+}
+
+// Reorg replaces depth top blocks with the same number of blocks of a new fork, carrying the given transactions in its first block
+func (n *Node) Reorg(depth int, txs ...Tx) []*types.BlockDetailed {
+	n.lock.Lock()
+	if depth >= len(n.chain) {
+		depth = len(n.chain) - 1
+	}
+	n.fork++
+	n.chain = n.chain[:len(n.chain)-depth]
+	replaced := make([]*block, 0, depth)
+	for i := 0; i < depth; i++ {
+		head := n.chain[len(n.chain)-1]
+		var blockTxs []Tx
+		if i == 0 {
+			blockTxs = txs
+		}
+		blk := n.newBlock(head.number+1, head.hash, blockTxs)
+		n.chain = append(n.chain, blk)
+		replaced = append(replaced, blk)
+	}
+	n.lock.Unlock()
+
+	out := make([]*types.BlockDetailed, len(replaced))
+	for i, blk := range replaced {
+		out[i] = n.decode(blk)
+	}
+	return out
+}
+
+// Head returns the top block
+func (n *Node) Head() *types.BlockDetailed {
+	n.lock.Lock()
+	head := n.chain[len(n.chain)-1]
+	n.lock.Unlock()
+	return n.decode(head)
+}
+
+// Block returns canonical block by number, nil if it does not exist yet
+func (n *Node) Block(number uint64) *types.BlockDetailed {
+	n.lock.Lock()
+	if number >= uint64(len(n.chain)) {
+		n.lock.Unlock()
+		return nil
+	}
+	blk := n.chain[number]
+	n.lock.Unlock()
+	return n.decode(blk)
+}
+
+// FailNext answers next count calls of the method, or of any method with AnyMethod, with JSON-RPC error
+func (n *Node) FailNext(method string, count, code int, message string) {
+	n.addFailure(&failure{method: method, count: count, err: &rpc.ErrorObject{Code: code, Message: message}})
+}
+
+// FailHTTP answers next count HTTP requests calling the method with the status and empty body
+func (n *Node) FailHTTP(method string, count, status int) {
+	n.addFailure(&failure{method: method, count: count, status: status})
+}
+
+// RateLimit answers next count HTTP requests with 429 and limit exceeded error, the way providers throttle
+func (n *Node) RateLimit(count int) {
+	n.addFailure(&failure{
+		method: AnyMethod,
+		count:  count,
+		status: http.StatusTooManyRequests,
+		err:    &rpc.ErrorObject{Code: rpc.CodeLimitExceeded, Message: "rate limit exceeded"},
+	})
+}
+
+// ReturnNull answers next count calls of the method with null result, like a load balanced node lagging behind
+func (n *Node) ReturnNull(method string, count int) {
+	n.addFailure(&failure{method: method, count: count, null: true})
+}
+
+// Calls returns number of calls of the method, or of all methods with AnyMethod
+func (n *Node) Calls(method string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if method != AnyMethod {
+		return n.calls[method]
+	}
+	var out int
+	for _, count := range n.calls {
+		out += count
+	}
+	return out
+}
+
+func (n *Node) addFailure(f *failure) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.failures = append(n.failures, f)
+}
+
+// takeFailure consumes first failure rule matching the method, it is called with lock held
+func (n *Node) takeFailure(method string, match func(*failure) bool) *failure {
+	for i, f := range n.failures {
+		if (f.method == method || f.method == AnyMethod) && match(f) {
+			f.count--
+			if f.count <= 0 {
+				n.failures = append(n.failures[:i], n.failures[i+1:]...)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func isHTTPFailure(f *failure) bool {
+	return f.status != 0
+}
+
+func isCallFailure(f *failure) bool {
+	return f.status == 0
+}
+
+func hashOf(format string, args ...any) types.EthHash {
+	return sha256.Sum256([]byte(fmt.Sprintf(format, args...)))
+}
+
+// newBlock builds block, it is called with lock held or before server starts
+func (n *Node) newBlock(number uint64, parent types.EthHash, txs []Tx) *block {
+	out := &block{
+		number:    number,
+		hash:      hashOf("block/%d/%d", n.fork, number),
+		parent:    parent,
+		timestamp: uint64(n.opts.genesis.Unix()) + number*n.opts.blockTime,
+		miner:     n.miner,
+		baseFee:   n.opts.baseFee,
+		gasLimit:  n.opts.gasLimit,
+	}
+	logIndex := 0
+	for i, spec := range txs {
+		if spec.Gas == 0 {
+			spec.Gas = 21000
+		}
+		if spec.GasUsed == 0 {
+			spec.GasUsed = spec.Gas
+		}
+		if spec.GasPrice == 0 && spec.MaxFeePerGas == 0 {
+			spec.GasPrice = n.opts.baseFee
+		}
+		out.txs = append(out.txs, &tx{
+			Tx:            spec,
+			hash:          hashOf("tx/%d/%d/%d", n.fork, number, i),
+			index:         i,
+			firstLogIndex: logIndex,
+		})
+		logIndex += len(spec.Logs)
+	}
+	return out
+}
+
+func (n *Node) decode(blk *block) *types.BlockDetailed {
+	data, err := json.Marshal(blk.toJSON(true))
+	if err != nil {
+		panic(err)
+	}
+	out := new(types.BlockDetailed)
+	if err = json.Unmarshal(data, out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (n *Node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var requests []*rpc.Message
+	batch := len(bytes.TrimSpace(body)) > 0 && bytes.TrimSpace(body)[0] == '['
+	if batch {
+		err = json.Unmarshal(body, &requests)
+	} else {
+		var req rpc.Message
+		err = json.Unmarshal(body, &req)
+		requests = []*rpc.Message{&req}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, &rpc.Message{
+			JSONRPC: "2.0",
+			ID:      json.RawMessage("null"),
+			Error:   &rpc.ErrorObject{Code: rpc.CodeParseError, Message: "parse error"},
+		})
+		return
+	}
+
+	n.lock.Lock()
+	latency := n.latency
+	var httpFailure *failure
+	for _, req := range requests {
+		n.calls[req.Method]++
+		if httpFailure == nil {
+			httpFailure = n.takeFailure(req.Method, isHTTPFailure)
+		}
+	}
+	n.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if httpFailure != nil {
+		if httpFailure.err == nil {
+			w.WriteHeader(httpFailure.status)
+			return
+		}
+		writeJSON(w, httpFailure.status, &rpc.Message{JSONRPC: "2.0", ID: requests[0].ID, Error: httpFailure.err})
+		return
+	}
+
+	responses := make([]*rpc.Message, len(requests))
+	for i, req := range requests {
+		responses[i] = n.handle(req)
+	}
+	if batch {
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+	writeJSON(w, http.StatusOK, responses[0])
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func (n *Node) handle(req *rpc.Message) *rpc.Message {
+	out := &rpc.Message{JSONRPC: "2.0", ID: req.ID}
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			out.Error = &rpc.ErrorObject{Code: rpc.CodeInvalidParams, Message: "params must be an array"}
+			return out
+		}
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if f := n.takeFailure(req.Method, isCallFailure); f != nil {
+		if f.null {
+			out.Result = json.RawMessage("null")
+		} else {
+			out.Error = f.err
+		}
+		return out
+	}
+
+	result, rpcErr := n.call(req.Method, params)
+	if rpcErr != nil {
+		out.Error = rpcErr
+		return out
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		out.Error = &rpc.ErrorObject{Code: rpc.CodeInternalError, Message: err.Error()}
+		return out
+	}
+	out.Result = data
+	return out
+}
+
+func invalidParams(format string, args ...any) *rpc.ErrorObject {
+	return &rpc.ErrorObject{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// call serves the method, it is called with lock held
+func (n *Node) call(method string, params []json.RawMessage) (any, *rpc.ErrorObject) {
+	head := n.chain[len(n.chain)-1]
+	switch method {
+	case "eth_chainId":
+		return quantity(n.opts.chainID), nil
+	case "eth_blockNumber":
+		return quantityU(head.number), nil
+	case "eth_getBalance":
+		var address types.EthAddress
+		if len(params) < 1 || json.Unmarshal(params[0], &address) != nil {
+			return nil, invalidParams("missing address")
+		}
+		ref := "latest"
+		if len(params) > 1 {
+			if err := json.Unmarshal(params[1], &ref); err != nil {
+				return nil, invalidParams("invalid block")
+			}
+		}
+		number, rpcErr := n.resolveNumber(ref)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		balance := new(big.Int)
+		for _, b := range n.balances[address] {
+			if b.number <= number {
+				balance = b.wei
+			}
+		}
+		return "0x" + balance.Text(16), nil
+	case "eth_getBlockByNumber", "eth_getBlockByHash":
+		if len(params) < 2 {
+			return nil, invalidParams("expected block and full transactions flag")
+		}
+		var full bool
+		if err := json.Unmarshal(params[1], &full); err != nil {
+			return nil, invalidParams("invalid full transactions flag")
+		}
+		blk, rpcErr := n.resolveBlock(method == "eth_getBlockByHash", params[0])
+		if rpcErr != nil || blk == nil {
+			return nil, rpcErr
+		}
+		return blk.toJSON(full), nil
+	case "eth_getBlockReceipts":
+		if len(params) < 1 {
+			return nil, invalidParams("missing block")
+		}
+		blk, rpcErr := n.resolveBlock(false, params[0])
+		if rpcErr != nil || blk == nil {
+			return nil, rpcErr
+		}
+		out := make([]map[string]any, len(blk.txs))
+		var cumulative int64
+		for i, t := range blk.txs {
+			cumulative += t.GasUsed
+			out[i] = blk.receiptJSON(t, cumulative)
+		}
+		return out, nil
+	case "eth_getTransactionByHash", "eth_getTransactionReceipt":
+		var hash types.EthHash
+		if len(params) < 1 || json.Unmarshal(params[0], &hash) != nil {
+			return nil, invalidParams("missing transaction hash")
+		}
+		for _, blk := range n.chain {
+			var cumulative int64
+			for _, t := range blk.txs {
+				cumulative += t.GasUsed
+				if t.hash != hash {
+					continue
+				}
+				if method == "eth_getTransactionByHash" {
+					return blk.txJSON(t), nil
+				}
+				return blk.receiptJSON(t, cumulative), nil
+			}
+		}
+		return nil, nil
+	case "eth_getLogs":
+		if len(params) < 1 {
+			return nil, invalidParams("missing filter")
+		}
+		return n.getLogs(params[0])
+	default:
+		return nil, &rpc.ErrorObject{Code: rpc.CodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+	}
+}
+
+// resolveBlock finds block by tag, hex number or hash, nil block means it is not found
+func (n *Node) resolveBlock(byHash bool, param json.RawMessage) (*block, *rpc.ErrorObject) {
+	if byHash {
+		var hash types.EthHash
+		if err := json.Unmarshal(param, &hash); err != nil {
+			return nil, invalidParams("invalid block hash")
+		}
+		for _, blk := range n.chain {
+			if blk.hash == hash {
+				return blk, nil
+			}
+		}
+		return nil, nil
+	}
+	var ref string
+	if err := json.Unmarshal(param, &ref); err != nil {
+		return nil, invalidParams("invalid block reference")
+	}
+	number, rpcErr := n.resolveNumber(ref)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if number >= uint64(len(n.chain)) {
+		return nil, nil
+	}
+	return n.chain[number], nil
+}
+
+// resolveNumber turns tag or hex quantity into block number; safe and finalized lag behind head like on mainnet
+func (n *Node) resolveNumber(ref string) (uint64, *rpc.ErrorObject) {
+	head := uint64(len(n.chain) - 1)
+	lagging := func(distance uint64) uint64 {
+		if head < distance {
+			return 0
+		}
+		return head - distance
+	}
+	switch ref {
+	case "", "latest", "pending":
+		return head, nil
+	case "earliest":
+		return 0, nil
+	case "safe":
+		return lagging(32), nil
+	case "finalized":
+		return lagging(64), nil
+	}
+	number, ok := new(big.Int).SetString(strings.TrimPrefix(ref, "0x"), 16)
+	if !ok || !strings.HasPrefix(ref, "0x") || !number.IsUint64() {
+		return 0, invalidParams("invalid block number %q", ref)
+	}
+	return number.Uint64(), nil
+}
+
+func (n *Node) getLogs(param json.RawMessage) (any, *rpc.ErrorObject) {
+	var filter struct {
+		BlockHash *types.EthHash    `json:"blockHash"`
+		FromBlock string            `json:"fromBlock"`
+		ToBlock   string            `json:"toBlock"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+	if err := json.Unmarshal(param, &filter); err != nil {
+		return nil, invalidParams("invalid filter: %s", err)
+	}
+
+	var addresses []types.EthAddress
+	if len(filter.Address) > 0 && string(filter.Address) != "null" {
+		if json.Unmarshal(filter.Address, &addresses) != nil {
+			var single types.EthAddress
+			if err := json.Unmarshal(filter.Address, &single); err != nil {
+				return nil, invalidParams("invalid address filter")
+			}
+			addresses = []types.EthAddress{single}
+		}
+	}
+	topics := make([][]types.EthHash, len(filter.Topics))
+	for i, raw := range filter.Topics {
+		if string(raw) == "null" {
+			continue
+		}
+		if json.Unmarshal(raw, &topics[i]) != nil {
+			var single types.EthHash
+			if err := json.Unmarshal(raw, &single); err != nil {
+				return nil, invalidParams("invalid topic filter")
+			}
+			topics[i] = []types.EthHash{single}
+		}
+	}
+
+	var blocks []*block
+	if filter.BlockHash != nil {
+		for _, blk := range n.chain {
+			if blk.hash == *filter.BlockHash {
+				blocks = append(blocks, blk)
+			}
+		}
+	} else {
+		from, rpcErr := n.resolveNumber(filter.FromBlock)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		to, rpcErr := n.resolveNumber(filter.ToBlock)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		for num := from; num <= to && num < uint64(len(n.chain)); num++ {
+			blocks = append(blocks, n.chain[num])
+		}
+	}
+
+	out := []map[string]any{}
+	for _, blk := range blocks {
+		for _, t := range blk.txs {
+			for i, l := range t.Logs {
+				if matchLog(l, addresses, topics) {
+					out = append(out, blk.logJSON(t, i))
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func matchLog(l Log, addresses []types.EthAddress, topics [][]types.EthHash) bool {
+	if len(addresses) > 0 {
+		found := false
+		for _, address := range addresses {
+			if address == l.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for i, alternatives := range topics {
+		if len(alternatives) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) {
+			return false
+		}
+		found := false
+		for _, topic := range alternatives {
+			if topic == l.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func quantity(val int64) string {
+	return fmt.Sprintf("0x%x", val)
+}
+
+func quantityU(val uint64) string {
+	return fmt.Sprintf("0x%x", val)
+}
+
+func hexData(data []byte) string {
+	return fmt.Sprintf("0x%x", data)
+}
+
+func (b *block) gasUsed() int64 {
+	var out int64
+	for _, t := range b.txs {
+		out += t.GasUsed
+	}
+	return out
+}
+
+func (b *block) toJSON(full bool) map[string]any {
+	txs := make([]any, len(b.txs))
+	for i, t := range b.txs {
+		if full {
+			txs[i] = b.txJSON(t)
+		} else {
+			txs[i] = t.hash
+		}
+	}
+	return map[string]any{
+		"number":           quantityU(b.number),
+		"hash":             b.hash,
+		"parentHash":       b.parent,
+		"timestamp":        quantityU(b.timestamp),
+		"miner":            b.miner,
+		"difficulty":       "0x0",
+		"totalDifficulty":  "0x0",
+		"extraData":        "0x",
+		"nonce":            "0x0000000000000000",
+		"gasLimit":         quantity(b.gasLimit),
+		"gasUsed":          quantity(b.gasUsed()),
+		"baseFeePerGas":    quantity(b.baseFee),
+		"blobGasUsed":      "0x0",
+		"excessBlobGas":    "0x0",
+		"size":             "0x0",
+		"mixHash":          types.EthHash{},
+		"receiptsRoot":     types.EthHash{},
+		"stateRoot":        types.EthHash{},
+		"transactionsRoot": types.EthHash{},
+		"sha3Uncles":       types.EthHash{},
+		"uncles":           []any{},
+		"withdrawals":      []any{},
+		"transactions":     txs,
+	}
+}
+
+func (b *block) txJSON(t *tx) map[string]any {
+	out := map[string]any{
+		"hash":             t.hash,
+		"blockHash":        b.hash,
+		"blockNumber":      quantityU(b.number),
+		"transactionIndex": quantity(int64(t.index)),
+		"from":             t.From,
+		"to":               t.To,
+		"value":            quantity(t.Value),
+		"gas":              quantity(t.Gas),
+		"input":            hexData(t.Input),
+		"nonce":            "0x0",
+		"type":             "0x0",
+	}
+	if t.MaxFeePerGas != 0 {
+		out["type"] = "0x2"
+		out["maxFeePerGas"] = quantity(t.MaxFeePerGas)
+		out["maxPriorityFeePerGas"] = quantity(t.MaxPriorityFeePerGas)
+		out["gasPrice"] = quantity(t.effectiveGasPrice(b.baseFee))
+	} else {
+		out["gasPrice"] = quantity(t.GasPrice)
+	}
+	return out
+}
+
+func (t *tx) effectiveGasPrice(baseFee int64) int64 {
+	if t.MaxFeePerGas == 0 {
+		return t.GasPrice
+	}
+	return min(t.MaxFeePerGas, baseFee+t.MaxPriorityFeePerGas)
+}
+
+func (b *block) receiptJSON(t *tx, cumulativeGasUsed int64) map[string]any {
+	logs := make([]map[string]any, len(t.Logs))
+	for i := range t.Logs {
+		logs[i] = b.logJSON(t, i)
+	}
+	txType := "0x0"
+	if t.MaxFeePerGas != 0 {
+		txType = "0x2"
+	}
+	status := "0x1"
+	if t.Failed {
+		status = "0x0"
+	}
+	return map[string]any{
+		"blockHash":         b.hash,
+		"blockNumber":       quantityU(b.number),
+		"transactionHash":   t.hash,
+		"transactionIndex":  quantity(int64(t.index)),
+		"from":              t.From,
+		"to":                t.To,
+		"contractAddress":   nil,
+		"cumulativeGasUsed": quantity(cumulativeGasUsed),
+		"gasUsed":           quantity(t.GasUsed),
+		"effectiveGasPrice": quantity(t.effectiveGasPrice(b.baseFee)),
+		"status":            status,
+		"type":              txType,
+		"logs":              logs,
+	}
+}
+
+func (b *block) logJSON(t *tx, i int) map[string]any {
+	l := t.Logs[i]
+	topics := l.Topics
+	if topics == nil {
+		topics = []types.EthHash{}
+	}
+	return map[string]any{
+		"address":          l.Address,
+		"topics":           topics,
+		"data":             hexData(l.Data),
+		"blockNumber":      quantityU(b.number),
+		"blockHash":        b.hash,
+		"transactionHash":  t.hash,
+		"transactionIndex": quantity(int64(t.index)),
+		"logIndex":         quantity(int64(t.firstLogIndex + i)),
+		"removed":          false,
+	}
+}
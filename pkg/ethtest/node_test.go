@@ -0,0 +1,163 @@
+package ethtest_test
+
+import (
+	"context"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func address(t *testing.T, hex string) types.EthAddress {
+	var out types.EthAddress
+	require.NoError(t, out.UnmarshalJSON([]byte(`"`+hex+`"`)))
+	return out
+}
+
+func dial(t *testing.T, node *ethtest.Node, opts ...rpc.Option) *rpc.Client {
+	client, err := rpc.Dial(context.Background(), node.URL(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestNodeChain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node := ethtest.NewNode(t, ethtest.WithChainID(11155111))
+	client := dial(t, node)
+
+	alice := address(t, "0x00000000000000000000000000000000000000a1")
+	bob := address(t, "0x00000000000000000000000000000000000000b0")
+	token := address(t, "0x00000000000000000000000000000000000000c0")
+	topic := types.EthHash{0xdd}
+
+	node.AppendBlocks(2)
+	blk := node.AppendBlock(
+		ethtest.Tx{From: alice, To: bob, Value: 5},
+		ethtest.Tx{From: bob, To: token, Gas: 60000, GasUsed: 50000, MaxFeePerGas: 3_000_000_000, MaxPriorityFeePerGas: 1_000_000_000,
+			Logs: []ethtest.Log{{Address: token, Topics: []types.EthHash{topic}, Data: []byte{1}}}},
+	)
+
+	chainID, err := client.ChainID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11155111), chainID.Int64())
+
+	head, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), head.Int64())
+
+	detailed, err := rpc.GetBlockByNumber[types.BlockDetailed](ctx, client, rpc.Latest)
+	require.NoError(t, err)
+	require.Len(t, detailed.Transactions, 2)
+	assert.Equal(t, blk.Hash, detailed.Hash)
+	assert.Equal(t, node.Block(2).Hash, detailed.ParentHash)
+	assert.Equal(t, int64(5), detailed.Transactions[0].Value.AsBigInt().Int64())
+	// Dynamic fee transaction pays base fee plus tip
+	assert.Equal(t, int64(2_000_000_000), detailed.Transactions[1].GasPrice.AsBigInt().Int64())
+
+	hashes, err := rpc.GetBlockByHash[types.Block](ctx, client, blk.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, []types.EthHash{detailed.Transactions[0].Hash, detailed.Transactions[1].Hash}, hashes.Transactions)
+
+	receipts, err := client.GetBlockReceipts(ctx, rpc.NumberRef(big.NewInt(3)))
+	require.NoError(t, err)
+	require.Len(t, receipts, 2)
+	assert.Equal(t, int64(71000), receipts[1].CumulativeGasUsed.AsBigInt().Int64())
+	require.Len(t, receipts[1].Logs, 1)
+
+	receipt, err := client.GetTransactionReceipt(ctx, detailed.Transactions[1].Hash)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50000), receipt.GasUsed.AsBigInt().Int64())
+
+	logs, err := client.GetLogs(ctx, rpc.FilterQuery{
+		FromBlock: rpc.NumberRef(big.NewInt(0)),
+		ToBlock:   rpc.Latest,
+		Addresses: []types.EthAddress{token},
+		Topics:    [][]types.EthHash{{topic}},
+	})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, detailed.Transactions[1].Hash, logs[0].TransactionHash)
+
+	logs, err = client.GetLogs(ctx, rpc.FilterQuery{Addresses: []types.EthAddress{alice}})
+	require.NoError(t, err)
+	assert.Empty(t, logs)
+
+	node.SetBalance(alice, big.NewInt(1e18))
+	balance, err := client.GetBalance(ctx, alice, rpc.Latest)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1e18), balance)
+	// Balance set later does not change history
+	before := node.Head().Number.AsBigInt()
+	node.AppendBlock()
+	node.SetBalance(alice, big.NewInt(2e18))
+	balance, err = client.GetBalance(ctx, alice, rpc.NumberRef(before))
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1e18), balance)
+	balance, err = client.GetBalance(ctx, alice, rpc.Latest)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2e18), balance)
+
+	_, err = rpc.GetBlockByNumber[types.Block](ctx, client, rpc.NumberRef(big.NewInt(10)))
+	assert.True(t, rpc.IsNotFound(err), err)
+	err = client.Call(ctx, "eth_unknown", nil, nil)
+	assert.True(t, rpc.IsMethodNotSupported(err), err)
+	assert.Equal(t, 2, node.Calls("eth_getLogs"))
+}
+
+func TestNodeReorg(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(5)
+	old := node.Block(4)
+
+	replaced := node.Reorg(2, ethtest.Tx{Value: 1})
+	require.Len(t, replaced, 2)
+	assert.Equal(t, int64(4), replaced[0].Number.AsBigInt().Int64())
+	assert.NotEqual(t, old.Hash, replaced[0].Hash)
+	assert.Equal(t, node.Block(3).Hash, replaced[0].ParentHash)
+	assert.Len(t, replaced[0].Transactions, 1)
+	assert.Equal(t, replaced[1].Hash, node.Head().Hash)
+
+	client := dial(t, node)
+	_, err := rpc.GetBlockByHash[types.Block](context.Background(), client, old.Hash)
+	assert.True(t, rpc.IsNotFound(err), err)
+}
+
+func TestNodeFailures(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node := ethtest.NewNode(t)
+	client := dial(t, node)
+
+	node.FailNext("eth_blockNumber", 1, rpc.CodeInternalError, "boom")
+	_, err := client.BlockNumber(ctx)
+	assert.ErrorContains(t, err, "boom")
+	_, err = client.BlockNumber(ctx)
+	assert.NoError(t, err)
+
+	node.RateLimit(1)
+	_, err = client.ChainID(ctx)
+	assert.True(t, rpc.IsRateLimited(err), err)
+
+	node.FailHTTP(ethtest.AnyMethod, 1, http.StatusUnauthorized)
+	_, err = client.BlockNumber(ctx)
+	assert.True(t, rpc.IsUnauthorized(err), err)
+
+	node.ReturnNull("eth_getBlockByNumber", 1)
+	_, err = rpc.GetBlockByNumber[types.Block](ctx, client, rpc.Latest)
+	assert.True(t, rpc.IsNotFound(err), err)
+
+	node.SetLatency(200 * time.Millisecond)
+	slow := dial(t, node, rpc.WithNetworkConfig(rpc.NetworkConfig{Timeout: 50 * time.Millisecond}))
+	_, err = slow.BlockNumber(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 4, node.Calls("eth_blockNumber"))
+}
@@ -0,0 +1,312 @@
+// Package ledger builds ledger of native currency of a wallet from its stored transactions and block rewards.
+// Every row moves the running balance, outgoing transactions pay fees at effective gas price of their receipts,
+// and the balance is reconciled against eth_getBalance at checkpoints, so that movements the store does not see,
+// like internal transfers and withdrawals, show up as drift.
+package ledger
+
+import (
+	"encoding/csv"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kind of ledger row
+type Kind string
+
+const (
+	// KindTransaction is a transaction sent from or to the wallet
+	KindTransaction Kind = "transaction"
+	// KindReward is priority fees of a block the wallet was fee recipient of
+	KindReward Kind = "reward"
+)
+
+// Row is an entry of the ledger, amounts are in wei
+type Row struct {
+	Kind           Kind          `json:"kind"`
+	BlockNumber    types.BigInt  `json:"blockNumber"`
+	BlockTimestamp *types.BigInt `json:"blockTimestamp,omitempty"`
+	// Hash is hash of the transaction, or of the block for rewards
+	Hash types.EthHash `json:"hash"`
+	// Direction is one of txstore.Direction names: incoming, outgoing or self
+	Direction    string           `json:"direction"`
+	Counterparty types.EthAddress `json:"counterparty"`
+	// Value is value of the transaction, it is not moved when the transaction failed
+	Value types.BigInt `json:"value"`
+	// Fee is gas used times effective gas price, paid by outgoing transactions only, failed ones included
+	Fee    types.BigInt `json:"fee"`
+	Failed bool         `json:"failed,omitempty"`
+	// Change is what the row changed the balance by, Balance is the running balance after it
+	Change  types.BigInt `json:"change"`
+	Balance types.BigInt `json:"balance"`
+}
+
+// Checkpoint compares the running balance with eth_getBalance at the end of a block
+type Checkpoint struct {
+	BlockNumber types.BigInt `json:"blockNumber"`
+	Balance     types.BigInt `json:"balance"`
+	Actual      types.BigInt `json:"actual"`
+	// Drift is Actual minus Balance, it is zero when the ledger accounts for everything
+	Drift types.BigInt `json:"drift"`
+}
+
+// Ledger of a wallet
+type Ledger struct {
+	Wallet string `json:"wallet"`
+	// Opening is eth_getBalance at OpeningBlock, the block before the first row
+	OpeningBlock types.BigInt  `json:"openingBlock"`
+	Opening      types.BigInt  `json:"opening"`
+	Rows         []*Row        `json:"rows"`
+	Checkpoints  []*Checkpoint `json:"checkpoints"`
+	// Balance is the running balance after the last row
+	Balance types.BigInt `json:"balance"`
+}
+
+// Drifts returns checkpoints where the running balance differs from eth_getBalance
+func (l *Ledger) Drifts() []*Checkpoint {
+	var out []*Checkpoint
+	for _, checkpoint := range l.Checkpoints {
+		if checkpoint.Drift.AsBigInt().Sign() != 0 {
+			out = append(out, checkpoint)
+		}
+	}
+	return out
+}
+
+// Chain reads what the ledger needs from the node, blksubscriber.Subscriber implements it
+type Chain interface {
+	GetBlockReceipts(blockNum *big.Int) ([]*types.Receipt, error)
+	GetBalance(address types.EthAddress, blockNum *big.Int) (*big.Int, error)
+}
+
+type (
+	options struct {
+		checkpointEvery uint64
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithCheckpointEvery reconciles the balance at the first block of rows at least blocks after the previous checkpoint,
+// besides the block of the last row, which is always reconciled
+func WithCheckpointEvery(blocks uint64) Option {
+	return func(opts *options) {
+		opts.checkpointEvery = blocks
+	}
+}
+
+// Build builds ledger of the wallet from its transactions and rewards, as stores return them.
+// Transactions of orphaned blocks are skipped, rewards of other fee recipients are ignored.
+func Build(chain Chain, wallet string, txs []*types.Transaction, rewards []*types.ProposedBlockReward, opts ...Option) (*Ledger, error) {
+	o := options{}
+	o.apply(opts...)
+	wallet = strings.ToLower(wallet)
+	var address types.EthAddress
+	if err := address.UnmarshalJSON([]byte(wallet)); err != nil {
+		return nil, errors.Wrapf(err, "invalid wallet %q", wallet)
+	}
+
+	b := &builder{chain: chain, wallet: wallet, receipts: map[string]map[types.EthHash]*types.Receipt{}}
+	for _, tx := range txs {
+		if tx.Orphaned {
+			continue
+		}
+		row, err := b.txRow(tx)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			b.rows = append(b.rows, row)
+		}
+	}
+	for _, reward := range rewards {
+		if reward.FeeRecipient != address {
+			continue
+		}
+		b.rows = append(b.rows, &Row{
+			Kind:           KindReward,
+			BlockNumber:    reward.BlockNumber,
+			BlockTimestamp: nonZero(reward.Timestamp),
+			Hash:           reward.BlockHash,
+			Direction:      txstore.DirectionIncoming.String(),
+			Value:          reward.PriorityFees,
+			Change:         reward.PriorityFees,
+		})
+	}
+	// Rewards go after transactions of their block, as the block is done when the fee recipient is paid
+	sort.SliceStable(b.rows, func(i, j int) bool {
+		if cmp := b.rows[i].BlockNumber.AsBigInt().Cmp(b.rows[j].BlockNumber.AsBigInt()); cmp != 0 {
+			return cmp < 0
+		}
+		return b.rows[i].Kind == KindTransaction && b.rows[j].Kind == KindReward
+	})
+
+	out := &Ledger{Wallet: wallet, Rows: b.rows}
+	if len(b.rows) == 0 {
+		return out, nil
+	}
+	opening := new(big.Int).Sub(b.rows[0].BlockNumber.AsBigInt(), big.NewInt(1))
+	if opening.Sign() >= 0 {
+		balance, err := chain.GetBalance(address, opening)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get opening balance")
+		}
+		out.OpeningBlock, out.Opening = types.BigInt(*opening), types.BigInt(*balance)
+	}
+
+	balance := new(big.Int).Set(out.Opening.AsBigInt())
+	var last *big.Int
+	for i, row := range b.rows {
+		balance.Add(balance, row.Change.AsBigInt())
+		row.Balance = types.BigInt(*new(big.Int).Set(balance))
+		block := row.BlockNumber.AsBigInt()
+		if i+1 < len(b.rows) && b.rows[i+1].BlockNumber.AsBigInt().Cmp(block) == 0 {
+			continue
+		}
+		due := i+1 == len(b.rows) || o.checkpointEvery != 0 &&
+			(last == nil || new(big.Int).Sub(block, last).Cmp(new(big.Int).SetUint64(o.checkpointEvery)) >= 0)
+		if !due {
+			continue
+		}
+		actual, err := chain.GetBalance(address, block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get balance at block %s", block)
+		}
+		out.Checkpoints = append(out.Checkpoints, &Checkpoint{
+			BlockNumber: row.BlockNumber,
+			Balance:     row.Balance,
+			Actual:      types.BigInt(*actual),
+			Drift:       types.BigInt(*new(big.Int).Sub(actual, balance)),
+		})
+		last = block
+	}
+	out.Balance = types.BigInt(*balance)
+	return out, nil
+}
+
+type builder struct {
+	chain  Chain
+	wallet string
+	rows   []*Row
+	// receipts are receipts of blocks read so far, by block number
+	receipts map[string]map[types.EthHash]*types.Receipt
+}
+
+// txRow returns row of the transaction, nil when it is not of the wallet
+func (b *builder) txRow(tx *types.Transaction) (*Row, error) {
+	direction := txstore.DirectionOf(b.wallet, tx)
+	row := &Row{
+		Kind:           KindTransaction,
+		BlockNumber:    tx.BlockNumber,
+		BlockTimestamp: tx.BlockTimestamp,
+		Hash:           tx.Hash,
+		Direction:      direction.String(),
+		Value:          tx.Value,
+	}
+	change := new(big.Int)
+	switch direction {
+	case txstore.DirectionAny:
+		return nil, nil
+	case txstore.DirectionIncoming:
+		row.Counterparty = tx.From
+		change.Set(tx.Value.AsBigInt())
+	case txstore.DirectionOutgoing:
+		row.Counterparty = tx.To
+		change.Neg(tx.Value.AsBigInt())
+	case txstore.DirectionSelf:
+		row.Counterparty = tx.To
+	}
+
+	// Failed transactions move no value, but whoever sent them still pays for the gas
+	receipt, err := b.receipt(tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Failed() {
+		row.Failed = true
+		change.SetInt64(0)
+	}
+	if direction == txstore.DirectionOutgoing || direction == txstore.DirectionSelf {
+		fee := new(big.Int).Mul(receipt.GasUsed.AsBigInt(), receipt.EffectiveGasPrice.AsBigInt())
+		row.Fee = types.BigInt(*fee)
+		change.Sub(change, fee)
+	}
+	row.Change = types.BigInt(*change)
+	return row, nil
+}
+
+func (b *builder) receipt(tx *types.Transaction) (*types.Receipt, error) {
+	key := tx.BlockNumber.AsBigInt().String()
+	byHash, ok := b.receipts[key]
+	if !ok {
+		receipts, err := b.chain.GetBlockReceipts(tx.BlockNumber.AsBigInt())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get receipts of block %s", key)
+		}
+		byHash = map[types.EthHash]*types.Receipt{}
+		for _, receipt := range receipts {
+			byHash[receipt.TransactionHash] = receipt
+		}
+		b.receipts[key] = byHash
+	}
+	// Receipt of another block means the transaction was reorged into it after it was stored
+	receipt, ok := byHash[tx.Hash]
+	if !ok || tx.BlockHash != (types.EthHash{}) && receipt.BlockHash != tx.BlockHash {
+		return nil, errors.Errorf("no receipt of transaction %s in block %s", hashHex(tx.Hash), key)
+	}
+	return receipt, nil
+}
+
+func hashHex(hash types.EthHash) string {
+	data, _ := hash.MarshalJSON()
+	return strings.Trim(string(data), `"`)
+}
+
+func nonZero(val types.BigInt) *types.BigInt {
+	if val.AsBigInt().Sign() == 0 {
+		return nil
+	}
+	return &val
+}
+
+// Columns of CSV ledger, amounts are decimal wei, block_time is RFC 3339 in UTC
+var Columns = []string{
+	"block_number", "block_time", "kind", "hash", "direction", "counterparty", "value", "fee", "failed", "change", "balance",
+}
+
+// WriteCSV writes rows of the ledger with Columns header
+func (l *Ledger) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(Columns); err != nil {
+		return err
+	}
+	for _, row := range l.Rows {
+		blockTime := ""
+		if row.BlockTimestamp != nil {
+			blockTime = time.Unix(row.BlockTimestamp.AsBigInt().Int64(), 0).UTC().Format(time.RFC3339)
+		}
+		err := out.Write([]string{
+			row.BlockNumber.AsBigInt().String(), blockTime, string(row.Kind), hashHex(row.Hash),
+			row.Direction, row.Counterparty.String(), row.Value.AsBigInt().String(), row.Fee.AsBigInt().String(),
+			strconv.FormatBool(row.Failed), row.Change.AsBigInt().String(), row.Balance.AsBigInt().String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,166 @@
+package ledger_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/ledger"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+)
+
+const (
+	ether    = 1_000_000_000_000_000_000
+	gasPrice = 2_000_000_000
+)
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	balance := big.NewInt(8 * ether)
+	node.SetBalance(alice, balance)
+	// step appends block and moves balance of the node the way the ledger is expected to
+	step := func(change int64, txs ...ethtest.Tx) *types.BlockDetailed {
+		blk := node.AppendBlock(txs...)
+		balance.Add(balance, big.NewInt(change))
+		node.SetBalance(alice, balance)
+		return blk
+	}
+	var txs []*types.Transaction
+	txs = append(txs, step(3*ether, ethtest.Tx{From: bob, To: alice, Value: 3 * ether, GasPrice: gasPrice}).Transactions...)
+	txs = append(txs, step(-ether-21000*gasPrice, ethtest.Tx{From: alice, To: bob, Value: ether, GasPrice: gasPrice}).Transactions...)
+	// Failed transaction moves no value, but pays for gas it used
+	txs = append(txs, step(-30000*gasPrice, ethtest.Tx{From: alice, To: bob, Value: 5 * ether, Gas: 50000, GasUsed: 30000, GasPrice: gasPrice, Failed: true}).Transactions...)
+	self := step(-21000*gasPrice+777, ethtest.Tx{From: alice, To: alice, Value: ether, GasPrice: gasPrice})
+	txs = append(txs, self.Transactions...)
+	rewards := []*types.ProposedBlockReward{
+		{BlockHash: self.Hash, BlockNumber: self.Number, FeeRecipient: alice, PriorityFees: types.BigInt(*big.NewInt(777))},
+		{BlockHash: self.Hash, BlockNumber: self.Number, FeeRecipient: bob, PriorityFees: types.BigInt(*big.NewInt(999))},
+	}
+	// Internal transfer the store does not see
+	step(1)
+	txs = append(txs, step(ether, ethtest.Tx{From: bob, To: alice, Value: ether, GasPrice: gasPrice}).Transactions...)
+	// Transactions of other wallets are skipped
+	txs = append(txs, step(0, ethtest.Tx{From: bob, To: bob, Value: ether}).Transactions...)
+
+	chain, err := blksubscriber.New[types.BlockDetailed](node.URL())
+	require.NoError(t, err)
+	l, err := ledger.Build(chain, alice.String(), txs, rewards, ledger.WithCheckpointEvery(2))
+	require.NoError(t, err)
+
+	assert.Equal(t, alice.String(), l.Wallet)
+	assert.Equal(t, int64(0), l.OpeningBlock.AsBigInt().Int64())
+	assert.Equal(t, big.NewInt(8*ether), l.Opening.AsBigInt())
+	type row struct {
+		Block     int64
+		Kind      ledger.Kind
+		Direction string
+		Value     int64
+		Fee       int64
+		Failed    bool
+		Change    int64
+	}
+	var rows []row
+	for _, r := range l.Rows {
+		rows = append(rows, row{r.BlockNumber.AsBigInt().Int64(), r.Kind, r.Direction, r.Value.AsBigInt().Int64(),
+			r.Fee.AsBigInt().Int64(), r.Failed, r.Change.AsBigInt().Int64()})
+	}
+	assert.Equal(t, []row{
+		{1, ledger.KindTransaction, "incoming", 3 * ether, 0, false, 3 * ether},
+		{2, ledger.KindTransaction, "outgoing", ether, 21000 * gasPrice, false, -ether - 21000*gasPrice},
+		{3, ledger.KindTransaction, "outgoing", 5 * ether, 30000 * gasPrice, true, -30000 * gasPrice},
+		{4, ledger.KindTransaction, "self", ether, 21000 * gasPrice, false, -21000 * gasPrice},
+		{4, ledger.KindReward, "incoming", 777, 0, false, 777},
+		{6, ledger.KindTransaction, "incoming", ether, 0, false, ether},
+	}, rows)
+	assert.Equal(t, bob, l.Rows[0].Counterparty)
+	assert.Equal(t, bob, l.Rows[1].Counterparty)
+
+	expected := new(big.Int).Sub(balance, big.NewInt(1))
+	assert.Equal(t, expected, l.Balance.AsBigInt())
+	assert.Equal(t, expected, l.Rows[5].Balance.AsBigInt())
+
+	// Checkpoints are at block 1, at least 2 blocks after it, and at the last row
+	var checkpoints [][2]int64
+	for _, c := range l.Checkpoints {
+		checkpoints = append(checkpoints, [2]int64{c.BlockNumber.AsBigInt().Int64(), c.Drift.AsBigInt().Int64()})
+	}
+	assert.Equal(t, [][2]int64{{1, 0}, {3, 0}, {6, 1}}, checkpoints)
+	drifts := l.Drifts()
+	require.Len(t, drifts, 1)
+	assert.Equal(t, balance, drifts[0].Actual.AsBigInt())
+
+	var buf bytes.Buffer
+	require.NoError(t, l.WriteCSV(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 7)
+	assert.Equal(t, ledger.Columns, records[0])
+	assert.Equal(t, []string{"3", "", "transaction", "outgoing", "5000000000000000000", "60000000000000", "true", "-60000000000000"},
+		[]string{records[3][0], records[3][1], records[3][2], records[3][4], records[3][6], records[3][7], records[3][8], records[3][9]})
+}
+
+func TestBuildErrors(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	blk := node.AppendBlock(ethtest.Tx{From: alice, To: bob, Value: 1})
+	chain, err := blksubscriber.New[types.BlockDetailed](node.URL())
+	require.NoError(t, err)
+
+	_, err = ledger.Build(chain, "0x1", blk.Transactions, nil)
+	assert.Error(t, err)
+
+	// Transaction moved to another block by a reorg has no receipt where it was stored
+	moved := *blk.Transactions[0]
+	moved.BlockHash = types.EthHash{0xff}
+	_, err = ledger.Build(chain, alice.String(), []*types.Transaction{&moved}, nil)
+	assert.Error(t, err)
+
+	l, err := ledger.Build(chain, bob.String(), nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, l.Rows)
+	assert.Empty(t, l.Checkpoints)
+}
+
+// staticChain answers with the same receipts for every block, balances are by block number
+type staticChain struct {
+	receipts []*types.Receipt
+	balances map[int64]int64
+}
+
+func (c staticChain) GetBlockReceipts(*big.Int) ([]*types.Receipt, error) {
+	return c.receipts, nil
+}
+
+func (c staticChain) GetBalance(_ types.EthAddress, blockNum *big.Int) (*big.Int, error) {
+	return big.NewInt(c.balances[blockNum.Int64()]), nil
+}
+
+func TestBuildPreByzantium(t *testing.T) {
+	t.Parallel()
+	tx := &types.Transaction{Hash: types.EthHash{1}, BlockNumber: types.BigInt(*big.NewInt(100)), From: bob, To: alice, Value: types.BigInt(*big.NewInt(5))}
+	// Receipts before Byzantium carry state root instead of status
+	var receipt types.Receipt
+	require.NoError(t, receipt.UnmarshalJSON([]byte(`{"transactionHash":"0x0100000000000000000000000000000000000000000000000000000000000000",
+		"root":"0x0200000000000000000000000000000000000000000000000000000000000000","gasUsed":"0x5208","effectiveGasPrice":"0x1"}`)))
+	require.Nil(t, receipt.Status)
+	require.NotNil(t, receipt.Root)
+	assert.False(t, receipt.Failed())
+
+	l, err := ledger.Build(staticChain{receipts: []*types.Receipt{&receipt}, balances: map[int64]int64{100: 5}}, alice.String(), []*types.Transaction{tx}, nil)
+	require.NoError(t, err)
+	require.Len(t, l.Rows, 1)
+	assert.False(t, l.Rows[0].Failed)
+	assert.Equal(t, int64(5), l.Rows[0].Change.AsBigInt().Int64())
+	assert.Empty(t, l.Drifts())
+}
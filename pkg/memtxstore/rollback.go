@@ -0,0 +1,128 @@
+package memtxstore
+
+import (
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sort"
+)
+
+// Rollback rolls back transactions and rewards of blocks above blkId, after they were reorged out.
+// Rewards are removed, transactions are removed or flagged as orphaned depending on WithRollbackMode.
+// Everything rolled back is recorded as a tombstone. It returns the number of rolled back transactions and rewards.
+func (s *Store) Rollback(blkId big.Int) (int, error) {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+
+	return s.rollback(&blkId), nil
+}
+
+// RollbackBlock rolls back the block with the hash and all blocks above it, see Rollback.
+// Block the store has nothing from is unknown to it, then nothing is rolled back.
+func (s *Store) RollbackBlock(hash types.EthHash) (int, error) {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+
+	height := s.heightOf(hash)
+	if height == nil {
+		return 0, nil
+	}
+	return s.rollback(height.Sub(height, big.NewInt(1))), nil
+}
+
+// heightOf returns number of the block with the hash, looking through stored transactions and rewards
+func (s *Store) heightOf(hash types.EthHash) *big.Int {
+	for _, e := range s.txs {
+		if e.tx.BlockHash == hash {
+			return new(big.Int).Set(e.tx.BlockNumber.AsBigInt())
+		}
+	}
+	s.rewardMapMutex.RLock()
+	defer s.rewardMapMutex.RUnlock()
+	for _, lst := range s.rewardMap {
+		for _, e := range lst {
+			if e.reward.BlockHash == hash {
+				return new(big.Int).Set(e.reward.BlockNumber.AsBigInt())
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) rollback(above *big.Int) int {
+	now := s.now()
+	from := types.BigInt(*new(big.Int).Add(above, big.NewInt(1)))
+	flag := s.rollbackMode == txstore.RollbackFlag
+
+	var victims []*entry
+	for _, e := range s.txs {
+		if !e.tx.Orphaned && e.tx.BlockNumber.AsBigInt().Cmp(above) > 0 {
+			victims = append(victims, e)
+		}
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		return txLess(victims[i].tx, victims[j].tx)
+	})
+	for _, e := range victims {
+		s.tombstones = append(s.tombstones, txstore.Tombstone{Transaction: e.tx, From: from, RemovedAt: now, Flagged: flag})
+		if flag {
+			orphan := *e.tx
+			orphan.Orphaned = true
+			e.tx = &orphan
+			continue
+		}
+		for _, address := range e.addresses {
+			s.removeFromAddress(e, address)
+		}
+	}
+	if s.headBlock != nil && s.headBlock.Cmp(above) > 0 {
+		s.headBlock = new(big.Int).Set(above)
+	}
+
+	s.rewardMapMutex.Lock()
+	var rewards []*types.ProposedBlockReward
+	for address, lst := range s.rewardMap {
+		kept := make([]*rewardEntry, 0, len(lst))
+		for _, e := range lst {
+			if e.reward.BlockNumber.AsBigInt().Cmp(above) > 0 {
+				rewards = append(rewards, e.reward)
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.rewardMap, address)
+		} else {
+			s.rewardMap[address] = kept
+		}
+	}
+	if s.rewardHead != nil && s.rewardHead.Cmp(above) > 0 {
+		s.rewardHead = new(big.Int).Set(above)
+	}
+	s.rewardMapMutex.Unlock()
+	sort.Slice(rewards, func(i, j int) bool {
+		return rewards[i].BlockNumber.AsBigInt().Cmp(rewards[j].BlockNumber.AsBigInt()) < 0
+	})
+	for _, reward := range rewards {
+		s.tombstones = append(s.tombstones, txstore.Tombstone{Reward: reward, From: from, RemovedAt: now})
+	}
+
+	if excess := len(s.tombstones) - s.maxTombstones; s.maxTombstones != 0 && excess > 0 {
+		s.tombstones = append(s.tombstones[:0], s.tombstones[excess:]...)
+	}
+	return len(victims) + len(rewards)
+}
+
+// Tombstones returns rolled back transactions and rewards of the address, in the order they were rolled back
+func (s *Store) Tombstones(address string) ([]txstore.Tombstone, error) {
+	s.txMutex.RLock()
+	defer s.txMutex.RUnlock()
+
+	var out []txstore.Tombstone
+	for _, tomb := range s.tombstones {
+		if tomb.Matches(address) {
+			out = append(out, tomb)
+		}
+	}
+	return out, nil
+}
@@ -1,77 +1,581 @@
 package memtxstore
 
 import (
-	"github.com/dkropachev/ethscan/pkg/synclist"
+	"bytes"
+	"container/list"
+	"github.com/dkropachev/ethscan/pkg/txstore"
 	"github.com/dkropachev/ethscan/pkg/types"
 	"math/big"
 	"slices"
+	"sort"
 	"sync"
+	"time"
 )
 
+// EvictionPolicy picks transactions to drop when a size limit is reached
+type EvictionPolicy int
+
+const (
+	// EvictOldest drops transactions stored first, within an address the ones from the lowest block
+	EvictOldest EvictionPolicy = iota
+	// EvictLRU drops transactions that were not stored or read for the longest time
+	EvictLRU
+)
+
+// DefaultMaxTombstones is how many rolled back transactions and rewards are remembered by default
+const DefaultMaxTombstones = 10000
+
+type (
+	options struct {
+		maxTxsPerAddress int
+		maxTxs           int
+		maxBlockAge      uint64
+		maxAge           time.Duration
+		policy           EvictionPolicy
+		rollbackMode     txstore.RollbackMode
+		maxTombstones    int
+		now              func() time.Time
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithMaxTxsPerAddress limits transactions kept for every address, 0 means no limit.
+// Transaction evicted from one address stays stored for its other one.
+func WithMaxTxsPerAddress(n int) Option {
+	return func(opts *options) {
+		opts.maxTxsPerAddress = n
+	}
+}
+
+// WithMaxTxs limits transactions kept in total, 0 means no limit
+func WithMaxTxs(n int) Option {
+	return func(opts *options) {
+		opts.maxTxs = n
+	}
+}
+
+// WithMaxBlockAge evicts transactions more than blocks below the highest block stored, 0 means no limit.
+// Rewards are evicted the same way, below the highest block of a stored reward.
+func WithMaxBlockAge(blocks uint64) Option {
+	return func(opts *options) {
+		opts.maxBlockAge = blocks
+	}
+}
+
+// WithMaxAge evicts transactions and rewards stored longer than age ago, 0 means no limit
+func WithMaxAge(age time.Duration) Option {
+	return func(opts *options) {
+		opts.maxAge = age
+	}
+}
+
+// WithEvictionPolicy sets which transactions go first when WithMaxTxs or WithMaxTxsPerAddress limit is reached,
+// EvictOldest by default
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(opts *options) {
+		opts.policy = policy
+	}
+}
+
+// WithRollbackMode sets what Rollback does with transactions, txstore.RollbackRemove by default
+func WithRollbackMode(mode txstore.RollbackMode) Option {
+	return func(opts *options) {
+		opts.rollbackMode = mode
+	}
+}
+
+// WithMaxTombstones limits tombstones kept, the oldest go first, 0 means no limit. DefaultMaxTombstones by default.
+func WithMaxTombstones(n int) Option {
+	return func(opts *options) {
+		opts.maxTombstones = n
+	}
+}
+
+// WithClock replaces time.Now for WithMaxAge
+func WithClock(now func() time.Time) Option {
+	return func(opts *options) {
+		opts.now = now
+	}
+}
+
+// Stats describes store size and evictions since it was created
+type Stats struct {
+	Transactions int `json:"transactions"`
+	Addresses    int `json:"addresses"`
+	Rewards      int `json:"rewards"`
+	// Evicted counts evicted transactions, fields below split it by limit.
+	// Transaction dropped by WithMaxTxsPerAddress from each of its addresses in turn is counted for each of them.
+	Evicted           uint64 `json:"evicted"`
+	EvictedPerAddress uint64 `json:"evictedPerAddress"`
+	EvictedTotal      uint64 `json:"evictedTotal"`
+	EvictedBlockAge   uint64 `json:"evictedBlockAge"`
+	EvictedAge        uint64 `json:"evictedAge"`
+	// EvictedRewards counts rewards evicted by WithMaxBlockAge and WithMaxAge, they are not counted above
+	EvictedRewards uint64 `json:"evictedRewards"`
+}
+
+type evictReason int
+
+const (
+	evictPerAddress evictReason = iota
+	evictTotal
+	evictBlockAge
+	evictAge
+)
+
+// entry is a stored transaction, it is referenced by lists of its sender and recipient
+type entry struct {
+	tx       *types.Transaction
+	storedAt time.Time
+	// lastUsed is a tick of the last store or read, for EvictLRU
+	lastUsed  uint64
+	addresses []string
+	// stored is element of Store.stored, used is element of Store.used
+	stored *list.Element
+	used   *list.Element
+}
+
+// rewardEntry is a stored block reward
+type rewardEntry struct {
+	reward   *types.ProposedBlockReward
+	storedAt time.Time
+}
+
 type Store struct {
-	addrMap map[string]*synclist.EquatableList[*types.Transaction]
+	// txs indexes every stored transaction by hash, addrTxs keeps transactions of every address
+	// sorted by block number and transaction index
+	txs     map[types.EthHash]*entry
+	addrTxs map[string][]*entry
+	// rewardMap keeps rewards of every fee recipient in the order they were stored
+	rewardMap map[string][]*rewardEntry
+	// stored lists entries in the order they were stored, used in the order they were last used, it is kept for EvictLRU only
+	stored *list.List
+	used   *list.List
+	tick   uint64
+	// headBlock is the highest block stored, rewardHead the highest block of a reward, for WithMaxBlockAge
+	headBlock  *big.Int
+	rewardHead *big.Int
+	// stats is guarded by txMutex, except EvictedRewards guarded by rewardMapMutex
+	stats Stats
+	// tombstones are rolled back transactions and rewards, oldest first
+	tombstones []txstore.Tombstone
 
-	addMapMutex sync.RWMutex
+	txMutex        sync.RWMutex
+	rewardMapMutex sync.RWMutex
+	options
 }
 
-func New() *Store {
-	return &Store{
-		addrMap: make(map[string]*synclist.EquatableList[*types.Transaction]),
+func New(opts ...Option) *Store {
+	out := &Store{
+		txs:       make(map[types.EthHash]*entry),
+		addrTxs:   make(map[string][]*entry),
+		rewardMap: make(map[string][]*rewardEntry),
+		stored:    list.New(),
+		used:      list.New(),
+		options: options{
+			maxTombstones: DefaultMaxTombstones,
+			now:           time.Now,
+		},
 	}
+	out.options.apply(opts...)
+	return out
 }
 
-func (s *Store) storeTx(address string, tx *types.Transaction) error {
-	var val *synclist.EquatableList[*types.Transaction]
-	s.addMapMutex.RLock()
+// StoreTransaction stores the transaction under its sender and recipient.
+// Transactions are identified by hash: storing the same one again is a no-op,
+// storing it with different content, e.g. included into another block after a reorg, replaces the old one.
+// Retention limits are applied after every store.
+func (s *Store) StoreTransaction(tx *types.Transaction) error {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+
+	now := s.now()
+	if old := s.txs[tx.Hash]; old != nil {
+		if old.tx.Equal(tx) {
+			s.touch(old)
+			return nil
+		}
+		for _, address := range old.addresses {
+			s.removeFromAddress(old, address)
+		}
+	}
+	e := &entry{tx: tx, storedAt: now, addresses: addressesOf(tx)}
+	e.stored = s.stored.PushBack(e)
+	if s.policy == EvictLRU {
+		e.used = s.used.PushBack(e)
+	}
+	s.tick++
+	e.lastUsed = s.tick
+	s.txs[tx.Hash] = e
+	for _, address := range e.addresses {
+		s.addrTxs[address] = insertSorted(s.addrTxs[address], e)
+		s.limitAddress(address)
+	}
 
-	if val = s.addrMap[address]; val == nil {
-		s.addMapMutex.RUnlock()
-		s.addMapMutex.Lock()
-		if val = s.addrMap[address]; val == nil {
-			val = &synclist.EquatableList[*types.Transaction]{}
-			s.addrMap[address] = val
+	s.expire(now)
+	// Transaction from an old block can be gone already, evicted by WithMaxTxsPerAddress from all its addresses
+	if s.maxBlockAge != 0 && s.txs[tx.Hash] == e {
+		s.evictBlockAge(e)
+	}
+	for s.maxTxs != 0 && len(s.txs) > s.maxTxs {
+		front := s.stored.Front()
+		if s.policy == EvictLRU {
+			front = s.used.Front()
 		}
-		s.addMapMutex.Unlock()
-		s.addMapMutex.RLock()
+		s.evict(front.Value.(*entry), evictTotal)
 	}
-	val.AppendIfNotExists(tx)
-	s.addMapMutex.RUnlock()
 	return nil
 }
 
-func (s *Store) StoreTransaction(tx *types.Transaction) error {
+// touch marks entry as used, for EvictLRU
+func (s *Store) touch(e *entry) {
+	if s.policy != EvictLRU {
+		return
+	}
+	s.tick++
+	e.lastUsed = s.tick
+	s.used.MoveToBack(e.used)
+}
+
+// limitAddress evicts transactions of the address above WithMaxTxsPerAddress
+func (s *Store) limitAddress(address string) {
+	for s.maxTxsPerAddress != 0 && len(s.addrTxs[address]) > s.maxTxsPerAddress {
+		lst := s.addrTxs[address]
+		victim := lst[0]
+		if s.policy == EvictLRU {
+			for _, e := range lst[1:] {
+				if e.lastUsed < victim.lastUsed {
+					victim = e
+				}
+			}
+		}
+		s.countEviction(evictPerAddress)
+		s.removeFromAddress(victim, address)
+	}
+}
+
+// expire evicts transactions stored longer than WithMaxAge ago, they are at the front of stored list
+func (s *Store) expire(now time.Time) {
+	if s.maxAge == 0 {
+		return
+	}
+	for front := s.stored.Front(); front != nil; front = s.stored.Front() {
+		e := front.Value.(*entry)
+		if now.Sub(e.storedAt) <= s.maxAge {
+			return
+		}
+		s.evict(e, evictAge)
+	}
+}
+
+// evictBlockAge evicts transactions more than WithMaxBlockAge blocks below the head.
+// When the stored entry raises the head, old transactions are at the front of address lists, otherwise only the entry can be too old.
+func (s *Store) evictBlockAge(stored *entry) {
+	block := stored.tx.BlockNumber.AsBigInt()
+	if s.headBlock != nil && block.Cmp(s.headBlock) <= 0 {
+		cutoff := new(big.Int).Sub(s.headBlock, new(big.Int).SetUint64(s.maxBlockAge))
+		if block.Cmp(cutoff) < 0 {
+			s.evict(stored, evictBlockAge)
+		}
+		return
+	}
+	s.headBlock = new(big.Int).Set(block)
+	cutoff := new(big.Int).Sub(s.headBlock, new(big.Int).SetUint64(s.maxBlockAge))
+	for address, lst := range s.addrTxs {
+		for len(lst) != 0 && lst[0].tx.BlockNumber.AsBigInt().Cmp(cutoff) < 0 {
+			s.evict(lst[0], evictBlockAge)
+			lst = s.addrTxs[address]
+		}
+	}
+}
+
+// evict removes transaction from all its addresses, it is counted as one eviction
+func (s *Store) evict(e *entry, reason evictReason) {
+	s.countEviction(reason)
+	for len(e.addresses) != 0 {
+		s.removeFromAddress(e, e.addresses[0])
+	}
+}
+
+func (s *Store) countEviction(reason evictReason) {
+	s.stats.Evicted++
+	switch reason {
+	case evictPerAddress:
+		s.stats.EvictedPerAddress++
+	case evictTotal:
+		s.stats.EvictedTotal++
+	case evictBlockAge:
+		s.stats.EvictedBlockAge++
+	case evictAge:
+		s.stats.EvictedAge++
+	}
+}
+
+// removeFromAddress drops entry from the address list, entry is dropped from the store once no address references it
+func (s *Store) removeFromAddress(e *entry, address string) {
+	lst := s.addrTxs[address]
+	idx := searchTx(lst, e.tx)
+	for ; idx < len(lst) && lst[idx] != e; idx++ {
+	}
+	if idx < len(lst) {
+		if lst = append(lst[:idx], lst[idx+1:]...); len(lst) == 0 {
+			delete(s.addrTxs, address)
+		} else {
+			s.addrTxs[address] = lst
+		}
+	}
+
+	for i, val := range e.addresses {
+		if val == address {
+			e.addresses = append(e.addresses[:i:i], e.addresses[i+1:]...)
+			break
+		}
+	}
+	if len(e.addresses) != 0 {
+		return
+	}
+	delete(s.txs, e.tx.Hash)
+	s.stored.Remove(e.stored)
+	if e.used != nil {
+		s.used.Remove(e.used)
+	}
+}
+
+func addressesOf(tx *types.Transaction) []string {
 	to := tx.To.String()
 	from := tx.From.String()
+	if to == from {
+		return []string{to}
+	}
+	return []string{to, from}
+}
+
+// txLess orders transactions by block number, then by index in the block, then by hash, see txstore.Position
+func txLess(a, b *types.Transaction) bool {
+	if cmp := a.BlockNumber.AsBigInt().Cmp(b.BlockNumber.AsBigInt()); cmp != 0 {
+		return cmp < 0
+	}
+	if cmp := a.TransactionIndex.AsBigInt().Cmp(b.TransactionIndex.AsBigInt()); cmp != 0 {
+		return cmp < 0
+	}
+	return bytes.Compare(a.Hash[:], b.Hash[:]) < 0
+}
+
+// searchTx returns index of the first entry in sorted list that is not less than tx
+func searchTx(lst []*entry, tx *types.Transaction) int {
+	return sort.Search(len(lst), func(i int) bool {
+		return !txLess(lst[i].tx, tx)
+	})
+}
+
+// insertSorted puts e after entries not greater than it, transactions mostly come in order, so it is usually an append
+func insertSorted(lst []*entry, e *entry) []*entry {
+	if len(lst) == 0 || !txLess(e.tx, lst[len(lst)-1].tx) {
+		return append(lst, e)
+	}
+	idx := sort.Search(len(lst), func(i int) bool {
+		return txLess(e.tx, lst[i].tx)
+	})
+	lst = append(lst, nil)
+	copy(lst[idx+1:], lst[idx:])
+	lst[idx] = e
+	return lst
+}
+
+// lockRead locks the store for a read and returns the unlock.
+// Reads only change the store with EvictLRU, which marks entries used, and with WithMaxAge, which drops expired ones first.
+func (s *Store) lockRead() func() {
+	if s.policy == EvictLRU || s.maxAge != 0 {
+		s.txMutex.Lock()
+		s.expire(s.now())
+		return s.txMutex.Unlock
+	}
+	s.txMutex.RLock()
+	return s.txMutex.RUnlock
+}
+
+// read returns transactions of the address starting from index returned by from
+func (s *Store) read(address string, from func(lst []*entry) int) []*types.Transaction {
+	defer s.lockRead()()
+
+	lst := s.addrTxs[address]
+	lst = lst[from(lst):]
+	if len(lst) == 0 {
+		return nil
+	}
+	out := make([]*types.Transaction, len(lst))
+	for i, e := range lst {
+		s.touch(e)
+		out[i] = e.tx
+	}
+	return out
+}
+
+// GetTransactions returns transactions sent from or to the address, ordered by block number and transaction index
+func (s *Store) GetTransactions(address string) ([]*types.Transaction, error) {
+	return s.read(address, func([]*entry) int { return 0 }), nil
+}
+
+// GetTransactionsAfterBlock returns transactions of the address from blocks above blkId, in the same order as GetTransactions
+func (s *Store) GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error) {
+	return s.read(address, func(lst []*entry) int {
+		return sort.Search(len(lst), func(i int) bool {
+			return lst[i].tx.BlockNumber.AsBigInt().Cmp(&blkId) > 0
+		})
+	}), nil
+}
+
+// QueryTransactions returns a page of transactions of the query address, see txstore.Query
+func (s *Store) QueryTransactions(q txstore.Query) (txstore.Page, error) {
+	if err := q.Validate(); err != nil {
+		return txstore.Page{}, err
+	}
+	from, to, ok := q.Bounds()
+	if !ok {
+		return txstore.Page{}, nil
+	}
+	defer s.lockRead()()
+
+	lst := s.addrTxs[q.Address]
+	start := sort.Search(len(lst), func(i int) bool { return !txstore.PositionOf(lst[i].tx).Less(from) })
+	end := sort.Search(len(lst), func(i int) bool { return to.Less(txstore.PositionOf(lst[i].tx)) })
+	if start >= end {
+		return txstore.Page{}, nil
+	}
+	lst = lst[start:end]
+	i, step := 0, 1
+	if q.Order == txstore.OrderDescending {
+		i, step = len(lst)-1, -1
+	}
+	page := q.Collect(func() *types.Transaction {
+		if i < 0 || i >= len(lst) {
+			return nil
+		}
+		e := lst[i]
+		i += step
+		return e.tx
+	})
+	for _, tx := range page.Transactions {
+		s.touch(s.txs[tx.Hash])
+	}
+	return page, nil
+}
 
-	if err := s.storeTx(to, tx); err != nil {
-		return err
+// Stats returns current size of the store and evictions so far
+func (s *Store) Stats() Stats {
+	s.txMutex.Lock()
+	defer s.txMutex.Unlock()
+	s.rewardMapMutex.Lock()
+	defer s.rewardMapMutex.Unlock()
+
+	now := s.now()
+	s.expire(now)
+	s.expireRewards(now)
+	out := s.stats
+	out.Transactions = len(s.txs)
+	out.Addresses = len(s.addrTxs)
+	for _, lst := range s.rewardMap {
+		out.Rewards += len(lst)
 	}
+	return out
+}
+
+// StoreBlockReward stores the reward under its fee recipient, storing the same one again is a no-op.
+// WithMaxBlockAge and WithMaxAge are applied after every store.
+func (s *Store) StoreBlockReward(reward *types.ProposedBlockReward) error {
+	address := reward.FeeRecipient.String()
+
+	s.rewardMapMutex.Lock()
+	defer s.rewardMapMutex.Unlock()
 
-	if to != from {
-		if err := s.storeTx(from, tx); err != nil {
-			return err
+	now := s.now()
+	lst := s.rewardMap[address]
+	for _, val := range lst {
+		if val.reward.Equal(reward) {
+			return nil
 		}
 	}
+	s.rewardMap[address] = append(lst, &rewardEntry{reward: reward, storedAt: now})
+
+	s.expireRewards(now)
+	if s.maxBlockAge != 0 {
+		s.evictRewardBlockAge(reward)
+	}
 	return nil
 }
 
-func (s *Store) GetTransactions(address string) ([]*types.Transaction, error) {
-	s.addMapMutex.RLock()
-	defer s.addMapMutex.RUnlock()
+// expireRewards evicts rewards stored longer than WithMaxAge ago, they are at the front of address lists
+func (s *Store) expireRewards(now time.Time) {
+	if s.maxAge == 0 {
+		return
+	}
+	for address, lst := range s.rewardMap {
+		idx := 0
+		for idx < len(lst) && now.Sub(lst[idx].storedAt) > s.maxAge {
+			idx++
+		}
+		s.keepRewards(address, len(lst), lst[idx:])
+	}
+}
+
+// evictRewardBlockAge evicts rewards more than WithMaxBlockAge blocks below the highest reward block.
+// When the stored reward raises the head, every address is checked, otherwise only the reward can be too old.
+func (s *Store) evictRewardBlockAge(stored *types.ProposedBlockReward) {
+	block := stored.BlockNumber.AsBigInt()
+	tooOld := func(e *rewardEntry) bool {
+		return new(big.Int).Sub(s.rewardHead, e.reward.BlockNumber.AsBigInt()).Cmp(new(big.Int).SetUint64(s.maxBlockAge)) > 0
+	}
+	if s.rewardHead != nil && block.Cmp(s.rewardHead) <= 0 {
+		address := stored.FeeRecipient.String()
+		if lst := s.rewardMap[address]; tooOld(lst[len(lst)-1]) {
+			s.keepRewards(address, len(lst), lst[:len(lst)-1])
+		}
+		return
+	}
+	s.rewardHead = new(big.Int).Set(block)
+	for address, lst := range s.rewardMap {
+		s.keepRewards(address, len(lst), slices.DeleteFunc(lst, tooOld))
+	}
+}
 
-	if lst := s.addrMap[address]; lst != nil {
-		return lst.GetAll(), nil
+// keepRewards replaces rewards of the address with kept, the rest of stored ones are counted as evicted
+func (s *Store) keepRewards(address string, stored int, kept []*rewardEntry) {
+	if len(kept) == stored {
+		return
+	}
+	s.stats.EvictedRewards += uint64(stored - len(kept))
+	if len(kept) == 0 {
+		delete(s.rewardMap, address)
+	} else {
+		s.rewardMap[address] = kept
 	}
-	return nil, nil
 }
 
-func (s *Store) GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error) {
-	allTxs, err := s.GetTransactions(address)
-	if err != nil {
-		return nil, err
+// GetBlockRewards returns rewards of the fee recipient in the order they were stored
+func (s *Store) GetBlockRewards(address string) ([]*types.ProposedBlockReward, error) {
+	if s.maxAge != 0 {
+		s.rewardMapMutex.Lock()
+		defer s.rewardMapMutex.Unlock()
+		s.expireRewards(s.now())
+	} else {
+		s.rewardMapMutex.RLock()
+		defer s.rewardMapMutex.RUnlock()
 	}
 
-	return slices.DeleteFunc(allTxs, func(tx *types.Transaction) bool {
-		return tx.BlockNumber.AsBigInt().Cmp(&blkId) <= 0
-	}), nil
+	lst := s.rewardMap[address]
+	if len(lst) == 0 {
+		return nil, nil
+	}
+	out := make([]*types.ProposedBlockReward, len(lst))
+	for i, e := range lst {
+		out[i] = e.reward
+	}
+	return out, nil
 }
@@ -2,13 +2,16 @@ package memtxstore_test
 
 import (
 	"github.com/dkropachev/ethscan/pkg/memtxstore"
-	"github.com/dkropachev/ethscan/pkg/synclist"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/txstore/storetest"
 	"github.com/dkropachev/ethscan/pkg/types"
 	"math/big"
 	"math/rand/v2"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +20,10 @@ func TestEquatableParallel(t *testing.T) {
 	lst := memtxstore.New()
 	wg := sync.WaitGroup{}
 
-	atomicList := synclist.EquatableList[*types.Transaction]{}
+	var (
+		expectedLock sync.Mutex
+		expected     []*types.Transaction
+	)
 
 	for range 10 {
 		wg.Add(2)
@@ -40,7 +46,9 @@ func TestEquatableParallel(t *testing.T) {
 					Value:            randomInt(),
 				}
 				if tx.To == targetAddress || tx.From == targetAddress {
-					atomicList.AppendIfNotExists(&types.Transaction{})
+					expectedLock.Lock()
+					expected = append(expected, &tx)
+					expectedLock.Unlock()
 				}
 
 				err := lst.StoreTransaction(&tx)
@@ -64,7 +72,13 @@ func TestEquatableParallel(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, atomicList.GetAll(), txs)
+	sort.Slice(expected, func(i, j int) bool {
+		if cmp := expected[i].BlockNumber.AsBigInt().Cmp(expected[j].BlockNumber.AsBigInt()); cmp != 0 {
+			return cmp < 0
+		}
+		return expected[i].TransactionIndex.AsBigInt().Cmp(expected[j].TransactionIndex.AsBigInt()) < 0
+	})
+	assert.Equal(t, expected, txs)
 }
 
 var atomicHashNum atomic.Int64
@@ -88,3 +102,400 @@ func randomAddr() types.EthAddress {
 func randomInt() types.BigInt {
 	return types.BigInt(*big.NewInt(rand.Int64()))
 }
+
+func TestStoreOrderAndDedup(t *testing.T) {
+	store := memtxstore.New()
+	other := types.EthAddress{1}
+	newTx := func(block, index int64) *types.Transaction {
+		return &types.Transaction{
+			Hash:             uniqueHash(),
+			BlockNumber:      types.BigInt(*big.NewInt(block)),
+			TransactionIndex: types.BigInt(*big.NewInt(index)),
+			From:             targetAddress,
+			To:               other,
+		}
+	}
+	b5i1, b3i0, b5i0, b7i2 := newTx(5, 1), newTx(3, 0), newTx(5, 0), newTx(7, 2)
+	for _, tx := range []*types.Transaction{b5i1, b3i0, b5i0, b7i2, b3i0} {
+		assert.NoError(t, store.StoreTransaction(tx))
+	}
+	// Copy decoded again from the node is the same transaction
+	dup := *b5i1
+	assert.NoError(t, store.StoreTransaction(&dup))
+
+	txs, err := store.GetTransactions(targetAddress.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Transaction{b3i0, b5i0, b5i1, b7i2}, txs)
+	txs, err = store.GetTransactions(other.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Transaction{b3i0, b5i0, b5i1, b7i2}, txs)
+
+	for _, tc := range []struct {
+		after    int64
+		expected []*types.Transaction
+	}{
+		{after: 0, expected: []*types.Transaction{b3i0, b5i0, b5i1, b7i2}},
+		{after: 3, expected: []*types.Transaction{b5i0, b5i1, b7i2}},
+		{after: 4, expected: []*types.Transaction{b5i0, b5i1, b7i2}},
+		{after: 5, expected: []*types.Transaction{b7i2}},
+		{after: 7, expected: nil},
+	} {
+		txs, err = store.GetTransactionsAfterBlock(*big.NewInt(tc.after), targetAddress.String())
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, txs, tc.after)
+	}
+
+	// Transaction re-included into another block replaces the old one
+	moved := *b3i0
+	moved.BlockNumber = types.BigInt(*big.NewInt(8))
+	assert.NoError(t, store.StoreTransaction(&moved))
+	txs, err = store.GetTransactions(targetAddress.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Transaction{b5i0, b5i1, b7i2, &moved}, txs)
+
+	// Self transfer is listed once
+	self := newTx(9, 0)
+	self.To = targetAddress
+	assert.NoError(t, store.StoreTransaction(self))
+	txs, err = store.GetTransactionsAfterBlock(*big.NewInt(8), targetAddress.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.Transaction{self}, txs)
+
+	txs, err = store.GetTransactions(types.EthAddress{2}.String())
+	assert.NoError(t, err)
+	assert.Nil(t, txs)
+}
+
+func TestRetention(t *testing.T) {
+	alice, bob, carol := types.EthAddress{0xa}, types.EthAddress{0xb}, types.EthAddress{0xc}
+	newTx := func(block int64, from, to types.EthAddress) *types.Transaction {
+		return &types.Transaction{
+			Hash:        uniqueHash(),
+			BlockNumber: types.BigInt(*big.NewInt(block)),
+			From:        from,
+			To:          to,
+		}
+	}
+	get := func(store *memtxstore.Store, address types.EthAddress) []*types.Transaction {
+		txs, err := store.GetTransactions(address.String())
+		assert.NoError(t, err)
+		return txs
+	}
+
+	t.Run("PerAddress", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxTxsPerAddress(2))
+		tx1, tx2, tx3 := newTx(1, alice, bob), newTx(2, alice, carol), newTx(3, alice, carol)
+		for _, tx := range []*types.Transaction{tx1, tx2, tx3} {
+			assert.NoError(t, store.StoreTransaction(tx))
+		}
+		assert.Equal(t, []*types.Transaction{tx2, tx3}, get(store, alice))
+		// Bob is within the limit, so he keeps the transaction alice has dropped
+		assert.Equal(t, []*types.Transaction{tx1}, get(store, bob))
+		assert.Equal(t, memtxstore.Stats{Transactions: 3, Addresses: 3, Evicted: 1, EvictedPerAddress: 1}, store.Stats())
+	})
+
+	t.Run("Total", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxTxs(2))
+		tx1, tx2, tx3 := newTx(1, alice, bob), newTx(2, alice, carol), newTx(3, bob, carol)
+		for _, tx := range []*types.Transaction{tx1, tx2, tx3} {
+			assert.NoError(t, store.StoreTransaction(tx))
+		}
+		assert.Equal(t, []*types.Transaction{tx2}, get(store, alice))
+		assert.Equal(t, []*types.Transaction{tx3}, get(store, bob))
+		stats := store.Stats()
+		assert.Equal(t, 2, stats.Transactions)
+		assert.Equal(t, uint64(1), stats.EvictedTotal)
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxTxs(2), memtxstore.WithEvictionPolicy(memtxstore.EvictLRU))
+		tx1, tx2, tx3 := newTx(1, alice, alice), newTx(2, bob, bob), newTx(3, carol, carol)
+		assert.NoError(t, store.StoreTransaction(tx1))
+		assert.NoError(t, store.StoreTransaction(tx2))
+		// Reading alice makes bob the least recently used
+		assert.Equal(t, []*types.Transaction{tx1}, get(store, alice))
+		assert.NoError(t, store.StoreTransaction(tx3))
+		assert.Equal(t, []*types.Transaction{tx1}, get(store, alice))
+		assert.Nil(t, get(store, bob))
+		assert.Equal(t, []*types.Transaction{tx3}, get(store, carol))
+
+		perAddress := memtxstore.New(memtxstore.WithMaxTxsPerAddress(2), memtxstore.WithEvictionPolicy(memtxstore.EvictLRU))
+		tx4, tx5, tx6 := newTx(4, alice, bob), newTx(5, alice, carol), newTx(6, alice, alice)
+		assert.NoError(t, perAddress.StoreTransaction(tx4))
+		assert.NoError(t, perAddress.StoreTransaction(tx5))
+		assert.Equal(t, []*types.Transaction{tx4}, get(perAddress, bob))
+		assert.NoError(t, perAddress.StoreTransaction(tx6))
+		assert.Equal(t, []*types.Transaction{tx4, tx6}, get(perAddress, alice))
+	})
+
+	t.Run("BlockAge", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxBlockAge(10))
+		tx1, tx2, tx3 := newTx(100, alice, bob), newTx(105, alice, carol), newTx(111, bob, carol)
+		for _, tx := range []*types.Transaction{tx1, tx2, tx3} {
+			assert.NoError(t, store.StoreTransaction(tx))
+		}
+		assert.Equal(t, []*types.Transaction{tx2}, get(store, alice))
+		assert.Equal(t, []*types.Transaction{tx3}, get(store, bob))
+		assert.Equal(t, []*types.Transaction{tx2, tx3}, get(store, carol))
+		// Late transaction from an old block is dropped right away
+		assert.NoError(t, store.StoreTransaction(newTx(90, carol, carol)))
+		assert.Equal(t, []*types.Transaction{tx2, tx3}, get(store, carol))
+		// Transaction between two addresses is one eviction
+		assert.Equal(t, memtxstore.Stats{Transactions: 2, Addresses: 3, Evicted: 2, EvictedBlockAge: 2}, store.Stats())
+	})
+
+	t.Run("PerAddressAndBlockAge", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxTxsPerAddress(1), memtxstore.WithMaxBlockAge(10))
+		tx1 := newTx(100, alice, bob)
+		assert.NoError(t, store.StoreTransaction(tx1))
+		// Late transaction is the oldest of both addresses, so the per address limit drops it before the block age one
+		assert.NoError(t, store.StoreTransaction(newTx(50, alice, bob)))
+		assert.Equal(t, []*types.Transaction{tx1}, get(store, alice))
+		assert.Equal(t, memtxstore.Stats{Transactions: 1, Addresses: 2, Evicted: 2, EvictedPerAddress: 2}, store.Stats())
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		store := memtxstore.New(memtxstore.WithMaxAge(time.Minute), memtxstore.WithClock(func() time.Time { return now }))
+		tx1, tx2 := newTx(1, alice, bob), newTx(2, alice, bob)
+		assert.NoError(t, store.StoreTransaction(tx1))
+		now = now.Add(40 * time.Second)
+		assert.NoError(t, store.StoreTransaction(tx2))
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, []*types.Transaction{tx2}, get(store, alice))
+		now = now.Add(time.Minute)
+		assert.Nil(t, get(store, bob))
+		assert.Equal(t, memtxstore.Stats{Evicted: 2, EvictedAge: 2}, store.Stats())
+	})
+
+	newReward := func(block int64, recipient types.EthAddress) *types.ProposedBlockReward {
+		return &types.ProposedBlockReward{
+			BlockHash:    uniqueHash(),
+			BlockNumber:  types.BigInt(*big.NewInt(block)),
+			FeeRecipient: recipient,
+		}
+	}
+	rewards := func(store *memtxstore.Store, address types.EthAddress) []*types.ProposedBlockReward {
+		out, err := store.GetBlockRewards(address.String())
+		assert.NoError(t, err)
+		return out
+	}
+
+	t.Run("RewardBlockAge", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxBlockAge(10))
+		r1, r2, r3 := newReward(100, alice), newReward(105, bob), newReward(111, alice)
+		for _, reward := range []*types.ProposedBlockReward{r1, r2, r3} {
+			assert.NoError(t, store.StoreBlockReward(reward))
+		}
+		assert.Equal(t, []*types.ProposedBlockReward{r3}, rewards(store, alice))
+		assert.Equal(t, []*types.ProposedBlockReward{r2}, rewards(store, bob))
+		assert.NoError(t, store.StoreBlockReward(newReward(95, bob)))
+		assert.Equal(t, []*types.ProposedBlockReward{r2}, rewards(store, bob))
+		assert.Equal(t, memtxstore.Stats{Rewards: 2, EvictedRewards: 2}, store.Stats())
+	})
+
+	t.Run("RewardAge", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		store := memtxstore.New(memtxstore.WithMaxAge(time.Minute), memtxstore.WithClock(func() time.Time { return now }))
+		r1, r2 := newReward(1, alice), newReward(2, alice)
+		assert.NoError(t, store.StoreBlockReward(r1))
+		now = now.Add(40 * time.Second)
+		assert.NoError(t, store.StoreBlockReward(r2))
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, []*types.ProposedBlockReward{r2}, rewards(store, alice))
+		assert.Equal(t, memtxstore.Stats{Rewards: 1, EvictedRewards: 1}, store.Stats())
+		now = now.Add(time.Minute)
+		assert.Nil(t, rewards(store, alice))
+		assert.Equal(t, memtxstore.Stats{EvictedRewards: 2}, store.Stats())
+	})
+}
+
+func TestQueryTransactions(t *testing.T) {
+	store := memtxstore.New()
+	others := []types.EthAddress{{1}, {2}, {3}}
+	for n := range 500 {
+		tx := &types.Transaction{
+			Hash:             uniqueHash(),
+			BlockNumber:      types.BigInt(*big.NewInt(rand.Int64N(100))),
+			TransactionIndex: types.BigInt(*big.NewInt(rand.Int64N(5))),
+			From:             targetAddress,
+			To:               others[n%len(others)],
+			Value:            types.BigInt(*big.NewInt(rand.Int64N(1000))),
+		}
+		ts := types.BigInt(*big.NewInt(tx.BlockNumber.AsBigInt().Int64() * 12))
+		tx.BlockTimestamp = &ts
+		if n%2 == 0 {
+			tx.From, tx.To = tx.To, tx.From
+		}
+		assert.NoError(t, store.StoreTransaction(tx))
+	}
+	all, err := store.GetTransactions(targetAddress.String())
+	assert.NoError(t, err)
+
+	for _, query := range []txstore.Query{
+		{Limit: 7},
+		{Limit: 10, Order: txstore.OrderDescending},
+		{Limit: 5, Direction: txstore.DirectionIncoming, Counterparty: others[1].String()},
+		{Limit: 9, MinValue: big.NewInt(100), MaxValue: big.NewInt(500), FromBlock: big.NewInt(10), ToBlock: big.NewInt(80)},
+		{Limit: 4, Order: txstore.OrderDescending, FromTime: time.Unix(120, 0), ToTime: time.Unix(600, 0)},
+	} {
+		query.Address = targetAddress.String()
+		unpaged := query
+		unpaged.Limit = 0
+		expected, err := unpaged.Run(all)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, expected.Transactions)
+
+		var got []*types.Transaction
+		for {
+			page, err := store.QueryTransactions(query)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, len(page.Transactions), query.Limit)
+			got = append(got, page.Transactions...)
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Equal(t, expected.Transactions, got)
+	}
+
+	_, err = store.QueryTransactions(txstore.Query{})
+	assert.Error(t, err)
+}
+
+func TestRollback(t *testing.T) {
+	other := types.EthAddress{1}
+	newTx := func(block int64) *types.Transaction {
+		return &types.Transaction{
+			Hash:        uniqueHash(),
+			BlockHash:   types.EthHash{byte(block)},
+			BlockNumber: types.BigInt(*big.NewInt(block)),
+			From:        targetAddress,
+			To:          other,
+		}
+	}
+	newReward := func(block int64) *types.ProposedBlockReward {
+		return &types.ProposedBlockReward{
+			BlockHash:    types.EthHash{byte(block)},
+			BlockNumber:  types.BigInt(*big.NewInt(block)),
+			FeeRecipient: targetAddress,
+		}
+	}
+	fill := func(store *memtxstore.Store) []*types.Transaction {
+		txs := []*types.Transaction{newTx(1), newTx(2), newTx(3), newTx(3)}
+		for _, tx := range txs {
+			assert.NoError(t, store.StoreTransaction(tx))
+		}
+		for block := range int64(3) {
+			assert.NoError(t, store.StoreBlockReward(newReward(block+1)))
+		}
+		return txs
+	}
+	blocks := func(rewards []*types.ProposedBlockReward) (out []int64) {
+		for _, reward := range rewards {
+			out = append(out, reward.BlockNumber.AsBigInt().Int64())
+		}
+		return out
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		store := memtxstore.New()
+		txs := fill(store)
+		removed, err := store.Rollback(*big.NewInt(1))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, removed)
+
+		for _, address := range []types.EthAddress{targetAddress, other} {
+			got, err := store.GetTransactions(address.String())
+			assert.NoError(t, err)
+			assert.Equal(t, txs[:1], got)
+		}
+		rewards, err := store.GetBlockRewards(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{1}, blocks(rewards))
+		assert.Equal(t, 1, store.Stats().Transactions)
+
+		tombs, err := store.Tombstones(other.String())
+		assert.NoError(t, err)
+		assert.Len(t, tombs, 3)
+		for _, tomb := range tombs {
+			assert.Equal(t, int64(2), tomb.From.AsBigInt().Int64())
+			assert.False(t, tomb.Flagged)
+		}
+		tombs, err = store.Tombstones(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Len(t, tombs, 5)
+		assert.Equal(t, newReward(3).BlockHash, tombs[4].Reward.BlockHash)
+
+		// Nothing above is left
+		removed, err = store.Rollback(*big.NewInt(1))
+		assert.NoError(t, err)
+		assert.Zero(t, removed)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithMaxTombstones(2))
+		txs := fill(store)
+		removed, err := store.RollbackBlock(types.EthHash{3})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, removed)
+		got, err := store.GetTransactions(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Equal(t, txs[:2], got)
+
+		tombs, err := store.Tombstones(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Len(t, tombs, 2)
+
+		removed, err = store.RollbackBlock(types.EthHash{9})
+		assert.NoError(t, err)
+		assert.Zero(t, removed)
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		store := memtxstore.New(memtxstore.WithRollbackMode(txstore.RollbackFlag))
+		txs := fill(store)
+		removed, err := store.Rollback(*big.NewInt(2))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, removed)
+
+		got, err := store.GetTransactions(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Len(t, got, 4)
+		for _, tx := range got {
+			assert.Equal(t, tx.BlockNumber.AsBigInt().Int64() == 3, tx.Orphaned)
+		}
+		assert.False(t, txs[2].Orphaned, "stored transaction is not changed")
+
+		// Flagged transactions are not rolled back again
+		removed, err = store.Rollback(*big.NewInt(2))
+		assert.NoError(t, err)
+		assert.Zero(t, removed)
+
+		// Transaction included again into the new branch replaces the flagged one
+		assert.NoError(t, store.StoreTransaction(txs[3]))
+		got, err = store.GetTransactionsAfterBlock(*big.NewInt(2), targetAddress.String())
+		assert.NoError(t, err)
+		assert.Len(t, got, 2)
+		for _, tx := range got {
+			assert.Equal(t, tx.Hash != txs[3].Hash, tx.Orphaned)
+		}
+
+		tombs, err := store.Tombstones(targetAddress.String())
+		assert.NoError(t, err)
+		assert.Len(t, tombs, 3)
+		assert.True(t, tombs[0].Flagged)
+		assert.False(t, tombs[2].Flagged, "rewards are always removed")
+	})
+}
+
+func TestConformance(t *testing.T) {
+	for name, mode := range map[string]txstore.RollbackMode{"Remove": txstore.RollbackRemove, "Flag": txstore.RollbackFlag} {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) txstore.Store {
+				return memtxstore.New(memtxstore.WithRollbackMode(mode))
+			})
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package processors
+
+import (
+	"github.com/dkropachev/ethscan/pkg/synclist"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+)
+
+type receiptGetter interface {
+	GetBlockReceipts(blockNum *big.Int) ([]*types.Receipt, error)
+}
+
+// BlockReward passes blocks through and emits a ProposedBlockReward for every block
+// whose fee recipient (BlockBase.Miner) is one of the watched addresses.
+type BlockReward struct {
+	blkChan    <-chan *types.BlockDetailed
+	outChan    chan *types.BlockDetailed
+	rewardChan chan *types.ProposedBlockReward
+	receipts   receiptGetter
+	wallets    synclist.ComparableList[string]
+}
+
+// NewBlockRewardProcessor creates block reward processor.
+// receipts is optional, when it is nil or fails priority fees are estimated from block transactions.
+func NewBlockRewardProcessor(blkChan <-chan *types.BlockDetailed, receipts receiptGetter) *BlockReward {
+	out := &BlockReward{
+		blkChan:    blkChan,
+		outChan:    make(chan *types.BlockDetailed, 1000),
+		rewardChan: make(chan *types.ProposedBlockReward, 1000),
+		receipts:   receipts,
+	}
+	go out.body()
+	return out
+}
+
+func (p *BlockReward) body() {
+	defer close(p.outChan)
+	defer close(p.rewardChan)
+	for blk := range p.blkChan {
+		if blk == nil {
+			return
+		}
+		if p.wallets.Contains(blk.Miner.String()) {
+			p.rewardChan <- p.calculate(blk)
+		}
+		p.outChan <- blk
+	}
+}
+
+func (p *BlockReward) calculate(blk *types.BlockDetailed) *types.ProposedBlockReward {
+	baseFee := blk.BaseFeePerGas.AsBigInt()
+	out := &types.ProposedBlockReward{
+		BlockHash:     blk.Hash,
+		BlockNumber:   blk.Number,
+		Timestamp:     blk.Timestamp,
+		FeeRecipient:  blk.Miner,
+		BaseFeePerGas: blk.BaseFeePerGas,
+		GasUsed:       blk.GasUsed,
+		TxCount:       len(blk.Transactions),
+		ChainID:       blk.ChainID,
+	}
+	burnt := new(big.Int).Mul(baseFee, blk.GasUsed.AsBigInt())
+	out.BurntFees = types.BigInt(*burnt)
+
+	if p.receipts != nil {
+		if receipts, err := p.receipts.GetBlockReceipts(blk.Number.AsBigInt()); err == nil && len(receipts) == len(blk.Transactions) {
+			out.PriorityFees = types.BigInt(*priorityFeesFromReceipts(receipts, baseFee))
+			out.FromReceipts = true
+			return out
+		}
+	}
+	out.PriorityFees = types.BigInt(*estimatePriorityFees(blk.Transactions, baseFee, blk.GasUsed.AsBigInt()))
+	return out
+}
+
+func priorityFeesFromReceipts(receipts []*types.Receipt, baseFee *big.Int) *big.Int {
+	total := new(big.Int)
+	tip := new(big.Int)
+	for _, r := range receipts {
+		tip.Sub(r.EffectiveGasPrice.AsBigInt(), baseFee)
+		if tip.Sign() <= 0 {
+			continue
+		}
+		total.Add(total, tip.Mul(tip, r.GasUsed.AsBigInt()))
+	}
+	return total
+}
+
+// estimatePriorityFees weights every transaction tip by its gas limit and scales the sum
+// down to the gas actually used by the block, since per-transaction gas usage is only known from receipts.
+func estimatePriorityFees(txs []*types.Transaction, baseFee, blockGasUsed *big.Int) *big.Int {
+	total := new(big.Int)
+	gasLimits := new(big.Int)
+	for _, tx := range txs {
+		gas := tx.Gas.AsBigInt()
+		gasLimits.Add(gasLimits, gas)
+		total.Add(total, new(big.Int).Mul(tx.EffectiveTip(baseFee), gas))
+	}
+	if gasLimits.Sign() == 0 || gasLimits.Cmp(blockGasUsed) <= 0 {
+		return total
+	}
+	return total.Div(total.Mul(total, blockGasUsed), gasLimits)
+}
+
+func (p *BlockReward) AddWallet(wallet string) bool {
+	return p.wallets.AppendIfNotExists(wallet)
+}
+
+func (p *BlockReward) Out() <-chan *types.BlockDetailed {
+	return p.outChan
+}
+
+func (p *BlockReward) Rewards() <-chan *types.ProposedBlockReward {
+	return p.rewardChan
+}
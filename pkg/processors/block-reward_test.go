@@ -0,0 +1,129 @@
+package processors_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticReceipts []*types.Receipt
+
+func (r staticReceipts) GetBlockReceipts(*big.Int) ([]*types.Receipt, error) {
+	return r, nil
+}
+
+func bigInt(val int64) types.BigInt {
+	return types.BigInt(*big.NewInt(val))
+}
+
+func bigIntPtr(val int64) *types.BigInt {
+	return (*types.BigInt)(big.NewInt(val))
+}
+
+func TestBlockReward(t *testing.T) {
+	feeRecipient := types.EthAddress{1, 2, 3}
+	blk := &types.BlockDetailed{
+		BlockBase: types.BlockBase{
+			Number:        bigInt(100),
+			Miner:         feeRecipient,
+			BaseFeePerGas: bigInt(10),
+			GasUsed:       bigInt(30000),
+		},
+		Transactions: []*types.Transaction{
+			// legacy, tip 5
+			{Gas: bigInt(20000), GasPrice: bigInt(15)},
+			// dynamic fee, tip capped by max fee: min(7, 12-10) = 2
+			{Gas: bigInt(40000), GasPrice: bigInt(12), MaxFeePerGas: bigIntPtr(12), MaxPriorityFeePerGas: bigIntPtr(7)},
+		},
+	}
+	other := &types.BlockDetailed{
+		BlockBase: types.BlockBase{Number: bigInt(101), Miner: types.EthAddress{9}},
+	}
+
+	t.Run("Estimated", func(t *testing.T) {
+		in := make(chan *types.BlockDetailed, 2)
+		p := processors.NewBlockRewardProcessor(in, nil)
+		p.AddWallet(feeRecipient.String())
+		in <- blk
+		in <- other
+		close(in)
+
+		assert.Equal(t, blk, <-p.Out())
+		assert.Equal(t, other, <-p.Out())
+		reward := <-p.Rewards()
+		assert.False(t, reward.FromReceipts)
+		assert.Equal(t, int64(300000), reward.BurntFees.AsBigInt().Int64())
+		// (5*20000 + 2*40000) * 30000 / 60000
+		assert.Equal(t, int64(90000), reward.PriorityFees.AsBigInt().Int64())
+		_, ok := <-p.Rewards()
+		assert.False(t, ok)
+	})
+
+	t.Run("FromReceipts", func(t *testing.T) {
+		in := make(chan *types.BlockDetailed, 1)
+		p := processors.NewBlockRewardProcessor(in, staticReceipts{
+			{GasUsed: bigInt(21000), EffectiveGasPrice: bigInt(15)},
+			{GasUsed: bigInt(9000), EffectiveGasPrice: bigInt(12)},
+		})
+		p.AddWallet(feeRecipient.String())
+		in <- blk
+		close(in)
+
+		<-p.Out()
+		reward := <-p.Rewards()
+		assert.True(t, reward.FromReceipts)
+		assert.Equal(t, int64(5*21000+2*9000), reward.PriorityFees.AsBigInt().Int64())
+	})
+}
+
+func TestBlockRewardFromNode(t *testing.T) {
+	t.Parallel()
+	feeRecipient := types.EthAddress{1, 2, 3}
+	node := ethtest.NewNode(t, ethtest.WithBaseFee(10))
+	node.SetMiner(feeRecipient)
+	txs := []ethtest.Tx{
+		// legacy, tip 5
+		{Gas: 30000, GasUsed: 21000, GasPrice: 15},
+		// dynamic fee, tip capped by max fee: min(7, 12-10) = 2
+		{Gas: 40000, GasUsed: 9000, MaxFeePerGas: 12, MaxPriorityFeePerGas: 7},
+	}
+	node.AppendBlock(txs...)
+	node.AppendBlock(txs...)
+	// First block falls back to estimation from gas limits scaled to block gas used
+	node.FailNext("eth_getBlockReceipts", 1, rpc.CodeMethodNotSupported, "method not supported")
+
+	sub, err := blksubscriber.New[types.BlockDetailed](node.URL(),
+		blksubscriber.WithStartBlock(big.NewInt(1)),
+		blksubscriber.WithEndBlock(big.NewInt(2)),
+		blksubscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	p := processors.NewBlockRewardProcessor(sub.GetBlockChan(), sub)
+	p.AddWallet(feeRecipient.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	var rewards []*types.ProposedBlockReward
+	for reward := range p.Rewards() {
+		rewards = append(rewards, reward)
+	}
+	require.NoError(t, sub.LastError())
+	require.Len(t, rewards, 2)
+
+	assert.False(t, rewards[0].FromReceipts)
+	// (5*30000 + 2*40000) * 30000 / 70000
+	assert.Equal(t, int64(98571), rewards[0].PriorityFees.AsBigInt().Int64())
+
+	assert.Equal(t, int64(1), rewards[0].ChainID.AsBigInt().Int64())
+	assert.True(t, rewards[1].FromReceipts)
+	assert.Equal(t, int64(5*21000+2*9000), rewards[1].PriorityFees.AsBigInt().Int64())
+	assert.Equal(t, int64(10*30000), rewards[1].BurntFees.AsBigInt().Int64())
+}
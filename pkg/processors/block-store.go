@@ -0,0 +1,176 @@
+package processors
+
+import (
+	stderr "errors"
+	"github.com/dkropachev/ethscan/pkg/synclist"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// BlockStore stores block rewards and transactions of watched wallets block by block.
+// When the store keeps checkpoints, the block is saved as one once everything from it is stored,
+// so that subscriber restarted from the checkpoint misses nothing.
+// When the store can roll back, a block that does not extend the previous one, as sources send after a reorg,
+// rolls back everything stored from the blocks it replaces first.
+// The first store error stops storing and checkpoints for good, blocks are still drained so that the source
+// does not stall, and IsRunning turns false.
+type BlockStore struct {
+	blkChan    <-chan *types.BlockDetailed
+	rewardChan <-chan *types.ProposedBlockReward
+	store      txstore.Store
+	wallets    synclist.ComparableList[string]
+	// rewards are received but not stored yet, by block hash
+	rewards    map[types.EthHash][]*types.ProposedBlockReward
+	last       *types.BlockDetailed
+	rolledBack atomic.Uint64
+	lastError  atomic.Pointer[error]
+	failed     atomic.Bool
+}
+
+// NewBlockStore creates block store processor, rewardChan is BlockReward.Rewards() of the processor blocks come from,
+// BlockReward emits reward of a block before the block itself, so it is always stored before the checkpoint.
+func NewBlockStore(blkChan <-chan *types.BlockDetailed, rewardChan <-chan *types.ProposedBlockReward, store txstore.Store) *BlockStore {
+	out := &BlockStore{
+		blkChan:    blkChan,
+		rewardChan: rewardChan,
+		store:      store,
+		rewards:    map[types.EthHash][]*types.ProposedBlockReward{},
+	}
+	go out.body()
+	return out
+}
+
+func (p *BlockStore) body() {
+	checkpoints, _ := p.store.(txstore.Checkpointer)
+	for blk := range p.blkChan {
+		if blk == nil {
+			break
+		}
+		p.receiveRewards(false)
+		if p.failed.Load() {
+			clear(p.rewards)
+			continue
+		}
+		err := p.rollback(blk)
+		if err == nil {
+			err = stderr.Join(p.storeRewards(blk), p.storeTransactions(blk))
+		}
+		if err == nil && checkpoints != nil {
+			err = checkpoints.SaveCheckpoint(*blk.Number.AsBigInt())
+		}
+		if err != nil {
+			// Later checkpoint would skip over what is missing, so stop here
+			p.lastError.Store(&err)
+			p.failed.Store(true)
+			continue
+		}
+		p.last = blk
+	}
+	// Rewards are emitted before their blocks, so what is left belongs to blocks that never came
+	p.receiveRewards(true)
+}
+
+// receiveRewards takes rewards that are already emitted, or all of them until the channel is closed if wait is set
+func (p *BlockStore) receiveRewards(wait bool) {
+	for {
+		var reward *types.ProposedBlockReward
+		var ok bool
+		if wait {
+			reward, ok = <-p.rewardChan
+		} else {
+			select {
+			case reward, ok = <-p.rewardChan:
+			default:
+				return
+			}
+		}
+		if !ok || reward == nil {
+			return
+		}
+		p.rewards[reward.BlockHash] = append(p.rewards[reward.BlockHash], reward)
+	}
+}
+
+// storeRewards stores rewards of the block, and drops ones of blocks up to it that were reorged out before they came
+func (p *BlockStore) storeRewards(blk *types.BlockDetailed) error {
+	for hash, rewards := range p.rewards {
+		if hash != blk.Hash && rewards[0].BlockNumber.AsBigInt().Cmp(blk.Number.AsBigInt()) <= 0 {
+			delete(p.rewards, hash)
+		}
+	}
+	for _, reward := range p.rewards[blk.Hash] {
+		if err := p.store.StoreBlockReward(reward); err != nil {
+			return err
+		}
+	}
+	delete(p.rewards, blk.Hash)
+	return nil
+}
+
+// rollback rolls back blocks the block replaces: ones from its number up when source went back,
+// or the previous one when it is not the parent
+func (p *BlockStore) rollback(blk *types.BlockDetailed) error {
+	rollbacks, ok := p.store.(txstore.Rollbacker)
+	if !ok || p.last == nil {
+		return nil
+	}
+	var removed int
+	var err error
+	switch next := new(big.Int).Add(p.last.Number.AsBigInt(), big.NewInt(1)); blk.Number.AsBigInt().Cmp(next) {
+	case -1:
+		removed, err = rollbacks.Rollback(*new(big.Int).Sub(blk.Number.AsBigInt(), big.NewInt(1)))
+	case 0:
+		if blk.ParentHash != p.last.Hash {
+			removed, err = rollbacks.RollbackBlock(p.last.Hash)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to roll back blocks replaced by %s", blk.Number.AsBigInt())
+	}
+	p.rolledBack.Add(uint64(removed))
+	return nil
+}
+
+// RolledBack returns number of stored transactions and rewards rolled back so far
+func (p *BlockStore) RolledBack() uint64 {
+	return p.rolledBack.Load()
+}
+
+func (p *BlockStore) storeTransactions(blk *types.BlockDetailed) error {
+	for _, tx := range blk.Transactions {
+		if !p.wallets.Contains(tx.From.String(), tx.To.String()) {
+			continue
+		}
+		if tx.ChainID == nil {
+			tx.ChainID = blk.ChainID
+		}
+		if tx.BlockTimestamp == nil {
+			tx.BlockTimestamp = &blk.Timestamp
+		}
+		if err := p.store.StoreTransaction(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *BlockStore) AddWallet(wallet string) bool {
+	return p.wallets.AppendIfNotExists(wallet)
+}
+
+// IsRunning is false once storing failed
+func (p *BlockStore) IsRunning() bool {
+	return !p.failed.Load()
+}
+
+// LastError returns the error storing has stopped on
+func (p *BlockStore) LastError() error {
+	if err := p.lastError.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package processors_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/memtxstore"
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore fails to store transactions of the block and counts checkpoints
+type failingStore struct {
+	txstore.Store
+	failBlock   int64
+	checkpoints atomic.Int64
+}
+
+func (s *failingStore) StoreTransaction(tx *types.Transaction) error {
+	if tx.BlockNumber.AsBigInt().Int64() == s.failBlock {
+		return errors.New("fsync failed")
+	}
+	return s.Store.StoreTransaction(tx)
+}
+
+func (s *failingStore) SaveCheckpoint(big.Int) error {
+	s.checkpoints.Add(1)
+	return nil
+}
+
+func (s *failingStore) GetCheckpoint() (*big.Int, error) {
+	return nil, nil
+}
+
+func TestBlockStoreError(t *testing.T) {
+	const blocks = 3000
+	wallet := types.EthAddress{1}
+	store := &failingStore{Store: memtxstore.New(), failBlock: 2}
+	in := make(chan *types.BlockDetailed)
+	reward := processors.NewBlockRewardProcessor(in, nil)
+	reward.AddWallet(wallet.String())
+	p := processors.NewBlockStore(reward.Out(), reward.Rewards(), store)
+	p.AddWallet(wallet.String())
+
+	// Blocks keep flowing after the failure, more than buffers of the processors hold
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(1); i <= blocks; i++ {
+			in <- &types.BlockDetailed{
+				BlockBase: types.BlockBase{Number: bigInt(i), Hash: types.EthHash{byte(i), byte(i >> 8)}, Miner: wallet},
+				Transactions: []*types.Transaction{
+					{BlockNumber: bigInt(i), Hash: types.EthHash{byte(i), byte(i >> 8), 1}, To: wallet},
+				},
+			}
+		}
+		close(in)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("block store stalled the pipeline")
+	}
+
+	require.Eventually(t, func() bool { return !p.IsRunning() }, 5*time.Second, time.Millisecond)
+	for i := 0; i < 2; i++ {
+		assert.ErrorContains(t, p.LastError(), "fsync failed")
+	}
+	// Nothing past the failed block is stored or checkpointed
+	assert.Equal(t, int64(1), store.checkpoints.Load())
+	txs, err := store.GetTransactions(wallet.String())
+	require.NoError(t, err)
+	assert.Len(t, txs, 1)
+}
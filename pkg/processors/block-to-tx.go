@@ -25,6 +25,12 @@ func (p *BlockToTx) body() {
 			return
 		}
 		for _, tx := range blk.Transactions {
+			if tx.ChainID == nil {
+				tx.ChainID = blk.ChainID
+			}
+			if tx.BlockTimestamp == nil {
+				tx.BlockTimestamp = &blk.Timestamp
+			}
 			p.outChan <- tx
 		}
 	}
@@ -0,0 +1,298 @@
+package processors
+
+import (
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FeeParams configures fee market calculations of the FeeStats processor.
+type FeeParams struct {
+	// WindowSize is the number of most recent blocks kept for FeeHistory queries
+	WindowSize int
+	// Percentiles of priority fees reported in every BlockFeeStats
+	Percentiles              []float64
+	ElasticityMultiplier     uint64
+	BaseFeeChangeDenominator uint64
+	// Blob gas parameters are left zero on chains without blobs, they change with forks, see chains.Chain.FeeParams
+	TargetBlobGasPerBlock     uint64
+	MaxBlobGasPerBlock        uint64
+	BlobBaseFeeUpdateFraction uint64
+	// SystemTxTypes are left out of priority fee percentiles, protocol transactions like OP deposits pay no fees
+	SystemTxTypes []uint64
+}
+
+// DefaultFeeParams returns Ethereum mainnet EIP-1559 parameters without blob gas ones, which differ between chains.
+func DefaultFeeParams() FeeParams {
+	return FeeParams{
+		WindowSize:               1024,
+		Percentiles:              []float64{10, 25, 50, 75, 90},
+		ElasticityMultiplier:     2,
+		BaseFeeChangeDenominator: 8,
+	}
+}
+
+type weightedTip struct {
+	tip *big.Int
+	gas uint64
+}
+
+type feeWindowEntry struct {
+	stats *types.BlockFeeStats
+	// tips are sorted in ascending order
+	tips []weightedTip
+}
+
+// FeeStats turns detailed blocks into per-block fee statistics
+// and keeps a rolling window of them that can be queried in eth_feeHistory fashion.
+type FeeStats struct {
+	blkChan  <-chan *types.BlockDetailed
+	outChan  chan *types.BlockFeeStats
+	params   FeeParams
+	receipts receiptGetter
+
+	windowLock sync.RWMutex
+	window     []*feeWindowEntry
+}
+
+// NewFeeStatsProcessor creates fee statistics processor.
+// Priority fee percentiles are weighted by gas used of every transaction, as eth_feeHistory does, which is taken
+// from receipts. receipts is optional, when it is nil or fails they are estimated by weighting with gas limits.
+func NewFeeStatsProcessor(blkChan <-chan *types.BlockDetailed, params FeeParams, receipts receiptGetter) *FeeStats {
+	out := &FeeStats{
+		blkChan:  blkChan,
+		outChan:  make(chan *types.BlockFeeStats, 1000),
+		params:   params,
+		receipts: receipts,
+	}
+	go out.body()
+	return out
+}
+
+func (p *FeeStats) body() {
+	defer close(p.outChan)
+	for blk := range p.blkChan {
+		if blk == nil {
+			return
+		}
+		entry := p.calculate(blk)
+		p.push(entry)
+		p.outChan <- entry.stats
+	}
+}
+
+func (p *FeeStats) calculate(blk *types.BlockDetailed) *feeWindowEntry {
+	baseFee := blk.BaseFeePerGas.AsBigInt()
+	stats := &types.BlockFeeStats{
+		Number:        blk.Number,
+		Hash:          blk.Hash,
+		Timestamp:     blk.Timestamp,
+		BaseFeePerGas: blk.BaseFeePerGas,
+		GasLimit:      blk.GasLimit,
+		GasUsed:       blk.GasUsed,
+		BlobGasUsed:   blk.BlobGasUsed,
+		ExcessBlobGas: blk.ExcessBlobGas,
+		TxCount:       len(blk.Transactions),
+		ChainID:       blk.ChainID,
+	}
+	stats.NextBaseFeePerGas = types.BigInt(*p.nextBaseFee(blk))
+	stats.BlobBaseFee = types.BigInt(*p.blobBaseFee(blk.ExcessBlobGas.AsBigInt()))
+	if gasLimit := blk.GasLimit.AsBigInt().Uint64(); gasLimit != 0 {
+		stats.GasUsedRatio = float64(blk.GasUsed.AsBigInt().Uint64()) / float64(gasLimit)
+	}
+	if p.params.MaxBlobGasPerBlock != 0 {
+		stats.BlobGasUsedRatio = float64(blk.BlobGasUsed.AsBigInt().Uint64()) / float64(p.params.MaxBlobGasPerBlock)
+	}
+
+	var receipts []*types.Receipt
+	if p.receipts != nil && len(blk.Transactions) != 0 {
+		if val, err := p.receipts.GetBlockReceipts(blk.Number.AsBigInt()); err == nil && len(val) == len(blk.Transactions) {
+			receipts = val
+			stats.FromReceipts = true
+		}
+	}
+	// Without receipts per-transaction gas usage is not known, so tips are weighted by gas limits
+	tips := make([]weightedTip, 0, len(blk.Transactions))
+	for i, tx := range blk.Transactions {
+		if len(p.params.SystemTxTypes) != 0 && tx.Type.AsBigInt().IsUint64() &&
+			slices.Contains(p.params.SystemTxTypes, tx.Type.AsBigInt().Uint64()) {
+			continue
+		}
+		gas := tx.Gas.AsBigInt().Uint64()
+		if receipts != nil {
+			gas = receipts[i].GasUsed.AsBigInt().Uint64()
+		}
+		tips = append(tips, weightedTip{tip: tx.EffectiveTip(baseFee), gas: gas})
+	}
+	slices.SortStableFunc(tips, func(a, b weightedTip) int {
+		return a.tip.Cmp(b.tip)
+	})
+
+	for i, val := range tipPercentiles(tips, p.params.Percentiles) {
+		stats.PriorityFees = append(stats.PriorityFees, types.PriorityFeePercentile{
+			Percentile: p.params.Percentiles[i],
+			Value:      types.BigInt(*val),
+		})
+	}
+	return &feeWindowEntry{stats: stats, tips: tips}
+}
+
+// nextBaseFee implements EIP-1559 base fee update rule
+func (p *FeeStats) nextBaseFee(blk *types.BlockDetailed) *big.Int {
+	baseFee := blk.BaseFeePerGas.AsBigInt()
+	if p.params.ElasticityMultiplier == 0 || p.params.BaseFeeChangeDenominator == 0 {
+		return new(big.Int).Set(baseFee)
+	}
+	target := new(big.Int).Div(blk.GasLimit.AsBigInt(), new(big.Int).SetUint64(p.params.ElasticityMultiplier))
+	if target.Sign() == 0 {
+		return new(big.Int).Set(baseFee)
+	}
+	gasUsed := blk.GasUsed.AsBigInt()
+	denominator := new(big.Int).SetUint64(p.params.BaseFeeChangeDenominator)
+
+	switch gasUsed.Cmp(target) {
+	case 0:
+		return new(big.Int).Set(baseFee)
+	case 1:
+		delta := new(big.Int).Sub(gasUsed, target)
+		delta.Mul(delta, baseFee).Div(delta, target).Div(delta, denominator)
+		if delta.Sign() == 0 {
+			delta.SetInt64(1)
+		}
+		return delta.Add(delta, baseFee)
+	default:
+		delta := new(big.Int).Sub(target, gasUsed)
+		delta.Mul(delta, baseFee).Div(delta, target).Div(delta, denominator)
+		delta.Sub(baseFee, delta)
+		if delta.Sign() < 0 {
+			delta.SetInt64(0)
+		}
+		return delta
+	}
+}
+
+// blobBaseFee implements EIP-4844 fake_exponential(MIN_BASE_FEE_PER_BLOB_GAS, excessBlobGas, BLOB_BASE_FEE_UPDATE_FRACTION)
+func (p *FeeStats) blobBaseFee(excessBlobGas *big.Int) *big.Int {
+	if p.params.BlobBaseFeeUpdateFraction == 0 {
+		return big.NewInt(1)
+	}
+	denominator := new(big.Int).SetUint64(p.params.BlobBaseFeeUpdateFraction)
+	output := new(big.Int)
+	accum := new(big.Int).Set(denominator)
+	for i := int64(1); accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+		accum.Mul(accum, excessBlobGas)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(i))
+	}
+	return output.Div(output, denominator)
+}
+
+func (p *FeeStats) nextExcessBlobGas(stats *types.BlockFeeStats) *big.Int {
+	out := new(big.Int).Add(stats.ExcessBlobGas.AsBigInt(), stats.BlobGasUsed.AsBigInt())
+	out.Sub(out, new(big.Int).SetUint64(p.params.TargetBlobGasPerBlock))
+	if out.Sign() < 0 {
+		out.SetInt64(0)
+	}
+	return out
+}
+
+func tipPercentiles(tips []weightedTip, percentiles []float64) []*big.Int {
+	out := make([]*big.Int, len(percentiles))
+	if len(tips) == 0 {
+		for i := range out {
+			out[i] = new(big.Int)
+		}
+		return out
+	}
+	var total uint64
+	for _, tip := range tips {
+		total += tip.gas
+	}
+	idx := 0
+	sum := tips[0].gas
+	for i, percentile := range percentiles {
+		threshold := uint64(float64(total) * percentile / 100)
+		for sum < threshold && idx < len(tips)-1 {
+			idx++
+			sum += tips[idx].gas
+		}
+		out[i] = new(big.Int).Set(tips[idx].tip)
+	}
+	return out
+}
+
+// push appends entry to the window, dropping entries that it replaces on reorg
+// and resetting the window if there is a gap between blocks.
+func (p *FeeStats) push(entry *feeWindowEntry) {
+	p.windowLock.Lock()
+	defer p.windowLock.Unlock()
+
+	number := entry.stats.Number.AsBigInt()
+	p.window = slices.DeleteFunc(p.window, func(e *feeWindowEntry) bool {
+		return e.stats.Number.AsBigInt().Cmp(number) >= 0
+	})
+	if len(p.window) != 0 {
+		last := p.window[len(p.window)-1].stats.Number.AsBigInt()
+		if new(big.Int).Sub(number, last).Cmp(big.NewInt(1)) != 0 {
+			p.window = p.window[:0]
+		}
+	}
+	p.window = append(p.window, entry)
+	if p.params.WindowSize > 0 && len(p.window) > p.params.WindowSize {
+		p.window = slices.Delete(p.window, 0, len(p.window)-p.params.WindowSize)
+	}
+}
+
+// FeeHistory returns fee history of up to blockCount most recent blocks,
+// with priority fees at the requested gas-weighted percentiles, like eth_feeHistory does.
+// Percentiles of blocks processed without receipts are weighted by gas limits, see NewFeeStatsProcessor.
+func (p *FeeStats) FeeHistory(blockCount int, rewardPercentiles []float64) (*types.FeeHistory, error) {
+	for i, percentile := range rewardPercentiles {
+		if percentile < 0 || percentile > 100 {
+			return nil, errors.Errorf("invalid reward percentile %f", percentile)
+		}
+		if i > 0 && percentile < rewardPercentiles[i-1] {
+			return nil, errors.Errorf("reward percentiles are not monotonic: %f after %f", percentile, rewardPercentiles[i-1])
+		}
+	}
+
+	p.windowLock.RLock()
+	defer p.windowLock.RUnlock()
+
+	if len(p.window) == 0 {
+		return nil, errors.New("no blocks processed yet")
+	}
+	if blockCount <= 0 || blockCount > len(p.window) {
+		blockCount = len(p.window)
+	}
+	entries := p.window[len(p.window)-blockCount:]
+
+	out := &types.FeeHistory{
+		OldestBlock: entries[0].stats.Number,
+	}
+	for _, entry := range entries {
+		out.BaseFeePerGas = append(out.BaseFeePerGas, entry.stats.BaseFeePerGas)
+		out.GasUsedRatio = append(out.GasUsedRatio, entry.stats.GasUsedRatio)
+		out.BaseFeePerBlobGas = append(out.BaseFeePerBlobGas, entry.stats.BlobBaseFee)
+		out.BlobGasUsedRatio = append(out.BlobGasUsedRatio, entry.stats.BlobGasUsedRatio)
+		if len(rewardPercentiles) != 0 {
+			var rewards []types.BigInt
+			for _, val := range tipPercentiles(entry.tips, rewardPercentiles) {
+				rewards = append(rewards, types.BigInt(*val))
+			}
+			out.Reward = append(out.Reward, rewards)
+		}
+	}
+	newest := entries[len(entries)-1].stats
+	out.BaseFeePerGas = append(out.BaseFeePerGas, newest.NextBaseFeePerGas)
+	out.BaseFeePerBlobGas = append(out.BaseFeePerBlobGas, types.BigInt(*p.blobBaseFee(p.nextExcessBlobGas(newest))))
+	return out, nil
+}
+
+func (p *FeeStats) Out() <-chan *types.BlockFeeStats {
+	return p.outChan
+}
@@ -0,0 +1,114 @@
+package processors_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func feeBlock(number, baseFee, gasUsed int64, txs ...*types.Transaction) *types.BlockDetailed {
+	return &types.BlockDetailed{
+		BlockBase: types.BlockBase{
+			Number:        bigInt(number),
+			BaseFeePerGas: bigInt(baseFee),
+			GasLimit:      bigInt(30_000_000),
+			GasUsed:       bigInt(gasUsed),
+		},
+		Transactions: txs,
+	}
+}
+
+func TestFeeStats(t *testing.T) {
+	in := make(chan *types.BlockDetailed, 10)
+	params := processors.DefaultFeeParams()
+	params.WindowSize = 2
+	params.Percentiles = []float64{0, 50, 100}
+	p := processors.NewFeeStatsProcessor(in, params, nil)
+
+	in <- feeBlock(10, 1000, 15_000_000)
+	in <- feeBlock(11, 1000, 30_000_000,
+		&types.Transaction{Gas: bigInt(100), GasPrice: bigInt(1001)},
+		&types.Transaction{Gas: bigInt(300), GasPrice: bigInt(1005)},
+		&types.Transaction{Gas: bigInt(100), GasPrice: bigInt(1009)},
+	)
+	in <- feeBlock(12, 1125, 0)
+	close(in)
+
+	first := <-p.Out()
+	assert.Equal(t, 0.5, first.GasUsedRatio)
+	assert.Equal(t, int64(1000), first.NextBaseFeePerGas.AsBigInt().Int64())
+	assert.Equal(t, int64(1), first.BlobBaseFee.AsBigInt().Int64())
+
+	second := <-p.Out()
+	assert.Equal(t, int64(1125), second.NextBaseFeePerGas.AsBigInt().Int64())
+	require.Len(t, second.PriorityFees, 3)
+	assert.Equal(t, int64(1), second.PriorityFees[0].Value.AsBigInt().Int64())
+	assert.Equal(t, int64(5), second.PriorityFees[1].Value.AsBigInt().Int64())
+	assert.Equal(t, int64(9), second.PriorityFees[2].Value.AsBigInt().Int64())
+
+	third := <-p.Out()
+	assert.Equal(t, int64(985), third.NextBaseFeePerGas.AsBigInt().Int64())
+	_, ok := <-p.Out()
+	assert.False(t, ok)
+
+	history, err := p.FeeHistory(5, []float64{50})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), history.OldestBlock.AsBigInt().Int64())
+	require.Len(t, history.BaseFeePerGas, 3)
+	assert.Equal(t, int64(985), history.BaseFeePerGas[2].AsBigInt().Int64())
+	assert.Equal(t, []float64{1, 0}, history.GasUsedRatio)
+	assert.Equal(t, int64(5), history.Reward[0][0].AsBigInt().Int64())
+
+	_, err = p.FeeHistory(1, []float64{60, 50})
+	assert.Error(t, err)
+}
+
+func TestFeeStatsSystemTransactions(t *testing.T) {
+	in := make(chan *types.BlockDetailed, 1)
+	params := processors.DefaultFeeParams()
+	params.Percentiles = []float64{0}
+	params.SystemTxTypes = []uint64{0x7e}
+	p := processors.NewFeeStatsProcessor(in, params, nil)
+
+	blk := feeBlock(10, 1000, 15_000_000,
+		// OP stack deposit pays nothing, it would drag the lowest percentile to zero
+		&types.Transaction{Gas: bigInt(1_000_000), Type: bigInt(0x7e)},
+		&types.Transaction{Gas: bigInt(100), GasPrice: bigInt(1003)},
+	)
+	blk.ChainID = bigIntPtr(10)
+	in <- blk
+	close(in)
+
+	stats := <-p.Out()
+	assert.Equal(t, int64(3), stats.PriorityFees[0].Value.AsBigInt().Int64())
+	assert.Equal(t, 2, stats.TxCount)
+	assert.Equal(t, int64(10), stats.ChainID.AsBigInt().Int64())
+}
+
+func TestFeeStatsFromReceipts(t *testing.T) {
+	in := make(chan *types.BlockDetailed, 1)
+	params := processors.DefaultFeeParams()
+	params.Percentiles = []float64{50}
+	// Transaction with the lowest tip uses most of the gas, while the one in the middle has the highest limit
+	p := processors.NewFeeStatsProcessor(in, params, staticReceipts{
+		{GasUsed: bigInt(400)},
+		{GasUsed: bigInt(50)},
+		{GasUsed: bigInt(50)},
+	})
+	in <- feeBlock(11, 1000, 500,
+		&types.Transaction{Gas: bigInt(400), GasPrice: bigInt(1001)},
+		&types.Transaction{Gas: bigInt(3000), GasPrice: bigInt(1005)},
+		&types.Transaction{Gas: bigInt(400), GasPrice: bigInt(1009)},
+	)
+	close(in)
+
+	stats := <-p.Out()
+	assert.True(t, stats.FromReceipts)
+	assert.Equal(t, int64(1), stats.PriorityFees[0].Value.AsBigInt().Int64())
+	history, err := p.FeeHistory(1, []float64{50, 90})
+	require.NoError(t, err)
+	assert.Equal(t, []types.BigInt{bigInt(1), bigInt(5)}, history.Reward[0])
+}
@@ -0,0 +1,258 @@
+// Package redact masks credentials, such as API keys embedded into endpoint URLs and auth headers,
+// in errors, log lines and request dumps.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces redacted values
+const Mask = "****"
+
+// DefaultSensitiveHeaders are headers which values are always masked
+var DefaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Api-Key",
+	"X-Auth-Token",
+	"X-Access-Token",
+}
+
+// sensitiveQueryParams are query parameters which values are masked in URLs
+var sensitiveQueryParams = []string{
+	"key", "apikey", "api_key", "api-key", "token", "access_token", "auth", "secret", "password", "jwt",
+}
+
+var (
+	urlPattern        = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+	authSchemePattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	queryParamPattern = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(quoteAll(sensitiveQueryParams), "|") + `)=)[^&\s"']+`)
+)
+
+// Redactor masks secrets it has been told about and anything that looks like one
+type Redactor struct {
+	lock             sync.RWMutex
+	secrets          []string
+	sensitiveHeaders map[string]struct{}
+}
+
+// New creates redactor that treats DefaultSensitiveHeaders and extraHeaders as sensitive
+func New(extraHeaders ...string) *Redactor {
+	out := &Redactor{
+		sensitiveHeaders: make(map[string]struct{}),
+	}
+	out.AddSensitiveHeaders(DefaultSensitiveHeaders...)
+	out.AddSensitiveHeaders(extraHeaders...)
+	return out
+}
+
+// AddSensitiveHeaders extends the list of headers which values are masked
+func (r *Redactor) AddSensitiveHeaders(names ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, name := range names {
+		r.sensitiveHeaders[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+}
+
+// IsSensitiveHeader reports whether header value has to be masked
+func (r *Redactor) IsSensitiveHeader(name string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok := r.sensitiveHeaders[http.CanonicalHeaderKey(name)]
+	return ok
+}
+
+// AddSecret registers a value that is masked wherever it appears
+func (r *Redactor) AddSecret(secrets ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, secret := range secrets {
+		// Very short values would mask unrelated text
+		if len(secret) < 4 {
+			continue
+		}
+		r.secrets = append(r.secrets, secret)
+	}
+	// Longer secrets go first, so that a secret containing another one is masked as a whole
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// AddURL registers secrets found in the endpoint URL: password, path segments that look like keys and sensitive query values
+func (r *Redactor) AddURL(endpoint string) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			r.AddSecret(password, url.QueryEscape(password))
+		}
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if looksLikeKey(segment) {
+			r.AddSecret(segment)
+		}
+	}
+	for name, values := range u.Query() {
+		if isSensitiveQueryParam(name) {
+			r.AddSecret(values...)
+		}
+	}
+}
+
+// AddHeaders registers values of sensitive headers as secrets
+func (r *Redactor) AddHeaders(headers http.Header) {
+	for name, values := range headers {
+		if r.IsSensitiveHeader(name) {
+			r.AddSecret(values...)
+		}
+	}
+}
+
+// String masks known secrets, credentials in URLs and auth scheme tokens
+func (r *Redactor) String(s string) string {
+	r.lock.RLock()
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	r.lock.RUnlock()
+
+	s = urlPattern.ReplaceAllStringFunc(s, URL)
+	s = authSchemePattern.ReplaceAllString(s, "$1 "+Mask)
+	return queryParamPattern.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// Header returns copy of headers with values of sensitive ones masked
+func (r *Redactor) Header(headers http.Header) http.Header {
+	out := make(http.Header, len(headers))
+	for name, values := range headers {
+		if r.IsSensitiveHeader(name) {
+			masked := make([]string, len(values))
+			for i := range masked {
+				masked[i] = Mask
+			}
+			out[name] = masked
+			continue
+		}
+		out[name] = values
+	}
+	return out
+}
+
+// Error returns error with redacted message that still unwraps to the original one, so errors.As and errors.Is keep working
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// URL masks password, key-like path segments and sensitive query values of the URL.
+// Strings that cannot be parsed are returned as is.
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" && u.Scheme != "ipc" && u.Scheme != "unix" {
+		return raw
+	}
+	changed := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Mask)
+			changed = true
+		}
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if looksLikeKey(segment) {
+			segments[i] = Mask
+			changed = true
+		}
+	}
+	if changed {
+		u.Path = strings.Join(segments, "/")
+		u.RawPath = ""
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if isSensitiveQueryParam(name) {
+				query.Set(name, Mask)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	if !changed {
+		return raw
+	}
+	// Keep mask readable instead of percent-encoded
+	return strings.ReplaceAll(u.String(), url.PathEscape(Mask), Mask)
+}
+
+// looksLikeKey reports whether URL path segment is likely an API key: long token mixing letters and digits
+func looksLikeKey(segment string) bool {
+	if len(segment) < 16 {
+		return false
+	}
+	var letters, digits bool
+	for _, c := range segment {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letters = true
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+	return letters && digits || len(segment) >= 32
+}
+
+func isSensitiveQueryParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, param := range sensitiveQueryParams {
+		if name == param {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(in []string) []string {
+	out := make([]string, len(in))
+	for i, val := range in {
+		out[i] = regexp.QuoteMeta(val)
+	}
+	return out
+}
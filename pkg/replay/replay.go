@@ -0,0 +1,305 @@
+// Package replay implements block source that reads recorded blocks from files instead of a node.
+// Files hold one JSON block per line, optionally gzip compressed, or tar archives of such files.
+package replay
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	options struct {
+		speed      float64
+		startBlock *big.Int
+		endBlock   *big.Int
+		chainID    *big.Int
+	}
+
+	Option func(opts *options)
+
+	// Source replays blocks from files, it implements blksubscriber.BlockSource
+	Source[T types.BlockType] struct {
+		paths        []string
+		ctx          context.Context
+		ctxCancel    context.CancelFunc
+		started      atomic.Bool
+		running      atomic.Bool
+		blocksChan   chan *T
+		lastError    atomic.Pointer[error]
+		currentBlock atomic.Pointer[big.Int]
+		options
+	}
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithSpeed replays blocks with delays between them scaled from their timestamps: 1 is real time, 10 is ten times faster.
+// Zero, the default, replays as fast as blocks are consumed.
+func WithSpeed(speed float64) Option {
+	return func(opts *options) {
+		opts.speed = speed
+	}
+}
+
+// WithChainID stamps blocks recorded without chain ID and fails replay on blocks of another chain
+func WithChainID(id *big.Int) Option {
+	return func(opts *options) {
+		opts.chainID = id
+	}
+}
+
+// WithStartBlock skips blocks below blkId
+func WithStartBlock(blkId *big.Int) Option {
+	return func(opts *options) {
+		opts.startBlock = blkId
+	}
+}
+
+// WithEndBlock stops after blkId
+func WithEndBlock(blkId *big.Int) Option {
+	return func(opts *options) {
+		opts.endBlock = blkId
+	}
+}
+
+// New creates source that replays files one after another
+func New[T types.BlockType](paths []string, opts ...Option) (*Source[T], error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files to replay")
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, errors.Wrap(err, "failed to open replay file")
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &Source[T]{
+		paths:      paths,
+		ctx:        ctx,
+		ctxCancel:  cancel,
+		blocksChan: make(chan *T, 1000),
+	}
+	out.options.apply(opts...)
+	if out.speed < 0 {
+		cancel()
+		return nil, errors.New("replay speed must not be negative")
+	}
+	if out.startBlock != nil {
+		out.currentBlock.Store(new(big.Int).Set(out.startBlock))
+	}
+	return out, nil
+}
+
+func (s *Source[T]) Start() error {
+	if !s.started.CompareAndSwap(false, true) {
+		return errors.New("replay has already been started")
+	}
+	s.running.Store(true)
+	go s.body()
+	return nil
+}
+
+func (s *Source[T]) Stop() {
+	s.ctxCancel()
+}
+
+func (s *Source[T]) GetBlockChan() <-chan *T {
+	return s.blocksChan
+}
+
+func (s *Source[T]) LastError() error {
+	if err := s.lastError.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (s *Source[T]) GetCurrentBlock() big.Int {
+	val := s.currentBlock.Load()
+	if val == nil {
+		return big.Int{}
+	}
+	return *val
+}
+
+func (s *Source[T]) IsRunning() bool {
+	return s.running.Load()
+}
+
+func (s *Source[T]) body() {
+	defer func() {
+		close(s.blocksChan)
+		s.running.Store(false)
+	}()
+	var prevTimestamp *big.Int
+	var chainErr error
+	emit := func(blk *T) bool {
+		base := blockBase(blk)
+		number := base.Number.AsBigInt()
+		if s.chainID != nil {
+			if base.ChainID == nil {
+				base.ChainID = (*types.BigInt)(new(big.Int).Set(s.chainID))
+			} else if base.ChainID.AsBigInt().Cmp(s.chainID) != 0 {
+				chainErr = errors.Errorf("block %s belongs to chain %s, expected chain %s", number, base.ChainID.AsBigInt(), s.chainID)
+				return false
+			}
+		}
+		if s.startBlock != nil && number.Cmp(s.startBlock) < 0 {
+			return true
+		}
+		if s.endBlock != nil && s.endBlock.Sign() != 0 && number.Cmp(s.endBlock) > 0 {
+			return false
+		}
+		timestamp := base.Timestamp.AsBigInt()
+		if s.speed > 0 && prevTimestamp != nil && timestamp.Cmp(prevTimestamp) > 0 {
+			gap := new(big.Int).Sub(timestamp, prevTimestamp).Int64()
+			if !s.sleep(time.Duration(float64(gap) * float64(time.Second) / s.speed)) {
+				return false
+			}
+		}
+		prevTimestamp = timestamp
+		select {
+		case s.blocksChan <- blk:
+		case <-s.ctx.Done():
+			return false
+		}
+		s.currentBlock.Store(new(big.Int).Add(number, big.NewInt(1)))
+		return true
+	}
+	for _, path := range s.paths {
+		cont, err := replayFile(path, emit)
+		if err == nil {
+			err = chainErr
+		}
+		if err != nil {
+			s.lastError.Store(toPtr(errors.Wrapf(err, "failed to replay %s", path)))
+			return
+		}
+		if !cont {
+			return
+		}
+	}
+}
+
+func (s *Source[T]) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// replayFile feeds every block of the file into emit until it returns false
+func replayFile[T types.BlockType](path string, emit func(*T) bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return replayStream(f, emit)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is position of "ustar" magic in tar header
+const tarMagicOffset = 257
+
+// replayStream detects gzip and tar by their magic bytes, so file names do not matter
+func replayStream[T types.BlockType](r io.Reader, emit func(*T) bool) (bool, error) {
+	buffered := bufio.NewReaderSize(r, 64*1024)
+	if head, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to read gzip")
+		}
+		defer gz.Close()
+		return replayStream(gz, emit)
+	}
+	if head, _ := buffered.Peek(tarMagicOffset + len(tarMagic)); len(head) == tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(head[tarMagicOffset:], tarMagic) {
+		return replayTar(tar.NewReader(buffered), emit)
+	}
+	return replayJSONL(buffered, emit)
+}
+
+func replayTar[T types.BlockType](archive *tar.Reader, emit func(*T) bool) (bool, error) {
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "failed to read tar")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		cont, err := replayStream(archive, emit)
+		if err != nil {
+			return false, errors.Wrap(err, hdr.Name)
+		}
+		if !cont {
+			return false, nil
+		}
+	}
+}
+
+func replayJSONL[T types.BlockType](r io.Reader, emit func(*T) bool) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	// Blocks with all transactions easily exceed default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		blk := new(T)
+		if err := json.Unmarshal(line, blk); err != nil {
+			return false, errors.Wrapf(err, "line %d", lineNum)
+		}
+		if !emit(blk) {
+			return false, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, errors.Wrap(err, "failed to read blocks")
+	}
+	return true, nil
+}
+
+func blockBase[T types.BlockType](blk *T) *types.BlockBase {
+	switch val := any(blk).(type) {
+	case *types.Block:
+		return &val.BlockBase
+	case *types.BlockDetailed:
+		return &val.BlockBase
+	default:
+		panic("unexpected block type")
+	}
+}
+
+func toPtr[T any](in T) *T {
+	return &in
+}
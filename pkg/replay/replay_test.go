@@ -0,0 +1,213 @@
+package replay_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/replay"
+	"github.com/dkropachev/ethscan/pkg/subscriber"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const wallet = "0xc940323bdacd868c319e9039ea5fddd35745e62d"
+
+// blocksJSONL renders blocks from..to, one second apart, every block has a single transfer to wallet
+func blocksJSONL(from, to int) string {
+	var out strings.Builder
+	for num := from; num <= to; num++ {
+		fmt.Fprintf(&out, `{"number":"0x%x","timestamp":"0x%x","baseFeePerGas":"0x1","transactions":[`+
+			`{"hash":"0x%064x","blockNumber":"0x%x","from":"0x0000000000000000000000000000000000000001","to":"%s","value":"0x1","gas":"0x5208","gasPrice":"0x2"}]}`+"\n",
+			num, 1700000000+num, num, num, wallet)
+	}
+	return out.String()
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buff bytes.Buffer
+	gz := gzip.NewWriter(&buff)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buff.Bytes()
+}
+
+func tarData(t *testing.T, files map[string][]byte, names ...string) []byte {
+	var buff bytes.Buffer
+	archive := tar.NewWriter(&buff)
+	for _, name := range names {
+		require.NoError(t, archive.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(files[name])), Typeflag: tar.TypeReg}))
+		_, err := archive.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	return buff.Bytes()
+}
+
+// writeRecordings writes blocks 1..9 as plain, gzip and tar.gz of gzip and plain members, file names carry no hints
+func writeRecordings(t *testing.T) []string {
+	dir := t.TempDir()
+	members := map[string][]byte{
+		"7-8.jsonl.gz": gzipData(t, blocksJSONL(7, 8)),
+		"9.jsonl":      []byte(blocksJSONL(9, 9)),
+	}
+	files := [][]byte{
+		[]byte(blocksJSONL(1, 3)),
+		gzipData(t, blocksJSONL(4, 6)),
+		gzipData(t, string(tarData(t, members, "7-8.jsonl.gz", "9.jsonl"))),
+	}
+	out := make([]string, len(files))
+	for i, data := range files {
+		out[i] = filepath.Join(dir, fmt.Sprintf("part-%d", i))
+		require.NoError(t, os.WriteFile(out[i], data, 0o600))
+	}
+	return out
+}
+
+func collect[T types.BlockType](t *testing.T, source *replay.Source[T]) []int64 {
+	var out []int64
+	for blk := range source.GetBlockChan() {
+		switch val := any(blk).(type) {
+		case *types.Block:
+			out = append(out, val.Number.AsBigInt().Int64())
+		case *types.BlockDetailed:
+			out = append(out, val.Number.AsBigInt().Int64())
+		}
+	}
+	require.NoError(t, source.LastError())
+	return out
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+	files := writeRecordings(t)
+
+	source, err := replay.New[types.BlockDetailed](files)
+	require.NoError(t, err)
+	require.NoError(t, source.Start())
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}, collect(t, source))
+	assert.False(t, source.IsRunning())
+	current := source.GetCurrentBlock()
+	assert.Equal(t, int64(10), current.Int64())
+	assert.Error(t, source.Start())
+
+	ranged, err := replay.New[types.BlockDetailed](files, replay.WithStartBlock(big.NewInt(3)), replay.WithEndBlock(big.NewInt(7)))
+	require.NoError(t, err)
+	require.NoError(t, ranged.Start())
+	assert.Equal(t, []int64{3, 4, 5, 6, 7}, collect(t, ranged))
+
+	_, err = replay.New[types.Block]([]string{filepath.Join(t.TempDir(), "missing.jsonl")})
+	assert.Error(t, err)
+}
+
+func TestReplaySpeed(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "blocks.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(blocksJSONL(1, 3)), 0o600))
+
+	// Blocks are one second apart, twenty times faster replay takes 100ms for three of them
+	source, err := replay.New[types.BlockDetailed]([]string{path}, replay.WithSpeed(20))
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, source.Start())
+	assert.Len(t, collect(t, source), 3)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	slow, err := replay.New[types.BlockDetailed]([]string{path}, replay.WithSpeed(0.001))
+	require.NoError(t, err)
+	require.NoError(t, slow.Start())
+	<-slow.GetBlockChan()
+	slow.Stop()
+	assert.Eventually(t, func() bool { return !slow.IsRunning() }, time.Second, 10*time.Millisecond)
+}
+
+func TestReplayBadLine(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "blocks.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(blocksJSONL(1, 1)+"{not json\n"), 0o600))
+
+	source, err := replay.New[types.BlockDetailed]([]string{path})
+	require.NoError(t, err)
+	require.NoError(t, source.Start())
+	for range source.GetBlockChan() {
+	}
+	assert.ErrorContains(t, source.LastError(), "line 2")
+}
+
+func TestChanSubscriberFromReplay(t *testing.T) {
+	t.Parallel()
+	source, err := replay.New[types.BlockDetailed](writeRecordings(t), replay.WithStartBlock(big.NewInt(5)))
+	require.NoError(t, err)
+
+	sub := subscriber.NewChanSubscriberFromSource(source)
+	sub.Subscribe(wallet)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	var blocks []int64
+	for tx := range sub.GetTransactionChan() {
+		blocks = append(blocks, tx.BlockNumber.AsBigInt().Int64())
+	}
+	assert.Equal(t, []int64{5, 6, 7, 8, 9}, blocks)
+	assert.NoError(t, source.LastError())
+	_, limited := sub.RateLimitStats()
+	assert.False(t, limited)
+}
+
+func TestReplayPrintedBlocks(t *testing.T) {
+	t.Parallel()
+	// Blocks printed by ethscan --target block-detailed have decimal numbers instead of hex quantities
+	var blk types.BlockDetailed
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(blocksJSONL(42, 42))), &blk))
+	printed, err := json.Marshal(blk)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "printed.jsonl")
+	require.NoError(t, os.WriteFile(path, append(printed, '\n'), 0o600))
+
+	source, err := replay.New[types.BlockDetailed]([]string{path})
+	require.NoError(t, err)
+	require.NoError(t, source.Start())
+	replayed := <-source.GetBlockChan()
+	require.NotNil(t, replayed, source.LastError())
+	assert.Equal(t, int64(42), replayed.Number.AsBigInt().Int64())
+	require.Len(t, replayed.Transactions, 1)
+	assert.True(t, blk.Transactions[0].Equal(replayed.Transactions[0]))
+}
+
+func TestReplayChainID(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "blocks.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(blocksJSONL(1, 2)), 0o600))
+
+	source, err := replay.New[types.BlockDetailed]([]string{path}, replay.WithChainID(big.NewInt(10)))
+	require.NoError(t, err)
+	require.NoError(t, source.Start())
+	blk := <-source.GetBlockChan()
+	require.NotNil(t, blk, source.LastError())
+	assert.Equal(t, int64(10), blk.ChainID.AsBigInt().Int64())
+	collect(t, source)
+
+	// Blocks printed with chain ID keep it and are checked against the expected one
+	blk.Number = types.BigInt(*big.NewInt(3))
+	printed, err := json.Marshal(blk)
+	require.NoError(t, err)
+	stamped := filepath.Join(t.TempDir(), "stamped.jsonl")
+	require.NoError(t, os.WriteFile(stamped, append(printed, '\n'), 0o600))
+
+	mismatch, err := replay.New[types.BlockDetailed]([]string{stamped}, replay.WithChainID(big.NewInt(1)))
+	require.NoError(t, err)
+	require.NoError(t, mismatch.Start())
+	for range mismatch.GetBlockChan() {
+	}
+	assert.ErrorContains(t, mismatch.LastError(), "block 3 belongs to chain 10, expected chain 1")
+}
@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderFunc returns headers to send with a request, it is called for every HTTP request and websocket handshake
+type HeaderFunc func() (http.Header, error)
+
+// WithHeader adds header to every request, can be used multiple times
+func WithHeader(name, value string) Option {
+	return func(opts *options) {
+		if opts.headers == nil {
+			opts.headers = http.Header{}
+		}
+		opts.headers.Add(name, value)
+	}
+}
+
+// WithBasicAuth authenticates requests with HTTP basic auth.
+// Credentials embedded into endpoint URL are used the same way when this option is not set.
+func WithBasicAuth(username, password string) Option {
+	return func(opts *options) {
+		opts.basicAuth = &basicAuth{username: username, password: password}
+	}
+}
+
+// WithJWTSecret authenticates requests with HS256 JWT bearer token signed with the secret,
+// the way execution clients protect Engine API. Token is issued for every request.
+func WithJWTSecret(secret []byte) Option {
+	return func(opts *options) {
+		opts.jwtSecret = secret
+	}
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (o *options) headerFunc() HeaderFunc {
+	if len(o.headers) == 0 && o.basicAuth == nil && o.jwtSecret == nil {
+		return nil
+	}
+	headers := o.headers.Clone()
+	basic := o.basicAuth
+	secret := o.jwtSecret
+	return func() (http.Header, error) {
+		out := headers.Clone()
+		if out == nil {
+			out = http.Header{}
+		}
+		if basic != nil {
+			out.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basic.username+":"+basic.password)))
+		}
+		if secret != nil {
+			token, err := NewJWT(secret, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			out.Set("Authorization", "Bearer "+token)
+		}
+		return out, nil
+	}
+}
+
+// NewJWT issues HS256 token with "iat" claim as required by Engine API authentication
+func NewJWT(secret []byte, issuedAt time.Time) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("jwt secret is empty")
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(`{"iat":`+strconv.FormatInt(issuedAt.Unix(), 10)+`}`))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// LoadJWTSecret reads hex encoded 32 byte secret, the format of geth and reth jwt.hex files
+func LoadJWTSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read jwt secret file %s", path)
+	}
+	val := strings.TrimPrefix(strings.TrimSpace(string(data)), "0x")
+	secret, err := hex.DecodeString(val)
+	if err != nil {
+		return nil, errors.Wrapf(err, "jwt secret file %s is not hex encoded", path)
+	}
+	if len(secret) != 32 {
+		return nil, errors.Errorf("jwt secret in %s has %d bytes, expected 32", path, len(secret))
+	}
+	return secret, nil
+}
+
+// ParseHeader parses curl-style "Name: value" header
+func ParseHeader(header string) (name, value string, err error) {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", errors.Errorf("invalid header %q, expected \"Name: value\"", header)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// LoadHeaderFile reads curl-style headers, one per line, skipping empty lines and # comments
+func LoadHeaderFile(path string) (http.Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open header file %s", path)
+	}
+	defer f.Close()
+
+	out := http.Header{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, err := ParseHeader(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", path, lineNum)
+		}
+		out.Add(name, value)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read header file %s", path)
+	}
+	return out, nil
+}
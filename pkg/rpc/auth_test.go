@@ -0,0 +1,90 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaders(t *testing.T) {
+	t.Parallel()
+	requests := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	t.Run("HeadersAndURLBasicAuth", func(t *testing.T) {
+		client, err := rpc.Dial(context.Background(), strings.Replace(srv.URL, "http://", "http://user:secret@", 1),
+			rpc.WithHeader("X-Api-Key", "key"),
+			rpc.WithHeader("X-Extra", "1"),
+			rpc.WithHeader("X-Extra", "2"),
+		)
+		require.NoError(t, err)
+		_, err = client.ChainID(context.Background())
+		require.NoError(t, err)
+
+		req := <-requests
+		assert.Equal(t, "key", req.Header.Get("X-Api-Key"))
+		assert.Equal(t, []string{"1", "2"}, req.Header.Values("X-Extra"))
+		user, password, ok := req.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "secret", password)
+	})
+
+	t.Run("JWT", func(t *testing.T) {
+		secretFile := filepath.Join(t.TempDir(), "jwt.hex")
+		require.NoError(t, os.WriteFile(secretFile, []byte("0x"+strings.Repeat("ab", 32)+"\n"), 0o600))
+		secret, err := rpc.LoadJWTSecret(secretFile)
+		require.NoError(t, err)
+
+		client, err := rpc.Dial(context.Background(), srv.URL, rpc.WithJWTSecret(secret))
+		require.NoError(t, err)
+		_, err = client.ChainID(context.Background())
+		require.NoError(t, err)
+
+		req := <-requests
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		require.True(t, ok)
+		parts := strings.Split(token, ".")
+		require.Len(t, parts, 3)
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+
+		claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		var parsed struct {
+			IssuedAt int64 `json:"iat"`
+		}
+		require.NoError(t, json.Unmarshal(claims, &parsed))
+		assert.InDelta(t, time.Now().Unix(), parsed.IssuedAt, 5)
+	})
+
+	t.Run("HeaderFile", func(t *testing.T) {
+		headerFile := filepath.Join(t.TempDir(), "headers")
+		require.NoError(t, os.WriteFile(headerFile, []byte("# provider key\nX-Api-Key: key\n\nAuthorization: Bearer token\n"), 0o600))
+		headers, err := rpc.LoadHeaderFile(headerFile)
+		require.NoError(t, err)
+		assert.Equal(t, "key", headers.Get("X-Api-Key"))
+		assert.Equal(t, "Bearer token", headers.Get("Authorization"))
+
+		_, _, err = rpc.ParseHeader("no separator")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,401 @@
+// Package rpc implements Ethereum JSON-RPC client with pluggable HTTP, WebSocket and IPC transports.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const jsonrpcVersion = "2.0"
+
+// Message is JSON-RPC request, response or notification envelope
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ErrorObject    `json:"error,omitempty"`
+
+	// httpStatus is set by HTTP transport on responses
+	httpStatus int
+}
+
+func (m *Message) isNotification() bool {
+	return len(m.ID) == 0 && m.Method != ""
+}
+
+// idKey normalizes message id so that 1 and "1" match
+func (m *Message) idKey() string {
+	id := strings.TrimSpace(string(m.ID))
+	if unquoted, err := strconv.Unquote(id); err == nil {
+		return unquoted
+	}
+	return id
+}
+
+// Transport delivers JSON-RPC messages to the endpoint
+type Transport interface {
+	// Send sends requests either one by one or as a single batch when batch is set,
+	// and returns responses in arbitrary order.
+	Send(ctx context.Context, requests []*Message, batch bool) ([]*Message, error)
+	Close() error
+}
+
+// SubscriptionTransport is a Transport that can receive server push notifications
+type SubscriptionTransport interface {
+	Transport
+	// Notifications registers handler for notifications of the subscription,
+	// returned function unregisters it. Handler must not block reading of the connection,
+	// when its consumer lags behind the oldest notifications are dropped.
+	Notifications(subID string) (<-chan json.RawMessage, func())
+	// Dropped returns the number of notifications of the subscription dropped so far
+	Dropped(subID string) uint64
+	// Done is closed when the underlying connection is lost
+	Done() <-chan struct{}
+	// Err returns the reason the connection has been lost
+	Err() error
+}
+
+type (
+	options struct {
+		client    httpClient
+		headers   http.Header
+		basicAuth *basicAuth
+		jwtSecret []byte
+		network   NetworkConfig
+		limiter   *Limiter
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// WithHTTPClient sets client used by HTTP transport
+func WithHTTPClient(cl httpClient) Option {
+	return func(opts *options) {
+		opts.client = cl
+	}
+}
+
+// Client issues JSON-RPC calls over a Transport
+type Client struct {
+	transport Transport
+	nextID    atomic.Uint64
+	// timeout limits every call when it is set
+	timeout time.Duration
+	limiter *Limiter
+}
+
+// WithRateLimiter makes every call wait for compute units budget of the limiter
+func WithRateLimiter(l *Limiter) Option {
+	return func(opts *options) {
+		opts.limiter = l
+	}
+}
+
+// NewClient creates client on top of the transport
+func NewClient(transport Transport) *Client {
+	return &Client{transport: transport}
+}
+
+// Dial creates client for the endpoint, picking transport by URL scheme:
+// http:// and https:// use HTTP, ws:// and wss:// use WebSocket,
+// ipc:// and bare file system paths use IPC.
+func Dial(ctx context.Context, endpoint string, opts ...Option) (*Client, error) {
+	o := options{
+		client: http.DefaultClient,
+	}
+	o.apply(opts...)
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse endpoint URL")
+	}
+
+	if u.User != nil {
+		// Credentials are sent as a header, so that they do not end up in request URL and errors
+		if o.basicAuth == nil {
+			password, _ := u.User.Password()
+			o.basicAuth = &basicAuth{username: u.User.Username(), password: password}
+		}
+		u.User = nil
+		endpoint = u.String()
+	}
+	headers := o.headerFunc()
+
+	if o.network.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.network.Timeout)
+		defer cancel()
+	}
+
+	var transport Transport
+	switch u.Scheme {
+	case "http", "https":
+		client := o.client
+		if client == http.DefaultClient && !o.network.IsZero() {
+			if client, err = o.network.HTTPClient(); err != nil {
+				return nil, err
+			}
+		}
+		transport = NewHTTPTransport(endpoint, client, headers)
+	case "ws", "wss":
+		var dialer *websocket.Dialer
+		if dialer, err = o.network.WebSocketDialer(); err != nil {
+			return nil, err
+		}
+		if headers != nil {
+			if dialer.Header, err = headers(); err != nil {
+				return nil, errors.Wrap(err, "failed to build handshake headers")
+			}
+		}
+		transport, err = DialWebSocket(ctx, endpoint, dialer)
+	case "ipc", "unix", "":
+		transport, err = DialIPC(ctx, IPCPath(u))
+	default:
+		return nil, errors.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := NewClient(transport)
+	out.timeout = o.network.Timeout
+	out.limiter = o.limiter
+	return out, nil
+}
+
+// IPCPath extracts socket path from ipc:// URL or a bare path
+func IPCPath(u *url.URL) string {
+	if u.Scheme == "" {
+		return u.Path
+	}
+	return u.Host + u.Path
+}
+
+// Transport returns underlying transport
+func (c *Client) Transport() Transport {
+	return c.transport
+}
+
+// Close closes underlying transport
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
+
+func (c *Client) newRequest(method string, params []any) (*Message, error) {
+	if params == nil {
+		params = []any{}
+	}
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal %s params", method)
+	}
+	return &Message{
+		JSONRPC: jsonrpcVersion,
+		ID:      strconv.AppendUint(nil, c.nextID.Add(1), 10),
+		Method:  method,
+		Params:  rawParams,
+	}, nil
+}
+
+// Call invokes method with params and unmarshals its result into result, which can be nil to ignore it
+func (c *Client) Call(ctx context.Context, method string, params []any, result any) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	req, err := c.newRequest(method, params)
+	if err != nil {
+		return err
+	}
+	if c.limiter != nil {
+		if err = c.limiter.Wait(ctx, method); err != nil {
+			return err
+		}
+	}
+	responses, err := c.transport.Send(ctx, []*Message{req}, false)
+	if err != nil {
+		return c.checkThrottled(err)
+	}
+	for _, resp := range responses {
+		if resp.idKey() == req.idKey() {
+			return c.checkThrottled(decodeResult(method, resp, result))
+		}
+	}
+	return errors.Errorf("%s: no response received", method)
+}
+
+// BatchElem is a single call within a batch
+type BatchElem struct {
+	Method string
+	Params []any
+	// Result is a pointer the result is unmarshalled into, can be nil
+	Result any
+	// Error is set when this particular call failed
+	Error error
+}
+
+// BatchCall sends all elements as a single batch request.
+// Returned error is only set when the batch as a whole failed, failures of individual calls are stored in BatchElem.Error.
+func (c *Client) BatchCall(ctx context.Context, batch []BatchElem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	requests := make([]*Message, len(batch))
+	byID := make(map[string]int, len(batch))
+	for i := range batch {
+		req, err := c.newRequest(batch[i].Method, batch[i].Params)
+		if err != nil {
+			return err
+		}
+		requests[i] = req
+		byID[req.idKey()] = i
+	}
+
+	if c.limiter != nil {
+		methods := make([]string, len(batch))
+		for i := range batch {
+			methods[i] = batch[i].Method
+		}
+		if err := c.limiter.Wait(ctx, methods...); err != nil {
+			return err
+		}
+	}
+
+	responses, err := c.transport.Send(ctx, requests, true)
+	if err != nil {
+		return c.checkThrottled(err)
+	}
+
+	received := make([]bool, len(batch))
+	for _, resp := range responses {
+		i, ok := byID[resp.idKey()]
+		if !ok {
+			continue
+		}
+		received[i] = true
+		batch[i].Error = c.checkThrottled(decodeResult(batch[i].Method, resp, batch[i].Result))
+	}
+	for i, ok := range received {
+		if !ok {
+			batch[i].Error = errors.Errorf("%s: no response received", batch[i].Method)
+		}
+	}
+	return nil
+}
+
+// checkThrottled tells limiter to back off when provider rejected the call as rate limited
+func (c *Client) checkThrottled(err error) error {
+	if c.limiter != nil && IsRateLimited(err) {
+		c.limiter.Throttled()
+	}
+	return err
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func decodeResult(method string, resp *Message, result any) error {
+	if resp.Error != nil {
+		return resp.Error.toError(method, resp.httpStatus)
+	}
+	if result == nil {
+		return nil
+	}
+	if len(resp.Result) == 0 {
+		return errors.Errorf("%s: response has neither result nor error", method)
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return errors.Wrapf(err, "%s: failed to unmarshal result %q", method, truncate(resp.Result, 1024))
+	}
+	return nil
+}
+
+func truncate(data []byte, limit int) string {
+	if len(data) > limit {
+		return string(data[:limit])
+	}
+	return string(data)
+}
+
+// Subscription is an active eth_subscribe subscription
+type Subscription struct {
+	ID            string
+	client        *Client
+	notifications <-chan json.RawMessage
+	unregister    func()
+	done          <-chan struct{}
+	transport     SubscriptionTransport
+}
+
+// Subscribe calls eth_subscribe with params, e.g. "newHeads", and returns subscription delivering its notifications.
+// Transport has to implement SubscriptionTransport.
+func (c *Client) Subscribe(ctx context.Context, params ...any) (*Subscription, error) {
+	transport, ok := c.transport.(SubscriptionTransport)
+	if !ok {
+		return nil, errors.New("transport does not support subscriptions")
+	}
+	var subID string
+	if err := c.Call(ctx, "eth_subscribe", params, &subID); err != nil {
+		return nil, err
+	}
+	notifications, unregister := transport.Notifications(subID)
+	return &Subscription{
+		ID:            subID,
+		client:        c,
+		notifications: notifications,
+		unregister:    unregister,
+		done:          transport.Done(),
+		transport:     transport,
+	}, nil
+}
+
+// Notifications delivers raw "result" member of every notification. A consumer lagging behind by more than
+// the buffer loses the oldest ones, e.g. new heads keep the latest block number.
+func (s *Subscription) Notifications() <-chan json.RawMessage {
+	return s.notifications
+}
+
+// Dropped returns the number of notifications dropped because the consumer lagged behind
+func (s *Subscription) Dropped() uint64 {
+	return s.transport.Dropped(s.ID)
+}
+
+// Done is closed when the connection carrying the subscription is lost
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription has stopped
+func (s *Subscription) Err() error {
+	return s.transport.Err()
+}
+
+// Unsubscribe cancels the subscription on the server and stops delivering notifications
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.unregister()
+	return s.client.Call(ctx, "eth_unsubscribe", []any{s.ID}, nil)
+}
@@ -0,0 +1,368 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handle answers a single request the way a node would
+func handle(req *rpc.Message) *rpc.Message {
+	resp := &rpc.Message{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "eth_blockNumber":
+		resp.Result = json.RawMessage(`"0x10"`)
+	case "eth_chainId":
+		resp.Result = json.RawMessage(`"0x1"`)
+	case "eth_getBalance":
+		resp.Result = json.RawMessage(`"0xde0b6b3a7640000"`)
+	case "eth_getBlockByNumber":
+		var params []any
+		_ = json.Unmarshal(req.Params, &params)
+		if params[0] == "0x11" {
+			resp.Result = json.RawMessage(`null`)
+		} else {
+			resp.Result = json.RawMessage(`{"number":"0x10","hash":"0x0000000000000000000000000000000000000000000000000000000000000010","transactions":[]}`)
+		}
+	case "eth_call":
+		resp.Result = json.RawMessage(`"0x0102"`)
+	case "eth_subscribe":
+		resp.Result = json.RawMessage(`"0xabc"`)
+	default:
+		resp.Error = &rpc.ErrorObject{Code: rpc.CodeMethodNotFound, Message: "method not found"}
+	}
+	return resp
+}
+
+// handlePayload answers a request or a batch of them. It runs on server goroutines, so it does not stop the test
+// on malformed payload, it marks the test failed and returns nil.
+func handlePayload(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var batch []*rpc.Message
+	if json.Unmarshal(data, &batch) == nil {
+		out := make([]*rpc.Message, len(batch))
+		for i, req := range batch {
+			out[len(batch)-1-i] = handle(req)
+		}
+		resp, err := json.Marshal(out)
+		assert.NoError(t, err)
+		return resp
+	}
+	var req rpc.Message
+	if !assert.NoError(t, json.Unmarshal(data, &req)) {
+		return nil
+	}
+	resp, err := json.Marshal(handle(&req))
+	assert.NoError(t, err)
+	return resp
+}
+
+// servePayload answers HTTP request with handlePayload, malformed one gets 400
+func servePayload(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	t.Helper()
+	data, err := io.ReadAll(r.Body)
+	var resp []byte
+	if assert.NoError(t, err) {
+		resp = handlePayload(t, data)
+	}
+	if resp == nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+	_, _ = w.Write(resp)
+}
+
+func testClient(t *testing.T, client *rpc.Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	num, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), num.Int64())
+
+	balance, err := client.GetBalance(ctx, types.EthAddress{1}, rpc.Latest)
+	require.NoError(t, err)
+	assert.Equal(t, "1000000000000000000", balance.String())
+
+	block, err := rpc.GetBlockByNumber[types.Block](ctx, client, rpc.NumberRef(num))
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), block.Number.AsBigInt().Int64())
+
+	_, err = rpc.GetBlockByNumber[types.Block](ctx, client, "0x11")
+	assert.True(t, rpc.IsNotFound(err), err)
+
+	out, err := client.EthCall(ctx, rpc.CallMsg{To: &types.EthAddress{2}, Data: []byte{1}}, rpc.Latest)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, out)
+
+	var chainID, unknown string
+	batch := []rpc.BatchElem{
+		{Method: "eth_chainId", Result: &chainID},
+		{Method: "eth_unknown", Result: &unknown},
+	}
+	require.NoError(t, client.BatchCall(ctx, batch))
+	assert.NoError(t, batch[0].Error)
+	assert.Equal(t, "0x1", chainID)
+	assert.True(t, rpc.IsMethodNotSupported(batch[1].Error), batch[1].Error)
+}
+
+func TestHTTPClient(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePayload(t, w, r)
+	}))
+	defer srv.Close()
+
+	client, err := rpc.Dial(context.Background(), srv.URL)
+	require.NoError(t, err)
+	defer client.Close()
+	testClient(t, client)
+
+	_, err = client.Subscribe(context.Background(), "newHeads")
+	assert.Error(t, err)
+}
+
+// serveStream answers requests and sends a notification right after eth_subscribe response
+func serveStream(t *testing.T, read func() ([]byte, error), write func([]byte) error) {
+	t.Helper()
+	for {
+		data, err := read()
+		if err != nil {
+			return
+		}
+		resp := handlePayload(t, data)
+		if resp == nil {
+			return
+		}
+		if err = write(resp); err != nil {
+			return
+		}
+		var req rpc.Message
+		if json.Unmarshal(data, &req) == nil && req.Method == "eth_subscribe" {
+			_ = write([]byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xabc","result":{"number":"0x11"}}}`))
+		}
+	}
+}
+
+func testSubscription(t *testing.T, client *rpc.Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := client.Subscribe(ctx, "newHeads")
+	require.NoError(t, err)
+	select {
+	case head := <-sub.Notifications():
+		assert.JSONEq(t, `{"number":"0x11"}`, string(head))
+	case <-ctx.Done():
+		t.Fatal("no notification received")
+	}
+}
+
+func TestWebSocketClient(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveStream(t, conn.ReadMessage, conn.WriteMessage)
+	}))
+	defer srv.Close()
+
+	client, err := rpc.Dial(context.Background(), "ws"+srv.URL[len("http"):])
+	require.NoError(t, err)
+	defer client.Close()
+	testClient(t, client)
+	testSubscription(t, client)
+}
+
+func TestIPCClient(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "node.ipc")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		decoder := json.NewDecoder(conn)
+		serveStream(t, func() ([]byte, error) {
+			var msg json.RawMessage
+			err := decoder.Decode(&msg)
+			return msg, err
+		}, func(data []byte) error {
+			_, err := conn.Write(data)
+			return err
+		})
+	}()
+
+	client, err := rpc.Dial(context.Background(), "ipc://"+path)
+	require.NoError(t, err)
+	defer client.Close()
+	testClient(t, client)
+	testSubscription(t, client)
+}
+
+// TestSubscriptionSlowConsumer checks that notifications piling up for a consumer that does not read them
+// do not stall responses coming over the same connection
+func TestSubscriptionSlowConsumer(t *testing.T) {
+	t.Parallel()
+	const sent = 1500
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			resp := handlePayload(t, data)
+			if resp == nil {
+				return
+			}
+			if err = conn.WriteMessage(resp); err != nil {
+				return
+			}
+			var req rpc.Message
+			if json.Unmarshal(data, &req) != nil || req.Method != "eth_subscribe" {
+				continue
+			}
+			for i := 1; i <= sent; i++ {
+				msg := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xabc","result":{"number":"0x%x"}}}`, i)
+				if err = conn.WriteMessage([]byte(msg)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client, err := rpc.Dial(context.Background(), "ws"+srv.URL[len("http"):])
+	require.NoError(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := client.Subscribe(ctx, "newHeads")
+	require.NoError(t, err)
+
+	// Response follows every notification on the connection, so they all have been read once it arrives
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(sent-1000), sub.Dropped())
+
+	var heads []string
+	for len(heads) < 1000 {
+		select {
+		case head := <-sub.Notifications():
+			heads = append(heads, string(head))
+		case <-ctx.Done():
+			t.Fatalf("got %d notifications out of 1000", len(heads))
+		}
+	}
+	assert.JSONEq(t, fmt.Sprintf(`{"number":"0x%x"}`, sent-999), heads[0])
+	assert.JSONEq(t, fmt.Sprintf(`{"number":"0x%x"}`, sent), heads[len(heads)-1])
+}
+
+// TestStreamMisbehavingNode checks that late notifications of a cancelled subscription and duplicate responses
+// neither leak into later subscriptions nor stall the connection
+func TestStreamMisbehavingNode(t *testing.T) {
+	t.Parallel()
+	notification := func(number int) []byte {
+		return []byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xabc","result":{"number":"0x%x"}}}`, number))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpc.Message
+			if !assert.NoError(t, json.Unmarshal(data, &req)) {
+				return
+			}
+			resp := handlePayload(t, data)
+			var before [][]byte
+			copies := 1
+			switch req.Method {
+			case "eth_unsubscribe":
+				// Node keeps sending a few notifications before it handles the request
+				for i := range 5 {
+					before = append(before, notification(0xdead+i))
+				}
+				resp = []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":true}`, req.ID))
+			case "eth_blockNumber":
+				before = append(before, notification(0x12))
+			case "eth_chainId":
+				copies = 3
+			}
+			for _, msg := range before {
+				if conn.WriteMessage(msg) != nil {
+					return
+				}
+			}
+			for range copies {
+				if conn.WriteMessage(resp) != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client, err := rpc.Dial(context.Background(), "ws"+srv.URL[len("http"):])
+	require.NoError(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := client.Subscribe(ctx, "newHeads")
+	require.NoError(t, err)
+	require.NoError(t, sub.Unsubscribe(ctx))
+	// Node reuses the ID, late notifications of the first subscription must not show up in the second one
+	sub, err = client.Subscribe(ctx, "newHeads")
+	require.NoError(t, err)
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	select {
+	case head := <-sub.Notifications():
+		assert.JSONEq(t, `{"number":"0x12"}`, string(head))
+	case <-ctx.Done():
+		t.Fatal("no notification received")
+	}
+
+	for range 3 {
+		var chainID string
+		require.NoError(t, client.Call(ctx, "eth_chainId", nil, &chainID))
+		assert.Equal(t, "0x1", chainID)
+	}
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+}
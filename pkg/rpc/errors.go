@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSON-RPC error codes, see https://www.jsonrpc.org/specification#error_object and EIP-1474
+const (
+	CodeParseError          = -32700
+	CodeInvalidRequest      = -32600
+	CodeMethodNotFound      = -32601
+	CodeInvalidParams       = -32602
+	CodeInternalError       = -32603
+	CodeInvalidInput        = -32000
+	CodeResourceNotFound    = -32001
+	CodeResourceUnavailable = -32002
+	CodeTransactionRejected = -32003
+	CodeMethodNotSupported  = -32004
+	CodeLimitExceeded       = -32005
+)
+
+// Error is a failure reported by the endpoint,
+// either as a JSON-RPC error object or as a non-2xx HTTP status, or both.
+type Error struct {
+	// Method is the JSON-RPC method that has been called
+	Method string
+	// Code and Message come from the JSON-RPC error object, they are empty if there was none
+	Code    int
+	Message string
+	// Data is the raw "data" member of the JSON-RPC error object
+	Data json.RawMessage
+	// HTTPStatus is the HTTP status code of the response, zero for non-HTTP transports
+	HTTPStatus int
+	// Body is the beginning of the response body, set when it could not be parsed as JSON-RPC error
+	Body string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Method)
+	b.WriteString(": ")
+	if e.Code != 0 || e.Message != "" {
+		fmt.Fprintf(&b, "rpc error %d: %q", e.Code, e.Message)
+		if len(e.Data) != 0 && string(e.Data) != "null" {
+			fmt.Fprintf(&b, ", data: %s", e.Data)
+		}
+	} else {
+		b.WriteString("request failed")
+	}
+	if e.HTTPStatus != 0 && e.HTTPStatus != http.StatusOK {
+		fmt.Fprintf(&b, " (http status %d %s)", e.HTTPStatus, http.StatusText(e.HTTPStatus))
+	}
+	if e.Body != "" {
+		fmt.Fprintf(&b, ", response body %q", e.Body)
+	}
+	return b.String()
+}
+
+// NotFoundError is returned when the endpoint answers with a null result,
+// e.g. when requested block is not yet available on the node that served the request.
+type NotFoundError struct {
+	Method string
+	// Object describes what has been requested, e.g. block number
+	Object string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: %s not found", e.Method, e.Object)
+}
+
+// ErrorObject is JSON-RPC error object as it is sent over the wire
+type ErrorObject struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (o *ErrorObject) toError(method string, httpStatus int) *Error {
+	return &Error{
+		Method:     method,
+		Code:       o.Code,
+		Message:    o.Message,
+		Data:       o.Data,
+		HTTPStatus: httpStatus,
+	}
+}
+
+// IsRateLimited reports whether the endpoint throttled the request
+func IsRateLimited(err error) bool {
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.HTTPStatus == http.StatusTooManyRequests || rpcErr.Code == CodeLimitExceeded {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Message)
+	return strings.Contains(msg, "rate limit") ||
+		strings.Contains(msg, "too many requests") ||
+		strings.Contains(msg, "capacity exceeded")
+}
+
+// IsUnauthorized reports whether the endpoint rejected credentials, e.g. an invalid API key
+func IsUnauthorized(err error) bool {
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.HTTPStatus == http.StatusUnauthorized || rpcErr.HTTPStatus == http.StatusForbidden {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Message)
+	return strings.Contains(msg, "unauthorized") ||
+		strings.Contains(msg, "invalid api key") ||
+		strings.Contains(msg, "invalid project id")
+}
+
+// IsMethodNotSupported reports whether the endpoint does not provide the called method
+func IsMethodNotSupported(err error) bool {
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return rpcErr.Code == CodeMethodNotFound || rpcErr.Code == CodeMethodNotSupported
+}
+
+// IsNotFound reports whether the requested object does not exist (yet)
+func IsNotFound(err error) bool {
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var rpcErr *Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return rpcErr.Code == CodeResourceNotFound
+}
+
+// IsRetryable reports whether repeating the same request later may succeed
+func IsRetryable(err error) bool {
+	if err == nil || IsUnauthorized(err) || IsMethodNotSupported(err) {
+		return false
+	}
+	if IsRateLimited(err) || IsNotFound(err) {
+		return true
+	}
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		if rpcErr.HTTPStatus >= http.StatusInternalServerError || rpcErr.HTTPStatus == http.StatusRequestTimeout {
+			return true
+		}
+		return rpcErr.Code == CodeResourceUnavailable || rpcErr.Code == CodeInternalError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
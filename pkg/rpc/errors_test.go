@@ -0,0 +1,97 @@
+package rpc_test
+
+import (
+	"context"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPCErrors(t *testing.T) {
+	t.Parallel()
+
+	tcases := []struct {
+		name       string
+		status     int
+		body       string
+		check      func(error) bool
+		retryable  bool
+		code       int
+		httpStatus int
+	}{
+		{
+			name:       "RateLimitedHTTP",
+			status:     http.StatusTooManyRequests,
+			body:       "Too Many Requests",
+			check:      rpc.IsRateLimited,
+			retryable:  true,
+			httpStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "RateLimitedRPC",
+			status:     http.StatusOK,
+			body:       `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"daily request count exceeded","data":{"see":"https://infura.io/dashboard"}}}`,
+			check:      rpc.IsRateLimited,
+			retryable:  true,
+			code:       rpc.CodeLimitExceeded,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name:       "Unauthorized",
+			status:     http.StatusUnauthorized,
+			body:       `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"invalid project id"}}`,
+			check:      rpc.IsUnauthorized,
+			code:       rpc.CodeResourceUnavailable,
+			httpStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "MethodNotSupported",
+			status:     http.StatusOK,
+			body:       `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method eth_getBlockReceipts does not exist/is not available"}}`,
+			check:      rpc.IsMethodNotSupported,
+			code:       rpc.CodeMethodNotFound,
+			httpStatus: http.StatusOK,
+		},
+		{
+			name:      "NullBlock",
+			status:    http.StatusOK,
+			body:      `{"jsonrpc":"2.0","id":1,"result":null}`,
+			check:     rpc.IsNotFound,
+			retryable: true,
+		},
+	}
+
+	for _, tcase := range tcases {
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tcase.status)
+				_, _ = w.Write([]byte(tcase.body))
+			}))
+			defer srv.Close()
+
+			client, err := rpc.Dial(context.Background(), srv.URL)
+			require.NoError(t, err)
+			_, err = client.GetBlockReceipts(context.Background(), rpc.Latest)
+			require.Error(t, err)
+
+			assert.True(t, tcase.check(err), err.Error())
+			assert.Equal(t, tcase.retryable, rpc.IsRetryable(err), err.Error())
+
+			var rpcErr *rpc.Error
+			if tcase.httpStatus == 0 {
+				assert.False(t, errors.As(err, &rpcErr))
+				return
+			}
+			require.True(t, errors.As(err, &rpcErr))
+			assert.Equal(t, "eth_getBlockReceipts", rpcErr.Method)
+			assert.Equal(t, tcase.code, rpcErr.Code)
+			assert.Equal(t, tcase.httpStatus, rpcErr.HTTPStatus)
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BlockRef identifies a block in calls that accept block number or tag
+type BlockRef string
+
+const (
+	Latest    BlockRef = "latest"
+	Earliest  BlockRef = "earliest"
+	Pending   BlockRef = "pending"
+	Safe      BlockRef = "safe"
+	Finalized BlockRef = "finalized"
+)
+
+// NumberRef references block by its number
+func NumberRef(number *big.Int) BlockRef {
+	return BlockRef(toQuantity(number))
+}
+
+func toQuantity(val *big.Int) string {
+	return fmt.Sprintf("0x%x", val)
+}
+
+func parseQuantity(method, val string) (*big.Int, error) {
+	out, ok := new(big.Int).SetString(strings.TrimPrefix(val, "0x"), 16)
+	if !ok {
+		return nil, errors.Errorf("%s: failed to parse quantity %q", method, val)
+	}
+	return out, nil
+}
+
+func (c *Client) callQuantity(ctx context.Context, method string, params []any) (*big.Int, error) {
+	var result string
+	if err := c.Call(ctx, method, params, &result); err != nil {
+		return nil, err
+	}
+	if result == "" {
+		return nil, errors.Errorf("%s: empty result", method)
+	}
+	return parseQuantity(method, result)
+}
+
+// BlockNumber returns number of the most recent block, see eth_blockNumber
+func (c *Client) BlockNumber(ctx context.Context) (*big.Int, error) {
+	return c.callQuantity(ctx, "eth_blockNumber", nil)
+}
+
+// ChainID returns chain id used for signing replay-protected transactions, see eth_chainId
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	return c.callQuantity(ctx, "eth_chainId", nil)
+}
+
+// GetBalance returns balance of the account in wei, see eth_getBalance
+func (c *Client) GetBalance(ctx context.Context, address types.EthAddress, block BlockRef) (*big.Int, error) {
+	return c.callQuantity(ctx, "eth_getBalance", []any{address, block})
+}
+
+func isDetailed[T types.BlockType]() bool {
+	_, ok := any(new(T)).(*types.BlockDetailed)
+	return ok
+}
+
+// GetBlockByNumber returns block, with full transactions when T is types.BlockDetailed, see eth_getBlockByNumber.
+// *NotFoundError is returned if there is no such block.
+func GetBlockByNumber[T types.BlockType](ctx context.Context, c *Client, block BlockRef) (*T, error) {
+	const method = "eth_getBlockByNumber"
+	var result *T
+	if err := c.Call(ctx, method, []any{block, isDetailed[T]()}, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &NotFoundError{Method: method, Object: "block " + string(block)}
+	}
+	return result, nil
+}
+
+// GetBlockByHash returns block, with full transactions when T is types.BlockDetailed, see eth_getBlockByHash.
+// *NotFoundError is returned if there is no such block.
+func GetBlockByHash[T types.BlockType](ctx context.Context, c *Client, hash types.EthHash) (*T, error) {
+	const method = "eth_getBlockByHash"
+	var result *T
+	if err := c.Call(ctx, method, []any{hash, isDetailed[T]()}, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &NotFoundError{Method: method, Object: "block " + hashString(hash)}
+	}
+	return result, nil
+}
+
+// GetTransactionByHash returns transaction, see eth_getTransactionByHash.
+// *NotFoundError is returned if there is no such transaction.
+func (c *Client) GetTransactionByHash(ctx context.Context, hash types.EthHash) (*types.Transaction, error) {
+	const method = "eth_getTransactionByHash"
+	var result *types.Transaction
+	if err := c.Call(ctx, method, []any{hash}, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &NotFoundError{Method: method, Object: "transaction " + hashString(hash)}
+	}
+	return result, nil
+}
+
+// GetTransactionReceipt returns receipt of the transaction, see eth_getTransactionReceipt.
+// *NotFoundError is returned if the transaction is unknown or not mined yet.
+func (c *Client) GetTransactionReceipt(ctx context.Context, hash types.EthHash) (*types.Receipt, error) {
+	const method = "eth_getTransactionReceipt"
+	var result *types.Receipt
+	if err := c.Call(ctx, method, []any{hash}, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &NotFoundError{Method: method, Object: "receipt " + hashString(hash)}
+	}
+	return result, nil
+}
+
+// GetBlockReceipts returns receipts of all transactions in the block, see eth_getBlockReceipts.
+// *NotFoundError is returned if there is no such block.
+func (c *Client) GetBlockReceipts(ctx context.Context, block BlockRef) ([]*types.Receipt, error) {
+	const method = "eth_getBlockReceipts"
+	var result []*types.Receipt
+	if err := c.Call(ctx, method, []any{block}, &result); err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &NotFoundError{Method: method, Object: "block " + string(block)}
+	}
+	return result, nil
+}
+
+// FilterQuery selects logs returned by GetLogs
+type FilterQuery struct {
+	// BlockHash restricts logs to a single block, FromBlock and ToBlock are ignored when it is set
+	BlockHash *types.EthHash
+	FromBlock BlockRef
+	ToBlock   BlockRef
+	Addresses []types.EthAddress
+	// Topics are matched by position, nil entry matches any topic, entry with several hashes matches any of them
+	Topics [][]types.EthHash
+}
+
+func (q *FilterQuery) toParam() map[string]any {
+	out := map[string]any{}
+	if q.BlockHash != nil {
+		out["blockHash"] = *q.BlockHash
+	} else {
+		if q.FromBlock != "" {
+			out["fromBlock"] = q.FromBlock
+		}
+		if q.ToBlock != "" {
+			out["toBlock"] = q.ToBlock
+		}
+	}
+	if len(q.Addresses) != 0 {
+		out["address"] = q.Addresses
+	}
+	if len(q.Topics) != 0 {
+		topics := make([]any, len(q.Topics))
+		for i, alternatives := range q.Topics {
+			switch len(alternatives) {
+			case 0:
+				topics[i] = nil
+			case 1:
+				topics[i] = alternatives[0]
+			default:
+				topics[i] = alternatives
+			}
+		}
+		out["topics"] = topics
+	}
+	return out
+}
+
+// GetLogs returns logs matching the query, see eth_getLogs
+func (c *Client) GetLogs(ctx context.Context, query FilterQuery) ([]*types.Log, error) {
+	var result []*types.Log
+	if err := c.Call(ctx, "eth_getLogs", []any{query.toParam()}, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CallMsg describes message call executed by EthCall, nil fields are omitted
+type CallMsg struct {
+	From     *types.EthAddress
+	To       *types.EthAddress
+	Gas      *big.Int
+	GasPrice *big.Int
+	Value    *big.Int
+	Data     []byte
+}
+
+func (m *CallMsg) toParam() map[string]any {
+	out := map[string]any{}
+	if m.From != nil {
+		out["from"] = *m.From
+	}
+	if m.To != nil {
+		out["to"] = *m.To
+	}
+	if m.Gas != nil {
+		out["gas"] = toQuantity(m.Gas)
+	}
+	if m.GasPrice != nil {
+		out["gasPrice"] = toQuantity(m.GasPrice)
+	}
+	if m.Value != nil {
+		out["value"] = toQuantity(m.Value)
+	}
+	if m.Data != nil {
+		out["input"] = "0x" + hex.EncodeToString(m.Data)
+	}
+	return out
+}
+
+// EthCall executes message call without creating a transaction and returns its output, see eth_call
+func (c *Client) EthCall(ctx context.Context, msg CallMsg, block BlockRef) ([]byte, error) {
+	const method = "eth_call"
+	var result string
+	if err := c.Call(ctx, method, []any{msg.toParam(), block}, &result); err != nil {
+		return nil, err
+	}
+	out, err := hex.DecodeString(strings.TrimPrefix(result, "0x"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to decode result %q", method, result)
+	}
+	return out, nil
+}
+
+func hashString(hash types.EthHash) string {
+	return "0x" + hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPTransport posts every request or batch to the endpoint as a separate HTTP request
+type HTTPTransport struct {
+	endpoint string
+	client   httpClient
+	headers  HeaderFunc
+}
+
+// NewHTTPTransport creates HTTP transport, headers is optional and is called for every request
+func NewHTTPTransport(endpoint string, client httpClient, headers HeaderFunc) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{
+		endpoint: endpoint,
+		client:   client,
+		headers:  headers,
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, requests []*Message, batch bool) ([]*Message, error) {
+	if batch {
+		return t.post(ctx, batchMethod(requests), requests, true)
+	}
+	out := make([]*Message, 0, len(requests))
+	for _, req := range requests {
+		resp, err := t.post(ctx, req.Method, req, false)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, resp...)
+	}
+	return out, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, method string, payload any, batch bool) ([]*Message, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal %s request", method)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s request", method)
+	}
+	if t.headers != nil {
+		headers, err := t.headers()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to build %s request headers", method)
+		}
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to call %s", method)
+	}
+	defer resp.Body.Close()
+
+	buff, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s response body", method)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp Message
+		if json.Unmarshal(buff, &errResp) == nil && errResp.Error != nil {
+			return nil, errResp.Error.toError(method, resp.StatusCode)
+		}
+		return nil, &Error{Method: method, HTTPStatus: resp.StatusCode, Body: truncate(buff, 1024)}
+	}
+
+	out, err := decodeMessages(buff)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to unmarshal response body %q", method, truncate(buff, 1024))
+	}
+	if batch && len(out) == 1 && out[0].Error != nil && len(out[0].ID) == 0 {
+		// Some endpoints reject whole batch with a single error object
+		return nil, out[0].Error.toError(method, resp.StatusCode)
+	}
+	for _, msg := range out {
+		msg.httpStatus = resp.StatusCode
+	}
+	return out, nil
+}
+
+// decodeMessages decodes either a single message or a batch of them
+func decodeMessages(data []byte) ([]*Message, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) != 0 && data[0] == '[' {
+		var out []*Message
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return []*Message{&msg}, nil
+}
+
+func batchMethod(requests []*Message) string {
+	if len(requests) == 1 {
+		return requests[0].Method
+	}
+	return "batch"
+}
+
+func (t *HTTPTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DialIPC connects to node IPC endpoint, which is a unix domain socket, e.g. ~/.ethereum/geth.ipc
+func DialIPC(ctx context.Context, path string) (SubscriptionTransport, error) {
+	if path == "" {
+		return nil, errors.New("ipc socket path is empty")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to ipc socket %s", path)
+	}
+	return newStreamTransport(newIPCConn(conn)), nil
+}
+
+// ipcConn exchanges JSON values over a raw stream, which is how geth and reth speak over IPC
+type ipcConn struct {
+	conn      net.Conn
+	decoder   *json.Decoder
+	writeLock sync.Mutex
+}
+
+func newIPCConn(conn net.Conn) *ipcConn {
+	return &ipcConn{
+		conn:    conn,
+		decoder: json.NewDecoder(conn),
+	}
+}
+
+func (c *ipcConn) ReadMessage() ([]byte, error) {
+	var msg json.RawMessage
+	if err := c.decoder.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *ipcConn) WriteMessage(payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(append(payload, '\n'))
+	return err
+}
+
+func (c *ipcConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,217 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NetworkConfig configures connections of HTTP and WebSocket transports.
+// Zero value keeps Go defaults: system CA pool, proxy from HTTP_PROXY/HTTPS_PROXY/NO_PROXY and no timeout.
+type NetworkConfig struct {
+	// CAFile is PEM bundle of CA certificates trusted in addition to system ones
+	CAFile string
+	// CertFile and KeyFile are PEM client certificate and key for mTLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables server certificate verification, only meant for devnets
+	InsecureSkipVerify bool
+	// Proxy is http:// or https:// proxy URL, credentials in it are sent as Proxy-Authorization
+	Proxy string
+	// Timeout limits every call, including connection establishment
+	Timeout time.Duration
+	// MaxIdleConns limits idle HTTP connections kept to the endpoint, websocket uses a single connection
+	MaxIdleConns int
+}
+
+// WithNetworkConfig applies TLS, proxy, timeout and connection pool settings to HTTP and WebSocket transports.
+// HTTP client set by WithHTTPClient takes precedence for HTTP requests, but timeout is still applied to every call.
+func WithNetworkConfig(cfg NetworkConfig) Option {
+	return func(opts *options) {
+		opts.network = cfg
+	}
+}
+
+// IsZero reports whether config keeps all defaults
+func (c NetworkConfig) IsZero() bool {
+	return c == NetworkConfig{}
+}
+
+// TLSConfig builds TLS config, it returns nil when no TLS settings are given
+func (c NetworkConfig) TLSConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+	out := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read CA bundle %s", c.CAFile)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("CA bundle %s has no PEM certificates", c.CAFile)
+		}
+		out.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("client certificate and key have to be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		out.Certificates = []tls.Certificate{cert}
+	}
+	return out, nil
+}
+
+func (c NetworkConfig) proxyURL() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// HTTPClient builds HTTP client on top of a copy of http.DefaultTransport
+func (c NetworkConfig) HTTPClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := c.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+		// All requests go to a single host, so per host limit is the one that matters
+		transport.MaxIdleConnsPerHost = c.MaxIdleConns
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   c.Timeout,
+	}, nil
+}
+
+// WebSocketDialer builds dialer that tunnels through the proxy with CONNECT
+func (c NetworkConfig) WebSocketDialer() (*websocket.Dialer, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := c.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	netDialer := &net.Dialer{Timeout: c.Timeout}
+	return &websocket.Dialer{
+		TLSConfig: tlsConfig,
+		NetDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			proxyURL := proxy
+			if proxyURL == nil {
+				var err error
+				if proxyURL, err = proxyFromEnvironment(addr); err != nil {
+					return nil, err
+				}
+			}
+			if proxyURL == nil {
+				return netDialer.DialContext(ctx, network, addr)
+			}
+			return dialThroughProxy(ctx, netDialer, proxyURL, addr)
+		},
+	}, nil
+}
+
+// proxyFromEnvironment picks proxy for websocket endpoint the same way net/http does for HTTPS requests
+func proxyFromEnvironment(addr string) (*url.URL, error) {
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+}
+
+// dialThroughProxy opens tunnel to addr with HTTP CONNECT request
+func dialThroughProxy(ctx context.Context, dialer *net.Dialer, proxy *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		if proxy.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+		}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to proxy %s", proxyAddr)
+	}
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname(), MinVersion: tls.VersionTLS12})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "proxy tls handshake failed")
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password)))
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to send CONNECT request to proxy")
+	}
+	// Proxy does not send anything after response until the tunnel is used, so buffered reader loses no data
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to read proxy CONNECT response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		_ = conn.Close()
+		return nil, errors.Errorf("proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,152 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nodeHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			conn, err := websocket.Upgrade(w, r)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			serveStream(t, conn.ReadMessage, conn.WriteMessage)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		_, _ = w.Write(handlePayload(t, data))
+	}
+}
+
+// proxyServer tunnels CONNECT requests and forwards plain HTTP ones, counting both
+func proxyServer(t *testing.T, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodConnect {
+			r.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			_ = upstream.Close()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		_ = conn.Close()
+	}))
+}
+
+func TestNetworkConfigCABundle(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(nodeHandler(t))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, endpoint := range []string{srv.URL, "wss" + srv.URL[len("https"):]} {
+		untrusted, err := rpc.Dial(ctx, endpoint)
+		if err == nil {
+			_, err = untrusted.BlockNumber(ctx)
+			_ = untrusted.Close()
+		}
+		assert.Error(t, err, endpoint)
+
+		client, err := rpc.Dial(ctx, endpoint, rpc.WithNetworkConfig(rpc.NetworkConfig{CAFile: caFile}))
+		require.NoError(t, err, endpoint)
+		num, err := client.BlockNumber(ctx)
+		require.NoError(t, err, endpoint)
+		assert.Equal(t, int64(16), num.Int64())
+		_ = client.Close()
+
+		insecure, err := rpc.Dial(ctx, endpoint, rpc.WithNetworkConfig(rpc.NetworkConfig{InsecureSkipVerify: true}))
+		require.NoError(t, err, endpoint)
+		_, err = insecure.BlockNumber(ctx)
+		assert.NoError(t, err, endpoint)
+		_ = insecure.Close()
+	}
+
+	_, err := rpc.NetworkConfig{CertFile: caFile}.TLSConfig()
+	assert.Error(t, err)
+}
+
+func TestNetworkConfigProxy(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(nodeHandler(t))
+	defer srv.Close()
+	var requests atomic.Int32
+	proxy := proxyServer(t, &requests)
+	defer proxy.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, endpoint := range []string{srv.URL, "ws" + srv.URL[len("http"):]} {
+		before := requests.Load()
+		client, err := rpc.Dial(ctx, endpoint, rpc.WithNetworkConfig(rpc.NetworkConfig{Proxy: proxy.URL, MaxIdleConns: 2}))
+		require.NoError(t, err, endpoint)
+		testClient(t, client)
+		_ = client.Close()
+		assert.Greater(t, requests.Load(), before, endpoint)
+	}
+}
+
+func TestNetworkConfigTimeout(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	client, err := rpc.Dial(context.Background(), srv.URL, rpc.WithNetworkConfig(rpc.NetworkConfig{Timeout: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	start := time.Now()
+	_, err = client.BlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,246 @@
+package rpc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CostTable tells how many compute units every method costs
+type CostTable struct {
+	// Default is the cost of methods missing in Methods
+	Default int
+	Methods map[string]int
+}
+
+// Cost returns compute units charged for the method
+func (t CostTable) Cost(method string) int {
+	if cost, ok := t.Methods[method]; ok {
+		return cost
+	}
+	return t.Default
+}
+
+// RateLimitPreset is provider budget and its method costs
+type RateLimitPreset struct {
+	CUPerSecond float64
+	Costs       CostTable
+}
+
+// RateLimitPresets are budgets of free plans of known providers as they publish them, pick one with --rate-limit and
+// override the budget with --cu-per-second to match your plan
+var RateLimitPresets = map[string]RateLimitPreset{
+	// https://docs.metamask.io/services/get-started/pricing/credit-cost/
+	"infura": {
+		CUPerSecond: 2000,
+		Costs: CostTable{
+			Default: 80,
+			Methods: map[string]int{
+				"eth_chainId":               5,
+				"eth_subscribe":             5,
+				"eth_unsubscribe":           10,
+				"eth_blockNumber":           80,
+				"eth_getBalance":            80,
+				"eth_getBlockByNumber":      80,
+				"eth_getBlockByHash":        80,
+				"eth_getTransactionByHash":  80,
+				"eth_getTransactionReceipt": 80,
+				"eth_call":                  80,
+				"eth_feeHistory":            80,
+				"eth_getLogs":               255,
+				"eth_getBlockReceipts":      1000,
+			},
+		},
+	},
+	// https://docs.alchemy.com/reference/compute-unit-costs
+	"alchemy": {
+		CUPerSecond: 330,
+		Costs: CostTable{
+			Default: 26,
+			Methods: map[string]int{
+				"eth_chainId":               0,
+				"eth_subscribe":             10,
+				"eth_unsubscribe":           10,
+				"eth_blockNumber":           10,
+				"eth_feeHistory":            10,
+				"eth_getBlockByNumber":      16,
+				"eth_getBlockByHash":        16,
+				"eth_getTransactionReceipt": 15,
+				"eth_getTransactionByHash":  17,
+				"eth_getBalance":            19,
+				"eth_call":                  26,
+				"eth_getLogs":               75,
+				"eth_getBlockReceipts":      500,
+			},
+		},
+	},
+	// Tatum charges requests rather than compute units
+	"tatum": {
+		CUPerSecond: 3,
+		Costs: CostTable{
+			Default: 1,
+		},
+	},
+}
+
+// RateLimitPresetNames returns sorted names of RateLimitPresets
+func RateLimitPresetNames() []string {
+	out := make([]string, 0, len(RateLimitPresets))
+	for name := range RateLimitPresets {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NewRateLimitPreset creates limiter for the preset, cuPerSecond overrides preset budget when it is positive
+func NewRateLimitPreset(name string, cuPerSecond float64) (*Limiter, error) {
+	preset, ok := RateLimitPresets[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.Errorf("unknown rate limit preset %q, known presets: %s", name, strings.Join(RateLimitPresetNames(), ", "))
+	}
+	if cuPerSecond > 0 {
+		preset.CUPerSecond = cuPerSecond
+	}
+	return NewLimiter(preset.CUPerSecond, preset.Costs), nil
+}
+
+// MethodStats is spend on a single method
+type MethodStats struct {
+	Calls        uint64 `json:"calls"`
+	ComputeUnits uint64 `json:"computeUnits"`
+}
+
+// RateLimitStats is spend since limiter has been created
+type RateLimitStats struct {
+	Calls        uint64                 `json:"calls"`
+	ComputeUnits uint64                 `json:"computeUnits"`
+	Methods      map[string]MethodStats `json:"methods"`
+	// Waited is total time calls spent waiting for budget
+	Waited time.Duration `json:"waited"`
+	// Throttled counts calls rejected by the provider as rate limited despite the limiter
+	Throttled uint64 `json:"throttled"`
+}
+
+// Limiter is a token bucket holding up to a second of compute units.
+// Call that costs more than the bucket holds is let through once the bucket is full and leaves it in debt,
+// so large batches are throttled instead of blocked forever.
+type Limiter struct {
+	costs    CostTable
+	rate     float64
+	capacity float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  RateLimitStats
+}
+
+// NewLimiter creates limiter refilling cuPerSecond compute units every second
+func NewLimiter(cuPerSecond float64, costs CostTable) *Limiter {
+	return &Limiter{
+		costs:    costs,
+		rate:     cuPerSecond,
+		capacity: cuPerSecond,
+		tokens:   cuPerSecond,
+		last:     time.Now(),
+		stats: RateLimitStats{
+			Methods: make(map[string]MethodStats),
+		},
+	}
+}
+
+// Cost returns compute units charged for the methods sent together
+func (l *Limiter) Cost(methods ...string) int {
+	var out int
+	for _, method := range methods {
+		out += l.costs.Cost(method)
+	}
+	return out
+}
+
+// Wait blocks until budget allows to send the methods, batch is passed as all its methods at once
+func (l *Limiter) Wait(ctx context.Context, methods ...string) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	cost := float64(l.Cost(methods...))
+
+	l.lock.Lock()
+	l.refill(time.Now())
+	deficit := min(cost, l.capacity) - l.tokens
+	l.tokens -= cost
+	var delay time.Duration
+	if deficit > 0 {
+		delay = time.Duration(deficit / l.rate * float64(time.Second))
+	}
+	if delay == 0 {
+		l.record(methods, 0)
+	}
+	l.lock.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		l.lock.Lock()
+		l.record(methods, delay)
+		l.lock.Unlock()
+		return nil
+	case <-ctx.Done():
+		// Give budget back, the call is not going to be sent
+		l.lock.Lock()
+		l.tokens += cost
+		l.lock.Unlock()
+		return errors.Wrap(ctx.Err(), "rate limiter wait interrupted")
+	}
+}
+
+// Throttled is called when provider rejected the call as rate limited, it empties the bucket to back off
+func (l *Limiter) Throttled() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill(time.Now())
+	l.stats.Throttled++
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+}
+
+// Stats returns spend since limiter has been created
+func (l *Limiter) Stats() RateLimitStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	out := l.stats
+	out.Methods = make(map[string]MethodStats, len(l.stats.Methods))
+	for method, stats := range l.stats.Methods {
+		out.Methods[method] = stats
+	}
+	return out
+}
+
+// record accounts methods that are about to be sent, it is called with lock held
+func (l *Limiter) record(methods []string, waited time.Duration) {
+	l.stats.Waited += waited
+	for _, method := range methods {
+		methodCost := uint64(l.costs.Cost(method))
+		stats := l.stats.Methods[method]
+		stats.Calls++
+		stats.ComputeUnits += methodCost
+		l.stats.Methods[method] = stats
+		l.stats.Calls++
+		l.stats.ComputeUnits += methodCost
+	}
+}
+
+func (l *Limiter) refill(now time.Time) {
+	l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
@@ -0,0 +1,94 @@
+package rpc_test
+
+import (
+	"context"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	costs := rpc.CostTable{Default: 10, Methods: map[string]int{"eth_blockNumber": 50}}
+	limiter := rpc.NewLimiter(100, costs)
+	ctx := context.Background()
+
+	// Full bucket lets the first second worth of calls through immediately
+	start := time.Now()
+	require.NoError(t, limiter.Wait(ctx, "eth_blockNumber"))
+	require.NoError(t, limiter.Wait(ctx, "eth_blockNumber"))
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Empty bucket needs 0.2s to refill 20 units for a batch of two default calls
+	start = time.Now()
+	require.NoError(t, limiter.Wait(ctx, "eth_call", "eth_getBalance"))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// Batch costing more than the bucket holds is let through once bucket is full, instead of blocking forever
+	waitCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	require.NoError(t, limiter.Wait(waitCtx, "eth_blockNumber", "eth_blockNumber", "eth_blockNumber"))
+
+	// Debt of the large batch is paid by the next caller
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	assert.Error(t, limiter.Wait(shortCtx, "eth_chainId"))
+
+	stats := limiter.Stats()
+	// Call that gave up waiting is not charged
+	assert.Equal(t, uint64(7), stats.Calls)
+	assert.Equal(t, uint64(5*50+2*10), stats.ComputeUnits)
+	assert.Equal(t, rpc.MethodStats{Calls: 5, ComputeUnits: 250}, stats.Methods["eth_blockNumber"])
+	assert.Positive(t, stats.Waited)
+}
+
+func TestRateLimitPresets(t *testing.T) {
+	t.Parallel()
+	for _, name := range rpc.RateLimitPresetNames() {
+		limiter, err := rpc.NewRateLimitPreset(name, 0)
+		require.NoError(t, err, name)
+		assert.Positive(t, limiter.Cost("eth_getBlockByNumber"), name)
+	}
+	alchemy, err := rpc.NewRateLimitPreset("Alchemy", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 16+500, alchemy.Cost("eth_getBlockByNumber", "eth_getBlockReceipts"))
+
+	_, err = rpc.NewRateLimitPreset("unknown", 0)
+	assert.Error(t, err)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	t.Parallel()
+	throttle := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle {
+			throttle = false
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"too many requests"}}`))
+			return
+		}
+		servePayload(t, w, r)
+	}))
+	defer srv.Close()
+
+	limiter := rpc.NewLimiter(1000, rpc.CostTable{Default: 1})
+	client, err := rpc.Dial(context.Background(), srv.URL, rpc.WithRateLimiter(limiter))
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.BlockNumber(context.Background())
+	assert.True(t, rpc.IsRateLimited(err), err)
+	testClient(t, client)
+
+	stats := limiter.Stats()
+	assert.Equal(t, uint64(1), stats.Throttled)
+	// Batch of two in testClient is charged per element
+	assert.Equal(t, uint64(1), stats.Methods["eth_chainId"].Calls)
+	assert.Equal(t, uint64(1), stats.Methods["eth_unknown"].Calls)
+	assert.Equal(t, uint64(2), stats.Methods["eth_blockNumber"].Calls)
+}
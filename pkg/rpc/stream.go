@@ -0,0 +1,251 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// messageConn is a connection that exchanges whole JSON payloads
+type messageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(payload []byte) error
+	Close() error
+}
+
+// notificationBuffer bounds notifications buffered per subscription, both before the subscription is registered
+// and while its consumer lags behind. The oldest ones are dropped beyond it, so that reading the connection never
+// blocks on a slow consumer, which would stall responses to its own requests.
+const notificationBuffer = 1000
+
+// earlySubscriptions bounds subscriptions notifications are buffered for before they are registered,
+// notifications of further unknown subscriptions are dropped
+const earlySubscriptions = 16
+
+// streamTransport multiplexes requests, responses and notifications over a single persistent connection
+type streamTransport struct {
+	conn messageConn
+
+	lock          sync.Mutex
+	pending       map[string]chan *Message
+	subscriptions map[string]chan json.RawMessage
+	// early keeps notifications that arrived before subscription has been registered
+	early map[string][]json.RawMessage
+	// unsubscribed are subscriptions the consumer is gone from, late notifications of them are dropped
+	unsubscribed map[string]struct{}
+	// dropped counts notifications dropped by subscription
+	dropped map[string]uint64
+
+	done    chan struct{}
+	err     error
+	errOnce sync.Once
+}
+
+func newStreamTransport(conn messageConn) *streamTransport {
+	out := &streamTransport{
+		conn:          conn,
+		pending:       make(map[string]chan *Message),
+		subscriptions: make(map[string]chan json.RawMessage),
+		early:         make(map[string][]json.RawMessage),
+		unsubscribed:  make(map[string]struct{}),
+		dropped:       make(map[string]uint64),
+		done:          make(chan struct{}),
+	}
+	go out.readLoop()
+	return out
+}
+
+func (t *streamTransport) Send(ctx context.Context, requests []*Message, batch bool) ([]*Message, error) {
+	waiters := make([]chan *Message, len(requests))
+
+	t.lock.Lock()
+	select {
+	case <-t.done:
+		t.lock.Unlock()
+		return nil, t.Err()
+	default:
+	}
+	for i, req := range requests {
+		waiters[i] = make(chan *Message, 1)
+		t.pending[req.idKey()] = waiters[i]
+	}
+	t.lock.Unlock()
+	defer t.forget(requests)
+
+	if batch {
+		if err := t.write(requests); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, req := range requests {
+			if err := t.write(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := make([]*Message, 0, len(requests))
+	for i, waiter := range waiters {
+		select {
+		case resp := <-waiter:
+			out = append(out, resp)
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "%s: no response", requests[i].Method)
+		case <-t.done:
+			return nil, t.Err()
+		}
+	}
+	return out, nil
+}
+
+func (t *streamTransport) write(payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal request")
+	}
+	if err = t.conn.WriteMessage(data); err != nil {
+		t.fail(err)
+		return errors.Wrap(err, "failed to send request")
+	}
+	return nil
+}
+
+func (t *streamTransport) forget(requests []*Message) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, req := range requests {
+		delete(t.pending, req.idKey())
+	}
+}
+
+func (t *streamTransport) readLoop() {
+	for {
+		data, err := t.conn.ReadMessage()
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		messages, err := decodeMessages(data)
+		if err != nil {
+			// Skip garbage instead of tearing down the connection
+			continue
+		}
+		for _, msg := range messages {
+			t.dispatch(msg)
+		}
+	}
+}
+
+func (t *streamTransport) dispatch(msg *Message) {
+	if msg.isNotification() {
+		var params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil || params.Subscription == "" {
+			return
+		}
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		sub, ok := t.subscriptions[params.Subscription]
+		if !ok {
+			if _, ok = t.unsubscribed[params.Subscription]; ok {
+				return
+			}
+			early, ok := t.early[params.Subscription]
+			if !ok && len(t.early) == earlySubscriptions {
+				return
+			}
+			if len(early) == notificationBuffer {
+				early = early[1:]
+				t.dropped[params.Subscription]++
+			}
+			t.early[params.Subscription] = append(early, params.Result)
+			return
+		}
+		// Only readLoop sends into the channel, so dropping the oldest notification always makes room
+		for {
+			select {
+			case sub <- params.Result:
+				return
+			default:
+			}
+			select {
+			case <-sub:
+				t.dropped[params.Subscription]++
+			default:
+			}
+		}
+	}
+
+	t.lock.Lock()
+	waiter := t.pending[msg.idKey()]
+	t.lock.Unlock()
+	if waiter != nil {
+		// Waiter has room for one response only, a duplicate one from the node is dropped
+		select {
+		case waiter <- msg:
+		default:
+		}
+	}
+}
+
+func (t *streamTransport) Notifications(subID string) (<-chan json.RawMessage, func()) {
+	ch := make(chan json.RawMessage, notificationBuffer)
+
+	t.lock.Lock()
+	for _, early := range t.early[subID] {
+		ch <- early
+	}
+	delete(t.early, subID)
+	delete(t.unsubscribed, subID)
+	t.subscriptions[subID] = ch
+	t.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			t.lock.Lock()
+			delete(t.subscriptions, subID)
+			delete(t.dropped, subID)
+			t.unsubscribed[subID] = struct{}{}
+			t.lock.Unlock()
+		})
+	}
+}
+
+func (t *streamTransport) Dropped(subID string) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.dropped[subID]
+}
+
+func (t *streamTransport) fail(err error) {
+	t.errOnce.Do(func() {
+		t.lock.Lock()
+		t.err = err
+		t.lock.Unlock()
+		close(t.done)
+		_ = t.conn.Close()
+	})
+}
+
+func (t *streamTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *streamTransport) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err == nil {
+		return nil
+	}
+	return errors.Wrap(t.err, "connection lost")
+}
+
+func (t *streamTransport) Close() error {
+	t.fail(errors.New("transport closed"))
+	return nil
+}
@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"context"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+
+	"github.com/pkg/errors"
+)
+
+// DialWebSocket connects to ws:// or wss:// endpoint
+func DialWebSocket(ctx context.Context, endpoint string, dialer *websocket.Dialer) (SubscriptionTransport, error) {
+	if dialer == nil {
+		dialer = &websocket.Dialer{}
+	}
+	conn, err := dialer.Dial(ctx, endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial websocket endpoint")
+	}
+	return newStreamTransport(conn), nil
+}
@@ -0,0 +1,109 @@
+package sqltxstore
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Dialect describes differences of SQL databases the store supports
+type Dialect struct {
+	Name string
+	// placeholder returns bind parameter n, counting from 1
+	placeholder func(n int) string
+	// maxParams is the number of bind parameters allowed in one statement
+	maxParams int
+	// numeric is column type of 256 bit integers, json of raw objects
+	numeric string
+	json    string
+	// serial is column definition of auto incremented primary key
+	serial string
+}
+
+var (
+	// SQLite needs 3.24 or later for upserts. Integers wider than 64 bits are stored as decimal text.
+	SQLite = Dialect{
+		Name:        "sqlite",
+		placeholder: func(int) string { return "?" },
+		// Default limit of SQLite before 3.32
+		maxParams: 999,
+		numeric:   "TEXT",
+		json:      "TEXT",
+		serial:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+	}
+	// Postgres stores integers as NUMERIC and raw objects as JSONB, so they can be queried with JSON operators
+	Postgres = Dialect{
+		Name:        "postgres",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		maxParams:   65535,
+		numeric:     "NUMERIC(78)",
+		json:        "JSONB",
+		serial:      "BIGSERIAL PRIMARY KEY",
+	}
+)
+
+var dialects = []Dialect{SQLite, Postgres}
+
+// DialectNames returns names ParseDialect accepts
+func DialectNames() []string {
+	out := make([]string, len(dialects))
+	for i, d := range dialects {
+		out[i] = d.Name
+	}
+	return out
+}
+
+// ParseDialect returns dialect by name, sqlite3, postgresql and pgx are accepted as aliases, as drivers are registered by them
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	}
+	return Dialect{}, errors.Errorf("unknown SQL dialect %q, options: %s", name, strings.Join(DialectNames(), ", "))
+}
+
+func (d Dialect) String() string {
+	return d.Name
+}
+
+// values returns VALUES list of rows bind parameters, numbered from the first one
+func (d Dialect) values(rows, columns int) string {
+	var b strings.Builder
+	n := 1
+	for row := range rows {
+		if row != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for column := range columns {
+			if column != 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(d.placeholder(n))
+			n++
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// rebind replaces ? in the query by dialect placeholders
+func (d Dialect) rebind(query string) string {
+	if d.placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 1
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString(d.placeholder(n))
+		n++
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+// Package integration_test runs the conformance suite of sqltxstore against real databases. It is a module of its own,
+// so that ethscan does not depend on SQL drivers:
+//
+//	cd pkg/sqltxstore/integration && go test ./...
+//
+// SQLite runs in a temporary directory and needs cgo. PostgreSQL runs when TEST_POSTGRES_DSN is set, every store
+// gets a schema of its own, which is dropped afterwards.
+package integration_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/sqltxstore"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/txstore/storetest"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modes = map[string]txstore.RollbackMode{"Remove": txstore.RollbackRemove, "Flag": txstore.RollbackFlag}
+
+// run runs the conformance suite and value checks on fresh databases made by newDB
+func run(t *testing.T, dialect sqltxstore.Dialect, newDB func(t *testing.T) *sql.DB) {
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) txstore.Store {
+				store, err := sqltxstore.New(newDB(t), dialect, sqltxstore.WithBatchSize(7), sqltxstore.WithRollbackMode(mode))
+				require.NoError(t, err)
+				t.Cleanup(func() { assert.NoError(t, store.Close()) })
+				return store
+			})
+		})
+	}
+	t.Run("Values", func(t *testing.T) { testValues(t, dialect, newDB(t)) })
+}
+
+func TestSQLite(t *testing.T) {
+	var dbs atomic.Int64
+	dir := t.TempDir()
+	dsn := func() string {
+		return fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", filepath.Join(dir, fmt.Sprintf("%d.db", dbs.Add(1))))
+	}
+	run(t, sqltxstore.SQLite, func(t *testing.T) *sql.DB {
+		db, err := sql.Open("sqlite3", dsn())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+
+	// Dialect is picked by the driver name
+	store, err := sqltxstore.Open("sqlite3", dsn())
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	var schemas atomic.Int64
+	run(t, sqltxstore.Postgres, func(t *testing.T) *sql.DB {
+		cfg, err := pgx.ParseConfig(dsn)
+		require.NoError(t, err)
+		admin := stdlib.OpenDB(*cfg)
+		t.Cleanup(func() { _ = admin.Close() })
+		schema := fmt.Sprintf("ethscan_test_%d_%d", os.Getpid(), schemas.Add(1))
+		_, err = admin.Exec("CREATE SCHEMA " + schema)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+			assert.NoError(t, err)
+		})
+
+		// Every connection of the pool works in the schema
+		cfg.RuntimeParams["search_path"] = schema
+		db := stdlib.OpenDB(*cfg)
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+}
+
+// testValues checks what the conformance suite does not reach: integers wider than 64 bits, optional fields
+// and reopening of the migrated schema
+func testValues(t *testing.T, dialect sqltxstore.Dialect, db *sql.DB) {
+	store, err := sqltxstore.New(db, dialect)
+	require.NoError(t, err)
+	wide, ok := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	require.True(t, ok)
+	from, to := types.EthAddress{0xa}, types.EthAddress{0xb}
+	tx := &types.Transaction{
+		Hash:                 types.EthHash{1},
+		BlockHash:            types.EthHash{2},
+		BlockNumber:          types.BigInt(*big.NewInt(1 << 40)),
+		From:                 from,
+		To:                   to,
+		Value:                types.BigInt(*wide),
+		GasPrice:             types.BigInt(*big.NewInt(7)),
+		MaxPriorityFeePerGas: (*types.BigInt)(big.NewInt(3)),
+		ChainID:              (*types.BigInt)(big.NewInt(8453)),
+		Input:                types.BinData{0, 1, 0},
+	}
+	require.NoError(t, store.StoreTransaction(tx))
+	require.NoError(t, store.StoreBlockReward(&types.ProposedBlockReward{
+		BlockHash:    types.EthHash{2},
+		BlockNumber:  types.BigInt(*big.NewInt(1 << 40)),
+		FeeRecipient: to,
+		PriorityFees: types.BigInt(*wide),
+	}))
+	require.NoError(t, store.SaveCheckpoint(*wide))
+	require.NoError(t, store.Close())
+
+	// Applied migrations are skipped by the next store
+	store, err = sqltxstore.New(db, dialect)
+	require.NoError(t, err)
+	var versions int
+	require.NoError(t, db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM schema_migrations").Scan(&versions))
+	assert.Equal(t, sqltxstore.SchemaVersion(), versions)
+
+	txs, err := store.GetTransactions(from.String())
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.True(t, tx.Equal(txs[0]))
+	assert.Equal(t, wide, txs[0].Value.AsBigInt())
+	txs, err = store.GetTransactionsAfterBlock(*big.NewInt(1<<40 - 1), to.String())
+	require.NoError(t, err)
+	assert.Len(t, txs, 1)
+
+	rewards, err := store.GetBlockRewards(to.String())
+	require.NoError(t, err)
+	require.Len(t, rewards, 1)
+	assert.Equal(t, wide, rewards[0].PriorityFees.AsBigInt())
+	checkpoint, err := store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Equal(t, wide, checkpoint)
+	require.NoError(t, store.Close())
+}
@@ -0,0 +1,162 @@
+package sqltxstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type migration struct {
+	version     int
+	description string
+	statements  func(d Dialect) []string
+}
+
+// migrations are applied in order, each in its own transaction. Released migrations must never change, add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "transactions, address index, blocks and checkpoints",
+		statements: func(d Dialect) []string {
+			return []string{
+				fmt.Sprintf(`CREATE TABLE transactions (
+	hash TEXT PRIMARY KEY,
+	chain_id %[1]s,
+	block_hash TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	tx_index INTEGER NOT NULL,
+	from_address TEXT NOT NULL,
+	to_address TEXT NOT NULL,
+	value %[1]s NOT NULL,
+	gas %[1]s NOT NULL,
+	gas_price %[1]s NOT NULL,
+	nonce %[1]s NOT NULL,
+	tx_type INTEGER NOT NULL,
+	input TEXT NOT NULL,
+	raw %[2]s NOT NULL
+)`, d.numeric, d.json),
+				`CREATE INDEX transactions_block_number ON transactions (block_number)`,
+				`CREATE TABLE address_transactions (
+	address TEXT NOT NULL,
+	hash TEXT NOT NULL REFERENCES transactions (hash),
+	block_number BIGINT NOT NULL,
+	tx_index INTEGER NOT NULL,
+	PRIMARY KEY (address, hash)
+)`,
+				`CREATE INDEX address_transactions_order ON address_transactions (address, block_number, tx_index)`,
+				fmt.Sprintf(`CREATE TABLE blocks (
+	hash TEXT PRIMARY KEY,
+	number BIGINT NOT NULL,
+	chain_id %[1]s,
+	timestamp BIGINT NOT NULL,
+	fee_recipient TEXT NOT NULL,
+	base_fee_per_gas %[1]s NOT NULL,
+	gas_used %[1]s NOT NULL,
+	burnt_fees %[1]s NOT NULL,
+	priority_fees %[1]s NOT NULL,
+	tx_count INTEGER NOT NULL,
+	from_receipts BOOLEAN NOT NULL,
+	raw %[2]s NOT NULL
+)`, d.numeric, d.json),
+				`CREATE INDEX blocks_fee_recipient ON blocks (fee_recipient, number)`,
+				fmt.Sprintf(`CREATE TABLE checkpoints (
+	name TEXT PRIMARY KEY,
+	block_number %s NOT NULL
+)`, d.numeric),
+			}
+		},
+	},
+	{
+		version:     2,
+		description: "orphaned transactions and tombstones of rolled back ones",
+		statements: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE transactions ADD COLUMN orphaned BOOLEAN NOT NULL DEFAULT FALSE`,
+				fmt.Sprintf(`CREATE TABLE tombstones (
+	id %s,
+	address TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	block_number BIGINT NOT NULL,
+	from_block BIGINT NOT NULL,
+	flagged BOOLEAN NOT NULL,
+	removed_at BIGINT NOT NULL,
+	raw %s NOT NULL
+)`, d.serial, d.json),
+				`CREATE INDEX tombstones_address ON tombstones (address, id)`,
+			}
+		},
+	},
+}
+
+// SchemaVersion is the version of the schema the store works with
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the schema up to date, it refuses database migrated by a newer version
+func migrate(ctx context.Context, db *sql.DB, d Dialect) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	applied_at BIGINT NOT NULL
+)`); err != nil {
+		return errors.Wrap(err, "failed to create schema_migrations table")
+	}
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+	for version := range applied {
+		if version > SchemaVersion() {
+			return errors.Errorf("database schema version %d is newer than supported %d", version, SchemaVersion())
+		}
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err = apply(ctx, db, d, m); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d (%s)", m.version, m.description)
+		}
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read schema version")
+	}
+	defer rows.Close()
+	out := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, errors.Wrap(err, "failed to read schema version")
+		}
+		out[version] = true
+	}
+	return out, errors.Wrap(rows.Err(), "failed to read schema version")
+}
+
+func apply(ctx context.Context, db *sql.DB, d Dialect, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range m.statements(d) {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), m.version, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
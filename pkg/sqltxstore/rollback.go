@@ -0,0 +1,177 @@
+package sqltxstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Rollback rolls back transactions and rewards of blocks above blkId, after they were reorged out.
+// Rewards are removed, transactions are removed or flagged as orphaned depending on WithRollbackMode.
+// Everything rolled back is recorded as a tombstone. It returns the number of rolled back transactions and rewards.
+func (s *Store) Rollback(blkId big.Int) (int, error) {
+	above := int64(-1)
+	if blkId.IsInt64() {
+		above = blkId.Int64()
+	} else if blkId.Sign() > 0 {
+		above = math.MaxInt64
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rollback(context.Background(), above)
+}
+
+// RollbackBlock rolls back the block with the hash and all blocks above it, see Rollback.
+// Block the store has nothing from is unknown to it, then nothing is rolled back.
+func (s *Store) RollbackBlock(hash types.EthHash) (int, error) {
+	ctx := context.Background()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.flush(ctx, nil); err != nil {
+		return 0, err
+	}
+	var number int64
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT block_number FROM transactions WHERE block_hash = ? LIMIT 1`), hashString(hash)).Scan(&number)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT number FROM blocks WHERE hash = ?`), hashString(hash)).Scan(&number)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to look up block")
+	}
+	return s.rollback(ctx, number-1)
+}
+
+// rollback inserts the buffer and rolls back blocks above in the same database transaction, it has to be called with lock held
+func (s *Store) rollback(ctx context.Context, above int64) (int, error) {
+	if above == math.MaxInt64 {
+		return 0, nil
+	}
+	var removed int
+	err := s.flush(ctx, func(dbTx *sql.Tx) error {
+		removed = 0
+		txs, err := selectRaw[types.Transaction](ctx, dbTx, s.dialect.rebind(
+			`SELECT raw FROM transactions WHERE block_number > ? AND orphaned = FALSE ORDER BY block_number, tx_index, hash`), above)
+		if err != nil {
+			return errors.Wrap(err, "failed to read rolled back transactions")
+		}
+		rewards, err := selectRaw[types.ProposedBlockReward](ctx, dbTx, s.dialect.rebind(
+			`SELECT raw FROM blocks WHERE number > ? ORDER BY number`), above)
+		if err != nil {
+			return errors.Wrap(err, "failed to read rolled back rewards")
+		}
+		if len(txs) == 0 && len(rewards) == 0 {
+			return nil
+		}
+
+		flag := s.rollbackMode == txstore.RollbackFlag
+		from, removedAt := types.BigInt(*big.NewInt(above + 1)), s.now()
+		var rows [][]any
+		bury := func(tomb *txstore.Tombstone, kind string, hash types.EthHash, block int64, addresses ...string) error {
+			raw, err := json.Marshal(tomb)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode tombstone")
+			}
+			for _, address := range addresses {
+				rows = append(rows, []any{address, kind, hashString(hash), block, above + 1, tomb.Flagged, removedAt.Unix(), string(raw)})
+			}
+			return nil
+		}
+		var orphans [][]any
+		for _, tx := range txs {
+			addresses := []string{tx.To.String()}
+			if tx.From != tx.To {
+				addresses = append(addresses, tx.From.String())
+			}
+			tomb := &txstore.Tombstone{Transaction: tx, From: from, RemovedAt: removedAt, Flagged: flag}
+			if err = bury(tomb, "tx", tx.Hash, tx.BlockNumber.AsBigInt().Int64(), addresses...); err != nil {
+				return err
+			}
+			if flag {
+				orphan := *tx
+				orphan.Orphaned = true
+				row, err := txRow(&orphan)
+				if err != nil {
+					return err
+				}
+				orphans = append(orphans, row)
+			}
+		}
+		for _, reward := range rewards {
+			tomb := &txstore.Tombstone{Reward: reward, From: from, RemovedAt: removedAt}
+			if err = bury(tomb, "reward", reward.BlockHash, reward.BlockNumber.AsBigInt().Int64(), reward.FeeRecipient.String()); err != nil {
+				return err
+			}
+		}
+		if err = s.insert(ctx, dbTx, "tombstones", tombstoneColumns, rows); err != nil {
+			return err
+		}
+
+		statements := []string{`DELETE FROM blocks WHERE number > ?`}
+		if flag {
+			if err = s.insert(ctx, dbTx, "transactions", txColumns, orphans, "hash"); err != nil {
+				return err
+			}
+		} else {
+			statements = append(statements,
+				`DELETE FROM address_transactions WHERE block_number > ?`,
+				`DELETE FROM transactions WHERE block_number > ?`)
+		}
+		for _, statement := range statements {
+			if _, err = dbTx.ExecContext(ctx, s.dialect.rebind(statement), above); err != nil {
+				return errors.Wrap(err, "failed to roll back")
+			}
+		}
+		removed = len(txs) + len(rewards)
+		return nil
+	})
+	return removed, err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// selectRaw runs the query and decodes raw column of the rows, db is either the database or its transaction
+func selectRaw[T any](ctx context.Context, db queryer, query string, args ...any) ([]*T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*T
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		val := new(T)
+		if err = json.Unmarshal(raw, val); err != nil {
+			return nil, err
+		}
+		out = append(out, val)
+	}
+	return out, rows.Err()
+}
+
+// Tombstones returns rolled back transactions and rewards of the address, in the order they were rolled back
+func (s *Store) Tombstones(address string) ([]txstore.Tombstone, error) {
+	tombs, err := selectRaw[txstore.Tombstone](context.Background(), s.db, s.dialect.rebind(
+		`SELECT raw FROM tombstones WHERE address = ? ORDER BY id`), address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tombstones")
+	}
+	out := make([]txstore.Tombstone, len(tombs))
+	for i, tomb := range tombs {
+		out[i] = *tomb
+	}
+	return out, nil
+}
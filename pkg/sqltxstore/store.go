@@ -0,0 +1,420 @@
+// Package sqltxstore is a transaction store on top of database/sql, so stored transactions can be queried with SQL.
+//
+// The schema is created and upgraded by versioned migrations when the store is created. Writes are buffered and
+// inserted in batches, every batch in one database transaction. The package registers no drivers,
+// import one for the dialect, e.g. modernc.org/sqlite or github.com/jackc/pgx/v5/stdlib.
+//
+// Rollback after a reorg records every rolled back transaction and reward in the tombstones table, once per address,
+// and deletes them or, for transactions, sets their orphaned column in the same database transaction.
+package sqltxstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	options struct {
+		batchSize      int
+		checkpointName string
+		migrate        bool
+		rollbackMode   txstore.RollbackMode
+		now            func() time.Time
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithBatchSize sets number of buffered transactions and rewards that triggers insert, 500 by default.
+// Buffer is also written before reads and with every checkpoint.
+func WithBatchSize(size int) Option {
+	return func(opts *options) {
+		opts.batchSize = size
+	}
+}
+
+// WithCheckpointName sets name the checkpoint is saved under, so that several subscribers can share a database
+func WithCheckpointName(name string) Option {
+	return func(opts *options) {
+		opts.checkpointName = name
+	}
+}
+
+// WithoutMigrations skips schema migrations, for databases managed by a migration tool of their own
+func WithoutMigrations() Option {
+	return func(opts *options) {
+		opts.migrate = false
+	}
+}
+
+// WithRollbackMode sets what Rollback does with transactions, txstore.RollbackRemove by default
+func WithRollbackMode(mode txstore.RollbackMode) Option {
+	return func(opts *options) {
+		opts.rollbackMode = mode
+	}
+}
+
+// WithClock replaces time.Now for tombstones
+func WithClock(now func() time.Time) Option {
+	return func(opts *options) {
+		opts.now = now
+	}
+}
+
+var (
+	txColumns = []string{"hash", "chain_id", "block_hash", "block_number", "tx_index", "from_address", "to_address",
+		"value", "gas", "gas_price", "nonce", "tx_type", "input", "orphaned", "raw"}
+	addressColumns = []string{"address", "hash", "block_number", "tx_index"}
+	blockColumns   = []string{"hash", "number", "chain_id", "timestamp", "fee_recipient", "base_fee_per_gas", "gas_used",
+		"burnt_fees", "priority_fees", "tx_count", "from_receipts", "raw"}
+	tombstoneColumns = []string{"address", "kind", "hash", "block_number", "from_block", "flagged", "removed_at", "raw"}
+)
+
+type Store struct {
+	db      *sql.DB
+	ownsDB  bool
+	dialect Dialect
+
+	lock sync.Mutex
+	// pending transactions and rewards by hash, order keeps insert order
+	pendingTxs     map[types.EthHash]*types.Transaction
+	pendingRewards map[types.EthHash]*types.ProposedBlockReward
+	order          []types.EthHash
+	rewardOrder    []types.EthHash
+	options
+}
+
+// New creates store on the database and migrates its schema, db is left open on Close
+func New(db *sql.DB, dialect Dialect, opts ...Option) (*Store, error) {
+	out := &Store{
+		db:             db,
+		dialect:        dialect,
+		pendingTxs:     map[types.EthHash]*types.Transaction{},
+		pendingRewards: map[types.EthHash]*types.ProposedBlockReward{},
+		options: options{
+			batchSize:      500,
+			checkpointName: "default",
+			migrate:        true,
+			now:            time.Now,
+		},
+	}
+	out.options.apply(opts...)
+	if out.migrate {
+		if err := migrate(context.Background(), db, dialect); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Open opens database with the driver and creates store on it, dialect is picked by the driver name
+func Open(driver, dsn string, opts ...Option) (*Store, error) {
+	dialect, err := ParseDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	out, err := New(db, dialect, opts...)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	out.ownsDB = true
+	return out, nil
+}
+
+func hashString(h types.EthHash) string {
+	return "0x" + hex.EncodeToString(h[:])
+}
+
+func bigString(b *types.BigInt) any {
+	if b == nil {
+		return nil
+	}
+	return b.AsBigInt().String()
+}
+
+// StoreTransaction buffers the transaction, it is inserted with the batch.
+// Transaction with the same hash replaces the stored one, e.g. when it is re-included into another block.
+func (s *Store) StoreTransaction(tx *types.Transaction) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pendingTxs[tx.Hash]; !ok {
+		s.order = append(s.order, tx.Hash)
+	}
+	s.pendingTxs[tx.Hash] = tx
+	return s.flushIfFull()
+}
+
+// StoreBlockReward buffers the reward, reward of the same block is stored once
+func (s *Store) StoreBlockReward(reward *types.ProposedBlockReward) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pendingRewards[reward.BlockHash]; !ok {
+		s.pendingRewards[reward.BlockHash] = reward
+		s.rewardOrder = append(s.rewardOrder, reward.BlockHash)
+	}
+	return s.flushIfFull()
+}
+
+func (s *Store) flushIfFull() error {
+	if len(s.pendingTxs)+len(s.pendingRewards) < s.batchSize {
+		return nil
+	}
+	return s.flush(context.Background(), nil)
+}
+
+// Flush inserts buffered transactions and rewards
+func (s *Store) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush(context.Background(), nil)
+}
+
+// flush inserts the buffer in one database transaction, then runs extra in it. Buffer is kept if anything fails.
+func (s *Store) flush(ctx context.Context, extra func(tx *sql.Tx) error) error {
+	if len(s.pendingTxs) == 0 && len(s.pendingRewards) == 0 && extra == nil {
+		return nil
+	}
+	dbTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer dbTx.Rollback()
+
+	txRows := make([][]any, 0, len(s.pendingTxs))
+	addressRows := make([][]any, 0, len(s.pendingTxs)*2)
+	for _, hash := range s.order {
+		tx := s.pendingTxs[hash]
+		row, err := txRow(tx)
+		if err != nil {
+			return err
+		}
+		txRows = append(txRows, row)
+		blockNumber, index := tx.BlockNumber.AsBigInt().Int64(), tx.TransactionIndex.AsBigInt().Int64()
+		addressRows = append(addressRows, []any{tx.To.String(), hashString(tx.Hash), blockNumber, index})
+		if tx.From != tx.To {
+			addressRows = append(addressRows, []any{tx.From.String(), hashString(tx.Hash), blockNumber, index})
+		}
+	}
+	if err = s.insert(ctx, dbTx, "transactions", txColumns, txRows, "hash"); err != nil {
+		return err
+	}
+	if err = s.insert(ctx, dbTx, "address_transactions", addressColumns, addressRows, "address", "hash"); err != nil {
+		return err
+	}
+
+	rewardRows := make([][]any, 0, len(s.pendingRewards))
+	for _, hash := range s.rewardOrder {
+		reward := s.pendingRewards[hash]
+		raw, err := json.Marshal(reward)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode block reward")
+		}
+		rewardRows = append(rewardRows, []any{
+			hashString(reward.BlockHash), reward.BlockNumber.AsBigInt().Int64(), bigString(reward.ChainID),
+			reward.Timestamp.AsBigInt().Int64(), reward.FeeRecipient.String(), bigString(&reward.BaseFeePerGas),
+			bigString(&reward.GasUsed), bigString(&reward.BurntFees), bigString(&reward.PriorityFees),
+			reward.TxCount, reward.FromReceipts, string(raw),
+		})
+	}
+	if err = s.insert(ctx, dbTx, "blocks", blockColumns, rewardRows); err != nil {
+		return err
+	}
+
+	if extra != nil {
+		if err = extra(dbTx); err != nil {
+			return err
+		}
+	}
+	if err = dbTx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	clear(s.pendingTxs)
+	clear(s.pendingRewards)
+	s.order = s.order[:0]
+	s.rewardOrder = s.rewardOrder[:0]
+	return nil
+}
+
+// txRow returns values of txColumns for the transaction
+func txRow(tx *types.Transaction) ([]any, error) {
+	raw, err := json.Marshal(tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode transaction")
+	}
+	return []any{
+		hashString(tx.Hash), bigString(tx.ChainID), hashString(tx.BlockHash), tx.BlockNumber.AsBigInt().Int64(),
+		tx.TransactionIndex.AsBigInt().Int64(), tx.From.String(), tx.To.String(), bigString(&tx.Value),
+		bigString(&tx.Gas), bigString(&tx.GasPrice), bigString(&tx.Nonce), tx.Type.AsBigInt().Int64(),
+		"0x" + hex.EncodeToString(tx.Input), tx.Orphaned, string(raw),
+	}, nil
+}
+
+// insert writes rows with multi-row INSERT statements, as many rows per statement as the dialect allows.
+// Rows conflicting on the key are updated, or skipped when there is no key.
+func (s *Store) insert(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]any, key ...string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	conflict := " ON CONFLICT DO NOTHING"
+	if len(key) != 0 {
+		var set []string
+		for _, column := range columns {
+			if !slices.Contains(key, column) {
+				set = append(set, column+" = excluded."+column)
+			}
+		}
+		conflict = " ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+	}
+	perStatement := max(s.dialect.maxParams/len(columns), 1)
+	for start := 0; start < len(rows); start += perStatement {
+		chunk := rows[start:min(start+perStatement, len(rows))]
+		args := make([]any, 0, len(chunk)*len(columns))
+		for _, row := range chunk {
+			args = append(args, row...)
+		}
+		query := "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES " +
+			s.dialect.values(len(chunk), len(columns)) + conflict
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "failed to insert into %s", table)
+		}
+	}
+	return nil
+}
+
+func (s *Store) queryTxs(query string, args ...any) ([]*types.Transaction, error) {
+	ctx := context.Background()
+	s.lock.Lock()
+	err := s.flush(ctx, nil)
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query transactions")
+	}
+	defer rows.Close()
+	var out []*types.Transaction
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, errors.Wrap(err, "failed to read transaction")
+		}
+		tx := &types.Transaction{}
+		if err = json.Unmarshal(raw, tx); err != nil {
+			return nil, errors.Wrap(err, "failed to decode transaction")
+		}
+		out = append(out, tx)
+	}
+	return out, errors.Wrap(rows.Err(), "failed to read transactions")
+}
+
+const addressTxsQuery = `SELECT t.raw FROM address_transactions a JOIN transactions t ON t.hash = a.hash WHERE a.address = ?`
+
+// GetTransactions returns transactions sent from or to the address, ordered by block number, transaction index and hash
+func (s *Store) GetTransactions(address string) ([]*types.Transaction, error) {
+	return s.queryTxs(addressTxsQuery+` ORDER BY a.block_number, a.tx_index, a.hash`, address)
+}
+
+// GetTransactionsAfterBlock returns transactions of the address from blocks above blkId, in the same order as GetTransactions
+func (s *Store) GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error) {
+	if !blkId.IsInt64() {
+		if blkId.Sign() < 0 {
+			return s.GetTransactions(address)
+		}
+		return nil, nil
+	}
+	return s.queryTxs(addressTxsQuery+` AND a.block_number > ? ORDER BY a.block_number, a.tx_index, a.hash`, address, blkId.Int64())
+}
+
+// GetBlockRewards returns rewards of the fee recipient ordered by block number
+func (s *Store) GetBlockRewards(address string) ([]*types.ProposedBlockReward, error) {
+	ctx := context.Background()
+	s.lock.Lock()
+	err := s.flush(ctx, nil)
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT raw FROM blocks WHERE fee_recipient = ? ORDER BY number`), address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query block rewards")
+	}
+	defer rows.Close()
+	var out []*types.ProposedBlockReward
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, errors.Wrap(err, "failed to read block reward")
+		}
+		reward := &types.ProposedBlockReward{}
+		if err = json.Unmarshal(raw, reward); err != nil {
+			return nil, errors.Wrap(err, "failed to decode block reward")
+		}
+		out = append(out, reward)
+	}
+	return out, errors.Wrap(rows.Err(), "failed to read block rewards")
+}
+
+// SaveCheckpoint inserts the buffer and records the block in the same database transaction
+func (s *Store) SaveCheckpoint(blkId big.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush(context.Background(), func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind(`INSERT INTO checkpoints (name, block_number) VALUES (?, ?) `+
+			`ON CONFLICT (name) DO UPDATE SET block_number = excluded.block_number`), s.checkpointName, blkId.String())
+		return errors.Wrap(err, "failed to save checkpoint")
+	})
+}
+
+// GetCheckpoint returns the last saved checkpoint, nil if there is none
+func (s *Store) GetCheckpoint() (*big.Int, error) {
+	var value string
+	err := s.db.QueryRow(s.dialect.rebind(`SELECT block_number FROM checkpoints WHERE name = ?`), s.checkpointName).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	out, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid checkpoint %q", value)
+	}
+	return out, nil
+}
+
+// Close inserts the buffer, database is closed only if the store opened it
+func (s *Store) Close() error {
+	err := s.Flush()
+	if s.ownsDB {
+		if closeErr := s.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,520 @@
+package sqltxstore_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/sqltxstore"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/txstore/storetest"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"maps"
+	"math/big"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB understands just enough of the statements the store runs, no SQL driver is vendored
+type fakeDB struct {
+	lock       sync.Mutex
+	statements []string
+	failOn     string
+	versions   []int64
+	raws       map[string]string
+	addresses  map[[2]string][2]int64
+	rewards    map[string][2]any
+	checkpoint map[string]string
+	// tombstones are address and raw columns
+	tombstones [][2]string
+}
+
+// snapshot copies the data, so that rolled back transaction can restore it
+func (db *fakeDB) snapshot() *fakeDB {
+	return &fakeDB{
+		versions:   slices.Clone(db.versions),
+		raws:       maps.Clone(db.raws),
+		addresses:  maps.Clone(db.addresses),
+		rewards:    maps.Clone(db.rewards),
+		checkpoint: maps.Clone(db.checkpoint),
+		tombstones: slices.Clone(db.tombstones),
+	}
+}
+
+func (db *fakeDB) restore(from *fakeDB) {
+	db.versions, db.raws, db.addresses, db.rewards, db.checkpoint, db.tombstones =
+		from.versions, from.raws, from.addresses, from.rewards, from.checkpoint, from.tombstones
+}
+
+// decoded returns stored transactions
+func (db *fakeDB) decoded() []*types.Transaction {
+	var out []*types.Transaction
+	for _, raw := range db.raws {
+		tx := &types.Transaction{}
+		if err := json.Unmarshal([]byte(raw), tx); err != nil {
+			panic(err)
+		}
+		out = append(out, tx)
+	}
+	return out
+}
+
+func rewardNumber(reward [2]any) int64 {
+	decoded := &types.ProposedBlockReward{}
+	if err := json.Unmarshal([]byte(reward[1].(string)), decoded); err != nil {
+		panic(err)
+	}
+	return decoded.BlockNumber.AsBigInt().Int64()
+}
+
+var (
+	fakeDBs   sync.Map
+	fakeDBNum atomic.Int64
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, errors.Errorf("no database %s", name)
+	}
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	name := fmt.Sprintf("db%d", fakeDBNum.Add(1))
+	out := &fakeDB{raws: map[string]string{}, addresses: map[[2]string][2]int64{}, rewards: map[string][2]any{}, checkpoint: map[string]string{}}
+	fakeDBs.Store(name, out)
+	db, err := sql.Open("fake", name)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return out, db
+}
+
+type fakeConn struct {
+	db *fakeDB
+	// backup is data before the open transaction, changes are applied right away and undone on rollback
+	backup *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	c.backup = c.db.snapshot()
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.backup = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	if c.backup != nil {
+		c.db.restore(c.backup)
+	}
+	c.backup = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+var (
+	insertRe = regexp.MustCompile(`^INSERT INTO (\w+) \(([^)]*)\)`)
+	deleteRe = regexp.MustCompile(`^DELETE FROM (\w+) WHERE`)
+)
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.statements = append(db.statements, s.query)
+	if db.failOn != "" && strings.Contains(s.query, db.failOn) {
+		return nil, errors.New("injected failure")
+	}
+
+	if m := deleteRe.FindStringSubmatch(s.query); m != nil {
+		above := args[0].(int64)
+		switch m[1] {
+		case "transactions":
+			for _, tx := range db.decoded() {
+				if tx.BlockNumber.AsBigInt().Int64() > above {
+					delete(db.raws, "0x"+hex.EncodeToString(tx.Hash[:]))
+				}
+			}
+		case "address_transactions":
+			maps.DeleteFunc(db.addresses, func(_ [2]string, position [2]int64) bool { return position[0] > above })
+		case "blocks":
+			maps.DeleteFunc(db.rewards, func(_ string, reward [2]any) bool { return rewardNumber(reward) > above })
+		}
+	}
+	if m := insertRe.FindStringSubmatch(s.query); m != nil {
+		columns := len(strings.Split(m[2], ","))
+		for row := 0; row+columns <= len(args); row += columns {
+			v := args[row : row+columns]
+			switch m[1] {
+			case "schema_migrations":
+				db.versions = append(db.versions, v[0].(int64))
+			case "transactions":
+				db.raws[v[0].(string)] = v[columns-1].(string)
+			case "address_transactions":
+				db.addresses[[2]string{v[0].(string), v[1].(string)}] = [2]int64{v[2].(int64), v[3].(int64)}
+			case "blocks":
+				if _, ok := db.rewards[v[0].(string)]; !ok {
+					db.rewards[v[0].(string)] = [2]any{v[4], v[columns-1]}
+				}
+			case "checkpoints":
+				db.checkpoint[v[0].(string)] = v[1].(string)
+			case "tombstones":
+				db.tombstones = append(db.tombstones, [2]string{v[0].(string), v[columns-1].(string)})
+			}
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.statements = append(db.statements, s.query)
+	out := &fakeRows{}
+	switch {
+	case strings.Contains(s.query, "FROM schema_migrations"):
+		for _, version := range db.versions {
+			out.rows = append(out.rows, []driver.Value{version})
+		}
+	case strings.Contains(s.query, "FROM checkpoints"):
+		if value, ok := db.checkpoint[args[0].(string)]; ok {
+			out.rows = append(out.rows, []driver.Value{value})
+		}
+	case strings.Contains(s.query, "FROM blocks WHERE fee_recipient"):
+		for _, reward := range db.rewards {
+			if reward[0] == args[0] {
+				out.rows = append(out.rows, []driver.Value{[]byte(reward[1].(string))})
+			}
+		}
+	case strings.Contains(s.query, "FROM blocks WHERE number >"):
+		var rewards [][2]any
+		for _, reward := range db.rewards {
+			if rewardNumber(reward) > args[0].(int64) {
+				rewards = append(rewards, reward)
+			}
+		}
+		sort.Slice(rewards, func(i, j int) bool { return rewardNumber(rewards[i]) < rewardNumber(rewards[j]) })
+		for _, reward := range rewards {
+			out.rows = append(out.rows, []driver.Value{[]byte(reward[1].(string))})
+		}
+	case strings.Contains(s.query, "SELECT number FROM blocks WHERE hash"):
+		if reward, ok := db.rewards[args[0].(string)]; ok {
+			out.rows = append(out.rows, []driver.Value{rewardNumber(reward)})
+		}
+	case strings.Contains(s.query, "FROM transactions WHERE block_hash"):
+		for _, tx := range db.decoded() {
+			if "0x"+hex.EncodeToString(tx.BlockHash[:]) == args[0] {
+				out.rows = append(out.rows, []driver.Value{tx.BlockNumber.AsBigInt().Int64()})
+				break
+			}
+		}
+	case strings.Contains(s.query, "FROM transactions WHERE block_number >"):
+		var txs []*types.Transaction
+		for _, tx := range db.decoded() {
+			if tx.BlockNumber.AsBigInt().Int64() > args[0].(int64) && !tx.Orphaned {
+				txs = append(txs, tx)
+			}
+		}
+		sort.Slice(txs, func(i, j int) bool { return txstore.PositionOf(txs[i]).Less(txstore.PositionOf(txs[j])) })
+		for _, tx := range txs {
+			out.rows = append(out.rows, []driver.Value{[]byte(db.raws["0x"+hex.EncodeToString(tx.Hash[:])])})
+		}
+	case strings.Contains(s.query, "FROM tombstones"):
+		for _, tomb := range db.tombstones {
+			if tomb[0] == args[0] {
+				out.rows = append(out.rows, []driver.Value{[]byte(tomb[1])})
+			}
+		}
+	case strings.Contains(s.query, "FROM address_transactions"):
+		type ref struct {
+			hash     string
+			position [2]int64
+		}
+		var refs []ref
+		for key, position := range db.addresses {
+			if key[0] == args[0] && (len(args) == 1 || position[0] > args[1].(int64)) {
+				refs = append(refs, ref{hash: key[1], position: position})
+			}
+		}
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].position[0] != refs[j].position[0] {
+				return refs[i].position[0] < refs[j].position[0]
+			}
+			return refs[i].position[1] < refs[j].position[1]
+		})
+		for _, r := range refs {
+			out.rows = append(out.rows, []driver.Value{[]byte(db.raws[r.hash])})
+		}
+	default:
+		return nil, errors.Errorf("unexpected query %s", s.query)
+	}
+	return out, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (db *fakeDB) executed(prefix string) []string {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	var out []string
+	for _, statement := range db.statements {
+		if strings.HasPrefix(statement, prefix) {
+			out = append(out, statement)
+		}
+	}
+	return out
+}
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+)
+
+func newTx(n byte, block, index int64) *types.Transaction {
+	return &types.Transaction{
+		Hash:             types.EthHash{n},
+		BlockNumber:      types.BigInt(*big.NewInt(block)),
+		TransactionIndex: types.BigInt(*big.NewInt(index)),
+		From:             alice,
+		To:               bob,
+		Value:            types.BigInt(*big.NewInt(int64(n) * 1000)),
+	}
+}
+
+func hashes(txs []*types.Transaction, err error) []types.EthHash {
+	if err != nil {
+		panic(err)
+	}
+	var out []types.EthHash
+	for _, tx := range txs {
+		out = append(out, tx.Hash)
+	}
+	return out
+}
+
+func TestMigrations(t *testing.T) {
+	for _, dialect := range []sqltxstore.Dialect{sqltxstore.SQLite, sqltxstore.Postgres} {
+		t.Run(dialect.Name, func(t *testing.T) {
+			fake, db := newFakeDB(t)
+			_, err := sqltxstore.New(db, dialect)
+			require.NoError(t, err)
+			created := fake.executed("CREATE TABLE ")
+			require.Len(t, created, 6)
+			assert.Contains(t, created[0], "schema_migrations")
+			assert.Equal(t, []int64{1, 2}, fake.versions)
+			assert.Len(t, fake.executed("ALTER TABLE transactions ADD COLUMN orphaned"), 1)
+			if dialect.Name == "postgres" {
+				assert.Contains(t, created[1], "value NUMERIC(78) NOT NULL")
+				assert.Contains(t, created[1], "raw JSONB NOT NULL")
+				assert.Contains(t, created[5], "id BIGSERIAL PRIMARY KEY")
+			} else {
+				assert.Contains(t, created[1], "value TEXT NOT NULL")
+				assert.Contains(t, created[5], "id INTEGER PRIMARY KEY AUTOINCREMENT")
+			}
+
+			// Applied migrations are not run again
+			_, err = sqltxstore.New(db, dialect)
+			require.NoError(t, err)
+			assert.Len(t, fake.executed("CREATE TABLE "), 7)
+			assert.Len(t, fake.versions, 2)
+
+			// Schema of a newer release is refused
+			fake.versions = append(fake.versions, int64(sqltxstore.SchemaVersion()+1))
+			_, err = sqltxstore.New(db, dialect)
+			assert.ErrorContains(t, err, "newer than supported")
+		})
+	}
+
+	_, err := sqltxstore.ParseDialect("pgx")
+	assert.NoError(t, err)
+	_, err = sqltxstore.ParseDialect("mysql")
+	assert.ErrorContains(t, err, "sqlite, postgres")
+}
+
+func TestStore(t *testing.T) {
+	fake, db := newFakeDB(t)
+	store, err := sqltxstore.New(db, sqltxstore.Postgres, sqltxstore.WithBatchSize(3))
+	require.NoError(t, err)
+
+	b5i1, b3i0, b5i0 := newTx(1, 5, 1), newTx(2, 3, 0), newTx(3, 5, 0)
+	for _, tx := range []*types.Transaction{b5i1, b3i0, b3i0} {
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	assert.Empty(t, fake.executed("INSERT INTO transactions"))
+	// Third buffered transaction fills the batch
+	require.NoError(t, store.StoreTransaction(b5i0))
+	inserts := fake.executed("INSERT INTO transactions")
+	require.Len(t, inserts, 1)
+	assert.Contains(t, inserts[0], "VALUES ($1, $2, ")
+	assert.Contains(t, inserts[0], "$45) ON CONFLICT (hash) DO UPDATE SET chain_id = excluded.chain_id")
+
+	// Reads see buffered writes
+	b7i2 := newTx(4, 7, 2)
+	require.NoError(t, store.StoreTransaction(b7i2))
+	assert.Equal(t, []types.EthHash{{2}, {3}, {1}, {4}}, hashes(store.GetTransactions(alice.String())))
+	assert.Equal(t, []types.EthHash{{3}, {1}, {4}}, hashes(store.GetTransactionsAfterBlock(*big.NewInt(4), bob.String())))
+	assert.Nil(t, hashes(store.GetTransactionsAfterBlock(*new(big.Int).Lsh(big.NewInt(1), 70), bob.String())))
+
+	reward := &types.ProposedBlockReward{BlockHash: types.EthHash{0xff}, BlockNumber: types.BigInt(*big.NewInt(7)), FeeRecipient: alice}
+	require.NoError(t, store.StoreBlockReward(reward))
+	require.NoError(t, store.StoreBlockReward(reward))
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	require.Len(t, rewards, 1)
+	assert.Equal(t, reward.BlockHash, rewards[0].BlockHash)
+}
+
+func TestCheckpoint(t *testing.T) {
+	fake, db := newFakeDB(t)
+	store, err := sqltxstore.New(db, sqltxstore.SQLite)
+	require.NoError(t, err)
+	checkpoint, err := store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	// Failed batch is kept and checkpoint is not moved
+	require.NoError(t, store.StoreTransaction(newTx(1, 1, 0)))
+	fake.failOn = "INSERT INTO address_transactions"
+	assert.Error(t, store.SaveCheckpoint(*big.NewInt(1)))
+	assert.Empty(t, fake.raws)
+	checkpoint, err = store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	fake.failOn = ""
+	require.NoError(t, store.SaveCheckpoint(*big.NewInt(1)))
+	assert.Len(t, fake.raws, 1)
+	checkpoint, err = store.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), checkpoint)
+
+	// Stores sharing database keep their own checkpoints
+	other, err := sqltxstore.New(db, sqltxstore.SQLite, sqltxstore.WithCheckpointName("other"))
+	require.NoError(t, err)
+	checkpoint, err = other.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+}
+
+func TestRollback(t *testing.T) {
+	for _, mode := range []txstore.RollbackMode{txstore.RollbackRemove, txstore.RollbackFlag} {
+		fake, db := newFakeDB(t)
+		store, err := sqltxstore.New(db, sqltxstore.SQLite, sqltxstore.WithRollbackMode(mode))
+		require.NoError(t, err)
+		for n := range 4 {
+			tx := newTx(byte(n+1), int64(n+1), 0)
+			tx.BlockHash = types.EthHash{0xff, byte(n + 1)}
+			require.NoError(t, store.StoreTransaction(tx))
+			reward := &types.ProposedBlockReward{BlockHash: tx.BlockHash, BlockNumber: tx.BlockNumber, FeeRecipient: alice}
+			require.NoError(t, store.StoreBlockReward(reward))
+		}
+
+		// Buffered block is rolled back with the stored ones
+		removed, err := store.RollbackBlock(types.EthHash{0xff, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		removed, err = store.Rollback(*big.NewInt(2))
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		removed, err = store.RollbackBlock(types.EthHash{0xff, 9})
+		require.NoError(t, err)
+		assert.Zero(t, removed)
+
+		txs, err := store.GetTransactions(bob.String())
+		if mode == txstore.RollbackFlag {
+			require.Equal(t, []types.EthHash{{1}, {2}, {3}, {4}}, hashes(txs, err))
+			assert.Equal(t, []bool{false, false, true, true}, []bool{txs[0].Orphaned, txs[1].Orphaned, txs[2].Orphaned, txs[3].Orphaned})
+			// Flagged transactions are not rolled back again
+			removed, err = store.Rollback(*big.NewInt(2))
+			require.NoError(t, err)
+			assert.Zero(t, removed)
+		} else {
+			assert.Equal(t, []types.EthHash{{1}, {2}}, hashes(txs, err))
+		}
+		rewards, err := store.GetBlockRewards(alice.String())
+		require.NoError(t, err)
+		assert.Len(t, rewards, 2)
+
+		tombs, err := store.Tombstones(bob.String())
+		require.NoError(t, err)
+		require.Len(t, tombs, 2)
+		assert.Equal(t, types.EthHash{4}, tombs[0].Transaction.Hash)
+		assert.Equal(t, int64(4), tombs[0].From.AsBigInt().Int64())
+		assert.Equal(t, types.EthHash{3}, tombs[1].Transaction.Hash)
+		assert.Equal(t, mode == txstore.RollbackFlag, tombs[1].Flagged)
+		tombs, err = store.Tombstones(alice.String())
+		require.NoError(t, err)
+		assert.Len(t, tombs, 4)
+
+		// Failed rollback leaves everything in place
+		fake.failOn = "DELETE FROM blocks"
+		_, err = store.Rollback(*big.NewInt(0))
+		assert.Error(t, err)
+		fake.failOn = ""
+		tombs, err = store.Tombstones(alice.String())
+		require.NoError(t, err)
+		assert.Len(t, tombs, 4)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for name, mode := range map[string]txstore.RollbackMode{"Remove": txstore.RollbackRemove, "Flag": txstore.RollbackFlag} {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) txstore.Store {
+				_, db := newFakeDB(t)
+				store, err := sqltxstore.New(db, sqltxstore.SQLite, sqltxstore.WithBatchSize(7), sqltxstore.WithRollbackMode(mode))
+				require.NoError(t, err)
+				return store
+			})
+		})
+	}
+}
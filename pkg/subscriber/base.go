@@ -2,6 +2,10 @@ package subscriber
 
 import (
 	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
 	"math/big"
 	"net/http"
 	"time"
@@ -9,6 +13,41 @@ import (
 
 type Option blksubscriber.Option
 
+type receiptSource interface {
+	GetBlockReceipts(blockNum *big.Int) ([]*types.Receipt, error)
+}
+
+// receiptsOf returns source as receipt getter if it can provide receipts, nil otherwise
+func receiptsOf(source blksubscriber.BlockSource[types.BlockDetailed]) receiptSource {
+	if receipts, ok := source.(receiptSource); ok {
+		return receipts
+	}
+	return nil
+}
+
+type rateLimitedSource interface {
+	RateLimitStats() (rpc.RateLimitStats, bool)
+}
+
+func rateLimitStatsOf(source blksubscriber.BlockSource[types.BlockDetailed]) (rpc.RateLimitStats, bool) {
+	if limited, ok := source.(rateLimitedSource); ok {
+		return limited.RateLimitStats()
+	}
+	return rpc.RateLimitStats{}, false
+}
+
+type finalitySource interface {
+	Finality() (head, safe, finalized *big.Int)
+}
+
+func finalityOf(source blksubscriber.BlockSource[types.BlockDetailed]) txstore.Finality {
+	if tracked, ok := source.(finalitySource); ok {
+		head, safe, finalized := tracked.Finality()
+		return txstore.Finality{Head: head, Safe: safe, Finalized: finalized}
+	}
+	return txstore.Finality{}
+}
+
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
@@ -17,6 +56,67 @@ func WithHTTPClient(cl httpClient) Option {
 	return Option(blksubscriber.WithHTTPClient(cl))
 }
 
+func WithHeader(name, value string) Option {
+	return Option(blksubscriber.WithHeader(name, value))
+}
+
+func WithBasicAuth(username, password string) Option {
+	return Option(blksubscriber.WithBasicAuth(username, password))
+}
+
+func WithJWTSecret(secret []byte) Option {
+	return Option(blksubscriber.WithJWTSecret(secret))
+}
+
+func WithSensitiveHeaders(names ...string) Option {
+	return Option(blksubscriber.WithSensitiveHeaders(names...))
+}
+
+func WithSecret(secrets ...string) Option {
+	return Option(blksubscriber.WithSecret(secrets...))
+}
+
+func WithDebugLog(w io.Writer) Option {
+	return Option(blksubscriber.WithDebugLog(w))
+}
+
+func WithNetworkConfig(cfg rpc.NetworkConfig) Option {
+	return Option(blksubscriber.WithNetworkConfig(cfg))
+}
+
+func WithCABundle(path string) Option {
+	return Option(blksubscriber.WithCABundle(path))
+}
+
+func WithClientCert(certFile, keyFile string) Option {
+	return Option(blksubscriber.WithClientCert(certFile, keyFile))
+}
+
+func WithInsecureSkipVerify(skip bool) Option {
+	return Option(blksubscriber.WithInsecureSkipVerify(skip))
+}
+
+func WithProxy(proxyURL string) Option {
+	return Option(blksubscriber.WithProxy(proxyURL))
+}
+
+func WithRequestTimeout(timeout time.Duration) Option {
+	return Option(blksubscriber.WithRequestTimeout(timeout))
+}
+
+func WithMaxIdleConns(n int) Option {
+	return Option(blksubscriber.WithMaxIdleConns(n))
+}
+
+func WithRateLimiter(l *rpc.Limiter) Option {
+	return Option(blksubscriber.WithRateLimiter(l))
+}
+
+// WithChainID makes Start fail when the endpoint serves another chain
+func WithChainID(id *big.Int) Option {
+	return Option(blksubscriber.WithChainID(id))
+}
+
 func WithPoolingPeriod(period time.Duration) Option {
 	return Option(blksubscriber.WithPoolingPeriod(period))
 }
@@ -29,6 +129,21 @@ func WithEndBlock(blkId *big.Int) Option {
 	return Option(blksubscriber.WithEndBlock(blkId))
 }
 
+// WithReorgDepth makes the subscriber follow reorgs up to depth blocks deep, see blksubscriber.WithReorgDepth
+func WithReorgDepth(depth int) Option {
+	return Option(blksubscriber.WithReorgDepth(depth))
+}
+
+// WithFinalityDepth makes blocks that deep below the head count as finalized without tags, see blksubscriber.WithFinalityDepth
+func WithFinalityDepth(depth int) Option {
+	return Option(blksubscriber.WithFinalityDepth(depth))
+}
+
+// WithFinalityTags makes the subscriber read blocks tagged safe and finalized, see blksubscriber.WithFinalityTags
+func WithFinalityTags(enabled bool) Option {
+	return Option(blksubscriber.WithFinalityTags(enabled))
+}
+
 func convOptions(in []Option) []blksubscriber.Option {
 	out := make([]blksubscriber.Option, len(in))
 	for i, opt := range in {
@@ -3,6 +3,7 @@ package subscriber
 import (
 	"github.com/dkropachev/ethscan/pkg/blksubscriber"
 	processors2 "github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/rpc"
 	"github.com/dkropachev/ethscan/pkg/types"
 	"math/big"
 
@@ -10,7 +11,8 @@ import (
 )
 
 type ChanSubscriber struct {
-	blkSub       *blksubscriber.Subscriber[types.BlockDetailed]
+	blkSub       blksubscriber.BlockSource[types.BlockDetailed]
+	blockReward  *processors2.BlockReward
 	walletFilter *processors2.TxWalletFilter
 }
 
@@ -19,17 +21,35 @@ func NewChanSubscriber(endpoint string, opts ...Option) (*ChanSubscriber, error)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create block subscriber")
 	}
+	return NewChanSubscriberFromSource(blkSub), nil
+}
 
+// NewChanSubscriberFromSource creates subscriber that reads blocks from any source, e.g. replay.Source.
+// Proposer priority fees are taken from receipts when source provides them, otherwise they are estimated.
+func NewChanSubscriberFromSource(blkSub blksubscriber.BlockSource[types.BlockDetailed]) *ChanSubscriber {
+	blockReward := processors2.NewBlockRewardProcessor(blkSub.GetBlockChan(), receiptsOf(blkSub))
 	return &ChanSubscriber{
 		blkSub:       blkSub,
-		walletFilter: processors2.NewTxWalletFilter(processors2.NewBlockToTxProcessor(blkSub.GetBlockChan()).Out()),
-	}, nil
+		blockReward:  blockReward,
+		walletFilter: processors2.NewTxWalletFilter(processors2.NewBlockToTxProcessor(blockReward.Out()).Out()),
+	}
 }
 
 func (s *ChanSubscriber) Subscribe(address string) bool {
 	return s.walletFilter.AddWallet(address)
 }
 
+// SubscribeBlockRewards starts tracking blocks proposed to the address as a fee recipient.
+// Once called, GetBlockRewardChan has to be drained, otherwise block processing stalls.
+func (s *ChanSubscriber) SubscribeBlockRewards(address string) bool {
+	return s.blockReward.AddWallet(address)
+}
+
+// RateLimitStats returns compute units spent through the rate limiter, ok is false when no limiter is set
+func (s *ChanSubscriber) RateLimitStats() (rpc.RateLimitStats, bool) {
+	return rateLimitStatsOf(s.blkSub)
+}
+
 func (s *ChanSubscriber) GetCurrentBlock() big.Int {
 	return s.blkSub.GetCurrentBlock()
 }
@@ -46,6 +66,10 @@ func (s *ChanSubscriber) GetTransactionChan() <-chan *types.Transaction {
 	return s.walletFilter.Out()
 }
 
+func (s *ChanSubscriber) GetBlockRewardChan() <-chan *types.ProposedBlockReward {
+	return s.blockReward.Rewards()
+}
+
 func (s *ChanSubscriber) Stop() {
 	s.blkSub.Stop()
 }
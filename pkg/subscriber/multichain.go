@@ -0,0 +1,319 @@
+package subscriber
+
+import (
+	stderr "errors"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/chains"
+	processors2 "github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/synclist"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// MultiChainSubscriber follows several chains at once, one block source per chain.
+// Wallets subscribed with Subscribe are watched on every chain, SubscribeChain and ExcludeChain override that per chain.
+// Transactions and block rewards of all chains come out of the same channels, tagged with chain ID.
+type MultiChainSubscriber struct {
+	lock    sync.RWMutex
+	chains  []*chainPipeline
+	wallets synclist.ComparableList[string]
+	// rewardWallets are fee recipients, tracked on chains that pay block rewards to them
+	rewardWallets synclist.ComparableList[string]
+	started       atomic.Bool
+	txChan        chan *types.Transaction
+	rewardChan    chan *types.ProposedBlockReward
+}
+
+// ChainStatus is progress of one chain of MultiChainSubscriber
+type ChainStatus struct {
+	Chain   chains.Chain
+	Running bool
+	// CurrentBlock is number of the next block to be read
+	CurrentBlock big.Int
+	LastError    error
+}
+
+type chainPipeline struct {
+	chain       chains.Chain
+	chainID     *types.BigInt
+	source      blksubscriber.BlockSource[types.BlockDetailed]
+	blockReward *processors2.BlockReward
+	txs         *processors2.BlockToTx
+	startErr    atomic.Pointer[error]
+	// overrides take precedence over the shared watch list, true adds wallet on this chain, false excludes it
+	overridesLock sync.RWMutex
+	overrides     map[string]bool
+}
+
+func NewMultiChainSubscriber() *MultiChainSubscriber {
+	return &MultiChainSubscriber{
+		txChan:     make(chan *types.Transaction, 1000),
+		rewardChan: make(chan *types.ProposedBlockReward, 1000),
+	}
+}
+
+// AddChain subscribes to the endpoint, which has to serve the chain.
+// Polling period, reorg depth and finality depth default to ones of the chain, opts can override them.
+func (s *MultiChainSubscriber) AddChain(chain chains.Chain, endpoint string, opts ...Option) error {
+	base := []Option{WithChainID(chain.BigID())}
+	if chain.BlockTime != 0 {
+		base = append(base, WithPoolingPeriod(chain.BlockTime))
+	}
+	if chain.ReorgDepth != 0 {
+		base = append(base, WithReorgDepth(int(chain.ReorgDepth)))
+	}
+	if chain.FinalityDepth != 0 {
+		base = append(base, WithFinalityDepth(int(chain.FinalityDepth)))
+	}
+	blkSub, err := blksubscriber.New[types.BlockDetailed](endpoint, convOptions(append(base, opts...))...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create block subscriber for chain %s", chain)
+	}
+	return s.AddChainSource(chain, blkSub)
+}
+
+// AddChainSource reads blocks of the chain from any source, e.g. replay.Source.
+// Chains have to be added before Start, every chain at most once.
+func (s *MultiChainSubscriber) AddChainSource(chain chains.Chain, source blksubscriber.BlockSource[types.BlockDetailed]) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started.Load() {
+		return errors.Errorf("failed to add chain %s, subscriber is already started", chain)
+	}
+	for _, c := range s.chains {
+		if c.chain.ID == chain.ID {
+			return errors.Errorf("chain %s is already added", chain)
+		}
+	}
+	blockReward := processors2.NewBlockRewardProcessor(source.GetBlockChan(), receiptsOf(source))
+	c := &chainPipeline{
+		chain:       chain,
+		chainID:     (*types.BigInt)(chain.BigID()),
+		source:      source,
+		blockReward: blockReward,
+		txs:         processors2.NewBlockToTxProcessor(blockReward.Out()),
+		overrides:   map[string]bool{},
+	}
+	if c.paysRewards() {
+		for _, wallet := range s.rewardWallets.Get() {
+			blockReward.AddWallet(wallet)
+		}
+	}
+	s.chains = append(s.chains, c)
+	return nil
+}
+
+func (s *MultiChainSubscriber) getChain(chainID int64) *chainPipeline {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, c := range s.chains {
+		if c.chain.ID == chainID {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *MultiChainSubscriber) getChains() []*chainPipeline {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*chainPipeline(nil), s.chains...)
+}
+
+// Subscribe watches the wallet on every chain, except ones it is excluded from
+func (s *MultiChainSubscriber) Subscribe(address string) bool {
+	return s.wallets.AppendIfNotExists(address)
+}
+
+// SubscribeChain watches the wallet on one chain only, it returns false if the chain is not added
+func (s *MultiChainSubscriber) SubscribeChain(chainID int64, address string) bool {
+	return s.override(chainID, address, true)
+}
+
+// ExcludeChain stops watching the wallet on one chain, even if it is subscribed on all of them
+func (s *MultiChainSubscriber) ExcludeChain(chainID int64, address string) bool {
+	return s.override(chainID, address, false)
+}
+
+func (s *MultiChainSubscriber) override(chainID int64, address string, watch bool) bool {
+	c := s.getChain(chainID)
+	if c == nil {
+		return false
+	}
+	c.overridesLock.Lock()
+	defer c.overridesLock.Unlock()
+	c.overrides[address] = watch
+	return true
+}
+
+// SubscribeBlockRewards starts tracking blocks proposed to the address on chains that pay fee recipients.
+// Once called, GetBlockRewardChan has to be drained, otherwise block processing stalls.
+func (s *MultiChainSubscriber) SubscribeBlockRewards(address string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if !s.rewardWallets.AppendIfNotExists(address) {
+		return false
+	}
+	for _, c := range s.chains {
+		if c.paysRewards() {
+			c.blockReward.AddWallet(address)
+		}
+	}
+	return true
+}
+
+// paysRewards is false on chains where block miner is not a proposer, chains without preset are given the benefit of the doubt
+func (c *chainPipeline) paysRewards() bool {
+	return c.chain.Name == "" || c.chain.FeeRecipientRewards
+}
+
+func (c *chainPipeline) watches(shared *synclist.ComparableList[string], addresses ...string) bool {
+	c.overridesLock.RLock()
+	defer c.overridesLock.RUnlock()
+	for _, address := range addresses {
+		watch, ok := c.overrides[address]
+		if !ok {
+			watch = shared.Contains(address)
+		}
+		if watch {
+			return true
+		}
+	}
+	return false
+}
+
+// Start starts sources of all chains. Chains that fail to start are reported in the error and in their status,
+// the rest keep running, call Stop to give up on all of them.
+// Channels are closed once all started chains stop.
+func (s *MultiChainSubscriber) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started.Swap(true) {
+		return errors.New("subscriber is already started")
+	}
+	var (
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for _, c := range s.chains {
+		if err := c.source.Start(); err != nil {
+			err = errors.Wrapf(err, "failed to run block subscriber for chain %s", c.chain)
+			c.startErr.Store(&err)
+			errs = append(errs, err)
+			continue
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.forwardTransactions(c)
+		}()
+		go func() {
+			defer wg.Done()
+			s.forwardRewards(c)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(s.txChan)
+		close(s.rewardChan)
+	}()
+	return stderr.Join(errs...)
+}
+
+func (s *MultiChainSubscriber) forwardTransactions(c *chainPipeline) {
+	for tx := range c.txs.Out() {
+		if tx == nil {
+			return
+		}
+		if !c.watches(&s.wallets, tx.From.String(), tx.To.String()) {
+			continue
+		}
+		if tx.ChainID == nil {
+			tx.ChainID = c.chainID
+		}
+		s.txChan <- tx
+	}
+}
+
+func (s *MultiChainSubscriber) forwardRewards(c *chainPipeline) {
+	for reward := range c.blockReward.Rewards() {
+		if reward == nil {
+			return
+		}
+		if reward.ChainID == nil {
+			reward.ChainID = c.chainID
+		}
+		s.rewardChan <- reward
+	}
+}
+
+func (s *MultiChainSubscriber) Stop() {
+	for _, c := range s.getChains() {
+		c.source.Stop()
+	}
+}
+
+// IsRunning reports whether any chain is still running
+func (s *MultiChainSubscriber) IsRunning() bool {
+	for _, c := range s.getChains() {
+		if c.source.IsRunning() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns progress of every chain, in the order they were added
+func (s *MultiChainSubscriber) Status() []ChainStatus {
+	chainList := s.getChains()
+	out := make([]ChainStatus, 0, len(chainList))
+	for _, c := range chainList {
+		out = append(out, c.status())
+	}
+	return out
+}
+
+// ChainStatus returns progress of one chain, ok is false if the chain is not added
+func (s *MultiChainSubscriber) ChainStatus(chainID int64) (status ChainStatus, ok bool) {
+	c := s.getChain(chainID)
+	if c == nil {
+		return ChainStatus{}, false
+	}
+	return c.status(), true
+}
+
+func (c *chainPipeline) status() ChainStatus {
+	lastError := c.source.LastError()
+	if err := c.startErr.Load(); err != nil {
+		lastError = *err
+	}
+	return ChainStatus{
+		Chain:        c.chain,
+		Running:      c.source.IsRunning(),
+		CurrentBlock: c.source.GetCurrentBlock(),
+		LastError:    lastError,
+	}
+}
+
+// LastError joins errors of all chains, each prefixed with its chain
+func (s *MultiChainSubscriber) LastError() error {
+	var errs []error
+	for _, status := range s.Status() {
+		if status.LastError != nil {
+			errs = append(errs, errors.Wrapf(status.LastError, "chain %s", status.Chain))
+		}
+	}
+	return stderr.Join(errs...)
+}
+
+func (s *MultiChainSubscriber) GetTransactionChan() <-chan *types.Transaction {
+	return s.txChan
+}
+
+func (s *MultiChainSubscriber) GetBlockRewardChan() <-chan *types.ProposedBlockReward {
+	return s.rewardChan
+}
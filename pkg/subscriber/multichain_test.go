@@ -0,0 +1,172 @@
+package subscriber_test
+
+import (
+	"errors"
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/chains"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/subscriber"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func address(t *testing.T, val string) types.EthAddress {
+	var out types.EthAddress
+	require.NoError(t, out.UnmarshalJSON([]byte(`"`+val+`"`)))
+	return out
+}
+
+func TestMultiChainSubscriber(t *testing.T) {
+	t.Parallel()
+	shared := address(t, "0x00000000000000000000000000000000000000a1")
+	baseOnly := address(t, "0x00000000000000000000000000000000000000b2")
+	notOnMainnet := address(t, "0x00000000000000000000000000000000000000c3")
+	miner := address(t, "0x00000000000000000000000000000000000000d4")
+
+	newNode := func(chainID int64) *ethtest.Node {
+		node := ethtest.NewNode(t, ethtest.WithChainID(chainID))
+		node.SetMiner(miner)
+		node.AppendBlock(ethtest.Tx{To: shared, Value: 1, GasPrice: 2_000_000_000})
+		node.AppendBlock(ethtest.Tx{To: baseOnly, Value: 2, GasPrice: 2_000_000_000})
+		node.AppendBlock(ethtest.Tx{To: notOnMainnet, Value: 3, GasPrice: 2_000_000_000})
+		return node
+	}
+	mainnet, base, polygon := newNode(1), newNode(8453), newNode(10)
+
+	opts := []subscriber.Option{
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithEndBlock(big.NewInt(3)),
+		subscriber.WithPoolingPeriod(10 * time.Millisecond),
+	}
+	sub := subscriber.NewMultiChainSubscriber()
+	require.NoError(t, sub.AddChain(chains.Presets["mainnet"], mainnet.URL(), opts...))
+	require.NoError(t, sub.AddChain(chains.Presets["base"], base.URL(), opts...))
+	require.NoError(t, sub.AddChain(chains.Presets["polygon"], polygon.URL(), opts...))
+	assert.ErrorContains(t, sub.AddChain(chains.Presets["base"], base.URL()), "chain base is already added")
+
+	assert.True(t, sub.Subscribe(shared.String()))
+	assert.True(t, sub.Subscribe(notOnMainnet.String()))
+	assert.True(t, sub.SubscribeChain(8453, baseOnly.String()))
+	assert.True(t, sub.ExcludeChain(1, notOnMainnet.String()))
+	assert.False(t, sub.SubscribeChain(42161, baseOnly.String()))
+	assert.True(t, sub.SubscribeBlockRewards(miner.String()))
+
+	// Polygon endpoint serves another chain, the rest keeps going
+	err := sub.Start()
+	var mismatch *blksubscriber.ChainMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.ErrorContains(t, err, "chain polygon")
+	defer sub.Stop()
+	assert.ErrorContains(t, sub.AddChain(chains.Presets["arbitrum"], base.URL()), "already started")
+
+	var got []string
+	var rewards []int64
+	txChan, rewardChan := sub.GetTransactionChan(), sub.GetBlockRewardChan()
+	timeout := time.After(5 * time.Second)
+	for txChan != nil || rewardChan != nil {
+		select {
+		case tx, ok := <-txChan:
+			if !ok {
+				txChan = nil
+				continue
+			}
+			got = append(got, tx.ChainID.AsBigInt().String()+"/"+tx.To.String())
+		case reward, ok := <-rewardChan:
+			if !ok {
+				rewardChan = nil
+				continue
+			}
+			rewards = append(rewards, reward.ChainID.AsBigInt().Int64())
+		case <-timeout:
+			t.Fatal("subscriber did not finish")
+		}
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{
+		"1/" + shared.String(),
+		"8453/" + shared.String(),
+		"8453/" + baseOnly.String(),
+		"8453/" + notOnMainnet.String(),
+	}, got)
+	// Base sequencer is not paid block rewards
+	assert.Equal(t, []int64{1, 1, 1}, rewards)
+
+	status := sub.Status()
+	require.Len(t, status, 3)
+	for _, st := range status[:2] {
+		assert.False(t, st.Running, st.Chain)
+		assert.NoError(t, st.LastError, st.Chain)
+		assert.Equal(t, int64(4), st.CurrentBlock.Int64(), st.Chain)
+	}
+	polygonStatus, ok := sub.ChainStatus(137)
+	require.True(t, ok)
+	assert.ErrorAs(t, polygonStatus.LastError, &mismatch)
+	assert.ErrorContains(t, sub.LastError(), "chain polygon")
+	assert.False(t, sub.IsRunning())
+}
+
+func TestMultiChainReorgDepth(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t, ethtest.WithChainID(137))
+	node.AppendBlocks(30)
+
+	sub := subscriber.NewMultiChainSubscriber()
+	require.NoError(t, sub.AddChain(chains.Presets["polygon"], node.URL(),
+		subscriber.WithStartBlock(big.NewInt(1)), subscriber.WithPoolingPeriod(10*time.Millisecond)))
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	go func() {
+		for range sub.GetTransactionChan() {
+		}
+	}()
+	require.Eventually(t, func() bool {
+		status, _ := sub.ChainStatus(137)
+		return status.CurrentBlock.Int64() == 31
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Reorg deeper than 16 blocks of Polygon finality stops the chain
+	node.Reorg(20)
+	node.AppendBlock()
+	var tooDeep *blksubscriber.ReorgTooDeepError
+	require.Eventually(t, func() bool {
+		status, _ := sub.ChainStatus(137)
+		return errors.As(status.LastError, &tooDeep)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 16, tooDeep.Depth)
+}
+
+func TestMultiChainRollupReorgDepth(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t, ethtest.WithChainID(42161))
+	node.AppendBlocks(100)
+
+	sub := subscriber.NewMultiChainSubscriber()
+	require.NoError(t, sub.AddChain(chains.Presets["arbitrum"], node.URL(),
+		subscriber.WithStartBlock(big.NewInt(1)), subscriber.WithPoolingPeriod(10*time.Millisecond)))
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	go func() {
+		for range sub.GetTransactionChan() {
+		}
+	}()
+	require.Eventually(t, func() bool {
+		status, _ := sub.ChainStatus(42161)
+		return status.CurrentBlock.Int64() == 101
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Arbitrum finalizes after 3600 blocks, but recent blocks kept for reorgs are bounded by its reorg depth
+	node.Reorg(70)
+	node.AppendBlock()
+	var tooDeep *blksubscriber.ReorgTooDeepError
+	require.Eventually(t, func() bool {
+		status, _ := sub.ChainStatus(42161)
+		return errors.As(status.LastError, &tooDeep)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 64, tooDeep.Depth)
+}
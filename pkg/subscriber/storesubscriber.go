@@ -3,7 +3,10 @@ package subscriber
 import (
 	stderr "errors"
 	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/ledger"
 	processors2 "github.com/dkropachev/ethscan/pkg/processors"
+	"github.com/dkropachev/ethscan/pkg/rpc"
+	"github.com/dkropachev/ethscan/pkg/txstore"
 	"github.com/dkropachev/ethscan/pkg/types"
 	"math/big"
 
@@ -11,54 +14,168 @@ import (
 )
 
 type StoreSubscriber struct {
-	blkSub           *blksubscriber.Subscriber[types.BlockDetailed]
-	walletFilter     *processors2.TxWalletFilter
-	txStoreProcessor *processors2.TxStore
-	store            txStore
+	blkSub      blksubscriber.BlockSource[types.BlockDetailed]
+	blockReward *processors2.BlockReward
+	blockStore  *processors2.BlockStore
+	store       txstore.Store
 }
 
-type txStore interface {
-	StoreTransaction(tx *types.Transaction) error
-	GetTransactions(address string) ([]*types.Transaction, error)
-	GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error)
-}
-
-func NewStoreSubscriber(endpoint string, store txStore, opts ...Option) (*StoreSubscriber, error) {
+// NewStoreSubscriber creates subscriber that stores transactions of subscribed wallets into the store.
+// When the store keeps checkpoints, subscriber continues from the block after the last one stored,
+// WithStartBlock overrides that.
+// Reorgs are followed with WithReorgDepth, stores that can roll back have transactions of orphaned blocks rolled back,
+// blksubscriber.DefaultReorgDepth is enough for Ethereum. Transaction statuses need WithFinalityTags, it costs
+// two more eth_getBlockByNumber calls per head.
+func NewStoreSubscriber(endpoint string, store txstore.Store, opts ...Option) (*StoreSubscriber, error) {
+	if checkpoints, ok := store.(txstore.Checkpointer); ok {
+		checkpoint, err := checkpoints.GetCheckpoint()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read checkpoint")
+		}
+		if checkpoint != nil {
+			opts = append([]Option{WithStartBlock(new(big.Int).Add(checkpoint, big.NewInt(1)))}, opts...)
+		}
+	}
 	blkSub, err := blksubscriber.New[types.BlockDetailed](endpoint, convOptions(opts)...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create block subscriber")
 	}
-	walletFilter := processors2.NewTxWalletFilter(processors2.NewBlockToTxProcessor(blkSub.GetBlockChan()).Out())
-	txStoreProcessor := processors2.NewTxStore(walletFilter.Out(), store)
+	return NewStoreSubscriberFromSource(blkSub, store), nil
+}
+
+// NewStoreSubscriberFromSource creates subscriber that reads blocks from any source, e.g. replay.Source.
+// Proposer priority fees are taken from receipts when source provides them, otherwise they are estimated.
+// Source is expected to start from the block after the store checkpoint, if the store keeps one.
+func NewStoreSubscriberFromSource(blkSub blksubscriber.BlockSource[types.BlockDetailed], store txstore.Store) *StoreSubscriber {
+	blockReward := processors2.NewBlockRewardProcessor(blkSub.GetBlockChan(), receiptsOf(blkSub))
 	return &StoreSubscriber{
-		blkSub:           blkSub,
-		store:            store,
-		walletFilter:     walletFilter,
-		txStoreProcessor: txStoreProcessor,
-	}, nil
+		blkSub:      blkSub,
+		store:       store,
+		blockReward: blockReward,
+		blockStore:  processors2.NewBlockStore(blockReward.Out(), blockReward.Rewards(), store),
+	}
 }
 
 func (s *StoreSubscriber) Subscribe(address string) bool {
-	return s.walletFilter.AddWallet(address)
+	return s.blockStore.AddWallet(address)
+}
+
+// SubscribeBlockRewards starts tracking blocks proposed to the address as a fee recipient
+func (s *StoreSubscriber) SubscribeBlockRewards(address string) bool {
+	return s.blockReward.AddWallet(address)
 }
 
+// IsRunning is false once the block subscriber stopped or storing failed
 func (s *StoreSubscriber) IsRunning() bool {
-	return s.blkSub.IsRunning()
+	return s.blkSub.IsRunning() && s.blockStore.IsRunning()
 }
 
 func (s *StoreSubscriber) LastError() error {
 	return stderr.Join(
-		errors.Wrap(s.txStoreProcessor.LastError(), "transaction store processor error"),
+		errors.Wrap(s.blockStore.LastError(), "block store processor error"),
 		errors.Wrap(s.blkSub.LastError(), "block subscriber error"),
 	)
 }
 
-func (s *StoreSubscriber) GetTransactions(address string) ([]*types.Transaction, error) {
-	return s.store.GetTransactions(address)
+// Finality returns the chain head and the last blocks tagged safe and finalized, as last seen by the subscriber
+func (s *StoreSubscriber) Finality() txstore.Finality {
+	return finalityOf(s.blkSub)
+}
+
+// GetTransactions returns transactions of the address with their confirmations and status.
+// When statuses are given, only transactions with any of them are returned, e.g. types.TxFinalized for accounting.
+func (s *StoreSubscriber) GetTransactions(address string, statuses ...types.TxStatus) ([]*types.Transaction, error) {
+	txs, err := s.store.GetTransactions(address)
+	if err != nil {
+		return nil, err
+	}
+	return s.stamp(address, txs, statuses)
+}
+
+// GetTransactionsAfterBlock returns transactions of the address from blocks above blkId, see GetTransactions
+func (s *StoreSubscriber) GetTransactionsAfterBlock(blkId big.Int, address string, statuses ...types.TxStatus) ([]*types.Transaction, error) {
+	txs, err := s.store.GetTransactionsAfterBlock(blkId, address)
+	if err != nil {
+		return nil, err
+	}
+	return s.stamp(address, txs, statuses)
+}
+
+// stamp returns copies of the transactions with confirmations and status set, only ones with any of statuses if given
+func (s *StoreSubscriber) stamp(address string, txs []*types.Transaction, statuses []types.TxStatus) ([]*types.Transaction, error) {
+	finality := s.Finality()
+	q := txstore.Query{Address: address, Statuses: statuses, Finality: &finality}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	var out []*types.Transaction
+	for _, tx := range txs {
+		if !q.MatchStatus(tx) {
+			continue
+		}
+		out = append(out, finality.Stamp(tx))
+	}
+	return out, nil
+}
+
+// QueryTransactions returns a page of transactions matching the query, pass Page.NextCursor as Query.Cursor to get the next one.
+// Stores that can not run queries have all transactions of the address read and filtered.
+// Statuses are matched against Finality of the subscriber unless the query has its own.
+func (s *StoreSubscriber) QueryTransactions(q txstore.Query) (txstore.Page, error) {
+	if q.Finality == nil {
+		finality := s.Finality()
+		q.Finality = &finality
+	}
+	var page txstore.Page
+	var err error
+	if querier, ok := s.store.(txstore.Querier); ok {
+		page, err = querier.QueryTransactions(q)
+	} else if err = q.Validate(); err == nil {
+		var txs []*types.Transaction
+		if txs, err = s.store.GetTransactions(q.Address); err == nil {
+			page, err = q.Run(txs)
+		}
+	}
+	for i, tx := range page.Transactions {
+		page.Transactions[i] = q.Finality.Stamp(tx)
+	}
+	return page, err
+}
+
+// Tombstones returns transactions and rewards of the address rolled back after reorgs
+func (s *StoreSubscriber) Tombstones(address string) ([]txstore.Tombstone, error) {
+	tombstones, ok := s.store.(txstore.TombstoneKeeper)
+	if !ok {
+		return nil, errors.New("store does not keep tombstones")
+	}
+	return tombstones.Tombstones(address)
+}
+
+func (s *StoreSubscriber) GetBlockRewards(address string) ([]*types.ProposedBlockReward, error) {
+	return s.store.GetBlockRewards(address)
+}
+
+// Ledger builds ledger of the address from its stored transactions and rewards, fees and balances are read from the node.
+// Subscribers of replayed blocks have no node to read them from.
+func (s *StoreSubscriber) Ledger(address string, opts ...ledger.Option) (*ledger.Ledger, error) {
+	chain, ok := s.blkSub.(ledger.Chain)
+	if !ok {
+		return nil, errors.New("block source can not read receipts and balances")
+	}
+	txs, err := s.store.GetTransactions(address)
+	if err != nil {
+		return nil, err
+	}
+	rewards, err := s.store.GetBlockRewards(address)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.Build(chain, address, txs, rewards, opts...)
 }
 
-func (s *StoreSubscriber) GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error) {
-	return s.store.GetTransactionsAfterBlock(blkId, address)
+// RateLimitStats returns compute units spent through the rate limiter, ok is false when no limiter is set
+func (s *StoreSubscriber) RateLimitStats() (rpc.RateLimitStats, bool) {
+	return rateLimitStatsOf(s.blkSub)
 }
 
 func (s *StoreSubscriber) GetCurrentBlock() big.Int {
@@ -0,0 +1,284 @@
+package subscriber_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/blksubscriber"
+	"github.com/dkropachev/ethscan/pkg/ethtest"
+	"github.com/dkropachev/ethscan/pkg/ledger"
+	"github.com/dkropachev/ethscan/pkg/memtxstore"
+	"github.com/dkropachev/ethscan/pkg/subscriber"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSubscriberReorg(t *testing.T) {
+	t.Parallel()
+	wallet := address(t, "0x00000000000000000000000000000000000000a1")
+	miner := address(t, "0x00000000000000000000000000000000000000d4")
+	node := ethtest.NewNode(t)
+	node.SetMiner(miner)
+	for value := range int64(3) {
+		node.AppendBlock(ethtest.Tx{To: wallet, Value: value + 1, GasPrice: 2_000_000_000})
+	}
+
+	store := memtxstore.New()
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), store,
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+		subscriber.WithReorgDepth(blksubscriber.DefaultReorgDepth),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet.String())
+	sub.SubscribeBlockRewards(miner.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	values := func() []int64 {
+		txs, err := sub.GetTransactions(wallet.String())
+		require.NoError(t, err)
+		var out []int64
+		for _, tx := range txs {
+			out = append(out, tx.Value.AsBigInt().Int64())
+		}
+		return out
+	}
+	require.Eventually(t, func() bool { return len(values()) == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	// Blocks 2 and 3 are replaced by a fork with one transaction, which gets longer
+	reorged := node.Reorg(2, ethtest.Tx{To: wallet, Value: 7, GasPrice: 2_000_000_000})
+	head := node.AppendBlock()
+	require.Eventually(t, func() bool {
+		rewards, err := sub.GetBlockRewards(miner.String())
+		require.NoError(t, err)
+		return len(rewards) == 4 && rewards[3].BlockHash == head.Hash
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 7}, values())
+	assert.NoError(t, sub.LastError())
+
+	rewards, err := sub.GetBlockRewards(miner.String())
+	require.NoError(t, err)
+	assert.Equal(t, []types.EthHash{node.Block(1).Hash, reorged[0].Hash, reorged[1].Hash, head.Hash},
+		[]types.EthHash{rewards[0].BlockHash, rewards[1].BlockHash, rewards[2].BlockHash, rewards[3].BlockHash})
+
+	tombs, err := sub.Tombstones(wallet.String())
+	require.NoError(t, err)
+	require.Len(t, tombs, 2)
+	assert.Equal(t, []int64{2, 3}, []int64{tombs[0].Transaction.Value.AsBigInt().Int64(), tombs[1].Transaction.Value.AsBigInt().Int64()})
+	assert.Equal(t, int64(2), tombs[0].From.AsBigInt().Int64())
+	tombs, err = sub.Tombstones(miner.String())
+	require.NoError(t, err)
+	assert.Len(t, tombs, 2)
+}
+
+func TestStoreSubscriberFinality(t *testing.T) {
+	t.Parallel()
+	wallet := address(t, "0x00000000000000000000000000000000000000a2")
+	node := ethtest.NewNode(t)
+	// Transactions land in blocks 1, 50 and 90 of 100, node finalizes 64 and marks safe 32 blocks behind head
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 1, GasPrice: 2_000_000_000})
+	node.AppendBlocks(48)
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 2, GasPrice: 2_000_000_000})
+	node.AppendBlocks(39)
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 3, GasPrice: 2_000_000_000})
+	node.AppendBlocks(10)
+
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), memtxstore.New(),
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+		subscriber.WithFinalityTags(true),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	require.Eventually(t, func() bool {
+		txs, err := sub.GetTransactions(wallet.String())
+		require.NoError(t, err)
+		return len(txs) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	finality := sub.Finality()
+	require.NotNil(t, finality.Finalized)
+	assert.Equal(t, int64(100), finality.Head.Int64())
+	assert.Equal(t, int64(68), finality.Safe.Int64())
+	assert.Equal(t, int64(36), finality.Finalized.Int64())
+
+	txs, err := sub.GetTransactions(wallet.String())
+	require.NoError(t, err)
+	assert.Equal(t, []types.TxStatus{types.TxFinalized, types.TxSafe, types.TxUnconfirmed},
+		[]types.TxStatus{txs[0].Status, txs[1].Status, txs[2].Status})
+	assert.Equal(t, []int64{100, 51, 11},
+		[]int64{txs[0].Confirmations.AsBigInt().Int64(), txs[1].Confirmations.AsBigInt().Int64(), txs[2].Confirmations.AsBigInt().Int64()})
+
+	txs, err = sub.GetTransactions(wallet.String(), types.TxFinalized)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.Equal(t, int64(1), txs[0].Value.AsBigInt().Int64())
+
+	txs, err = sub.GetTransactionsAfterBlock(*big.NewInt(1), wallet.String(), types.TxSafe, types.TxUnconfirmed)
+	require.NoError(t, err)
+	assert.Len(t, txs, 2)
+
+	_, err = sub.GetTransactions(wallet.String(), "confirmed")
+	assert.Error(t, err)
+
+	page, err := sub.QueryTransactions(txstore.Query{Address: wallet.String(), Statuses: []types.TxStatus{types.TxSafe}})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	assert.Equal(t, types.TxSafe, page.Transactions[0].Status)
+
+	// Statuses move forward as the chain grows
+	node.AppendBlocks(40)
+	require.Eventually(t, func() bool {
+		txs, err := sub.GetTransactions(wallet.String(), types.TxFinalized)
+		require.NoError(t, err)
+		return len(txs) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStoreSubscriberDefaults(t *testing.T) {
+	t.Parallel()
+	wallet := address(t, "0x00000000000000000000000000000000000000a5")
+	node := ethtest.NewNode(t)
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 1, GasPrice: 2_000_000_000})
+	node.AppendBlocks(99)
+
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), memtxstore.New(),
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	require.Eventually(t, func() bool {
+		current := sub.GetCurrentBlock()
+		return current.Int64() == 101
+	}, 5*time.Second, 10*time.Millisecond)
+	// Finality tags and reorgs are opt-in, so every block is read once and nothing else
+	assert.Equal(t, 100, node.Calls("eth_getBlockByNumber"))
+	finality := sub.Finality()
+	assert.Nil(t, finality.Safe)
+	assert.Nil(t, finality.Finalized)
+	_, err = sub.GetTransactions(wallet.String(), types.TxFinalized)
+	assert.ErrorIs(t, err, txstore.ErrFinalityUnknown)
+}
+
+func TestStoreSubscriberFinalityWithoutTags(t *testing.T) {
+	t.Parallel()
+	wallet := address(t, "0x00000000000000000000000000000000000000a4")
+	node := ethtest.NewNode(t)
+	// Transactions land in blocks 1, 50 and 90 of 100
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 1, GasPrice: 2_000_000_000})
+	node.AppendBlocks(48)
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 2, GasPrice: 2_000_000_000})
+	node.AppendBlocks(39)
+	node.AppendBlock(ethtest.Tx{To: wallet, Value: 3, GasPrice: 2_000_000_000})
+	node.AppendBlocks(10)
+
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), memtxstore.New(),
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+		subscriber.WithFinalityTags(false),
+		subscriber.WithReorgDepth(20),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	require.Eventually(t, func() bool {
+		txs, err := sub.GetTransactions(wallet.String())
+		require.NoError(t, err)
+		return len(txs) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Blocks deeper than reorgs are followed are finalized
+	finality := sub.Finality()
+	assert.Nil(t, finality.Safe)
+	assert.Equal(t, int64(80), finality.Finalized.Int64())
+	txs, err := sub.GetTransactions(wallet.String(), types.TxFinalized)
+	require.NoError(t, err)
+	assert.Len(t, txs, 2)
+
+	// Safe blocks are not known without tags, so the filter fails instead of matching nothing
+	_, err = sub.GetTransactions(wallet.String(), types.TxSafe)
+	assert.ErrorIs(t, err, txstore.ErrFinalityUnknown)
+	_, err = sub.QueryTransactions(txstore.Query{Address: wallet.String(), Statuses: []types.TxStatus{types.TxSafe}})
+	assert.ErrorIs(t, err, txstore.ErrFinalityUnknown)
+}
+
+func TestStoreSubscriberFinalityDepth(t *testing.T) {
+	t.Parallel()
+	node := ethtest.NewNode(t)
+	node.AppendBlocks(100)
+
+	// Chain finalizes slower than it reorgs, e.g. a rollup
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), memtxstore.New(),
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+		subscriber.WithReorgDepth(20),
+		subscriber.WithFinalityDepth(60),
+	)
+	require.NoError(t, err)
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+
+	require.Eventually(t, func() bool {
+		finality := sub.Finality()
+		return finality.Head != nil && finality.Head.Int64() == 100
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(40), sub.Finality().Finalized.Int64())
+}
+
+func TestStoreSubscriberLedger(t *testing.T) {
+	t.Parallel()
+	wallet := address(t, "0x00000000000000000000000000000000000000a3")
+	other := address(t, "0x00000000000000000000000000000000000000b3")
+	node := ethtest.NewNode(t)
+	balance := big.NewInt(1_000_000_000_000_000_000)
+	node.SetBalance(wallet, balance)
+	node.SetMiner(other)
+	node.AppendBlock(ethtest.Tx{From: other, To: wallet, Value: 500_000_000_000_000_000, GasPrice: 2_000_000_000})
+	balance.Add(balance, big.NewInt(500_000_000_000_000_000))
+	node.SetBalance(wallet, balance)
+	// Wallet proposes the block with its own transaction and gets its tip back, base fee is burnt
+	node.SetMiner(wallet)
+	node.AppendBlock(ethtest.Tx{From: wallet, To: other, Value: 200_000_000_000_000_000, GasPrice: 2_000_000_000})
+	balance.Sub(balance, big.NewInt(200_000_000_000_000_000+21000*2_000_000_000-21000*1_000_000_000))
+	node.SetBalance(wallet, balance)
+
+	sub, err := subscriber.NewStoreSubscriber(node.URL(), memtxstore.New(),
+		subscriber.WithStartBlock(big.NewInt(1)),
+		subscriber.WithPoolingPeriod(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	sub.Subscribe(wallet.String())
+	sub.SubscribeBlockRewards(wallet.String())
+	require.NoError(t, sub.Start())
+	defer sub.Stop()
+	require.Eventually(t, func() bool {
+		rewards, err := sub.GetBlockRewards(wallet.String())
+		require.NoError(t, err)
+		txs, err := sub.GetTransactions(wallet.String())
+		require.NoError(t, err)
+		return len(rewards) == 1 && len(txs) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	l, err := sub.Ledger(wallet.String())
+	require.NoError(t, err)
+	require.Len(t, l.Rows, 3)
+	assert.Equal(t, []ledger.Kind{ledger.KindTransaction, ledger.KindTransaction, ledger.KindReward},
+		[]ledger.Kind{l.Rows[0].Kind, l.Rows[1].Kind, l.Rows[2].Kind})
+	assert.Equal(t, int64(21000*2_000_000_000), l.Rows[1].Fee.AsBigInt().Int64())
+	assert.Equal(t, balance, l.Balance.AsBigInt())
+	require.Len(t, l.Checkpoints, 1)
+	assert.Empty(t, l.Drifts())
+}
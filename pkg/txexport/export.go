@@ -0,0 +1,387 @@
+// Package txexport writes transactions of wallets into JSONL or CSV files, optionally gzip compressed and split
+// by wallet or by day, and loads such files back into any txstore.Store.
+package txexport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	stderr "errors"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Format of exported files
+type Format int
+
+const (
+	// FormatJSONL writes a Record per line, transactions keep every field, extensions of other chains included
+	FormatJSONL Format = iota
+	// FormatCSV writes Columns, fields of other chains are not exported
+	FormatCSV
+)
+
+var formatNames = []string{"jsonl", "csv"}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return "unknown"
+	}
+	return formatNames[f]
+}
+
+// ParseFormat parses format by name, empty name is FormatJSONL
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatJSONL, nil
+	}
+	for i, known := range formatNames {
+		if strings.EqualFold(name, known) {
+			return Format(i), nil
+		}
+	}
+	return 0, errors.Errorf("unknown format %q, options: %s", name, strings.Join(formatNames, ", "))
+}
+
+// Split tells how records are spread over files
+type Split int
+
+const (
+	// SplitNone writes everything into transactions.<format>
+	SplitNone Split = iota
+	// SplitAddress writes a file per wallet, named by its address
+	SplitAddress
+	// SplitDay writes a file per UTC day of block timestamps, e.g. 2024-01-31.csv,
+	// transactions without timestamp go to unknown.<format>
+	SplitDay
+)
+
+var splitNames = []string{"none", "address", "day"}
+
+func (s Split) String() string {
+	if s < 0 || int(s) >= len(splitNames) {
+		return "unknown"
+	}
+	return splitNames[s]
+}
+
+// ParseSplit parses split by name, empty name is SplitNone
+func ParseSplit(name string) (Split, error) {
+	if name == "" {
+		return SplitNone, nil
+	}
+	for i, known := range splitNames {
+		if strings.EqualFold(name, known) {
+			return Split(i), nil
+		}
+	}
+	return 0, errors.Errorf("unknown split %q, options: %s", name, strings.Join(splitNames, ", "))
+}
+
+// Columns of CSV files. The schema is stable: columns are never renamed or reordered, new ones are appended.
+// Amounts are decimal wei, hashes, addresses and input are 0x prefixed hex, block_time is RFC 3339 in UTC.
+var Columns = []string{
+	"wallet", "direction", "chain_id", "block_number", "block_hash", "block_time", "tx_index", "hash", "from", "to",
+	"value", "gas", "gas_price", "max_fee_per_gas", "max_priority_fee_per_gas", "nonce", "type", "input", "orphaned",
+}
+
+// Record is a transaction of a wallet, a transaction between two exported wallets makes a record for each of them
+type Record struct {
+	Wallet string `json:"wallet"`
+	// Direction is one of txstore.Direction names: incoming, outgoing or self
+	Direction   string             `json:"direction"`
+	Transaction *types.Transaction `json:"transaction"`
+}
+
+// NewRecord makes record of the transaction for the wallet
+func NewRecord(wallet string, tx *types.Transaction) *Record {
+	wallet = strings.ToLower(wallet)
+	return &Record{Wallet: wallet, Direction: txstore.DirectionOf(wallet, tx).String(), Transaction: tx}
+}
+
+type (
+	options struct {
+		format Format
+		split  Split
+		gzip   bool
+	}
+
+	Option func(opts *options)
+)
+
+func (o *options) apply(mods ...Option) {
+	for _, opt := range mods {
+		opt(o)
+	}
+}
+
+// WithFormat sets format of the files, FormatJSONL by default
+func WithFormat(format Format) Option {
+	return func(opts *options) {
+		opts.format = format
+	}
+}
+
+// WithSplit sets how records are spread over files, SplitNone by default
+func WithSplit(split Split) Option {
+	return func(opts *options) {
+		opts.split = split
+	}
+}
+
+// WithGzip compresses files, .gz is appended to their names
+func WithGzip(enabled bool) Option {
+	return func(opts *options) {
+		opts.gzip = enabled
+	}
+}
+
+// maxOpenFiles bounds files kept open by Writer, e.g. a year split by day
+const maxOpenFiles = 16
+
+// Writer writes records into files of a directory, files are created as the first record for them comes.
+// At most maxOpenFiles files are open at once, the least recently written one is closed to open another one
+// and reopened for appending when a record for it comes again, gzip files get another gzip member then.
+// Writer is not safe for concurrent use.
+type Writer struct {
+	dir   string
+	files map[string]*file
+	paths []string
+	// created are names of files created so far, open or not
+	created map[string]bool
+	// writes counts records written, to tell the least recently written file
+	writes uint64
+	options
+}
+
+type file struct {
+	f   *os.File
+	gz  *gzip.Writer
+	buf *bufio.Writer
+	csv *csv.Writer
+	// lastWrite is Writer.writes when the file was written last time
+	lastWrite uint64
+}
+
+// NewWriter creates the directory when it does not exist, existing files of the same names are overwritten
+func NewWriter(dir string, opts ...Option) (*Writer, error) {
+	out := &Writer{dir: dir, files: map[string]*file{}, created: map[string]bool{}}
+	out.apply(opts...)
+	if out.format != FormatJSONL && out.format != FormatCSV {
+		return nil, errors.Errorf("unknown format %d", out.format)
+	}
+	if out.split < SplitNone || out.split > SplitDay {
+		return nil, errors.Errorf("unknown split %d", out.split)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create output directory")
+	}
+	return out, nil
+}
+
+// Write writes transaction of the wallet
+func (w *Writer) Write(wallet string, tx *types.Transaction) error {
+	return w.WriteRecord(NewRecord(wallet, tx))
+}
+
+// WriteRecord writes the record as it is, e.g. one read back by ReadFile
+func (w *Writer) WriteRecord(rec *Record) error {
+	out, err := w.file(w.name(rec))
+	if err != nil {
+		return err
+	}
+	if out.csv != nil {
+		return errors.Wrap(out.csv.Write(rec.row()), "failed to write record")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal record")
+	}
+	_, err = out.buf.Write(append(data, '\n'))
+	return errors.Wrap(err, "failed to write record")
+}
+
+// name returns file name of the record
+func (w *Writer) name(rec *Record) string {
+	name := "transactions"
+	switch w.split {
+	case SplitAddress:
+		name = rec.Wallet
+	case SplitDay:
+		name = "unknown"
+		if ts := rec.Transaction.BlockTimestamp; ts != nil {
+			name = time.Unix(ts.AsBigInt().Int64(), 0).UTC().Format(time.DateOnly)
+		}
+	}
+	name += "." + w.format.String()
+	if w.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+func (w *Writer) file(name string) (*file, error) {
+	w.writes++
+	if out, ok := w.files[name]; ok {
+		out.lastWrite = w.writes
+		return out, nil
+	}
+	if len(w.files) >= maxOpenFiles {
+		if err := w.closeLeastRecent(); err != nil {
+			return nil, err
+		}
+	}
+	var f *os.File
+	var err error
+	path := filepath.Join(w.dir, name)
+	reopen := w.created[name]
+	if reopen {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	} else {
+		f, err = os.Create(path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	if !reopen {
+		w.created[name] = true
+		w.paths = append(w.paths, f.Name())
+	}
+	out := w.open(name, f)
+	if w.format == FormatCSV {
+		out.csv = csv.NewWriter(out.buf)
+		if !reopen {
+			if err = out.csv.Write(Columns); err != nil {
+				return nil, errors.Wrap(err, "failed to write header")
+			}
+		}
+	}
+	return out, nil
+}
+
+// open wraps the file into buffer and gzip, if enabled, and keeps it among open ones
+func (w *Writer) open(name string, f *os.File) *file {
+	out := &file{f: f, lastWrite: w.writes}
+	var dst io.Writer = f
+	if w.gzip {
+		out.gz = gzip.NewWriter(f)
+		dst = out.gz
+	}
+	out.buf = bufio.NewWriterSize(dst, 64*1024)
+	w.files[name] = out
+	return out
+}
+
+// closeLeastRecent closes the open file written longest ago
+func (w *Writer) closeLeastRecent() error {
+	var oldest string
+	for name, out := range w.files {
+		if oldest == "" || out.lastWrite < w.files[oldest].lastWrite {
+			oldest = name
+		}
+	}
+	err := w.files[oldest].close()
+	delete(w.files, oldest)
+	return errors.Wrapf(err, "failed to close %s", oldest)
+}
+
+// close flushes and closes the file
+func (out *file) close() error {
+	var errs []error
+	if out.csv != nil {
+		out.csv.Flush()
+		errs = append(errs, out.csv.Error())
+	}
+	errs = append(errs, out.buf.Flush())
+	if out.gz != nil {
+		errs = append(errs, out.gz.Close())
+	}
+	errs = append(errs, out.f.Close())
+	return stderr.Join(errs...)
+}
+
+// Files returns paths of written files, sorted
+func (w *Writer) Files() []string {
+	out := slices.Clone(w.paths)
+	slices.Sort(out)
+	return out
+}
+
+// Close flushes and closes every file, files are complete only after it
+func (w *Writer) Close() error {
+	var errs []error
+	for name, out := range w.files {
+		errs = append(errs, out.close())
+		delete(w.files, name)
+	}
+	return errors.Wrap(stderr.Join(errs...), "failed to close files")
+}
+
+// Export writes transactions of the wallets from blocks fromBlock to toBlock inclusive, nil bound is open.
+// It returns the number of records written.
+func Export(w *Writer, store txstore.Store, wallets []string, fromBlock, toBlock *big.Int) (int, error) {
+	count := 0
+	seen := map[string]bool{}
+	for _, wallet := range wallets {
+		wallet = strings.ToLower(wallet)
+		if seen[wallet] {
+			continue
+		}
+		seen[wallet] = true
+		txs, err := store.GetTransactions(wallet)
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to read transactions of %s", wallet)
+		}
+		page, err := (&txstore.Query{Address: wallet, FromBlock: fromBlock, ToBlock: toBlock}).Run(txs)
+		if err != nil {
+			return count, err
+		}
+		for _, tx := range page.Transactions {
+			if err = w.Write(wallet, tx); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// row returns CSV row of the record in the order of Columns
+func (r *Record) row() []string {
+	tx := r.Transaction
+	optional := func(val *types.BigInt) string {
+		if val == nil {
+			return ""
+		}
+		return val.AsBigInt().String()
+	}
+	blockTime := ""
+	if tx.BlockTimestamp != nil {
+		blockTime = time.Unix(tx.BlockTimestamp.AsBigInt().Int64(), 0).UTC().Format(time.RFC3339)
+	}
+	input, _ := tx.Input.MarshalJSON()
+	hash, _ := tx.Hash.MarshalJSON()
+	blockHash, _ := tx.BlockHash.MarshalJSON()
+	return []string{
+		r.Wallet, r.Direction, optional(tx.ChainID), tx.BlockNumber.AsBigInt().String(), unquote(blockHash), blockTime,
+		tx.TransactionIndex.AsBigInt().String(), unquote(hash), tx.From.String(), tx.To.String(),
+		tx.Value.AsBigInt().String(), tx.Gas.AsBigInt().String(), tx.GasPrice.AsBigInt().String(),
+		optional(tx.MaxFeePerGas), optional(tx.MaxPriorityFeePerGas), tx.Nonce.AsBigInt().String(),
+		tx.Type.AsBigInt().String(), unquote(input), strconv.FormatBool(tx.Orphaned),
+	}
+}
+
+func unquote(data []byte) string {
+	return strings.Trim(string(data), `"`)
+}
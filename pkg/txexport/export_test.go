@@ -0,0 +1,213 @@
+package txexport_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/dkropachev/ethscan/pkg/memtxstore"
+	"github.com/dkropachev/ethscan/pkg/txexport"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+	carol = types.EthAddress{0xc}
+)
+
+func bigInt(val int64) *types.BigInt {
+	return (*types.BigInt)(big.NewInt(val))
+}
+
+func newTx(n byte, block int64, from, to types.EthAddress, at time.Time) *types.Transaction {
+	tx := &types.Transaction{
+		Hash:             types.EthHash{n},
+		BlockHash:        types.EthHash{0xff, byte(block)},
+		BlockNumber:      *bigInt(block),
+		TransactionIndex: *bigInt(0),
+		From:             from,
+		To:               to,
+		Value:            *bigInt(int64(n) * 1_000_000_000_000_000_000 / 4),
+		Gas:              *bigInt(21000),
+		GasPrice:         *bigInt(2_000_000_000),
+		Nonce:            *bigInt(int64(n)),
+		Input:            types.BinData{},
+		ChainID:          bigInt(1),
+	}
+	if !at.IsZero() {
+		tx.BlockTimestamp = bigInt(at.Unix())
+	}
+	return tx
+}
+
+func newStore(t *testing.T) *memtxstore.Store {
+	day := time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	dynamic := newTx(3, 3, bob, carol, day.Add(2*time.Hour))
+	dynamic.Type = *bigInt(2)
+	dynamic.MaxFeePerGas, dynamic.MaxPriorityFeePerGas = bigInt(3_000_000_000), bigInt(1_000_000_000)
+	dynamic.Input = types.BinData{0xde, 0xad}
+	txs := []*types.Transaction{
+		newTx(1, 1, carol, alice, day),
+		newTx(2, 2, alice, bob, day.Add(time.Minute)),
+		dynamic,
+		newTx(4, 4, alice, alice, time.Time{}),
+	}
+	store := memtxstore.New()
+	for _, tx := range txs {
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	return store
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []txexport.Format{txexport.FormatJSONL, txexport.FormatCSV} {
+		for _, gzip := range []bool{false, true} {
+			for _, tc := range []struct {
+				split txexport.Split
+				files []string
+			}{
+				{split: txexport.SplitNone, files: []string{"transactions"}},
+				{split: txexport.SplitAddress, files: []string{alice.String(), bob.String()}},
+				{split: txexport.SplitDay, files: []string{"2024-01-31", "2024-02-01", "unknown"}},
+			} {
+				name := format.String() + "/" + tc.split.String()
+				ext := "." + format.String()
+				if gzip {
+					name += "/gzip"
+					ext += ".gz"
+				}
+				t.Run(name, func(t *testing.T) {
+					store := newStore(t)
+					dir := t.TempDir()
+					w, err := txexport.NewWriter(dir, txexport.WithFormat(format), txexport.WithSplit(tc.split), txexport.WithGzip(gzip))
+					require.NoError(t, err)
+					// Wallets are deduplicated case-insensitively, the transaction between them is exported for both
+					count, err := txexport.Export(w, store, []string{alice.String(), bob.String(), "0x0A00000000000000000000000000000000000000"}, nil, nil)
+					require.NoError(t, err)
+					assert.Equal(t, 5, count)
+					require.NoError(t, w.Close())
+					var expected []string
+					for _, file := range tc.files {
+						expected = append(expected, filepath.Join(dir, file+ext))
+					}
+					assert.Equal(t, expected, w.Files())
+
+					imported := memtxstore.New()
+					count, err = txexport.Import(imported, w.Files()...)
+					require.NoError(t, err)
+					assert.Equal(t, 5, count)
+					for _, address := range []types.EthAddress{alice, bob, carol} {
+						expected, err := store.GetTransactions(address.String())
+						require.NoError(t, err)
+						actual, err := imported.GetTransactions(address.String())
+						require.NoError(t, err)
+						require.Len(t, actual, len(expected))
+						for i := range expected {
+							assert.True(t, expected[i].Equal(actual[i]), "%s %d", address, i)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	store := newStore(t)
+	dir := t.TempDir()
+	w, err := txexport.NewWriter(dir, txexport.WithFormat(txexport.FormatCSV), txexport.WithSplit(txexport.SplitAddress))
+	require.NoError(t, err)
+	_, err = txexport.Export(w, store, []string{bob.String()}, big.NewInt(2), big.NewInt(3))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, bob.String()+".csv"))
+	require.NoError(t, err)
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, txexport.Columns, rows[0])
+	row := map[string]string{}
+	for i, column := range rows[0] {
+		row[column] = rows[2][i]
+	}
+	assert.Equal(t, map[string]string{
+		"wallet": bob.String(), "direction": txstore.DirectionOutgoing.String(), "chain_id": "1", "block_number": "3",
+		"block_hash": "0xff03000000000000000000000000000000000000000000000000000000000000", "block_time": "2024-02-01T01:00:00Z",
+		"tx_index": "0", "hash": "0x0300000000000000000000000000000000000000000000000000000000000000",
+		"from": bob.String(), "to": carol.String(), "value": "750000000000000000", "gas": "21000", "gas_price": "2000000000",
+		"max_fee_per_gas": "3000000000", "max_priority_fee_per_gas": "1000000000", "nonce": "3", "type": "2",
+		"input": "0xdead", "orphaned": "false",
+	}, row)
+	assert.Equal(t, "incoming", rows[1][1])
+
+	// Files of older schema, with fewer columns, are read by header names
+	var records []*txexport.Record
+	err = txexport.Read(bytes.NewReader([]byte("hash,from,to,block_number\n0x"+
+		"0500000000000000000000000000000000000000000000000000000000000000,"+alice.String()+","+bob.String()+",7\n")),
+		func(rec *txexport.Record) error {
+			records = append(records, rec)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, types.EthHash{5}, records[0].Transaction.Hash)
+	assert.Equal(t, int64(7), records[0].Transaction.BlockNumber.AsBigInt().Int64())
+	assert.Nil(t, records[0].Transaction.ChainID)
+
+	assert.Error(t, txexport.Read(bytes.NewReader([]byte("wallet,direction\n")), func(*txexport.Record) error { return nil }))
+	assert.Error(t, txexport.Read(bytes.NewReader([]byte("hash,from,to,block_number\nnope,,,1\n")), func(*txexport.Record) error { return nil }))
+}
+
+func TestSplitDayYear(t *testing.T) {
+	openFiles := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("open files are not known on this platform")
+		}
+		return len(entries)
+	}
+	store := memtxstore.New()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for day := range 366 {
+		tx := newTx(1, int64(day+1), alice, bob, start.AddDate(0, 0, day))
+		tx.Hash, tx.BlockHash = types.EthHash{byte(day >> 8), byte(day)}, types.EthHash{0xff, byte(day >> 8), byte(day)}
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	for _, format := range []txexport.Format{txexport.FormatJSONL, txexport.FormatCSV} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := txexport.NewWriter(dir, txexport.WithFormat(format), txexport.WithSplit(txexport.SplitDay), txexport.WithGzip(true))
+			require.NoError(t, err)
+			before := openFiles()
+			// Every day is written for alice and then once again for bob, so files are closed and reopened
+			count, err := txexport.Export(w, store, []string{alice.String(), bob.String()}, nil, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 732, count)
+			assert.LessOrEqual(t, openFiles()-before, 16)
+			require.NoError(t, w.Close())
+			assert.Equal(t, before, openFiles())
+			require.Len(t, w.Files(), 366)
+
+			var records []*txexport.Record
+			require.NoError(t, txexport.ReadFile(w.Files()[0], func(rec *txexport.Record) error {
+				records = append(records, rec)
+				return nil
+			}))
+			require.Len(t, records, 2)
+			assert.Equal(t, []string{alice.String(), bob.String()}, []string{records[0].Wallet, records[1].Wallet})
+			imported := memtxstore.New()
+			count, err = txexport.Import(imported, w.Files()...)
+			require.NoError(t, err)
+			assert.Equal(t, 732, count)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package txexport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Import stores transactions of the files into the store and returns the number of records read.
+// Stores deduplicate transactions, so records of the same transaction for several wallets are stored once.
+func Import(store txstore.Store, paths ...string) (int, error) {
+	count := 0
+	for _, path := range paths {
+		err := ReadFile(path, func(rec *Record) error {
+			count++
+			return store.StoreTransaction(rec.Transaction)
+		})
+		if err != nil {
+			return count, errors.Wrap(err, path)
+		}
+	}
+	return count, nil
+}
+
+// ReadFile feeds every record of the file into emit, stops at the first error emit returns
+func ReadFile(path string, emit func(*Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Read(f, emit)
+}
+
+// Read detects gzip and format by content, so file names do not matter: JSONL lines start with '{',
+// anything else is read as CSV with Columns header
+func Read(r io.Reader, emit func(*Record) error) error {
+	buffered := bufio.NewReaderSize(r, 64*1024)
+	if head, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return errors.Wrap(err, "failed to read gzip")
+		}
+		defer gz.Close()
+		return Read(gz, emit)
+	}
+	if head, _ := buffered.Peek(1); len(head) == 1 && head[0] == '{' {
+		return readJSONL(buffered, emit)
+	}
+	return readCSV(buffered, emit)
+}
+
+func readJSONL(r io.Reader, emit func(*Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return errors.Wrapf(err, "line %d", lineNum)
+		}
+		if rec.Transaction == nil {
+			return errors.Errorf("line %d: no transaction", lineNum)
+		}
+		if err := emit(rec); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "failed to read records")
+}
+
+// readCSV maps columns by header names, so files of older schema versions, with fewer columns, are read as well
+func readCSV(r io.Reader, emit func(*Record) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read header")
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[name] = i
+	}
+	for _, required := range []string{"hash", "block_number", "from", "to"} {
+		if _, ok := index[required]; !ok {
+			return errors.Errorf("no %s column", required)
+		}
+	}
+	for lineNum := 2; ; lineNum++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "line %d", lineNum)
+		}
+		column := func(name string) string {
+			if i, ok := index[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		rec, err := parseRow(column)
+		if err != nil {
+			return errors.Wrapf(err, "line %d", lineNum)
+		}
+		if err = emit(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// parseRow builds record of the row, column returns value by column name, empty for missing ones
+func parseRow(column func(name string) string) (*Record, error) {
+	tx := &types.Transaction{}
+	rec := &Record{Wallet: column("wallet"), Direction: column("direction"), Transaction: tx}
+	var err error
+	// Hex values are parsed as JSON strings are, so that the same checks apply
+	for name, dst := range map[string]json.Unmarshaler{
+		"hash": &tx.Hash, "block_hash": &tx.BlockHash, "from": &tx.From, "to": &tx.To, "input": &tx.Input,
+	} {
+		if val := column(name); val != "" {
+			if err = dst.UnmarshalJSON([]byte(val)); err != nil {
+				return nil, errors.Wrap(err, name)
+			}
+		}
+	}
+	for name, dst := range map[string]*types.BigInt{
+		"block_number": &tx.BlockNumber, "tx_index": &tx.TransactionIndex, "value": &tx.Value, "gas": &tx.Gas,
+		"gas_price": &tx.GasPrice, "nonce": &tx.Nonce, "type": &tx.Type,
+	} {
+		if err = parseDecimal(column(name), dst); err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+	}
+	for name, dst := range map[string]**types.BigInt{
+		"chain_id": &tx.ChainID, "max_fee_per_gas": &tx.MaxFeePerGas, "max_priority_fee_per_gas": &tx.MaxPriorityFeePerGas,
+	} {
+		if val := column(name); val != "" {
+			*dst = new(types.BigInt)
+			if err = parseDecimal(val, *dst); err != nil {
+				return nil, errors.Wrap(err, name)
+			}
+		}
+	}
+	if val := column("block_time"); val != "" {
+		ts, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, errors.Wrap(err, "block_time")
+		}
+		tx.BlockTimestamp = (*types.BigInt)(big.NewInt(ts.Unix()))
+	}
+	if val := column("orphaned"); val != "" {
+		if tx.Orphaned, err = strconv.ParseBool(val); err != nil {
+			return nil, errors.Wrap(err, "orphaned")
+		}
+	}
+	return rec, nil
+}
+
+func parseDecimal(val string, dst *types.BigInt) error {
+	if val == "" {
+		return nil
+	}
+	i, ok := new(big.Int).SetString(val, 10)
+	if !ok {
+		return errors.Errorf("invalid number %q", val)
+	}
+	*dst = types.BigInt(*i)
+	return nil
+}
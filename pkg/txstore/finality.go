@@ -0,0 +1,67 @@
+package txstore
+
+import (
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+)
+
+// Finality is what is known of the chain: its head and the last blocks tagged safe and finalized, nil when unknown.
+// Subscribers of chains without finality tags take blocks of their reorg depth below the head as finalized,
+// see blksubscriber.Subscriber.Finality, and know no safe blocks.
+type Finality struct {
+	Head      *big.Int
+	Safe      *big.Int
+	Finalized *big.Int
+}
+
+// Status returns status of the transaction, it is TxReorged for orphaned ones
+// and TxUnconfirmed when nothing is known of the block
+func (f *Finality) Status(tx *types.Transaction) types.TxStatus {
+	if tx.Orphaned {
+		return types.TxReorged
+	}
+	if f == nil {
+		return types.TxUnconfirmed
+	}
+	block := tx.BlockNumber.AsBigInt()
+	if f.Finalized != nil && block.Cmp(f.Finalized) <= 0 {
+		return types.TxFinalized
+	}
+	if f.Safe != nil && block.Cmp(f.Safe) <= 0 {
+		return types.TxSafe
+	}
+	return types.TxUnconfirmed
+}
+
+// Knows reports if the status can be told apart from TxUnconfirmed, which is not the case for safe and finalized
+// transactions when blocks of the tag are unknown
+func (f *Finality) Knows(status types.TxStatus) bool {
+	switch status {
+	case types.TxFinalized:
+		return f != nil && f.Finalized != nil
+	case types.TxSafe:
+		return f != nil && f.Safe != nil
+	}
+	return true
+}
+
+// Confirmations returns number of blocks from the transaction block up to the head, including both.
+// It is 0 for orphaned transactions and when the head is unknown or below the block.
+func (f *Finality) Confirmations(tx *types.Transaction) *big.Int {
+	if f == nil || f.Head == nil || tx.Orphaned {
+		return new(big.Int)
+	}
+	out := new(big.Int).Sub(f.Head, tx.BlockNumber.AsBigInt())
+	if out.Sign() < 0 {
+		return new(big.Int)
+	}
+	return out.Add(out, big.NewInt(1))
+}
+
+// Stamp returns copy of the transaction with Confirmations and Status set, stored transaction is never changed
+func (f *Finality) Stamp(tx *types.Transaction) *types.Transaction {
+	out := *tx
+	out.Confirmations = (*types.BigInt)(f.Confirmations(tx))
+	out.Status = f.Status(tx)
+	return &out
+}
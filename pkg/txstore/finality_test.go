@@ -0,0 +1,74 @@
+package txstore_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinality(t *testing.T) {
+	finality := &txstore.Finality{Head: big.NewInt(5), Safe: big.NewInt(3), Finalized: big.NewInt(1)}
+	orphaned := *history[5]
+	orphaned.Orphaned = true
+	for _, tc := range []struct {
+		tx            *types.Transaction
+		status        types.TxStatus
+		confirmations int64
+	}{
+		{tx: history[0], status: types.TxFinalized, confirmations: 5},
+		{tx: history[2], status: types.TxSafe, confirmations: 4},
+		{tx: history[3], status: types.TxSafe, confirmations: 3},
+		{tx: history[4], status: types.TxUnconfirmed, confirmations: 1},
+		{tx: &orphaned, status: types.TxReorged, confirmations: 0},
+	} {
+		stamped := finality.Stamp(tc.tx)
+		assert.Equal(t, tc.status, stamped.Status, tc.tx.Hash)
+		assert.Equal(t, tc.confirmations, stamped.Confirmations.AsBigInt().Int64(), tc.tx.Hash)
+		assert.Empty(t, tc.tx.Status, "stored transaction is not changed")
+		assert.True(t, tc.tx.Equal(stamped))
+	}
+
+	// Nothing is known of chains without finality tags
+	unknown := &txstore.Finality{Head: big.NewInt(3)}
+	assert.Equal(t, types.TxUnconfirmed, unknown.Status(history[0]))
+	assert.Equal(t, int64(0), unknown.Confirmations(history[5]).Int64())
+	assert.Equal(t, types.TxUnconfirmed, (*txstore.Finality)(nil).Status(history[0]))
+}
+
+func TestQueryStatuses(t *testing.T) {
+	finality := &txstore.Finality{Head: big.NewInt(5), Safe: big.NewInt(3), Finalized: big.NewInt(1)}
+	for name, tc := range map[string]struct {
+		statuses []types.TxStatus
+		expected []byte
+	}{
+		"Finalized": {statuses: []types.TxStatus{types.TxFinalized}, expected: []byte{1, 2}},
+		"Safe":      {statuses: []types.TxStatus{types.TxFinalized, types.TxSafe}, expected: []byte{1, 2, 3, 4}},
+		"Pending":   {statuses: []types.TxStatus{types.TxUnconfirmed}, expected: []byte{5, 6}},
+		"Reorged":   {statuses: []types.TxStatus{types.TxReorged}, expected: nil},
+	} {
+		page, err := (&txstore.Query{Address: alice.String(), Statuses: tc.statuses, Finality: finality}).Run(history)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, ids(page), name)
+	}
+
+	_, err := (&txstore.Query{Address: alice.String(), Statuses: []types.TxStatus{"Finalized"}}).Run(history)
+	assert.Error(t, err)
+
+	// Safe and finalized transactions can not be told apart from unconfirmed ones without the tags
+	for _, finality := range []*txstore.Finality{nil, {Head: big.NewInt(5)}, {Head: big.NewInt(5), Finalized: big.NewInt(1)}} {
+		_, err = (&txstore.Query{Address: alice.String(), Statuses: []types.TxStatus{types.TxSafe}, Finality: finality}).Run(history)
+		assert.ErrorIs(t, err, txstore.ErrFinalityUnknown)
+	}
+	_, err = (&txstore.Query{Address: alice.String(), Statuses: []types.TxStatus{types.TxFinalized}, Finality: &txstore.Finality{Head: big.NewInt(5)}}).Run(history)
+	assert.ErrorIs(t, err, txstore.ErrFinalityUnknown)
+	page, err := (&txstore.Query{Address: alice.String(), Statuses: []types.TxStatus{types.TxUnconfirmed, types.TxReorged}}).Run(history)
+	require.NoError(t, err)
+	assert.Len(t, page.Transactions, 6)
+	status, err := types.ParseTxStatus("Finalized")
+	require.NoError(t, err)
+	assert.Equal(t, types.TxFinalized, status)
+}
@@ -0,0 +1,390 @@
+package txstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Direction selects transactions by the side the queried address is on
+type Direction int
+
+const (
+	// DirectionAny matches every transaction sent from or to the address
+	DirectionAny Direction = iota
+	// DirectionIncoming matches transactions sent to the address by others
+	DirectionIncoming
+	// DirectionOutgoing matches transactions sent by the address to others
+	DirectionOutgoing
+	// DirectionSelf matches transactions the address sent to itself
+	DirectionSelf
+)
+
+var directionNames = []string{"any", "incoming", "outgoing", "self"}
+
+func (d Direction) String() string {
+	if d < 0 || int(d) >= len(directionNames) {
+		return "unknown"
+	}
+	return directionNames[d]
+}
+
+// ParseDirection parses direction by name, empty name is DirectionAny
+func ParseDirection(name string) (Direction, error) {
+	if name == "" {
+		return DirectionAny, nil
+	}
+	for i, known := range directionNames {
+		if strings.EqualFold(name, known) {
+			return Direction(i), nil
+		}
+	}
+	return 0, errors.Errorf("unknown direction %q, options: %s", name, strings.Join(directionNames, ", "))
+}
+
+// DirectionOf tells the side the address is on, DirectionAny when the transaction is not of the address
+func DirectionOf(address string, tx *types.Transaction) Direction {
+	incoming, outgoing := tx.To.String() == address, tx.From.String() == address
+	switch {
+	case incoming && outgoing:
+		return DirectionSelf
+	case incoming:
+		return DirectionIncoming
+	case outgoing:
+		return DirectionOutgoing
+	}
+	return DirectionAny
+}
+
+// Order of returned transactions, by block number and transaction index
+type Order int
+
+const (
+	OrderAscending Order = iota
+	OrderDescending
+)
+
+// Query selects transactions of an address. Zero value of every filter matches everything.
+type Query struct {
+	// Address is the wallet transactions are queried for, it is required
+	Address   string
+	Direction Direction
+	// Counterparty matches transactions sent to or from the address, the other side of the transfer
+	Counterparty string
+	// MinValue and MaxValue are inclusive bounds of transferred wei
+	MinValue *big.Int
+	MaxValue *big.Int
+	// FromBlock and ToBlock are inclusive bounds of the block number
+	FromBlock *big.Int
+	ToBlock   *big.Int
+	// FromTime is inclusive and ToTime exclusive bound of the block timestamp,
+	// transactions stored without timestamp are not matched by them
+	FromTime time.Time
+	ToTime   time.Time
+	// Statuses matches transactions with any of the statuses, as Finality tells them.
+	// Safe and finalized ones can only be matched when Finality knows blocks of the tag.
+	Statuses []types.TxStatus
+	Finality *Finality
+	Order    Order
+	// Limit is the page size, 0 means no limit
+	Limit int
+	// Cursor continues the query from Page.NextCursor of the previous page
+	Cursor string
+}
+
+// Page is a result of Query
+type Page struct {
+	Transactions []*types.Transaction `json:"transactions"`
+	// NextCursor is set when there are more transactions
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrFinalityUnknown is returned by queries of safe or finalized transactions when the blocks of the tag are unknown,
+// as they would match nothing
+var ErrFinalityUnknown = errors.New("finality is unknown")
+
+// Validate checks the query is well-formed
+func (q *Query) Validate() error {
+	if q.Address == "" {
+		return errors.New("query address is required")
+	}
+	if q.Direction < DirectionAny || q.Direction > DirectionSelf {
+		return errors.Errorf("unknown direction %d", q.Direction)
+	}
+	if q.Order != OrderAscending && q.Order != OrderDescending {
+		return errors.Errorf("unknown order %d", q.Order)
+	}
+	if q.Limit < 0 {
+		return errors.New("limit can not be negative")
+	}
+	if q.MinValue != nil && q.MaxValue != nil && q.MinValue.Cmp(q.MaxValue) > 0 {
+		return errors.New("min value is above max value")
+	}
+	if q.FromBlock != nil && q.ToBlock != nil && q.FromBlock.Cmp(q.ToBlock) > 0 {
+		return errors.New("from block is above to block")
+	}
+	for _, status := range q.Statuses {
+		if parsed, err := types.ParseTxStatus(string(status)); err != nil || parsed != status {
+			return errors.Errorf("unknown transaction status %q", status)
+		}
+		if !q.Finality.Knows(status) {
+			return errors.Wrapf(ErrFinalityUnknown, "can not match %s transactions", status)
+		}
+	}
+	if _, _, err := q.position(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Position of a transaction in the order of the store. Hash tells apart transactions of different chains
+// at the same block and index, so that pages never skip or repeat them.
+type Position struct {
+	Block uint64
+	Index uint64
+	Hash  types.EthHash
+}
+
+var maxHash = func() (out types.EthHash) {
+	for i := range out {
+		out[i] = 0xff
+	}
+	return out
+}()
+
+// PositionOf returns position of the transaction
+func PositionOf(tx *types.Transaction) Position {
+	return Position{Block: tx.BlockNumber.AsBigInt().Uint64(), Index: tx.TransactionIndex.AsBigInt().Uint64(), Hash: tx.Hash}
+}
+
+// Less orders positions by block number, then by index in the block, then by hash
+func (p Position) Less(o Position) bool {
+	if p.Block != o.Block {
+		return p.Block < o.Block
+	}
+	if p.Index != o.Index {
+		return p.Index < o.Index
+	}
+	return bytes.Compare(p.Hash[:], o.Hash[:]) < 0
+}
+
+// next returns the position right after p, ok is false if p is the last one
+func (p Position) next() (Position, bool) {
+	for i := len(p.Hash) - 1; i >= 0; i-- {
+		if p.Hash[i]++; p.Hash[i] != 0 {
+			return p, true
+		}
+	}
+	if p.Index++; p.Index != 0 {
+		return p, true
+	}
+	p.Block++
+	return p, p.Block != 0
+}
+
+// prev returns the position right before p, ok is false if p is the first one
+func (p Position) prev() (Position, bool) {
+	for i := len(p.Hash) - 1; i >= 0; i-- {
+		if p.Hash[i]--; p.Hash[i] != 0xff {
+			return p, true
+		}
+	}
+	if p.Index--; p.Index != ^uint64(0) {
+		return p, true
+	}
+	p.Block--
+	return p, p.Block != ^uint64(0)
+}
+
+// cursors are position of the last returned transaction, prefixed by version to change the format later
+const cursorVersion = 1
+
+// EncodeCursor returns cursor that continues the query after the position
+func EncodeCursor(p Position) string {
+	buf := make([]byte, 1, 1+2*binary.MaxVarintLen64+len(p.Hash))
+	buf[0] = cursorVersion
+	buf = binary.AppendUvarint(buf, p.Block)
+	buf = binary.AppendUvarint(buf, p.Index)
+	buf = append(buf, p.Hash[:]...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// position decodes the cursor, ok is false when there is none
+func (q *Query) position() (pos Position, ok bool, err error) {
+	if q.Cursor == "" {
+		return Position{}, false, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || len(buf) == 0 || buf[0] != cursorVersion {
+		return Position{}, false, ErrInvalidCursor
+	}
+	buf = buf[1:]
+	var n int
+	if pos.Block, n = binary.Uvarint(buf); n <= 0 {
+		return Position{}, false, ErrInvalidCursor
+	}
+	buf = buf[n:]
+	if pos.Index, n = binary.Uvarint(buf); n <= 0 || len(buf)-n != len(pos.Hash) {
+		return Position{}, false, ErrInvalidCursor
+	}
+	copy(pos.Hash[:], buf[n:])
+	return pos, true, nil
+}
+
+// Bounds returns the first and the last position the query can match, taking the cursor into account.
+// Stores use them to skip to the range of their ordered index, ok is false when nothing can match.
+func (q *Query) Bounds() (from, to Position, ok bool) {
+	from, to = Position{}, Position{Block: ^uint64(0), Index: ^uint64(0), Hash: maxHash}
+	if q.FromBlock != nil && q.FromBlock.Sign() > 0 {
+		if !q.FromBlock.IsUint64() {
+			return from, to, false
+		}
+		from.Block = q.FromBlock.Uint64()
+	}
+	if q.ToBlock != nil {
+		if q.ToBlock.Sign() < 0 {
+			return from, to, false
+		}
+		if q.ToBlock.IsUint64() {
+			to.Block = q.ToBlock.Uint64()
+		}
+	}
+	// Cursor is the last returned position, the page continues right after it
+	if cursor, set, err := q.position(); err == nil && set {
+		if q.Order == OrderAscending {
+			next, ok := cursor.next()
+			if !ok {
+				return from, to, false
+			}
+			if from.Less(next) {
+				from = next
+			}
+		} else {
+			prev, ok := cursor.prev()
+			if !ok {
+				return from, to, false
+			}
+			if prev.Less(to) {
+				to = prev
+			}
+		}
+	}
+	return from, to, !to.Less(from)
+}
+
+// Match reports if the transaction passes filters of the query, except for block range and cursor, which are
+// applied by Bounds. Address is compared as stores key transactions, by EthAddress.String().
+func (q *Query) Match(tx *types.Transaction) bool {
+	from, to := tx.From.String(), tx.To.String()
+	incoming, outgoing := to == q.Address, from == q.Address
+	switch q.Direction {
+	case DirectionAny:
+		if !incoming && !outgoing {
+			return false
+		}
+	case DirectionIncoming:
+		if !incoming || outgoing {
+			return false
+		}
+	case DirectionOutgoing:
+		if !outgoing || incoming {
+			return false
+		}
+	case DirectionSelf:
+		if !incoming || !outgoing {
+			return false
+		}
+	}
+	if q.Counterparty != "" {
+		if !(outgoing && to == q.Counterparty) && !(incoming && from == q.Counterparty) {
+			return false
+		}
+	}
+	if q.MinValue != nil && tx.Value.AsBigInt().Cmp(q.MinValue) < 0 {
+		return false
+	}
+	if q.MaxValue != nil && tx.Value.AsBigInt().Cmp(q.MaxValue) > 0 {
+		return false
+	}
+	if !q.MatchStatus(tx) {
+		return false
+	}
+	if !q.FromTime.IsZero() || !q.ToTime.IsZero() {
+		if tx.BlockTimestamp == nil {
+			return false
+		}
+		ts := tx.BlockTimestamp.AsBigInt().Int64()
+		if !q.FromTime.IsZero() && ts < q.FromTime.Unix() {
+			return false
+		}
+		if !q.ToTime.IsZero() && ts >= q.ToTime.Unix() {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchStatus reports if the transaction has any of Statuses of the query, it is true when there are none
+func (q *Query) MatchStatus(tx *types.Transaction) bool {
+	return len(q.Statuses) == 0 || slices.Contains(q.Statuses, q.Finality.Status(tx))
+}
+
+// Collect runs the query over transactions walked in the query order by next, which returns nil when there are no more.
+// It stops as soon as the page is full and it is known if there is a next one.
+func (q *Query) Collect(next func() *types.Transaction) Page {
+	var out Page
+	for tx := next(); tx != nil; tx = next() {
+		if !q.Match(tx) {
+			continue
+		}
+		if q.Limit != 0 && len(out.Transactions) == q.Limit {
+			out.NextCursor = EncodeCursor(PositionOf(out.Transactions[len(out.Transactions)-1]))
+			break
+		}
+		out.Transactions = append(out.Transactions, tx)
+	}
+	return out
+}
+
+// Run runs the query over transactions of the address ordered by their Position, as returned by GetTransactions. It is for stores that can not run queries themselves.
+func (q *Query) Run(txs []*types.Transaction) (Page, error) {
+	if err := q.Validate(); err != nil {
+		return Page{}, err
+	}
+	from, to, ok := q.Bounds()
+	if !ok {
+		return Page{}, nil
+	}
+	start := sort.Search(len(txs), func(i int) bool { return !PositionOf(txs[i]).Less(from) })
+	end := sort.Search(len(txs), func(i int) bool { return to.Less(PositionOf(txs[i])) })
+	if start >= end {
+		return Page{}, nil
+	}
+	txs = txs[start:end]
+	i := 0
+	if q.Order == OrderDescending {
+		i = len(txs) - 1
+	}
+	return q.Collect(func() *types.Transaction {
+		if i < 0 || i >= len(txs) {
+			return nil
+		}
+		tx := txs[i]
+		if q.Order == OrderDescending {
+			i--
+		} else {
+			i++
+		}
+		return tx
+	}), nil
+}
@@ -0,0 +1,128 @@
+package txstore_test
+
+import (
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+	carol = types.EthAddress{0xc}
+	epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newTx(n byte, block, index int64, from, to types.EthAddress, value int64) *types.Transaction {
+	ts := types.BigInt(*big.NewInt(epoch.Unix() + block*12))
+	return &types.Transaction{
+		Hash:             types.EthHash{n},
+		BlockNumber:      types.BigInt(*big.NewInt(block)),
+		TransactionIndex: types.BigInt(*big.NewInt(index)),
+		BlockTimestamp:   &ts,
+		From:             from,
+		To:               to,
+		Value:            types.BigInt(*big.NewInt(value)),
+	}
+}
+
+// history of alice ordered as stores return it
+var history = []*types.Transaction{
+	newTx(1, 1, 0, bob, alice, 100),
+	newTx(2, 1, 3, alice, bob, 50),
+	newTx(3, 2, 0, alice, alice, 0),
+	newTx(4, 3, 1, carol, alice, 1000),
+	newTx(5, 5, 0, alice, carol, 10),
+	newTx(6, 5, 2, bob, alice, 7),
+}
+
+func ids(page txstore.Page) []byte {
+	var out []byte
+	for _, tx := range page.Transactions {
+		out = append(out, tx.Hash[0])
+	}
+	return out
+}
+
+func TestQueryFilters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		query    txstore.Query
+		expected []byte
+	}{
+		"All":          {query: txstore.Query{}, expected: []byte{1, 2, 3, 4, 5, 6}},
+		"Incoming":     {query: txstore.Query{Direction: txstore.DirectionIncoming}, expected: []byte{1, 4, 6}},
+		"Outgoing":     {query: txstore.Query{Direction: txstore.DirectionOutgoing}, expected: []byte{2, 5}},
+		"Self":         {query: txstore.Query{Direction: txstore.DirectionSelf}, expected: []byte{3}},
+		"Counterparty": {query: txstore.Query{Counterparty: bob.String()}, expected: []byte{1, 2, 6}},
+		"IncomingFrom": {query: txstore.Query{Counterparty: bob.String(), Direction: txstore.DirectionIncoming}, expected: []byte{1, 6}},
+		"Value":        {query: txstore.Query{MinValue: big.NewInt(10), MaxValue: big.NewInt(100)}, expected: []byte{1, 2, 5}},
+		"Blocks":       {query: txstore.Query{FromBlock: big.NewInt(2), ToBlock: big.NewInt(3)}, expected: []byte{3, 4}},
+		"Time":         {query: txstore.Query{FromTime: epoch.Add(24 * time.Second), ToTime: epoch.Add(60 * time.Second)}, expected: []byte{3, 4}},
+		"Descending":   {query: txstore.Query{Order: txstore.OrderDescending, FromBlock: big.NewInt(3)}, expected: []byte{6, 5, 4}},
+		"NoBlocks":     {query: txstore.Query{FromBlock: new(big.Int).Lsh(big.NewInt(1), 64)}, expected: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.query.Address = alice.String()
+			page, err := tc.query.Run(history)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(page))
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestQueryPages(t *testing.T) {
+	for _, tc := range []struct {
+		order    txstore.Order
+		expected [][]byte
+	}{
+		{order: txstore.OrderAscending, expected: [][]byte{{1, 4}, {6}}},
+		{order: txstore.OrderDescending, expected: [][]byte{{6, 4}, {1}}},
+	} {
+		query := txstore.Query{Address: alice.String(), Direction: txstore.DirectionIncoming, Order: tc.order, Limit: 2}
+		var pages [][]byte
+		for {
+			page, err := query.Run(history)
+			require.NoError(t, err)
+			pages = append(pages, ids(page))
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Equal(t, tc.expected, pages)
+	}
+
+	// Exactly full last page has no cursor
+	page, err := (&txstore.Query{Address: alice.String(), Direction: txstore.DirectionOutgoing, Limit: 2}).Run(history)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2, 5}, ids(page))
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestQueryValidate(t *testing.T) {
+	for name, query := range map[string]txstore.Query{
+		"NoAddress": {},
+		"Cursor":    {Address: alice.String(), Cursor: "not a cursor"},
+		"Limit":     {Address: alice.String(), Limit: -1},
+		"Values":    {Address: alice.String(), MinValue: big.NewInt(2), MaxValue: big.NewInt(1)},
+		"Blocks":    {Address: alice.String(), FromBlock: big.NewInt(2), ToBlock: big.NewInt(1)},
+		"Direction": {Address: alice.String(), Direction: 7},
+	} {
+		_, err := query.Run(history)
+		assert.Error(t, err, name)
+	}
+	_, err := (&txstore.Query{Address: alice.String(), Cursor: "AQ"}).Run(history)
+	assert.ErrorIs(t, err, txstore.ErrInvalidCursor)
+
+	direction, err := txstore.ParseDirection("Incoming")
+	require.NoError(t, err)
+	assert.Equal(t, txstore.DirectionIncoming, direction)
+	_, err = txstore.ParseDirection("sideways")
+	assert.Error(t, err)
+}
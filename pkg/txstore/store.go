@@ -0,0 +1,48 @@
+// Package txstore holds what transaction stores share: the interfaces subscribers use them through,
+// the query of stored transactions and helpers to run it. Package storetest checks stores against the interfaces.
+package txstore
+
+import (
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+)
+
+// Store is what every transaction store implements, memtxstore.Store, disktxstore.Store and sqltxstore.Store included.
+// Transactions are deduplicated by hash, the last stored version wins, and are returned ordered by block,
+// index and hash. A transaction is returned for both its sender and its recipient. Methods are safe for concurrent use.
+type Store interface {
+	StoreTransaction(tx *types.Transaction) error
+	GetTransactions(address string) ([]*types.Transaction, error)
+	// GetTransactionsAfterBlock returns transactions of blocks above blkId
+	GetTransactionsAfterBlock(blkId big.Int, address string) ([]*types.Transaction, error)
+	// StoreBlockReward stores reward of a block once, rewards are deduplicated by block hash
+	StoreBlockReward(reward *types.ProposedBlockReward) error
+	GetBlockRewards(address string) ([]*types.ProposedBlockReward, error)
+}
+
+// Querier is implemented by stores that run Query themselves, e.g. memtxstore.Store
+type Querier interface {
+	QueryTransactions(q Query) (Page, error)
+}
+
+// Checkpointer is implemented by stores that survive restarts, e.g. disktxstore.Store
+type Checkpointer interface {
+	SaveCheckpoint(blkId big.Int) error
+	// GetCheckpoint returns nil when no checkpoint was saved
+	GetCheckpoint() (*big.Int, error)
+}
+
+// Rollbacker is implemented by stores that follow reorgs, both methods return how many transactions and rewards
+// were rolled back
+type Rollbacker interface {
+	// Rollback rolls back everything stored from blocks above blkId
+	Rollback(blkId big.Int) (int, error)
+	// RollbackBlock rolls back everything stored from the block and blocks above it, nothing when it is unknown
+	RollbackBlock(hash types.EthHash) (int, error)
+}
+
+// TombstoneKeeper is implemented by stores that keep history of rolled back transactions and rewards
+type TombstoneKeeper interface {
+	// Tombstones returns tombstones matching the address in the order they were made
+	Tombstones(address string) ([]Tombstone, error)
+}
@@ -0,0 +1,410 @@
+// Package storetest is the conformance suite of txstore.Store, every store of the library passes it,
+// third-party stores run it from their tests to prove they behave the same:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) txstore.Store {
+//			return mystore.New()
+//		})
+//	}
+package storetest
+
+import (
+	"fmt"
+	"github.com/dkropachev/ethscan/pkg/txstore"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a new empty store, closing it is up to the factory, e.g. with t.Cleanup
+type Factory func(t *testing.T) txstore.Store
+
+var (
+	alice = types.EthAddress{0xa}
+	bob   = types.EthAddress{0xb}
+	carol = types.EthAddress{0xc}
+)
+
+// Run runs the suite against stores made by the factory, every subtest gets a store of its own.
+// Rollback, checkpoint, tombstone and query subtests run when the store implements the matching interface of txstore.
+func Run(t *testing.T, factory Factory) {
+	t.Run("OrderAndDedup", func(t *testing.T) { testOrderAndDedup(t, factory(t)) })
+	t.Run("RangeQueries", func(t *testing.T) { testRangeQueries(t, factory(t)) })
+	t.Run("BlockRewards", func(t *testing.T) { testBlockRewards(t, factory(t)) })
+	t.Run("ConcurrentWrites", func(t *testing.T) { testConcurrentWrites(t, factory(t)) })
+	t.Run("ConcurrentDedup", func(t *testing.T) { testConcurrentDedup(t, factory(t)) })
+	t.Run("Rollback", func(t *testing.T) { testRollback(t, factory(t)) })
+	t.Run("Checkpoint", func(t *testing.T) { testCheckpoint(t, factory(t)) })
+	t.Run("Query", func(t *testing.T) { testQuery(t, factory(t)) })
+}
+
+// newTx makes transaction n from alice to bob, transactions of the same block share its hash
+func newTx(n int, block, index int64) *types.Transaction {
+	return &types.Transaction{
+		Hash:             types.EthHash{byte(n), byte(n >> 8), 0xee},
+		BlockHash:        blockHash(block),
+		BlockNumber:      types.BigInt(*big.NewInt(block)),
+		TransactionIndex: types.BigInt(*big.NewInt(index)),
+		From:             alice,
+		To:               bob,
+		Value:            types.BigInt(*big.NewInt(int64(n) * 1000)),
+		Input:            types.BinData{1, 2, 3, byte(n)},
+	}
+}
+
+func blockHash(block int64) types.EthHash {
+	return types.EthHash{0xbb, byte(block), byte(block >> 8)}
+}
+
+func newReward(block int64, recipient types.EthAddress) *types.ProposedBlockReward {
+	return &types.ProposedBlockReward{
+		BlockHash:    blockHash(block),
+		BlockNumber:  types.BigInt(*big.NewInt(block)),
+		FeeRecipient: recipient,
+		PriorityFees: types.BigInt(*big.NewInt(block * 10)),
+	}
+}
+
+// hashesOf returns helper that takes results of reads, hashes are compared instead of transactions,
+// since stores that decode them return different big integers internally
+func hashesOf(t *testing.T) func(txs []*types.Transaction, err error) []types.EthHash {
+	return func(txs []*types.Transaction, err error) []types.EthHash {
+		t.Helper()
+		require.NoError(t, err)
+		var out []types.EthHash
+		for _, tx := range txs {
+			out = append(out, tx.Hash)
+		}
+		return out
+	}
+}
+
+// live drops transactions stores flagged as orphaned instead of removing them
+func live(txs []*types.Transaction, err error) ([]*types.Transaction, error) {
+	return slices.DeleteFunc(txs, func(tx *types.Transaction) bool { return tx.Orphaned }), err
+}
+
+func ordered(txs []*types.Transaction) []types.EthHash {
+	txs = slices.Clone(txs)
+	slices.SortFunc(txs, func(a, b *types.Transaction) int {
+		if txstore.PositionOf(a).Less(txstore.PositionOf(b)) {
+			return -1
+		}
+		return 1
+	})
+	var out []types.EthHash
+	for _, tx := range txs {
+		out = append(out, tx.Hash)
+	}
+	return out
+}
+
+func testOrderAndDedup(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	b5i1, b3i0, b5i0, b7i2 := newTx(1, 5, 1), newTx(2, 3, 0), newTx(3, 5, 0), newTx(4, 7, 2)
+	for _, tx := range []*types.Transaction{b5i1, b3i0, b5i0, b7i2, b3i0} {
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	// Copy decoded again from the node is the same transaction
+	dup := *b5i1
+	require.NoError(t, store.StoreTransaction(&dup))
+
+	expected := []types.EthHash{b3i0.Hash, b5i0.Hash, b5i1.Hash, b7i2.Hash}
+	assert.Equal(t, expected, hashes(store.GetTransactions(alice.String())), "sender")
+	assert.Equal(t, expected, hashes(store.GetTransactions(bob.String())), "recipient")
+	assert.Empty(t, hashes(store.GetTransactions(carol.String())), "unknown address")
+
+	txs, err := store.GetTransactions(alice.String())
+	require.NoError(t, err)
+	assert.True(t, b3i0.Equal(txs[0]), "stored transaction is returned unchanged")
+
+	// The last stored version wins, re-included into another block the transaction moves
+	moved := *b3i0
+	moved.BlockNumber = types.BigInt(*big.NewInt(8))
+	moved.BlockHash = blockHash(8)
+	require.NoError(t, store.StoreTransaction(&moved))
+	assert.Equal(t, []types.EthHash{b5i0.Hash, b5i1.Hash, b7i2.Hash, b3i0.Hash}, hashes(store.GetTransactions(alice.String())))
+	txs, err = store.GetTransactions(bob.String())
+	require.NoError(t, err)
+	require.Len(t, txs, 4)
+	assert.Equal(t, int64(8), txs[3].BlockNumber.AsBigInt().Int64())
+
+	// Self transfer is listed once
+	self := newTx(5, 9, 0)
+	self.To = alice
+	require.NoError(t, store.StoreTransaction(self))
+	assert.Equal(t, []types.EthHash{self.Hash}, hashes(store.GetTransactionsAfterBlock(*big.NewInt(8), alice.String())))
+	assert.Empty(t, hashes(store.GetTransactionsAfterBlock(*big.NewInt(8), bob.String())))
+}
+
+func testRangeQueries(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	var all []types.EthHash
+	for n := range 10 {
+		// Two transactions a block, written out of order
+		tx := newTx(n+1, int64(10-n/2), int64(n%2))
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	for block := range int64(5) {
+		all = append(all, newTx(10-int(block)*2-1, block+6, 0).Hash, newTx(10-int(block)*2, block+6, 1).Hash)
+	}
+	for _, tc := range []struct {
+		after    int64
+		expected []types.EthHash
+	}{
+		{after: 0, expected: all},
+		{after: 5, expected: all},
+		{after: 6, expected: all[2:]},
+		{after: 8, expected: all[6:]},
+		{after: 9, expected: all[8:]},
+		{after: 10, expected: nil},
+		{after: 1 << 40, expected: nil},
+	} {
+		assert.Equal(t, tc.expected, hashes(store.GetTransactionsAfterBlock(*big.NewInt(tc.after), bob.String())), tc.after)
+	}
+}
+
+func testBlockRewards(t *testing.T, store txstore.Store) {
+	for _, reward := range []*types.ProposedBlockReward{newReward(3, alice), newReward(1, alice), newReward(2, bob), newReward(3, alice)} {
+		require.NoError(t, store.StoreBlockReward(reward))
+	}
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	var blocks []int64
+	for _, reward := range rewards {
+		blocks = append(blocks, reward.BlockNumber.AsBigInt().Int64())
+		assert.Equal(t, alice, reward.FeeRecipient)
+		assert.Equal(t, blockHash(reward.BlockNumber.AsBigInt().Int64()), reward.BlockHash)
+	}
+	slices.Sort(blocks)
+	assert.Equal(t, []int64{1, 3}, blocks)
+
+	rewards, err = store.GetBlockRewards(carol.String())
+	require.NoError(t, err)
+	assert.Empty(t, rewards)
+}
+
+// testConcurrentWrites writes from several goroutines while others read, as block processors and API handlers do
+func testConcurrentWrites(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	const writers, perWriter, reads = 4, 50, 20
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		expected []*types.Transaction
+	)
+	for w := range writers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := range perWriter {
+				tx := newTx(w*perWriter+n, int64(n+1), int64(w))
+				if n%3 == 0 {
+					tx.From = carol
+				}
+				if err := store.StoreTransaction(tx); err != nil {
+					t.Error(err)
+				}
+				if n%3 != 0 {
+					lock.Lock()
+					expected = append(expected, tx)
+					lock.Unlock()
+				}
+				if n%10 == 0 {
+					if err := store.StoreBlockReward(newReward(int64(w*perWriter+n+1), alice)); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := range reads {
+				if _, err := store.GetTransactions(alice.String()); err != nil {
+					t.Error(err)
+				}
+				if _, err := store.GetTransactionsAfterBlock(*big.NewInt(int64(n)), bob.String()); err != nil {
+					t.Error(err)
+				}
+				if _, err := store.GetBlockRewards(alice.String()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, ordered(expected), hashes(store.GetTransactions(alice.String())))
+	txs, err := store.GetTransactions(bob.String())
+	require.NoError(t, err)
+	assert.Len(t, txs, writers*perWriter)
+	assert.True(t, slices.IsSortedFunc(txs, func(a, b *types.Transaction) int {
+		if txstore.PositionOf(a).Less(txstore.PositionOf(b)) {
+			return -1
+		}
+		return 1
+	}))
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	assert.Len(t, rewards, writers*perWriter/10)
+}
+
+// testConcurrentDedup writes the same transactions and rewards from several goroutines, as overlapping subscribers do
+func testConcurrentDedup(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	const writers, count = 4, 50
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range count {
+				if err := store.StoreTransaction(newTx(n, int64(n/5+1), int64(n%5))); err != nil {
+					t.Error(err)
+				}
+				if err := store.StoreBlockReward(newReward(int64(n/5+1), alice)); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var expected []types.EthHash
+	for n := range count {
+		expected = append(expected, newTx(n, 0, 0).Hash)
+	}
+	assert.Equal(t, expected, hashes(store.GetTransactions(alice.String())))
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	assert.Len(t, rewards, count/5)
+}
+
+func testRollback(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	rollbacks, ok := store.(txstore.Rollbacker)
+	if !ok {
+		t.Skip("store does not roll back")
+	}
+	for n := range 4 {
+		require.NoError(t, store.StoreTransaction(newTx(n+1, int64(n+1), 0)))
+		require.NoError(t, store.StoreBlockReward(newReward(int64(n+1), alice)))
+	}
+
+	removed, err := rollbacks.RollbackBlock(blockHash(4))
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed, "transaction and reward of block 4")
+	removed, err = rollbacks.Rollback(*big.NewInt(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed, "transaction and reward of block 3")
+	removed, err = rollbacks.RollbackBlock(blockHash(9))
+	require.NoError(t, err)
+	assert.Zero(t, removed, "unknown block")
+	removed, err = rollbacks.Rollback(*big.NewInt(2))
+	require.NoError(t, err)
+	assert.Zero(t, removed, "nothing above")
+
+	one, two, three := newTx(1, 0, 0).Hash, newTx(2, 0, 0).Hash, newTx(3, 0, 0).Hash
+	assert.Equal(t, []types.EthHash{one, two}, hashes(live(store.GetTransactions(alice.String()))))
+	assert.Empty(t, hashes(live(store.GetTransactionsAfterBlock(*big.NewInt(2), bob.String()))))
+	rewards, err := store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	assert.Len(t, rewards, 2)
+
+	// The new branch includes transaction 3 again and has a block 3 of its own
+	require.NoError(t, store.StoreTransaction(newTx(3, 5, 0)))
+	reward := newReward(3, alice)
+	reward.BlockHash = types.EthHash{0xcc, 3}
+	require.NoError(t, store.StoreBlockReward(reward))
+	assert.Equal(t, []types.EthHash{one, two, three}, hashes(live(store.GetTransactions(bob.String()))))
+	rewards, err = store.GetBlockRewards(alice.String())
+	require.NoError(t, err)
+	assert.Len(t, rewards, 3)
+
+	tombstones, ok := store.(txstore.TombstoneKeeper)
+	if !ok {
+		return
+	}
+	tombs, err := tombstones.Tombstones(bob.String())
+	require.NoError(t, err)
+	require.Len(t, tombs, 2)
+	assert.Equal(t, newTx(4, 0, 0).Hash, tombs[0].Transaction.Hash)
+	assert.Equal(t, int64(4), tombs[0].From.AsBigInt().Int64())
+	assert.Equal(t, three, tombs[1].Transaction.Hash)
+	assert.Equal(t, int64(3), tombs[1].From.AsBigInt().Int64())
+	tombs, err = tombstones.Tombstones(alice.String())
+	require.NoError(t, err)
+	assert.Len(t, tombs, 4, "transactions and rewards")
+	tombs, err = tombstones.Tombstones(carol.String())
+	require.NoError(t, err)
+	assert.Empty(t, tombs)
+}
+
+func testCheckpoint(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	checkpoints, ok := store.(txstore.Checkpointer)
+	if !ok {
+		t.Skip("store does not keep checkpoints")
+	}
+	checkpoint, err := checkpoints.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	require.NoError(t, store.StoreTransaction(newTx(1, 5, 0)))
+	for _, blkId := range []int64{5, 7} {
+		require.NoError(t, checkpoints.SaveCheckpoint(*big.NewInt(blkId)))
+		checkpoint, err = checkpoints.GetCheckpoint()
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(blkId), checkpoint)
+	}
+	assert.Equal(t, []types.EthHash{newTx(1, 0, 0).Hash}, hashes(store.GetTransactions(alice.String())))
+}
+
+// testQuery checks that the store runs queries the same way txstore.Query.Run does over GetTransactions
+func testQuery(t *testing.T, store txstore.Store) {
+	hashes := hashesOf(t)
+	querier, ok := store.(txstore.Querier)
+	if !ok {
+		t.Skip("store does not run queries")
+	}
+	for n := range 20 {
+		tx := newTx(n+1, int64(n/3+1), int64(n%3))
+		if n%4 == 0 {
+			tx.From, tx.To = bob, alice
+		}
+		require.NoError(t, store.StoreTransaction(tx))
+	}
+	all, err := store.GetTransactions(alice.String())
+	require.NoError(t, err)
+
+	for i, q := range []txstore.Query{
+		{Address: alice.String()},
+		{Address: alice.String(), Direction: txstore.DirectionIncoming},
+		{Address: alice.String(), Counterparty: bob.String(), MinValue: big.NewInt(5000), MaxValue: big.NewInt(15000)},
+		{Address: alice.String(), FromBlock: big.NewInt(2), ToBlock: big.NewInt(5), Order: txstore.OrderDescending},
+		{Address: alice.String(), Limit: 3},
+		{Address: alice.String(), Limit: 4, Order: txstore.OrderDescending},
+	} {
+		name := fmt.Sprint("query ", i)
+		// Pages are followed to the end, both runs have to agree on every one of them
+		for page := 0; page < 10; page++ {
+			expected, err := q.Run(all)
+			require.NoError(t, err, name)
+			actual, err := querier.QueryTransactions(q)
+			require.NoError(t, err, name)
+			assert.Equal(t, hashes(expected.Transactions, nil), hashes(actual.Transactions, nil), name)
+			assert.Equal(t, expected.NextCursor, actual.NextCursor, name)
+			if actual.NextCursor == "" {
+				break
+			}
+			q.Cursor = actual.NextCursor
+		}
+	}
+	_, err = querier.QueryTransactions(txstore.Query{})
+	assert.Error(t, err, "query without address")
+}
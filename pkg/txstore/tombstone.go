@@ -0,0 +1,35 @@
+package txstore
+
+import (
+	"github.com/dkropachev/ethscan/pkg/types"
+	"time"
+)
+
+// RollbackMode tells what stores do with transactions of blocks reorged out
+type RollbackMode int
+
+const (
+	// RollbackRemove drops them from the store
+	RollbackRemove RollbackMode = iota
+	// RollbackFlag keeps them with Orphaned set, so that it is seen what was reorged out
+	RollbackFlag
+)
+
+// Tombstone records a transaction or a block reward rolled back because its block was reorged out
+type Tombstone struct {
+	Transaction *types.Transaction         `json:"transaction,omitempty"`
+	Reward      *types.ProposedBlockReward `json:"reward,omitempty"`
+	// From is the first block of the rollback
+	From      types.BigInt `json:"from"`
+	RemovedAt time.Time    `json:"removedAt"`
+	// Flagged is set when the transaction is kept in the store marked as orphaned instead of being removed
+	Flagged bool `json:"flagged,omitempty"`
+}
+
+// Matches reports if the tombstone is of a transaction sent from or to the address or of a reward to it
+func (t *Tombstone) Matches(address string) bool {
+	if t.Transaction != nil {
+		return t.Transaction.From.String() == address || t.Transaction.To.String() == address
+	}
+	return t.Reward != nil && t.Reward.FeeRecipient.String() == address
+}
@@ -0,0 +1,212 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Extension decodes chain specific fields that are not part of mainnet objects, e.g. OP stack deposit fields of transactions.
+// Registered extensions are tried on every decoded object, the ones whose fields are present get decoded into Extensions.
+type Extension struct {
+	// Name is the key decoded value is stored under in Extensions
+	Name string
+	// Fields are JSON fields the extension takes, at least one of them has to be present
+	Fields []string
+	// New returns pointer to a struct the fields are decoded into, it has to marshal back into the same fields
+	New func() any
+}
+
+// Extensions are chain specific values decoded by registered extensions, keyed by extension name
+type Extensions map[string]any
+
+// ExtensionOf returns extension value of type T, ok is false if there is none
+func ExtensionOf[T any](exts Extensions) (val *T, ok bool) {
+	for _, ext := range exts {
+		if val, ok = ext.(*T); ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// Extra is raw JSON of fields neither the object nor registered extensions know, kept so that nothing is lost on re-encoding
+type Extra map[string]json.RawMessage
+
+type extensionRegistry struct {
+	lock       sync.RWMutex
+	extensions []Extension
+}
+
+var (
+	blockExtensions   extensionRegistry
+	txExtensions      extensionRegistry
+	receiptExtensions extensionRegistry
+)
+
+// RegisterBlockExtension adds extension decoded on Block and BlockDetailed, it panics if the name is already taken
+func RegisterBlockExtension(ext Extension) {
+	blockExtensions.register(ext)
+}
+
+// RegisterTxExtension adds extension decoded on Transaction, it panics if the name is already taken
+func RegisterTxExtension(ext Extension) {
+	txExtensions.register(ext)
+}
+
+// RegisterReceiptExtension adds extension decoded on Receipt, it panics if the name is already taken
+func RegisterReceiptExtension(ext Extension) {
+	receiptExtensions.register(ext)
+}
+
+func (r *extensionRegistry) register(ext Extension) {
+	if ext.Name == "" || len(ext.Fields) == 0 || ext.New == nil {
+		panic("types: extension requires name, fields and constructor")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, registered := range r.extensions {
+		if registered.Name == ext.Name {
+			panic("types: extension " + ext.Name + " is registered twice")
+		}
+	}
+	r.extensions = append(r.extensions, ext)
+}
+
+func (r *extensionRegistry) get() []Extension {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.extensions
+}
+
+// decode splits fields of JSON object that plain type does not know between registered extensions and Extra
+func (r *extensionRegistry) decode(data []byte, plain reflect.Type) (Extensions, Extra, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	known := knownFields(plain)
+	for name := range fields {
+		if known[name] {
+			delete(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+
+	var exts Extensions
+	for _, ext := range r.get() {
+		taken := map[string]json.RawMessage{}
+		for _, name := range ext.Fields {
+			if val, ok := fields[name]; ok {
+				taken[name] = val
+			}
+		}
+		if len(taken) == 0 {
+			continue
+		}
+		raw, err := json.Marshal(taken)
+		if err != nil {
+			return nil, nil, err
+		}
+		val := ext.New()
+		if err = json.Unmarshal(raw, val); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to decode %s extension", ext.Name)
+		}
+		if exts == nil {
+			exts = Extensions{}
+		}
+		exts[ext.Name] = val
+		for name := range taken {
+			delete(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return exts, nil, nil
+	}
+	return exts, Extra(fields), nil
+}
+
+// encodeExtensions appends fields of extensions and extra to JSON object produced for plain type
+func encodeExtensions(data []byte, exts Extensions, extra Extra) ([]byte, error) {
+	if len(exts) == 0 && len(extra) == 0 {
+		return data, nil
+	}
+	fields := map[string]json.RawMessage{}
+	for name, raw := range extra {
+		fields[name] = raw
+	}
+	for name, val := range exts {
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode %s extension", name)
+		}
+		var extFields map[string]json.RawMessage
+		if err = json.Unmarshal(raw, &extFields); err != nil {
+			return nil, errors.Wrapf(err, "%s extension is not encoded into JSON object", name)
+		}
+		for field, fieldRaw := range extFields {
+			fields[field] = fieldRaw
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+64*len(names)))
+	out.Write(bytes.TrimSuffix(bytes.TrimSpace(data), []byte("}")))
+	for i, name := range names {
+		if i > 0 || len(data) > 2 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(fields[name])
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+var knownFieldsCache sync.Map
+
+// knownFields returns JSON names of fields of struct type, including promoted ones of embedded structs
+func knownFields(typ reflect.Type) map[string]bool {
+	if cached, ok := knownFieldsCache.Load(typ); ok {
+		return cached.(map[string]bool)
+	}
+	out := map[string]bool{}
+	collectFields(typ, out)
+	knownFieldsCache.Store(typ, out)
+	return out
+}
+
+func collectFields(typ reflect.Type, out map[string]bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			collectFields(field.Type, out)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		out[name] = true
+	}
+}
@@ -0,0 +1,141 @@
+package types_test
+
+import (
+	"encoding/json"
+	"github.com/dkropachev/ethscan/pkg/types"
+	"testing"
+
+	"github.com/nsf/jsondiff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const depositTx = `{"blockHash":"0x4ef5b2e4bb6d87eb4b5a4fcb1bc9c04a8e1e1d8fb1a8ef06e0b4fb4a0e6e7a35","blockNumber":"0x7b0c3e2","from":"0xdeaddeaddeaddeaddeaddeaddeaddeaddead0001",` +
+	`"gas":"0xf4240","gasPrice":"0x0","hash":"0x2b7e0d4d8f6e7e3fc0ab9b2b2f1c36fcd2d1d1d2e5b1b0c7a6f5e4d3c2b1a098","input":"0x440a5e20","nonce":"0x7b0c3e1",` +
+	`"to":"0x4200000000000000000000000000000000000015","transactionIndex":"0x0","type":"0x7e","value":"0x0",` +
+	`"sourceHash":"0x9b3f1f7c8e3d2a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b","mint":"0x0","depositReceiptVersion":"0x1",` +
+	`"v":"0x0","r":"0x0","s":"0x0","yParity":"0x0"}`
+
+func TestTransactionExtensions(t *testing.T) {
+	var tx types.Transaction
+	require.NoError(t, json.Unmarshal([]byte(depositTx), &tx))
+	assert.Equal(t, int64(types.DepositTxType), tx.Type.AsBigInt().Int64())
+
+	deposit, ok := types.ExtensionOf[types.DepositTx](tx.Extensions)
+	require.True(t, ok)
+	assert.Equal(t, "0x9b3f1f7c8e3d2a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b", mustMarshal(t, deposit.SourceHash))
+	assert.Zero(t, deposit.Mint.AsBigInt().Sign())
+	assert.False(t, deposit.IsSystemTx)
+	assert.Equal(t, types.Extra{"v": json.RawMessage(`"0x0"`), "r": json.RawMessage(`"0x0"`), "s": json.RawMessage(`"0x0"`), "yParity": json.RawMessage(`"0x0"`)}, tx.Extra)
+
+	// Printed transaction keeps every field and reads back into the same one
+	out, err := json.Marshal(&tx)
+	require.NoError(t, err)
+	for _, field := range []string{"sourceHash", "mint", "depositReceiptVersion", "yParity", "v", "r", "s"} {
+		assert.Contains(t, string(out), `"`+field+`"`)
+	}
+	var decoded types.Transaction
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.True(t, tx.Equal(&decoded))
+	assert.Equal(t, mustMarshalRaw(t, tx.Extensions), mustMarshalRaw(t, decoded.Extensions))
+	assert.Equal(t, tx.Extra, decoded.Extra)
+
+	_, ok = types.ExtensionOf[types.ArbitrumReceipt](tx.Extensions)
+	assert.False(t, ok)
+}
+
+func TestBlockExtensions(t *testing.T) {
+	const block = `{"number":"0xa","hash":"0x1111111111111111111111111111111111111111111111111111111111111111",` +
+		`"l1BlockNumber":"0x12d3a0b","sendCount":"0x3","sendRoot":"0x2222222222222222222222222222222222222222222222222222222222222222",` +
+		`"logsBloom":"0x00","transactions":[`
+
+	var detailed types.BlockDetailed
+	require.NoError(t, json.Unmarshal([]byte(block+depositTx+`]}`), &detailed))
+	assert.Equal(t, int64(10), detailed.Number.AsBigInt().Int64())
+	require.Len(t, detailed.Transactions, 1)
+	_, ok := types.ExtensionOf[types.DepositTx](detailed.Transactions[0].Extensions)
+	assert.True(t, ok)
+	arbitrum, ok := types.ExtensionOf[types.ArbitrumBlock](detailed.Extensions)
+	require.True(t, ok)
+	assert.Equal(t, int64(19741195), arbitrum.L1BlockNumber.AsBigInt().Int64())
+	assert.Equal(t, int64(3), arbitrum.SendCount.AsBigInt().Int64())
+	assert.Equal(t, types.Extra{"logsBloom": json.RawMessage(`"0x00"`)}, detailed.Extra)
+
+	var hashes types.Block
+	require.NoError(t, json.Unmarshal([]byte(block+`"0x2b7e0d4d8f6e7e3fc0ab9b2b2f1c36fcd2d1d1d2e5b1b0c7a6f5e4d3c2b1a098"]}`), &hashes))
+	require.Len(t, hashes.Transactions, 1)
+	_, ok = types.ExtensionOf[types.ArbitrumBlock](hashes.Extensions)
+	assert.True(t, ok)
+
+	out, err := json.Marshal(hashes)
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, float64(19741195), decoded["l1BlockNumber"])
+	assert.Equal(t, "0x00", decoded["logsBloom"])
+	assert.Equal(t, []any{"0x2b7e0d4d8f6e7e3fc0ab9b2b2f1c36fcd2d1d1d2e5b1b0c7a6f5e4d3c2b1a098"}, decoded["transactions"])
+}
+
+func TestReceiptExtensions(t *testing.T) {
+	var receipt types.Receipt
+	require.NoError(t, json.Unmarshal([]byte(`{"gasUsed":"0x5208","gasUsedForL1":"0x1a2","l1BlockNumber":"0x12d3a0b","logs":[]}`), &receipt))
+	arbitrum, ok := types.ExtensionOf[types.ArbitrumReceipt](receipt.Extensions)
+	require.True(t, ok)
+	assert.Equal(t, int64(0x1a2), arbitrum.GasUsedForL1.AsBigInt().Int64())
+	assert.Nil(t, receipt.Extra)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"gasUsed":"0x5208","l1Fee":"0x3e8","l1FeeScalar":"0.684","logs":[]}`), &receipt))
+	op, ok := types.ExtensionOf[types.OPReceipt](receipt.Extensions)
+	require.True(t, ok)
+	assert.Equal(t, int64(1000), op.L1Fee.AsBigInt().Int64())
+	assert.Equal(t, types.Extra{"l1FeeScalar": json.RawMessage(`"0.684"`)}, receipt.Extra)
+}
+
+type polygonBlock struct {
+	BorSignature types.BinData `json:"borSignature"`
+}
+
+func TestRegisterExtension(t *testing.T) {
+	types.RegisterBlockExtension(types.Extension{
+		Name:   "test/polygon",
+		Fields: []string{"borSignature"},
+		New:    func() any { return new(polygonBlock) },
+	})
+	assert.Panics(t, func() {
+		types.RegisterBlockExtension(types.Extension{Name: "test/polygon", Fields: []string{"other"}, New: func() any { return new(polygonBlock) }})
+	})
+	assert.Panics(t, func() {
+		types.RegisterTxExtension(types.Extension{Name: "test/empty"})
+	})
+
+	in := `{"number":"0x1","borSignature":"0xabcd","transactions":[]}`
+	var blk types.Block
+	require.NoError(t, json.Unmarshal([]byte(in), &blk))
+	polygon, ok := types.ExtensionOf[polygonBlock](blk.Extensions)
+	require.True(t, ok)
+	assert.Equal(t, types.BinData{0xab, 0xcd}, polygon.BorSignature)
+	assert.Nil(t, blk.Extra)
+
+	out, err := json.Marshal(blk)
+	require.NoError(t, err)
+	var decoded map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	status, diff := jsondiff.Compare([]byte(`"0xabcd"`), decoded["borSignature"], jsonCMPOptions)
+	assert.Equal(t, jsondiff.FullMatch, status, diff)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"number":"0x1","borSignature":5}`), &blk))
+}
+
+func mustMarshal(t *testing.T, val any) string {
+	out, err := json.Marshal(val)
+	require.NoError(t, err)
+	var str string
+	require.NoError(t, json.Unmarshal(out, &str))
+	return str
+}
+
+func mustMarshalRaw(t *testing.T, val any) string {
+	out, err := json.Marshal(val)
+	require.NoError(t, err)
+	return string(out)
+}
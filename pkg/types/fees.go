@@ -0,0 +1,39 @@
+package types
+
+// PriorityFeePercentile is a priority fee per gas at the given gas-weighted percentile of a block.
+type PriorityFeePercentile struct {
+	Percentile float64 `json:"percentile"`
+	Value      BigInt  `json:"value"`
+}
+
+// BlockFeeStats holds fee market statistics of a single block.
+// PriorityFees are weighted by gas used of every transaction when FromReceipts is set, by gas limits otherwise.
+type BlockFeeStats struct {
+	Number            BigInt                  `json:"number"`
+	Hash              EthHash                 `json:"hash"`
+	Timestamp         BigInt                  `json:"timestamp"`
+	BaseFeePerGas     BigInt                  `json:"baseFeePerGas"`
+	NextBaseFeePerGas BigInt                  `json:"nextBaseFeePerGas"`
+	GasLimit          BigInt                  `json:"gasLimit"`
+	GasUsed           BigInt                  `json:"gasUsed"`
+	GasUsedRatio      float64                 `json:"gasUsedRatio"`
+	BlobGasUsed       BigInt                  `json:"blobGasUsed"`
+	ExcessBlobGas     BigInt                  `json:"excessBlobGas"`
+	BlobBaseFee       BigInt                  `json:"blobBaseFeePerGas"`
+	BlobGasUsedRatio  float64                 `json:"blobGasUsedRatio"`
+	TxCount           int                     `json:"txCount"`
+	PriorityFees      []PriorityFeePercentile `json:"priorityFees"`
+	FromReceipts      bool                    `json:"fromReceipts"`
+	ChainID           *BigInt                 `json:"chainId,omitempty"`
+}
+
+// FeeHistory mirrors the result of eth_feeHistory.
+// BaseFeePerGas and BaseFeePerBlobGas contain one extra entry for the block following the newest one.
+type FeeHistory struct {
+	OldestBlock       BigInt     `json:"oldestBlock"`
+	BaseFeePerGas     []BigInt   `json:"baseFeePerGas"`
+	GasUsedRatio      []float64  `json:"gasUsedRatio"`
+	BaseFeePerBlobGas []BigInt   `json:"baseFeePerBlobGas"`
+	BlobGasUsedRatio  []float64  `json:"blobGasUsedRatio"`
+	Reward            [][]BigInt `json:"reward,omitempty"`
+}
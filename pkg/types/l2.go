@@ -0,0 +1,64 @@
+package types
+
+// OP stack and Arbitrum fields, registered by default. Fields of chains without an extension end up in Extra.
+
+// DepositTxType is type of OP stack deposit transactions, they are created by the protocol from L1 deposits
+const DepositTxType = 0x7e
+
+// DepositTx holds fields of OP stack deposit transactions
+type DepositTx struct {
+	// SourceHash uniquely identifies the L1 origin of the deposit
+	SourceHash EthHash `json:"sourceHash"`
+	// Mint is ETH minted on L2 by the deposit, it is added to sender balance before execution
+	Mint *BigInt `json:"mint,omitempty"`
+	// IsSystemTx is set on pre-Regolith system deposits, which used no gas
+	IsSystemTx            bool    `json:"isSystemTx,omitempty"`
+	DepositReceiptVersion *BigInt `json:"depositReceiptVersion,omitempty"`
+}
+
+// OPReceipt holds L1 data fee fields of OP stack receipts and deposit nonce of deposit receipts
+type OPReceipt struct {
+	L1Fee                 *BigInt `json:"l1Fee,omitempty"`
+	L1GasPrice            *BigInt `json:"l1GasPrice,omitempty"`
+	L1GasUsed             *BigInt `json:"l1GasUsed,omitempty"`
+	DepositNonce          *BigInt `json:"depositNonce,omitempty"`
+	DepositReceiptVersion *BigInt `json:"depositReceiptVersion,omitempty"`
+}
+
+// ArbitrumBlock holds fields Arbitrum adds to blocks
+type ArbitrumBlock struct {
+	// L1BlockNumber is the L1 block the sequencer saw when it produced the block
+	L1BlockNumber *BigInt  `json:"l1BlockNumber,omitempty"`
+	SendCount     *BigInt  `json:"sendCount,omitempty"`
+	SendRoot      *EthHash `json:"sendRoot,omitempty"`
+}
+
+// ArbitrumReceipt holds fields Arbitrum adds to receipts
+type ArbitrumReceipt struct {
+	// GasUsedForL1 is part of GasUsed that pays for posting the transaction to L1
+	GasUsedForL1  *BigInt `json:"gasUsedForL1,omitempty"`
+	L1BlockNumber *BigInt `json:"l1BlockNumber,omitempty"`
+}
+
+func init() {
+	RegisterTxExtension(Extension{
+		Name:   "opDeposit",
+		Fields: []string{"sourceHash", "mint", "isSystemTx", "depositReceiptVersion"},
+		New:    func() any { return new(DepositTx) },
+	})
+	RegisterReceiptExtension(Extension{
+		Name:   "opReceipt",
+		Fields: []string{"l1Fee", "l1GasPrice", "l1GasUsed", "depositNonce", "depositReceiptVersion"},
+		New:    func() any { return new(OPReceipt) },
+	})
+	RegisterBlockExtension(Extension{
+		Name:   "arbitrumBlock",
+		Fields: []string{"l1BlockNumber", "sendCount", "sendRoot"},
+		New:    func() any { return new(ArbitrumBlock) },
+	})
+	RegisterReceiptExtension(Extension{
+		Name:   "arbitrumReceipt",
+		Fields: []string{"gasUsedForL1", "l1BlockNumber"},
+		New:    func() any { return new(ArbitrumReceipt) },
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"math/big"
+	"reflect"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -72,7 +73,7 @@ func (d *BinData) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 	data = removeHexPrefix(removeQuotes(data))
-	out := make([]byte, len(data)/2+1)
+	out := make([]byte, hex.DecodedLen(len(data)))
 	_, err := hex.Decode(out, data)
 	if err != nil {
 		return errors.Wrap(err, "failed to decode hex data")
@@ -121,6 +122,12 @@ type BlockBase struct {
 	BaseFeePerGas   BigInt         `json:"baseFeePerGas"`
 	BlobGasUsed     BigInt         `json:"blobGasUsed"`
 	ExcessBlobGas   BigInt         `json:"excessBlobGas"`
+	// ChainID is not returned by nodes, subscribers stamp it on blocks they emit
+	ChainID *BigInt `json:"chainId,omitempty"`
+	// Extensions and Extra hold fields of other chains, see RegisterBlockExtension.
+	// BlockBase has no JSON methods of its own, they would be promoted over ones of Block and BlockDetailed.
+	Extensions Extensions `json:"-"`
+	Extra      Extra      `json:"-"`
 }
 
 func (b BlockBase) IsEmpty() bool {
@@ -132,11 +139,49 @@ type Block struct {
 	Transactions []EthHash `json:"transactions"`
 }
 
+func (b *Block) UnmarshalJSON(data []byte) error {
+	type plain Block
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	var err error
+	b.Extensions, b.Extra, err = blockExtensions.decode(data, reflect.TypeOf(plain{}))
+	return err
+}
+
+func (b Block) MarshalJSON() ([]byte, error) {
+	type plain Block
+	data, err := json.Marshal(plain(b))
+	if err != nil {
+		return nil, err
+	}
+	return encodeExtensions(data, b.Extensions, b.Extra)
+}
+
 type BlockDetailed struct {
 	BlockBase
 	Transactions []*Transaction `json:"transactions"`
 }
 
+func (b *BlockDetailed) UnmarshalJSON(data []byte) error {
+	type plain BlockDetailed
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	var err error
+	b.Extensions, b.Extra, err = blockExtensions.decode(data, reflect.TypeOf(plain{}))
+	return err
+}
+
+func (b BlockDetailed) MarshalJSON() ([]byte, error) {
+	type plain BlockDetailed
+	data, err := json.Marshal(plain(b))
+	if err != nil {
+		return nil, err
+	}
+	return encodeExtensions(data, b.Extensions, b.Extra)
+}
+
 type BlockType interface {
 	Block | BlockDetailed
 
@@ -145,7 +190,20 @@ type BlockType interface {
 
 type BigInt big.Int
 
+// UnmarshalJSON accepts hex quantities nodes return, as well as decimal numbers MarshalJSON produces,
+// so that printed objects can be read back
 func (b *BigInt) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] != '"' {
+		i, ok := new(big.Int).SetString(string(data), 10)
+		if !ok {
+			return errors.Errorf("failed to parse %s into big.Int", data)
+		}
+		*b = BigInt(*i)
+		return nil
+	}
 	var s string
 	if err := json.Unmarshal(data, &s); err != nil {
 		return err
@@ -167,17 +225,82 @@ func (b *BigInt) AsBigInt() *big.Int {
 }
 
 type Transaction struct {
-	BlockHash        EthHash    `json:"blockHash"`
-	BlockNumber      BigInt     `json:"blockNumber"`
-	From             EthAddress `json:"from"`
-	Gas              BigInt     `json:"gas"`
-	GasPrice         BigInt     `json:"gasPrice"`
-	Hash             EthHash    `json:"hash"`
-	Input            BinData    `json:"input"`
-	Nonce            BigInt     `json:"nonce"`
-	To               EthAddress `json:"to"`
-	TransactionIndex BigInt     `json:"transactionIndex"`
-	Value            BigInt     `json:"value"`
+	BlockHash            EthHash    `json:"blockHash"`
+	BlockNumber          BigInt     `json:"blockNumber"`
+	From                 EthAddress `json:"from"`
+	Gas                  BigInt     `json:"gas"`
+	GasPrice             BigInt     `json:"gasPrice"`
+	MaxFeePerGas         *BigInt    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *BigInt    `json:"maxPriorityFeePerGas,omitempty"`
+	Hash                 EthHash    `json:"hash"`
+	Input                BinData    `json:"input"`
+	Nonce                BigInt     `json:"nonce"`
+	To                   EthAddress `json:"to"`
+	TransactionIndex     BigInt     `json:"transactionIndex"`
+	Type                 BigInt     `json:"type"`
+	Value                BigInt     `json:"value"`
+	// ChainID is returned by nodes for EIP-155 and typed transactions, others get it from their block
+	ChainID *BigInt `json:"chainId,omitempty"`
+	// BlockTimestamp is returned by recent nodes only, subscribers stamp it from the block otherwise
+	BlockTimestamp *BigInt `json:"blockTimestamp,omitempty"`
+	// Orphaned is set by stores that keep transactions of blocks reorged out instead of removing them, like "removed" of logs
+	Orphaned bool `json:"orphaned,omitempty"`
+	// Confirmations and Status are set on read by subscribers that follow the chain, they are not part of the
+	// transaction and are not compared by Equal
+	Confirmations *BigInt  `json:"confirmations,omitempty"`
+	Status        TxStatus `json:"status,omitempty"`
+	// Extensions and Extra hold fields of other chains and signature fields, see RegisterTxExtension
+	Extensions Extensions `json:"-"`
+	Extra      Extra      `json:"-"`
+}
+
+// TxStatus tells how safe it is to rely on a transaction
+type TxStatus string
+
+const (
+	// TxUnconfirmed is included into a block that can still be reorged out
+	TxUnconfirmed TxStatus = "unconfirmed"
+	// TxSafe is included into a block tagged safe, which is unlikely to be reorged out
+	TxSafe TxStatus = "safe"
+	// TxFinalized is included into a finalized block, which can not be reorged out
+	TxFinalized TxStatus = "finalized"
+	// TxReorged was included into a block that was reorged out
+	TxReorged TxStatus = "reorged"
+)
+
+var txStatuses = []TxStatus{TxUnconfirmed, TxSafe, TxFinalized, TxReorged}
+
+// ParseTxStatus parses status by name
+func ParseTxStatus(name string) (TxStatus, error) {
+	for _, status := range txStatuses {
+		if strings.EqualFold(name, string(status)) {
+			return status, nil
+		}
+	}
+	names := make([]string, len(txStatuses))
+	for i, status := range txStatuses {
+		names[i] = string(status)
+	}
+	return "", errors.Errorf("unknown transaction status %q, options: %s", name, strings.Join(names, ", "))
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type plain Transaction
+	if err := json.Unmarshal(data, (*plain)(t)); err != nil {
+		return err
+	}
+	var err error
+	t.Extensions, t.Extra, err = txExtensions.decode(data, reflect.TypeOf(plain{}))
+	return err
+}
+
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	type plain Transaction
+	data, err := json.Marshal(plain(t))
+	if err != nil {
+		return nil, err
+	}
+	return encodeExtensions(data, t.Extensions, t.Extra)
 }
 
 func (t *Transaction) Equal(o *Transaction) bool {
@@ -191,7 +314,119 @@ func (t *Transaction) Equal(o *Transaction) bool {
 		t.Nonce.AsBigInt().Cmp(o.Nonce.AsBigInt()) == 0 &&
 		t.To == o.To &&
 		t.TransactionIndex.AsBigInt().Cmp(o.TransactionIndex.AsBigInt()) == 0 &&
-		t.Value.AsBigInt().Cmp(o.Value.AsBigInt()) == 0
+		t.Type.AsBigInt().Cmp(o.Type.AsBigInt()) == 0 &&
+		t.Value.AsBigInt().Cmp(o.Value.AsBigInt()) == 0 &&
+		equalBigIntPtr(t.MaxFeePerGas, o.MaxFeePerGas) &&
+		equalBigIntPtr(t.MaxPriorityFeePerGas, o.MaxPriorityFeePerGas) &&
+		equalBigIntPtr(t.ChainID, o.ChainID) &&
+		equalBigIntPtr(t.BlockTimestamp, o.BlockTimestamp) &&
+		t.Orphaned == o.Orphaned
+}
+
+// EffectiveTip returns the priority fee per gas the transaction pays on top of baseFee.
+// For dynamic fee transactions it is min(maxPriorityFeePerGas, maxFeePerGas-baseFee),
+// for legacy ones gasPrice-baseFee. It never returns a negative value.
+func (t *Transaction) EffectiveTip(baseFee *big.Int) *big.Int {
+	var tip *big.Int
+	if t.MaxFeePerGas != nil && t.MaxPriorityFeePerGas != nil {
+		tip = new(big.Int).Sub(t.MaxFeePerGas.AsBigInt(), baseFee)
+		if prio := t.MaxPriorityFeePerGas.AsBigInt(); prio.Cmp(tip) < 0 {
+			tip.Set(prio)
+		}
+	} else {
+		tip = new(big.Int).Sub(t.GasPrice.AsBigInt(), baseFee)
+	}
+	if tip.Sign() < 0 {
+		tip.SetInt64(0)
+	}
+	return tip
+}
+
+// Receipt is a transaction receipt as returned by eth_getTransactionReceipt and eth_getBlockReceipts.
+type Receipt struct {
+	BlockHash         EthHash    `json:"blockHash"`
+	BlockNumber       BigInt     `json:"blockNumber"`
+	TransactionHash   EthHash    `json:"transactionHash"`
+	TransactionIndex  BigInt     `json:"transactionIndex"`
+	From              EthAddress `json:"from"`
+	To                EthAddress `json:"to"`
+	ContractAddress   EthAddress `json:"contractAddress"`
+	CumulativeGasUsed BigInt     `json:"cumulativeGasUsed"`
+	GasUsed           BigInt     `json:"gasUsed"`
+	EffectiveGasPrice BigInt     `json:"effectiveGasPrice"`
+	// Status is 1 on success and 0 on failure, it is nil on pre-Byzantium receipts, which carry Root instead
+	Status *BigInt  `json:"status,omitempty"`
+	Root   *EthHash `json:"root,omitempty"`
+	Type   BigInt   `json:"type"`
+	Logs   []*Log   `json:"logs"`
+	// Extensions and Extra hold fields of other chains, e.g. L1 fees of rollups, see RegisterReceiptExtension
+	Extensions Extensions `json:"-"`
+	Extra      Extra      `json:"-"`
+}
+
+// Failed reports if the transaction reverted, pre-Byzantium receipts do not tell it and are treated as successful
+func (r *Receipt) Failed() bool {
+	return r.Status != nil && r.Status.AsBigInt().Sign() == 0
+}
+
+func (r *Receipt) UnmarshalJSON(data []byte) error {
+	type plain Receipt
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	var err error
+	r.Extensions, r.Extra, err = receiptExtensions.decode(data, reflect.TypeOf(plain{}))
+	return err
+}
+
+func (r Receipt) MarshalJSON() ([]byte, error) {
+	type plain Receipt
+	data, err := json.Marshal(plain(r))
+	if err != nil {
+		return nil, err
+	}
+	return encodeExtensions(data, r.Extensions, r.Extra)
+}
+
+// Log is an event emitted by a contract, as returned by eth_getLogs and in receipts.
+type Log struct {
+	Address          EthAddress `json:"address"`
+	Topics           []EthHash  `json:"topics"`
+	Data             BinData    `json:"data"`
+	BlockNumber      BigInt     `json:"blockNumber"`
+	BlockHash        EthHash    `json:"blockHash"`
+	TransactionHash  EthHash    `json:"transactionHash"`
+	TransactionIndex BigInt     `json:"transactionIndex"`
+	LogIndex         BigInt     `json:"logIndex"`
+	Removed          bool       `json:"removed"`
+}
+
+// ProposedBlockReward describes execution layer revenue of a block proposed to a fee recipient.
+// PriorityFees is exact when FromReceipts is set, otherwise it is estimated from the transactions
+// gas limits scaled down to the gas used by the block.
+type ProposedBlockReward struct {
+	BlockHash     EthHash    `json:"blockHash"`
+	BlockNumber   BigInt     `json:"blockNumber"`
+	Timestamp     BigInt     `json:"timestamp"`
+	FeeRecipient  EthAddress `json:"feeRecipient"`
+	BaseFeePerGas BigInt     `json:"baseFeePerGas"`
+	GasUsed       BigInt     `json:"gasUsed"`
+	BurntFees     BigInt     `json:"burntFees"`
+	PriorityFees  BigInt     `json:"priorityFees"`
+	TxCount       int        `json:"txCount"`
+	FromReceipts  bool       `json:"fromReceipts"`
+	ChainID       *BigInt    `json:"chainId,omitempty"`
+}
+
+func (r *ProposedBlockReward) Equal(o *ProposedBlockReward) bool {
+	return r.BlockHash == o.BlockHash && r.FeeRecipient == o.FeeRecipient
+}
+
+func equalBigIntPtr(a, b *BigInt) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.AsBigInt().Cmp(b.AsBigInt()) == 0
 }
 
 func removeQuotes(data []byte) []byte {
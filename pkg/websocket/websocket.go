@@ -0,0 +1,428 @@
+// Package websocket implements minimal RFC 6455 text message transport on top of the standard library,
+// just enough to carry JSON-RPC requests, responses and subscription notifications.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// DefaultMaxMessageSize limits size of a single incoming message
+	DefaultMaxMessageSize = 128 << 20
+)
+
+var ErrClosed = errors.New("websocket connection closed")
+
+var noDeadline time.Time
+
+// Conn is a websocket connection that exchanges whole messages
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isClient bool
+	maxSize  int64
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+// Dialer holds settings used to establish client connections
+type Dialer struct {
+	// Header is sent with the handshake request
+	Header http.Header
+	// TLSConfig is used for wss:// endpoints
+	TLSConfig *tls.Config
+	// NetDial dials TCP connections, net.Dialer is used when it is nil
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// MaxMessageSize limits incoming messages, DefaultMaxMessageSize is used when it is zero
+	MaxMessageSize int64
+}
+
+// Dial connects to ws:// or wss:// endpoint using default settings
+func Dial(ctx context.Context, endpoint string) (*Conn, error) {
+	return (&Dialer{}).Dial(ctx, endpoint)
+}
+
+// Dial connects to ws:// or wss:// endpoint and performs opening handshake
+func (d *Dialer) Dial(ctx context.Context, endpoint string) (*Conn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse endpoint URL")
+	}
+
+	var useTLS bool
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		useTLS = true
+	default:
+		return nil, errors.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if useTLS {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	netDial := d.NetDial
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+	conn, err := netDial(ctx, "tcp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", host)
+	}
+
+	if useTLS {
+		cfg := &tls.Config{}
+		if d.TLSConfig != nil {
+			cfg = d.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "tls handshake failed")
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	out, err := d.handshake(conn, u)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(noDeadline)
+	return out, nil
+}
+
+func (d *Dialer) handshake(conn net.Conn, u *url.URL) (*Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate handshake key")
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			req.SetBasicAuth(u.User.Username(), password)
+		}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := req.Write(conn); err != nil {
+		return nil, errors.Wrap(err, "failed to send handshake request")
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read handshake response")
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, &HandshakeError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("handshake response has invalid Sec-WebSocket-Accept")
+	}
+
+	return &Conn{
+		conn:     conn,
+		reader:   reader,
+		isClient: true,
+		maxSize:  d.maxMessageSize(),
+	}, nil
+}
+
+func (d *Dialer) maxMessageSize() int64 {
+	if d.MaxMessageSize > 0 {
+		return d.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+// HandshakeError is returned when the server refused to upgrade the connection
+type HandshakeError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket handshake failed with http status " + http.StatusText(e.StatusCode) + ": " + e.Body
+}
+
+// Upgrade performs server side of the opening handshake
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hijack connection")
+	}
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to send handshake response")
+	}
+	return &Conn{
+		conn:    conn,
+		reader:  rw.Reader,
+		maxSize: DefaultMaxMessageSize,
+	}, nil
+}
+
+// ReadMessage returns payload of the next text or binary message, answering pings on the way
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	var started bool
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			if err = c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeOnce.Do(func() {
+				_ = c.writeFrame(opClose, payload)
+				_ = c.conn.Close()
+			})
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				return nil, errors.New("new message started before previous one finished")
+			}
+			started = true
+			message = payload
+		case opContinuation:
+			if !started {
+				return nil, errors.New("continuation frame without message start")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, errors.Errorf("unknown websocket opcode %d", opcode)
+		}
+		if int64(len(message)) > c.maxSize {
+			return nil, errors.Errorf("websocket message exceeds %d bytes", c.maxSize)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// WriteMessage sends payload as a single text message
+func (c *Conn) WriteMessage(payload []byte) error {
+	return c.writeFrame(opText, payload)
+}
+
+// Close sends close frame and closes underlying connection
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		_ = c.writeFrame(opClose, []byte{0x03, 0xe8})
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, c.wrapReadErr(err)
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("websocket frame has reserved bits set")
+	}
+	// Clients mask every frame they send and servers never do
+	if masked != !c.isClient {
+		return false, 0, nil, errors.Errorf("websocket frame masking is invalid, masked: %t", masked)
+	}
+	if opcode&0x8 != 0 && (!fin || length > 125) {
+		return false, 0, nil, errors.New("websocket control frame is fragmented or exceeds 125 bytes")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, c.wrapReadErr(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, c.wrapReadErr(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(c.maxSize) {
+		return false, 0, nil, errors.Errorf("websocket frame exceeds %d bytes", c.maxSize)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, c.wrapReadErr(err)
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, c.wrapReadErr(err)
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) wrapReadErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return ErrClosed
+	}
+	return errors.Wrap(err, "failed to read websocket frame")
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if c.isClient {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return errors.Wrap(err, "failed to generate frame mask")
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if _, err := c.conn.Write(frame); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrClosed
+		}
+		return errors.Wrap(err, "failed to write websocket frame")
+	}
+	return nil
+}
+
+func acceptKey(key string) string {
+	// SHA-1 is mandated by RFC 6455 handshake
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, val := range h.Values(name) {
+		for _, part := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
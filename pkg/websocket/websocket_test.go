@@ -0,0 +1,387 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/dkropachev/ethscan/pkg/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// frame encodes a frame, mask is applied when it is set
+func frame(fin bool, opcode byte, payload []byte, mask []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	out := lengthHeader(first, uint64(len(payload)), mask != nil)
+	if mask == nil {
+		return append(out, payload...)
+	}
+	out = append(out, mask...)
+	for i, b := range payload {
+		out = append(out, b^mask[i%4])
+	}
+	return out
+}
+
+func lengthHeader(first byte, length uint64, masked bool) []byte {
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case length < 126:
+		return []byte{first, maskBit | byte(length)}
+	case length <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{first, maskBit | 126}, uint16(length))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{first, maskBit | 127}, length)
+	}
+}
+
+// readFrame reads a frame the way a peer would, unmasking it
+func readFrame(t *testing.T, r io.Reader) (fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	require.NoError(t, err)
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if header[1]&0x80 != 0 {
+		_, err = io.ReadFull(r, mask[:])
+		require.NoError(t, err)
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	if header[1]&0x80 != 0 {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return header[0]&0x80 != 0, header[0] & 0x0f, payload
+}
+
+// dialRaw connects client to a server which handshakes by hand and then hands its side of the connection to the test
+func dialRaw(t *testing.T, dialer *websocket.Dialer) (*websocket.Conn, net.Conn, *bufio.Reader) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	type accepted struct {
+		conn   net.Conn
+		reader *bufio.Reader
+		err    error
+	}
+	done := make(chan accepted, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- accepted{err: err}
+			return
+		}
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			done <- accepted{err: err}
+			return
+		}
+		h := sha1.New()
+		h.Write([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n\r\n"))
+		done <- accepted{conn: conn, reader: reader, err: err}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := dialer.Dial(ctx, "ws://"+listener.Addr().String())
+	require.NoError(t, err)
+	server := <-done
+	require.NoError(t, server.err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.conn.Close()
+	})
+	require.NoError(t, server.conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return client, server.conn, server.reader
+}
+
+func TestReadMessage(t *testing.T) {
+	t.Parallel()
+	long := bytes.Repeat([]byte{'a'}, 300)
+	huge := bytes.Repeat([]byte{'b'}, 70000)
+
+	type reply struct {
+		opcode  byte
+		payload []byte
+	}
+	for _, tc := range []struct {
+		name    string
+		maxSize int64
+		frames  [][]byte
+		want    []byte
+		wantErr string
+		// replies are frames the client is expected to send back
+		replies []reply
+	}{
+		{
+			name:   "single frame",
+			frames: [][]byte{frame(true, opText, []byte("hello"), nil)},
+			want:   []byte("hello"),
+		},
+		{
+			name:   "16-bit length",
+			frames: [][]byte{frame(true, opText, long, nil)},
+			want:   long,
+		},
+		{
+			name:   "64-bit length",
+			frames: [][]byte{frame(true, opText, huge, nil)},
+			want:   huge,
+		},
+		{
+			name: "fragmented",
+			frames: [][]byte{
+				frame(false, opText, []byte("hel"), nil),
+				frame(false, opContinuation, []byte("l"), nil),
+				frame(true, opContinuation, []byte("o"), nil),
+			},
+			want: []byte("hello"),
+		},
+		{
+			name: "control frames between fragments",
+			frames: [][]byte{
+				frame(false, opText, []byte("he"), nil),
+				frame(true, opPing, []byte("ping"), nil),
+				frame(true, opPong, []byte("pong"), nil),
+				frame(true, opContinuation, []byte("llo"), nil),
+			},
+			want:    []byte("hello"),
+			replies: []reply{{opPong, []byte("ping")}},
+		},
+		{
+			name:    "continuation without start",
+			frames:  [][]byte{frame(true, opContinuation, []byte("x"), nil)},
+			wantErr: "continuation frame without message start",
+		},
+		{
+			name: "new message inside fragmented one",
+			frames: [][]byte{
+				frame(false, opText, []byte("he"), nil),
+				frame(true, opText, []byte("llo"), nil),
+			},
+			wantErr: "new message started before previous one finished",
+		},
+		{
+			name:    "fragmented control frame",
+			frames:  [][]byte{frame(false, opPing, []byte("p"), nil)},
+			wantErr: "control frame",
+		},
+		{
+			name:    "oversized control frame",
+			frames:  [][]byte{frame(true, opPing, long[:126], nil)},
+			wantErr: "control frame",
+		},
+		{
+			name:    "oversized 64-bit length",
+			frames:  [][]byte{lengthHeader(0x80|opText, 1<<63, false)},
+			wantErr: "frame exceeds",
+		},
+		{
+			name:    "frame above max size",
+			maxSize: 4,
+			frames:  [][]byte{frame(true, opText, []byte("hello"), nil)},
+			wantErr: "frame exceeds 4 bytes",
+		},
+		{
+			name:    "fragments above max size",
+			maxSize: 4,
+			frames: [][]byte{
+				frame(false, opText, []byte("hel"), nil),
+				frame(true, opContinuation, []byte("lo"), nil),
+			},
+			wantErr: "message exceeds 4 bytes",
+		},
+		{
+			name:    "masked server frame",
+			frames:  [][]byte{frame(true, opText, []byte("hello"), []byte{1, 2, 3, 4})},
+			wantErr: "masking is invalid",
+		},
+		{
+			name:    "reserved bits",
+			frames:  [][]byte{append([]byte{0x80 | 0x40 | opText, 1}, 'x')},
+			wantErr: "reserved bits",
+		},
+		{
+			name:    "unknown opcode",
+			frames:  [][]byte{frame(true, 0x3, []byte("x"), nil)},
+			wantErr: "unknown websocket opcode 3",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client, server, reader := dialRaw(t, &websocket.Dialer{MaxMessageSize: tc.maxSize})
+			go func() {
+				for _, f := range tc.frames {
+					if _, err := server.Write(f); err != nil {
+						return
+					}
+				}
+			}()
+
+			got, err := client.ReadMessage()
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			for _, want := range tc.replies {
+				_, opcode, payload := readFrame(t, reader)
+				assert.Equal(t, want.opcode, opcode)
+				assert.Equal(t, want.payload, payload)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	t.Parallel()
+	client, _, reader := dialRaw(t, &websocket.Dialer{})
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		require.NoError(t, client.WriteMessage(payload))
+		fin, opcode, got := readFrame(t, reader)
+		assert.True(t, fin)
+		assert.Equal(t, byte(opText), opcode)
+		assert.Equal(t, payload, got)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	t.Parallel()
+
+	t.Run("peer closes", func(t *testing.T) {
+		t.Parallel()
+		client, server, reader := dialRaw(t, &websocket.Dialer{})
+		// Going away with a reason, the client echoes it back and drops the connection
+		_, err := server.Write(frame(true, opClose, []byte{0x03, 0xe9, 'b', 'y', 'e'}, nil))
+		require.NoError(t, err)
+		_, err = client.ReadMessage()
+		assert.ErrorIs(t, err, websocket.ErrClosed)
+		_, opcode, payload := readFrame(t, reader)
+		assert.Equal(t, byte(opClose), opcode)
+		assert.Equal(t, []byte{0x03, 0xe9, 'b', 'y', 'e'}, payload)
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+
+		_, err = client.ReadMessage()
+		assert.ErrorIs(t, err, websocket.ErrClosed)
+		assert.Error(t, client.WriteMessage([]byte("late")))
+	})
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+		client, _, reader := dialRaw(t, &websocket.Dialer{})
+		require.NoError(t, client.Close())
+		// Normal closure is sent once, repeated Close is a no-op
+		require.NoError(t, client.Close())
+		_, opcode, payload := readFrame(t, reader)
+		assert.Equal(t, byte(opClose), opcode)
+		assert.Equal(t, []byte{0x03, 0xe8}, payload)
+		_, err := reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+		_, err = client.ReadMessage()
+		assert.ErrorIs(t, err, websocket.ErrClosed)
+	})
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Parallel()
+	// Server echoes messages and reports why it stopped
+	serverErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msg, err := conn.ReadMessage()
+			if err == nil {
+				err = conn.WriteMessage(append([]byte("echo "), msg...))
+			}
+			if err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client, err := websocket.Dial(context.Background(), "ws"+srv.URL[len("http"):])
+	require.NoError(t, err)
+	require.NoError(t, client.WriteMessage([]byte("hi")))
+	msg, err := client.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("echo hi"), msg)
+	require.NoError(t, client.Close())
+	assert.ErrorIs(t, <-serverErr, websocket.ErrClosed)
+
+	// Client frames have to be masked
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	_, err = conn.Write(frame(true, opText, []byte("hi"), nil))
+	require.NoError(t, err)
+	err = <-serverErr
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "masking is invalid")
+
+	resp, err = http.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}